@@ -0,0 +1,282 @@
+// Command deployer runs preview, up, refresh or destroy on a stack of the program
+// through the Pulumi Automation API, for some of its environments or all of
+// them, and prints a JSON summary of each run for CI pipelines:
+//
+//	deployer -stack dev -env test,prod up
+//
+// The program's own output goes to stderr, so stdout only carries the summaries,
+// one JSON object per line. The exit status is 1 when any run failed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+var operations = []string{"preview", "up", "refresh", "destroy"}
+
+// The summary printed for each run.
+type result struct {
+	Stack string `json:"stack"`
+	// The environment the run was limited to, or "" for the whole stack.
+	Environment string `json:"environment"`
+	Operation   string `json:"operation"`
+	Succeeded   bool   `json:"succeeded"`
+	// Resources by the operation done to them, e.g. {"create": 3, "same": 120}.
+	Changes map[string]int `json:"changes,omitempty"`
+	// The stack outputs after an up, with secrets masked.
+	Outputs         map[string]interface{} `json:"outputs,omitempty"`
+	DurationSeconds float64                `json:"durationSeconds"`
+	Error           string                 `json:"error,omitempty"`
+}
+
+func main() {
+	// A flag set of its own, without the glog flags the SDK adds to the default one
+	flags := flag.NewFlagSet("deployer", flag.ExitOnError)
+	stackName := flags.String("stack", "", "the stack to run on (required)")
+	envs := flags.String("env", "", "comma-separated environments to limit the run to, all of the stack's by default")
+	workDir := flags.String("dir", ".", "the directory of the Pulumi program")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: deployer -stack <stack> [-env <env>,...] [-dir <dir>] %s\n",
+			strings.Join(operations, "|"))
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+	if *stackName == "" || flags.NArg() != 1 || !contains(operations, flags.Arg(0)) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var environments []string
+	if *envs != "" {
+		environments = strings.Split(*envs, ",")
+	}
+
+	ok := true
+	out := json.NewEncoder(os.Stdout)
+	for _, r := range deploy(context.Background(), *stackName, environments, *workDir, flags.Arg(0)) {
+		ok = ok && r.Succeeded
+		if err := out.Encode(r); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// A run of an operation on a stack, limited to targets when set.
+type run struct {
+	stack       auto.Stack
+	environment string
+	targets     []string
+}
+
+// Run operation on environments of stackName, or on the whole stack when none
+// are named. Stacks with `environmentPerStack` hold one environment each, so
+// there every environment is the stack named after it. Other stacks hold all of
+// them, and the run is limited to the named environment's resources with
+// targets, one run per environment.
+func deploy(ctx context.Context, stackName string, environments []string, workDir string, operation string) []result {
+	failed := func(env string, err error) []result {
+		return []result{{Stack: stackName, Environment: env, Operation: operation, Error: err.Error()}}
+	}
+	stack, err := auto.SelectStackLocalSource(ctx, stackName, workDir)
+	if err != nil {
+		return failed("", err)
+	}
+	perStack, err := environmentPerStack(ctx, stack)
+	if err != nil {
+		return failed("", err)
+	}
+
+	var runs []run
+	switch {
+	case len(environments) == 0:
+		runs = append(runs, run{stack: stack})
+	case perStack:
+		for _, env := range environments {
+			envStack, err := auto.SelectStackLocalSource(ctx, env, workDir)
+			if err != nil {
+				return failed(env, fmt.Errorf("environmentPerStack is set, but environment %s has no stack: %w", env, err))
+			}
+			runs = append(runs, run{stack: envStack, environment: env})
+		}
+	default:
+		state, err := stack.Export(ctx)
+		if err != nil {
+			return failed("", err)
+		}
+		project, err := stack.Workspace().ProjectSettings(ctx)
+		if err != nil {
+			return failed("", err)
+		}
+		for _, env := range environments {
+			targets, err := environmentTargets(state.Deployment, stackName, string(project.Name), env, operation)
+			if err != nil {
+				return failed(env, err)
+			}
+			runs = append(runs, run{stack: stack, environment: env, targets: targets})
+		}
+	}
+
+	var results []result
+	for _, r := range runs {
+		results = append(results, r.execute(ctx, operation))
+	}
+	return results
+}
+
+// Read whether the stack's config sets `environmentPerStack`.
+func environmentPerStack(ctx context.Context, stack auto.Stack) (bool, error) {
+	project, err := stack.Workspace().ProjectSettings(ctx)
+	if err != nil {
+		return false, err
+	}
+	config, err := stack.GetAllConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+	value, ok := config[fmt.Sprintf("%s:environmentPerStack", project.Name)]
+	return ok && value.Value == "true", nil
+}
+
+// Run the operation, sending the program's output to stderr.
+func (r run) execute(ctx context.Context, operation string) result {
+	res := result{Stack: r.stack.Name(), Environment: r.environment, Operation: operation}
+	start := time.Now()
+	var err error
+	switch operation {
+	case "preview":
+		var preview auto.PreviewResult
+		opts := []optpreview.Option{optpreview.ProgressStreams(os.Stderr)}
+		if r.targets != nil {
+			opts = append(opts, optpreview.Target(r.targets), optpreview.TargetDependents())
+		}
+		preview, err = r.stack.Preview(ctx, opts...)
+		if err == nil {
+			res.Changes = map[string]int{}
+			for op, count := range preview.ChangeSummary {
+				res.Changes[string(op)] = count
+			}
+		}
+	case "up":
+		var up auto.UpResult
+		opts := []optup.Option{optup.ProgressStreams(os.Stderr)}
+		if r.targets != nil {
+			opts = append(opts, optup.Target(r.targets), optup.TargetDependents())
+		}
+		up, err = r.stack.Up(ctx, opts...)
+		if err == nil {
+			res.Changes = resourceChanges(up.Summary)
+			res.Outputs = map[string]interface{}{}
+			for name, output := range up.Outputs {
+				if output.Secret {
+					res.Outputs[name] = "[secret]"
+				} else {
+					res.Outputs[name] = output.Value
+				}
+			}
+		}
+	case "refresh":
+		var refresh auto.RefreshResult
+		opts := []optrefresh.Option{optrefresh.ProgressStreams(os.Stderr)}
+		if r.targets != nil {
+			opts = append(opts, optrefresh.Target(r.targets))
+		}
+		refresh, err = r.stack.Refresh(ctx, opts...)
+		if err == nil {
+			res.Changes = resourceChanges(refresh.Summary)
+		}
+	case "destroy":
+		var destroy auto.DestroyResult
+		opts := []optdestroy.Option{optdestroy.ProgressStreams(os.Stderr)}
+		if r.targets != nil {
+			opts = append(opts, optdestroy.Target(r.targets), optdestroy.TargetDependents())
+		}
+		destroy, err = r.stack.Destroy(ctx, opts...)
+		if err == nil {
+			res.Changes = resourceChanges(destroy.Summary)
+		}
+	}
+	res.DurationSeconds = time.Since(start).Seconds()
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Succeeded = true
+	}
+	return res
+}
+
+func resourceChanges(summary auto.UpdateSummary) map[string]int {
+	if summary.ResourceChanges == nil {
+		return nil
+	}
+	return *summary.ResourceChanges
+}
+
+// The parts of a stack's state environmentTargets reads.
+type deployment struct {
+	Resources []struct {
+		URN    string `json:"urn"`
+		Type   string `json:"type"`
+		Parent string `json:"parent"`
+	} `json:"resources"`
+}
+
+// The URNs to target to run operation on env alone in a stack holding every
+// environment: env's EksEnvironment components, in the primary and any replica
+// region, and every resource under them in state. An up or preview may target
+// the primary component before it exists, as everything it creates is parented
+// to it, but a refresh or destroy needs env to be in state.
+func environmentTargets(state json.RawMessage, stack string, project string, env string, operation string) ([]string, error) {
+	var d deployment
+	if len(state) > 0 {
+		if err := json.Unmarshal(state, &d); err != nil {
+			return nil, fmt.Errorf("reading the state of stack %s: %w", stack, err)
+		}
+	}
+	components := map[string]bool{}
+	for _, r := range d.Resources {
+		if r.Type == "eksdemo:index:EksEnvironment" && strings.HasSuffix(r.URN, "::"+env) {
+			components[r.URN] = true
+		}
+	}
+	if len(components) == 0 {
+		if operation == "refresh" || operation == "destroy" {
+			return nil, fmt.Errorf("environment %s is not in stack %s", env, stack)
+		}
+		return []string{fmt.Sprintf("urn:pulumi:%s::%s::eksdemo:index:EksEnvironment::%s", stack, project, env)}, nil
+	}
+
+	// Parents come before their children in state, so one pass finds them all
+	var targets []string
+	under := map[string]bool{}
+	for _, r := range d.Resources {
+		if components[r.URN] || under[r.Parent] {
+			under[r.URN] = true
+			targets = append(targets, r.URN)
+		}
+	}
+	return targets, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
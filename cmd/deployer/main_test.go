@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testState = `{"resources": [
+	{"urn": "urn:pulumi:dev::aws-go-eks::pulumi:pulumi:Stack::aws-go-eks-dev", "type": "pulumi:pulumi:Stack"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::test", "type": "eksdemo:index:EksEnvironment"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment$aws:eks/cluster:Cluster::test-aws-demo",
+		"type": "aws:eks/cluster:Cluster", "parent": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::test"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment$kubernetes:helm.sh/v3:Chart::test-argo-cd",
+		"type": "kubernetes:helm.sh/v3:Chart", "parent": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::test"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment$kubernetes:helm.sh/v3:Chart$kubernetes:apps/v1:Deployment::argocd-server",
+		"type": "kubernetes:apps/v1:Deployment", "parent": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment$kubernetes:helm.sh/v3:Chart::test-argo-cd"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::prod", "type": "eksdemo:index:EksEnvironment"},
+	{"urn": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment$aws:eks/cluster:Cluster::prod-aws-demo",
+		"type": "aws:eks/cluster:Cluster", "parent": "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::prod"}
+]}`
+
+func TestEnvironmentTargets(t *testing.T) {
+	targets, err := environmentTargets([]byte(testState), "dev", "aws-go-eks", "test", "destroy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 4 || !strings.HasSuffix(targets[3], "::argocd-server") {
+		t.Errorf("expected the test component and the 3 resources under it, got %v", targets)
+	}
+	for _, target := range targets {
+		if strings.Contains(target, "prod") {
+			t.Errorf("expected no prod resources, got %s", target)
+		}
+	}
+
+	targets, err = environmentTargets([]byte(testState), "dev", "aws-go-eks", "staging", "up")
+	if err != nil || len(targets) != 1 || targets[0] != "urn:pulumi:dev::aws-go-eks::eksdemo:index:EksEnvironment::staging" {
+		t.Errorf("expected an up of a new environment to target its component, got %v, %v", targets, err)
+	}
+	if _, err := environmentTargets([]byte(testState), "dev", "aws-go-eks", "staging", "refresh"); err == nil {
+		t.Error("expected a refresh of an environment missing from the stack to fail")
+	}
+}
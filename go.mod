@@ -3,7 +3,9 @@ module aws-go-eks
 go 1.13
 
 require (
-	github.com/pulumi/pulumi-aws/sdk/v4 v4.0.0
-	github.com/pulumi/pulumi-kubernetes/sdk/v3 v3.0.0
-	github.com/pulumi/pulumi/sdk/v3 v3.0.0
+	github.com/pulumi/pulumi-aws/sdk/v4 v4.38.1
+	github.com/pulumi/pulumi-command/sdk v0.1.0
+	github.com/pulumi/pulumi-kubernetes/sdk/v3 v3.20.0
+	github.com/pulumi/pulumi/sdk/v3 v3.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,99 @@
+// Package addons installs the EKS add-ons, Helm charts and Kubernetes resources
+// that go into a provisioned cluster.
+package addons
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The EKS add-ons managed with `eksAddons`, in the order they are created.
+var eksAddonNames = []string{"vpc-cni", "kube-proxy", "coredns", "aws-ebs-csi-driver"}
+
+// The service account the EBS CSI driver's controller runs as in kube-system.
+const ebsCsiServiceAccount = "ebs-csi-controller-sa"
+
+// Add-on versions as `aws eks describe-addon-versions` lists them.
+var eksAddonVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+-eksbuild\.[0-9]+$`)
+
+// Read the add-on versions `eksAddonVersions` pins for env, keyed by add-on name.
+// Add-ons it does not list get the default version for the cluster's Kubernetes
+// version when they are created, and keep it until pinned.
+func eksAddonVersionsConfig(cfg *config.Config, env string) (map[string]string, error) {
+	var byEnv map[string]map[string]string
+	if err := cfg.GetObject("eksAddonVersions", &byEnv); err != nil {
+		return nil, fmt.Errorf("eksAddonVersions must map environment names to objects of add-on versions: %w", err)
+	}
+	versions := byEnv[env]
+	for name, version := range versions {
+		if !stackconfig.ContainsString(eksAddonNames, name) {
+			return nil, fmt.Errorf("eksAddonVersions for %s names %q, which is not one of the managed add-ons %v", env, name, eksAddonNames)
+		}
+		if !eksAddonVersion.MatchString(version) {
+			return nil, fmt.Errorf("eksAddonVersions %s for %s must be an add-on version such as v1.18.3-eksbuild.1, got %q", name, env, version)
+		}
+	}
+	return versions, nil
+}
+
+// InstallEksAddons manages the VPC CNI, kube-proxy, CoreDNS and the EBS CSI driver
+// as EKS add-ons when `eksAddons` is set for the cluster's environment, at the
+// versions `eksAddonVersions` pins, so their upgrades are made and tracked
+// through Pulumi. The EBS CSI driver gets an IRSA role with the AWS managed
+// policy. The add-ons EKS installed itself are taken over, overwriting any
+// changes made to them by hand; the VPC CNI and CoreDNS patches of this program
+// are applied on top, but only again on the next update that changes them, so
+// run `pulumi up --refresh` after changing a version.
+func InstallEksAddons(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "eksAddons", env, false)
+	if err != nil || !enabled {
+		return err
+	}
+	versions, err := eksAddonVersionsConfig(cfg, env)
+	if err != nil {
+		return err
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	ebsCsiRole, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-ebs-csi-irsa", env), oidcProvider,
+		"kube-system", ebsCsiServiceAccount, []string{"arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy"},
+		cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range eksAddonNames {
+		args := &eks.AddonArgs{
+			ClusterName:      cluster.Cluster.Name,
+			AddonName:        pulumi.String(name),
+			ResolveConflicts: pulumi.String("OVERWRITE"),
+			Tags:             cluster.ExpiryTags,
+		}
+		if version := versions[name]; version != "" {
+			args.AddonVersion = pulumi.String(version)
+		}
+		if name == "aws-ebs-csi-driver" {
+			args.ServiceAccountRoleArn = ebsCsiRole.Arn
+		}
+		// CoreDNS and the EBS CSI controller only become active once their pods
+		// are scheduled
+		addon, err := eks.NewAddon(ctx, fmt.Sprintf("%s-addon-%s", env, name), args,
+			cluster.ResourceOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+		if err != nil {
+			return err
+		}
+		cluster.EksAddons = append(cluster.EksAddons, addon)
+	}
+	return nil
+}
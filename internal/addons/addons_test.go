@@ -0,0 +1,1774 @@
+package addons
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/pulumitest"
+	"aws-go-eks/internal/stackconfig"
+)
+
+func provision(ctx *pulumi.Context, cfg *config.Config, env string) (*cluster.Cluster, error) {
+	defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := cluster.CreateShared(ctx, cfg, defaultNetwork)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.ProvisionCluster(ctx, cfg, env, shared)
+}
+
+func TestInstallArgo(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		argoCdUrl, err := InstallArgo(ctx, cfg, cluster)
+		if err != nil {
+			return err
+		}
+		// The mocked chart renders no resources, so there is no server Service
+		argoCdUrl.ApplyT(func(url string) string {
+			if url != "" {
+				t.Errorf("expected no Argo CD URL without a server Service, got %q", url)
+			}
+			return url
+		})
+		return CreateNamespaces(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	charts := m.ByType("kubernetes:helm.sh/v3:Chart")
+	if len(charts) != 2 {
+		t.Fatalf("expected argo-cd and argo-rollouts charts, got %d", len(charts))
+	}
+	var names []string
+	for _, ns := range m.ByType("kubernetes:core/v1:Namespace") {
+		names = append(names, ns.Inputs["metadata"].ObjectValue()["name"].StringValue())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "argocd,prod-app" {
+		t.Errorf("expected argocd and prod-app namespaces, got %v", names)
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	inline := pulumi.Map{
+		"replicas": pulumi.Int(2),
+		"server": pulumi.Map{
+			"service": pulumi.Map{"type": pulumi.String("ClusterIP"), "port": pulumi.Int(8080)},
+		},
+	}
+	merged := mergeValues(inline, map[string]interface{}{
+		"server": map[string]interface{}{
+			"service": map[string]interface{}{"type": "LoadBalancer"},
+		},
+	})
+	if _, ok := merged["replicas"]; !ok {
+		t.Error("expected inline-only values to be kept")
+	}
+	service := merged["server"].(pulumi.Map)["service"].(pulumi.Map)
+	if service["type"] != pulumi.String("LoadBalancer") {
+		t.Errorf("expected file values to win, got %v", service["type"])
+	}
+	if _, ok := service["port"]; !ok {
+		t.Error("expected nested inline values to be kept")
+	}
+	if inline["server"].(pulumi.Map)["service"].(pulumi.Map)["type"] != pulumi.String("ClusterIP") {
+		t.Error("expected the inline values to be left as they were")
+	}
+}
+
+func TestChartValuesFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "values-argo-cd-test.yaml"), []byte("server:\n  replicas: 3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var values pulumi.Map
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"helmValuesDir": dir}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		values, err = chartValues(cfg, "argo-cd", "test", pulumi.Map{"server": pulumi.Map{"replicas": pulumi.Int(1)}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := values["server"].(pulumi.Map)["replicas"]; replicas != pulumi.Int(3) {
+		t.Errorf("expected the file to override the inline replicas, got %v", replicas)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"helmValuesDir": dir}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := chartValues(cfg, "argo-cd", "prod", pulumi.Map{})
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "values-argo-cd-prod.yaml") {
+		t.Errorf("expected a missing values file to be an error, got %v", err)
+	}
+}
+
+func TestFargateOnly(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"enableNodeGroup":  "false",
+		"enableFargate":    "true",
+		"fargateSubnetIds": `["subnet-p1","subnet-p2"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return CreateNamespaces(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.ByType("aws:eks/nodeGroup:NodeGroup")); got != 0 {
+		t.Errorf("expected no node group, got %d", got)
+	}
+	profiles := m.ByType("aws:eks/fargateProfile:FargateProfile")
+	if len(profiles) != 1 {
+		t.Fatalf("expected one fargate profile, got %d", len(profiles))
+	}
+	if got := len(profiles[0].Inputs["selectors"].ArrayValue()); got != 4 {
+		t.Errorf("expected 4 namespace selectors, got %d", got)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"enableNodeGroup": "false"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "enableFargate") {
+		t.Errorf("expected disabling all compute to be rejected, got %v", err)
+	}
+}
+
+func TestCreateNamespaces(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"namespaces":      `[{"name":"monitoring","labels":{"team":"ops","pod-security.kubernetes.io/enforce":"privileged"}},{"name":"test-app","annotations":{"owner":"demo"}}]`,
+		"namespaceLabels": `{"pod-security.kubernetes.io/enforce":"baseline"}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+			return err
+		}
+		return CreateNamespaces(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const enforce = "pod-security.kubernetes.io/enforce"
+	expectedEnforce := map[string]string{"test-argocd-ns": "baseline", "test-app-ns": "baseline", "test-ns-monitoring": "privileged"}
+	var names []string
+	for _, ns := range m.ByType("kubernetes:core/v1:Namespace") {
+		labels := ns.Inputs["metadata"].ObjectValue()["labels"].ObjectValue()
+		if got := labels[enforce].StringValue(); got != expectedEnforce[ns.Name] {
+			t.Errorf("expected %s=%s on %s, got %q", enforce, expectedEnforce[ns.Name], ns.Name, got)
+		}
+		if ns.Name == "test-argocd-ns" {
+			continue
+		}
+		names = append(names, ns.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "test-app-ns,test-ns-monitoring" {
+		t.Errorf("expected the app and monitoring namespaces, got %v", names)
+	}
+
+	for _, invalid := range []string{`[{"name":"Team_A"}]`, `[{"name":"argocd"}]`, `[{"name":"a"},{"name":"a"}]`} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"namespaces": invalid}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			_, err := namespacesConfig(cfg, "test")
+			return err
+		})
+		if err == nil {
+			t.Errorf("expected namespaces %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestNamespacesWaitForNodeGroup(t *testing.T) {
+	m := pulumitest.NewMocks()
+	m.SlowType = "aws:eks/nodeGroup:NodeGroup"
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if err := CreateNamespaces(ctx, cfg, cluster); err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := map[string]int{}
+	for i, r := range m.Registered() {
+		registered[r.Name] = i
+	}
+	nodeGroup, ok := registered["test-aws-demo-node-group"]
+	if !ok {
+		t.Fatal("expected the test node group")
+	}
+	for _, name := range []string{"test-app-ns", "test-argocd-ns"} {
+		if i, ok := registered[name]; !ok || i < nodeGroup {
+			t.Errorf("expected %s to be created once the node group is, got position %d of %d", name, i, nodeGroup)
+		}
+	}
+}
+
+func TestArgoFinalizerCleanup(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		m := pulumitest.NewMocks()
+		values := map[string]string{"argoCleanupFinalizers": fmt.Sprint(enabled)}
+		err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			cluster, err := provision(ctx, cfg, "test")
+			if err != nil {
+				return err
+			}
+			_, err = InstallArgo(ctx, cfg, cluster)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cleanups []pulumi.MockResourceArgs
+		for _, c := range m.ByType("command:local:Command") {
+			if c.Name == "test-argocd-finalizer-cleanup" {
+				cleanups = append(cleanups, c)
+			}
+		}
+		if !enabled {
+			if len(cleanups) != 0 {
+				t.Errorf("expected no cleanup unless argoCleanupFinalizers is set, got %v", cleanups)
+			}
+			continue
+		}
+		if len(cleanups) != 1 {
+			t.Fatalf("expected a finalizer cleanup for test, got %v", cleanups)
+		}
+		// Only runs on destroy
+		if inputs := cleanups[0].Inputs; inputs.HasValue("create") ||
+			!strings.Contains(inputs["delete"].StringValue(), `{"metadata":{"finalizers":null}}`) ||
+			!inputs["environment"].ObjectValue().HasValue("KUBECONFIG_DATA") {
+			t.Errorf("expected a delete step clearing the Applications' finalizers with the kubeconfig, got %v", inputs)
+		}
+	}
+}
+
+func TestArm64NodeGroup(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"nodeArchitecture": "arm64"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeGroup := m.ByType("aws:eks/nodeGroup:NodeGroup")[0]
+	if nodeGroup.Inputs["amiType"].StringValue() != "AL2_ARM_64" {
+		t.Errorf("expected an arm64 AMI type, got %v", nodeGroup.Inputs["amiType"])
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeArchitecture": "arm64"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return cluster.CheckChartArchitecture(cfg, "some-x86-only-chart")
+	})
+	if err == nil {
+		t.Error("expected a chart without known arm64 images to be refused")
+	}
+}
+
+func TestCorednsZones(t *testing.T) {
+	var zones []corednsZone
+	values := map[string]string{"corednsZones": `[{"zone":"corp.example.com","upstreams":["10.0.0.2","10.0.0.3"]}]`}
+	err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		zones, err = corednsZonesConfig(cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(corefile(zones), "corp.example.com:53 {\n    errors\n    cache 30\n    forward . 10.0.0.2 10.0.0.3\n") {
+		t.Errorf("expected a forwarding block for corp.example.com, got\n%s", corefile(zones))
+	}
+
+	for _, invalid := range []string{`[{"zone":"Corp_Example","upstreams":["10.0.0.2"]}]`, `[{"zone":"corp.example.com","upstreams":["dns.example.com"]}]`, `[{"zone":"corp.example.com"}]`} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"corednsZones": invalid}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			_, err := corednsZonesConfig(cfg)
+			return err
+		})
+		if err == nil {
+			t.Errorf("expected corednsZones %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestArgoCdHaNeedsThreeNodes(t *testing.T) {
+	// HA is opted into, prod included
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeDesiredSize": "2"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Errorf("expected prod to install Argo CD without HA by default, got %v", err)
+	}
+
+	values := map[string]string{"nodeDesiredSize": "2", "argoCdHa": `{"prod": true}`}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "argoCdHa") {
+		t.Errorf("expected HA on two nodes to be rejected, got %v", err)
+	}
+}
+
+func TestKubernetesProviders(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"enableContainerInsights": "true",
+		"corednsZones":            `[{"zone":"corp.example.com","upstreams":["10.0.0.2"]}]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		network, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := cluster.CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := cluster.ProvisionCluster(ctx, cfg, env, shared)
+			if err != nil {
+				return err
+			}
+			if err := ConfigureCoreDns(ctx, cfg, cluster); err != nil {
+				return err
+			}
+			if err := InstallContainerInsights(ctx, cfg, cluster); err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+			if err := CreateNamespaces(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checked int
+	for _, r := range m.Registered() {
+		if !strings.HasPrefix(r.TypeToken, "kubernetes:") || !r.Custom {
+			continue
+		}
+		env := r.Name[:strings.Index(r.Name, "-")]
+		provider := env + "-k8sprovider"
+		if strings.HasSuffix(r.TypeToken, "Patch") {
+			provider = env + "-k8s-ssa-provider"
+		}
+		if !strings.Contains(r.Provider, "::"+provider+"::") {
+			t.Errorf("expected %s to use %s, got %q", r.Name, provider, r.Provider)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("expected Kubernetes resources to check")
+	}
+}
+
+func TestPostInstallKubectl(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"postInstallKubectl": `["annotate storageclass gp2 storageclass.kubernetes.io/is-default-class=false --overwrite", "kubectl -n kube-system get pods"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if err := CreateNamespaces(ctx, cfg, cluster); err != nil {
+			return err
+		}
+		return RunPostInstallKubectl(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := m.ByType("command:local:Command")
+	if len(commands) != 1 || commands[0].Name != "test-post-install-kubectl" {
+		t.Fatalf("expected one post-install command, got %v", commands)
+	}
+	script := commands[0].Inputs["create"].StringValue()
+	if !strings.Contains(script, "kubectl --kubeconfig \"$kubeconfig\" annotate storageclass gp2") ||
+		!strings.Contains(script, "kubectl --kubeconfig \"$kubeconfig\" -n kube-system get pods\n") {
+		t.Errorf("expected both commands against the kubeconfig, got\n%s", script)
+	}
+	if kubeconfig := commands[0].Inputs["environment"].ObjectValue()["KUBECONFIG_DATA"]; !kubeconfig.IsSecret() {
+		t.Errorf("expected the kubeconfig to be passed as a secret, got %v", kubeconfig)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"postInstallKubectl": `["kubectl "]`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := postInstallKubectlConfig(cfg)
+		return err
+	})
+	if err == nil {
+		t.Error("expected an empty command to be rejected")
+	}
+}
+
+func TestNodeTerminationHandler(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"nodeTerminationHandler": `{"prod": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if err := InstallNodeTerminationHandler(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	charts := m.ByType("kubernetes:helm.sh/v3:Chart")
+	if len(charts) != 1 || charts[0].Name != "prod-aws-node-termination-handler" {
+		t.Errorf("expected the handler in prod only, got %v", charts)
+	}
+
+	values := map[string]string{"nodeTerminationHandler": `{"test": true}`, "enableNodeGroup": "false", "enableFargate": "true", "fargateSubnetIds": `["subnet-p1"]`}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallNodeTerminationHandler(ctx, cfg, cluster)
+	})
+	if err == nil || !strings.Contains(err.Error(), "no node group") {
+		t.Errorf("expected the handler to be refused without nodes, got %v", err)
+	}
+}
+
+func TestChartInventory(t *testing.T) {
+	var inventory string
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"chartVersions": `{"argo-cd": "5.46.7"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		c, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if _, err := InstallArgo(ctx, cfg, c); err != nil {
+			return err
+		}
+		inventory, err = ChartInventory([]*cluster.Cluster{c})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"test":[` +
+		`{"name":"argo-cd","namespace":"argocd","repo":"https://argoproj.github.io/argo-helm","version":"5.46.7"},` +
+		`{"name":"argo-rollouts","namespace":"argocd","repo":"https://argoproj.github.io/argo-helm","version":"latest"}]}`
+	if inventory != expected {
+		t.Errorf("expected inventory\n%s\ngot\n%s", expected, inventory)
+	}
+}
+
+func TestVpcCniPrefixDelegation(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"vpcCniPrefixDelegation": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return ConfigureVpcCni(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches := m.ByType("kubernetes:apps/v1:DaemonSetPatch")
+	if len(patches) != 1 || !strings.Contains(patches[0].Provider, "::test-k8s-ssa-provider::") {
+		t.Fatalf("expected one aws-node patch through the server-side apply provider, got %v", patches)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"vpcCniPrefixDelegation": "true", "nodeInstanceType": "t2.small"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs a Nitro instance type") {
+		t.Errorf("expected t2.small to be rejected, got %v", err)
+	}
+}
+
+func TestArgoNotifications(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"argoNotifications":       `{"prod": true}`,
+		"argoNotificationsConfig": `{"notifiers": {"service.slack": "token: $slack-token"}}`,
+		"argoNotificationsSecret": `{"slack-token": "xoxb-123"}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := m.ByType("kubernetes:core/v1:Secret")
+	if len(secrets) != 1 || secrets[0].Name != "prod-argocd-notifications-secret" {
+		t.Fatalf("expected the notifications secret in prod only, got %v", secrets)
+	}
+	if data := secrets[0].Inputs["stringData"]; !data.IsSecret() {
+		t.Errorf("expected the notifier tokens to be passed as a secret, got %v", data)
+	}
+
+	values["argoNotificationsConfig"] = `{}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "no notifiers") {
+		t.Errorf("expected notifications without notifiers to be rejected, got %v", err)
+	}
+}
+
+func TestArgoAdminPassword(t *testing.T) {
+	m := pulumitest.NewMocks()
+	hash := "$2a$10$rRyBsGSHK6.uc8fntPwVIuLVHgsAhAX7TcdrqW/RADU0uh7CaChLa"
+	err := pulumitest.Run(t, m, map[string]string{"argoAdminPasswordBcrypt": hash}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := m.ByType("kubernetes:core/v1:Secret")
+	if len(secrets) != 1 || secrets[0].Name != "prod-argocd-secret" {
+		t.Fatalf("expected the argocd-secret, got %v", secrets)
+	}
+	data := secrets[0].Inputs["stringData"]
+	if !data.IsSecret() {
+		t.Fatalf("expected the password hash to be passed as a secret, got %v", data)
+	}
+	if got := data.SecretValue().Element.ObjectValue()["admin.password"].StringValue(); got != hash {
+		t.Errorf("expected the configured hash, got %q", got)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoAdminPasswordBcrypt": "hunter2"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "bcrypt") || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected a plain password to be rejected without echoing it, got %v", err)
+	}
+}
+
+func TestArgoDedicatedNodes(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"argoDedicatedNodes": `{"test": true}`,
+		"argoNodeTaint":      `{"key": "example.com/role", "value": "gitops"}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var argoGroup *pulumi.MockResourceArgs
+	for _, nodeGroup := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		nodeGroup := nodeGroup
+		if nodeGroup.Name == "test-aws-demo-node-group-argo" {
+			argoGroup = &nodeGroup
+		} else if _, ok := nodeGroup.Inputs["taints"]; ok {
+			t.Errorf("expected only the Argo node group to be tainted, got %v", nodeGroup.Inputs["taints"])
+		}
+	}
+	if argoGroup == nil {
+		t.Fatal("expected a dedicated Argo node group")
+	}
+	taints := argoGroup.Inputs["taints"].ArrayValue()
+	if len(taints) != 1 || taints[0].ObjectValue()["key"].StringValue() != "example.com/role" || taints[0].ObjectValue()["effect"].StringValue() != "NO_SCHEDULE" {
+		t.Errorf("unexpected taints %v", taints)
+	}
+	if got := argoGroup.Inputs["labels"].ObjectValue()["example.com/role"].StringValue(); got != "gitops" {
+		t.Errorf("expected the taint to be the node label too, got %q", got)
+	}
+	if got := argoGroup.Inputs["scalingConfig"].ObjectValue()["desiredSize"].NumberValue(); got != 3 {
+		t.Errorf("expected 3 Argo nodes by default, got %v", got)
+	}
+
+	values["argoNodeTaint"] = `{"key": "not a key", "value": "argo"}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "argoNodeTaint") {
+		t.Errorf("expected an invalid taint key to be rejected, got %v", err)
+	}
+}
+
+func TestCreateAppServiceAccount(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"appIrsa":           `{"prod": true}`,
+		"appIrsaPolicyArns": `["arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if err := CreateNamespaces(ctx, cfg, cluster); err != nil {
+				return err
+			}
+			if _, err := CreateAppServiceAccount(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := m.ByType("kubernetes:core/v1:ServiceAccount")
+	if len(accounts) != 1 || accounts[0].Name != "prod-app-sa" {
+		t.Fatalf("expected the app service account in prod only, got %v", accounts)
+	}
+	metadata := accounts[0].Inputs["metadata"].ObjectValue()
+	if metadata["namespace"].StringValue() != "prod-app" || metadata["name"].StringValue() != "app" {
+		t.Errorf("unexpected service account metadata %v", metadata)
+	}
+	if _, ok := metadata["annotations"].ObjectValue()["eks.amazonaws.com/role-arn"]; !ok {
+		t.Errorf("expected the service account to be annotated with the IRSA role, got %v", metadata)
+	}
+	var attached bool
+	for _, attachment := range m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		if attachment.Name == "prod-app-irsa-policy-AmazonS3ReadOnlyAccess" {
+			attached = true
+		}
+	}
+	if !attached {
+		t.Error("expected the configured policy to be attached to the app role")
+	}
+
+	values["appIrsaPolicyArns"] = `["AmazonS3ReadOnlyAccess"]`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = CreateAppServiceAccount(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "appIrsaPolicyArns") {
+		t.Errorf("expected a policy name instead of an ARN to be rejected, got %v", err)
+	}
+}
+
+func TestInstallSecretsController(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"secretsController": "external-secrets"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if err := CreateNamespaces(ctx, cfg, cluster); err != nil {
+			return err
+		}
+		return InstallSecretsController(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := m.ByType("aws:iam/rolePolicy:RolePolicy")
+	var readPolicy string
+	for _, policy := range policies {
+		if policy.Name == "test-external-secrets-irsa-read-policy" {
+			readPolicy = policy.Inputs["policy"].StringValue()
+		}
+	}
+	if !strings.Contains(readPolicy, "arn:aws:secretsmanager:eu-west-1:123456789012:secret:*") {
+		t.Errorf("expected the read policy to be scoped to the region and account, got %s", readPolicy)
+	}
+	stores := m.ByType("kubernetes:external-secrets.io/v1beta1:SecretStore")
+	if len(stores) != 1 {
+		t.Fatalf("expected a sample SecretStore, got %d", len(stores))
+	}
+	aws := stores[0].Inputs["spec"].ObjectValue()["provider"].ObjectValue()["aws"].ObjectValue()
+	if aws["region"].StringValue() != "eu-west-1" || aws["service"].StringValue() != "SecretsManager" {
+		t.Errorf("unexpected SecretStore provider %v", aws)
+	}
+
+	m = pulumitest.NewMocks()
+	err = pulumitest.Run(t, m, map[string]string{"secretsController": "sealed-secrets"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallSecretsController(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.ByType("kubernetes:external-secrets.io/v1beta1:SecretStore")); got != 0 {
+		t.Errorf("expected no SecretStore with sealed-secrets, got %d", got)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"secretsController": "vault"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallSecretsController(ctx, cfg, cluster)
+	})
+	if err == nil || !strings.Contains(err.Error(), "secretsController") {
+		t.Errorf("expected an unknown controller to be rejected, got %v", err)
+	}
+}
+
+func TestLogRetention(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"logRetentionDays": "14", "containerInsightsLogRetentionDays": "90"}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		if _, err := network.CreateFlowLogs(ctx, cfg, pulumi.String("vpc-123")); err != nil {
+			return err
+		}
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallContainerInsights(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, logGroup := range m.ByType("aws:cloudwatch/logGroup:LogGroup") {
+		want := 90.0
+		if logGroup.Name == "vpc-flow-logs" {
+			want = 14
+		}
+		if got := logGroup.Inputs["retentionInDays"].NumberValue(); got != want {
+			t.Errorf("expected %s to keep events for %v days, got %v", logGroup.Name, want, got)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"logRetentionDays": "10"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := network.CreateFlowLogs(ctx, cfg, pulumi.String("vpc-123"))
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "retention") {
+		t.Errorf("expected a period CloudWatch does not offer to be rejected, got %v", err)
+	}
+}
+
+func TestNodeScaleToZero(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"nodeScaleToZero":   `{"test": true}`,
+		"clusterAutoscaler": `{"test": true}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallClusterAutoscaler(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeGroups := m.ByType("aws:eks/nodeGroup:NodeGroup")
+	if len(nodeGroups) != 1 || nodeGroups[0].Inputs["scalingConfig"].ObjectValue()["minSize"].NumberValue() != 0 {
+		t.Fatalf("expected the node group to scale to zero, got %v", nodeGroups)
+	}
+	tags := m.ByType("aws:autoscaling/tag:Tag")
+	if len(tags) != 1 || tags[0].Inputs["tag"].ObjectValue()["value"].StringValue() != "20Gi" {
+		t.Errorf("expected the ephemeral storage scale-from-zero tag, got %v", tags)
+	}
+	var policy string
+	for _, p := range m.ByType("aws:iam/rolePolicy:RolePolicy") {
+		if p.Name == "test-cluster-autoscaler-irsa-policy" {
+			policy = p.Inputs["policy"].StringValue()
+		}
+	}
+	if !strings.Contains(policy, "k8s.io/cluster-autoscaler/test-aws-demo") {
+		t.Errorf("expected resizing to be limited to the cluster's groups, got %s", policy)
+	}
+
+	values["clusterAutoscaler"] = `{"prod": true}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterAutoscaler") {
+		t.Errorf("expected scaling to zero without the autoscaler to be rejected, got %v", err)
+	}
+}
+
+func TestArgoCdLoadBalancerScheme(t *testing.T) {
+	values := map[string]string{"argoCdLoadBalancerScheme": `{"prod": "internal"}`}
+	err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for env, want := range map[string]string{"prod": "internal", "test": "internet-facing"} {
+			scheme, err := argoCdLoadBalancerScheme(cfg, env)
+			if err != nil {
+				return err
+			}
+			if scheme != want {
+				t.Errorf("expected %s to get a %s load balancer, got %s", env, want, scheme)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values["argoCdLoadBalancerScheme"] = `{"prod": "private"}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "argoCdLoadBalancerScheme") {
+		t.Errorf("expected an unknown scheme to be rejected, got %v", err)
+	}
+}
+
+func TestArgoCdRedis(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"argoCdRedis":                 `{"prod": "external"}`,
+		"argoCdExternalRedis":         `{"host": "redis.example.internal"}`,
+		"argoCdExternalRedisPassword": "s3cret",
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := m.ByType("kubernetes:core/v1:Secret")
+	if len(secrets) != 1 || secrets[0].Name != "prod-argocd-external-redis" || !secrets[0].Inputs["stringData"].IsSecret() {
+		t.Errorf("expected the external Redis password in a secret, got %v", secrets)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoCdHa": `{"prod": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for env, want := range map[string]string{"test": "bundled", "prod": "ha"} {
+			ha, err := stackconfig.GetEnvBool(cfg, "argoCdHa", env, false)
+			if err != nil {
+				return err
+			}
+			mode, err := argoCdRedisMode(cfg, env, ha)
+			if err != nil {
+				return err
+			}
+			if mode != want {
+				t.Errorf("expected %s to default to %s Redis, got %s", env, want, mode)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values = map[string]string{"argoCdRedis": `{"test": "external"}`}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "no host") {
+		t.Errorf("expected an external Redis without a host to be rejected, got %v", err)
+	}
+}
+
+func TestAmpWorkspace(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"amp": `{"prod": true}`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			workspace, _, err := CreateAmpWorkspace(ctx, cfg, cluster)
+			if err != nil {
+				return err
+			}
+			if (workspace != nil) != (env == "prod") {
+				t.Errorf("expected a workspace only for prod, got %v for %s", workspace, env)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspaces := m.ByType("aws:amp/workspace:Workspace")
+	if len(workspaces) != 1 || workspaces[0].Inputs["alias"].StringValue() != "prod-aws-demo" {
+		t.Errorf("expected one prod-aws-demo workspace, got %v", workspaces)
+	}
+	policies := m.ByType("aws:iam/rolePolicy:RolePolicy")
+	found := false
+	for _, p := range policies {
+		if p.Name == "prod-prometheus-irsa-remote-write-policy" {
+			found = strings.Contains(p.Inputs["policy"].StringValue(), "aps:RemoteWrite")
+		}
+	}
+	if !found {
+		t.Errorf("expected Prometheus to be granted aps:RemoteWrite, got %v", policies)
+	}
+}
+
+func TestCustomNetworking(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"podSubnets": `{"eu-west-1a": "172.31.128.0/20", "eu-west-1b": "172.31.144.0/20", "us-east-1a": "10.1.0.0/20"}`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return ConfigureVpcCni(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subnets []string
+	for _, s := range m.ByType("aws:ec2/subnet:Subnet") {
+		subnets = append(subnets, s.Name)
+	}
+	sort.Strings(subnets)
+	if strings.Join(subnets, " ") != "pod-subnet-eu-west-1a pod-subnet-eu-west-1b" {
+		t.Errorf("expected a pod subnet in each of the region's zones, got %v", subnets)
+	}
+	var eniConfigs []string
+	for _, r := range m.ByType("kubernetes:crd.k8s.amazonaws.com/v1alpha1:ENIConfig") {
+		eniConfigs = append(eniConfigs, r.Inputs["metadata"].ObjectValue()["name"].StringValue())
+	}
+	sort.Strings(eniConfigs)
+	if strings.Join(eniConfigs, " ") != "eu-west-1a eu-west-1b" {
+		t.Errorf("expected an ENIConfig named after each zone, got %v", eniConfigs)
+	}
+	patches := m.ByType("kubernetes:apps/v1:DaemonSetPatch")
+	if len(patches) != 1 || !strings.Contains(patches[0].Inputs["spec"].String(), "AWS_VPC_K8S_CNI_CUSTOM_NETWORK_CFG") {
+		t.Errorf("expected aws-node to be patched for custom networking, got %v", patches)
+	}
+
+	for podSubnets, want := range map[string]string{
+		`{"eu-west-1a": "172.31.128.0/20"}`:                                                                   "no CIDR for eu-west-1b",
+		`{"eu-west-1a": "172.31.128.0/20", "eu-west-1b": "100.64.0.0/20"}`:                                    "not inside any of the VPC CIDRs",
+		`{"eu-west-1a": "172.31.128.0/20", "eu-west-1b": "172.31.144.0/20", "eu-west-1c": "172.31.160.0/20"}`: "no node subnets",
+	} {
+		err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"podSubnets": podSubnets}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			_, err := provision(ctx, cfg, "test")
+			return err
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected podSubnets %s to be rejected with %q, got %v", podSubnets, want, err)
+		}
+	}
+}
+
+func TestArgoProjects(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"argoProjects": `{"prod": true}`,
+		"argoProjectsConfig": `[{"name": "team-a", "sourceRepos": ["https://github.com/example/team-a"],
+			"destinations": [{"server": "https://kubernetes.default.svc", "namespace": "team-a"}],
+			"namespaceResourceWhitelist": [{"group": "apps", "kind": "Deployment"}],
+			"roles": [{"name": "deployer", "policies": ["p, proj:team-a:deployer, applications, sync, team-a/*, allow"]}]}]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := m.ByType("kubernetes:argoproj.io/v1alpha1:AppProject")
+	if len(projects) != 1 || projects[0].Name != "prod-argocd-project-team-a" {
+		t.Fatalf("expected the team-a project in prod only, got %v", projects)
+	}
+	spec := projects[0].Inputs["spec"].ObjectValue()
+	if _, ok := spec["name"]; ok || spec["sourceRepos"].ArrayValue()[0].StringValue() != "https://github.com/example/team-a" {
+		t.Errorf("expected the project spec to be passed through without the name, got %v", spec)
+	}
+
+	for projects, want := range map[string]string{
+		`[{"name": "default", "sourceRepos": ["*"], "destinations": [{"server": "*", "namespace": "*"}]}]`: "default project",
+		`[{"name": "team-a", "destinations": [{"server": "*", "namespace": "*"}]}]`:                        "no sourceRepos",
+		`[{"name": "team-a", "sourceRepos": ["*"], "destinations": [{"namespace": "team-a"}]}]`:            "one of server or name",
+		`[{"name": "team-a", "sourceRepos": ["*"], "destinations": [{"server": "*", "namespace": "*"}],
+			"roles": [{"name": "ci", "policies": ["p, proj:team-b:ci, applications, sync, team-b/*, allow"]}]}]`: "must look like",
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoProjectsConfig": projects}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected argoProjectsConfig %s to be rejected with %q, got %v", projects, want, err)
+		}
+	}
+}
+
+func TestGpuNodes(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"gpuNodes": `{"prod": true}`, "gpuNodeCount": "2"}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if err := InstallGpuDevicePlugin(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gpuGroup *pulumi.MockResourceArgs
+	for _, ng := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		if strings.HasSuffix(ng.Name, "-gpu") {
+			if gpuGroup != nil {
+				t.Fatalf("expected a GPU node group in prod only, also got %s", ng.Name)
+			}
+			ng := ng
+			gpuGroup = &ng
+		}
+	}
+	if gpuGroup == nil || gpuGroup.Name != "prod-aws-demo-node-group-gpu" {
+		t.Fatalf("expected the prod-aws-demo-node-group-gpu node group, got %v", gpuGroup)
+	}
+	if gpuGroup.Inputs["amiType"].StringValue() != "AL2_x86_64_GPU" ||
+		gpuGroup.Inputs["instanceTypes"].ArrayValue()[0].StringValue() != "g4dn.xlarge" ||
+		gpuGroup.Inputs["scalingConfig"].ObjectValue()["desiredSize"].NumberValue() != 2 {
+		t.Errorf("expected two g4dn.xlarge nodes on the GPU AMI, got %v", gpuGroup.Inputs)
+	}
+	taint := gpuGroup.Inputs["taints"].ArrayValue()[0].ObjectValue()
+	if taint["key"].StringValue() != "nvidia.com/gpu" || taint["effect"].StringValue() != "NO_SCHEDULE" {
+		t.Errorf("expected the GPU nodes to be tainted nvidia.com/gpu, got %v", taint)
+	}
+	charts := m.ByType("kubernetes:helm.sh/v3:Chart")
+	if len(charts) != 1 || charts[0].Name != "prod-nvidia-device-plugin" {
+		t.Errorf("expected the device plugin in prod only, got %v", charts)
+	}
+
+	values["gpuNodeInstanceType"] = "m5.large"
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "prod")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "has no NVIDIA GPUs") {
+		t.Errorf("expected m5.large to be rejected for GPU nodes, got %v", err)
+	}
+}
+
+func TestChartRepoCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/charts/index.yaml":
+		case "/get-only/index.yaml":
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	values := map[string]string{"skipHelmRepoCheck": "false"}
+	err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for repo, want := range map[string]string{
+			server.URL + "/charts":   "",
+			server.URL + "/charts/":  "",
+			server.URL + "/get-only": "",
+			server.URL + "/missing":  "answered 404 Not Found",
+			"http://127.0.0.1:1":     "is unreachable",
+			"oci://registry.example": "",
+		} {
+			err := checkChartRepo(cfg, chartSpec{Name: "demo", Repo: repo})
+			if want == "" && err != nil {
+				t.Errorf("expected %s to pass, got %v", repo, err)
+			}
+			if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+				t.Errorf("expected %s to fail with %q, got %v", repo, want, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"skipHelmRepoCheck": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return checkChartRepo(cfg, chartSpec{Name: "demo", Repo: server.URL + "/missing"})
+	})
+	if err != nil {
+		t.Errorf("expected skipHelmRepoCheck to skip the check, got %v", err)
+	}
+}
+
+func TestImagePrepuller(t *testing.T) {
+	dir := t.TempDir()
+	values := "global:\n  image:\n    repository: quay.io/argoproj/argocd\n    tag: v2.4.7\n"
+	if err := os.WriteFile(filepath.Join(dir, "values-argo-cd-test.yaml"), []byte(values), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Leaves the Argo Rollouts image to its chart
+	if err := os.WriteFile(filepath.Join(dir, "values-argo-rollouts-test.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"imagePrepull": `{"test": true}`, "helmValuesDir": dir}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if err := InstallImagePrepuller(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemonSets := m.ByType("kubernetes:apps/v1:DaemonSet")
+	if len(daemonSets) != 1 || !strings.Contains(daemonSets[0].Inputs["spec"].String(), "quay.io/argoproj/argocd:v2.4.7") {
+		t.Errorf("expected one prepuller for test pulling the pinned argocd image, got %v", daemonSets)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"imagePrepull": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallImagePrepuller(ctx, cfg, cluster)
+	})
+	if err == nil || !strings.Contains(err.Error(), "no Argo image is pinned") {
+		t.Errorf("expected the prepuller to need a pinned image, got %v", err)
+	}
+}
+
+func TestSmokeTest(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"smokeTest": `{"prod": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := RunSmokeTest(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := m.ByType("kubernetes:batch/v1:Job")
+	if len(jobs) != 1 || jobs[0].Name != "prod-smoke-test" {
+		t.Fatalf("expected a smoke test job for prod only, got %v", jobs)
+	}
+	container := jobs[0].Inputs["spec"].ObjectValue()["template"].ObjectValue()["spec"].ObjectValue()["containers"].ArrayValue()[0]
+	script := container.ObjectValue()["command"].ArrayValue()[2].StringValue()
+	if !strings.Contains(script, "https://prod-prod-argo-cd-server.argocd.svc/healthz") {
+		t.Errorf("expected the test to reach prod's Argo CD server service, got %s", script)
+	}
+	var waits []string
+	for _, c := range m.ByType("command:local:Command") {
+		if strings.Contains(c.Inputs["create"].StringValue(), "wait --for=condition=complete") {
+			waits = append(waits, c.Name)
+		}
+	}
+	if strings.Join(waits, " ") != "prod-smoke-test-wait" {
+		t.Errorf("expected the prod job to be waited for, got %v", waits)
+	}
+}
+
+func TestAppKustomization(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"appKustomizeDir": fmt.Sprintf(`{"test": %q}`, dir)}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return DeployAppKustomization(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps := m.ByType("kubernetes:core/v1:ConfigMap")
+	if len(configMaps) != 1 || configMaps[0].Inputs["metadata"].ObjectValue()["namespace"].StringValue() != "test-app" {
+		t.Errorf("expected the overlay's ConfigMap in the app namespace, got %v", configMaps)
+	}
+	clusterRoles := m.ByType("kubernetes:rbac.authorization.k8s.io/v1:ClusterRole")
+	if len(clusterRoles) != 1 || clusterRoles[0].Inputs["metadata"].ObjectValue()["namespace"].HasValue() {
+		t.Errorf("expected the overlay's ClusterRole to stay cluster scoped, got %v", clusterRoles)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"appKustomizeDir": fmt.Sprintf(`{"test": %q}`, t.TempDir())}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "no kustomization.yaml") {
+		t.Errorf("expected a directory without a kustomization to be refused, got %v", err)
+	}
+}
+
+func TestLoadBalancerController(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"loadBalancerController": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallLoadBalancerController(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	charts := m.ByType("kubernetes:helm.sh/v3:Chart")
+	if len(charts) != 1 || charts[0].Name != "test-aws-load-balancer-controller" {
+		t.Fatalf("expected the controller in test only, got %v", charts)
+	}
+	var trust, policy string
+	for _, role := range m.ByType("aws:iam/role:Role") {
+		if role.Name == "test-load-balancer-controller-irsa" {
+			trust = role.Inputs["assumeRolePolicy"].StringValue()
+		}
+	}
+	for _, p := range m.ByType("aws:iam/rolePolicy:RolePolicy") {
+		if p.Name == "test-load-balancer-controller-irsa-policy" {
+			policy = p.Inputs["policy"].StringValue()
+		}
+	}
+	if !strings.Contains(trust, "system:serviceaccount:kube-system:aws-load-balancer-controller") {
+		t.Errorf("expected the role to trust the controller's service account, got %s", trust)
+	}
+	if !strings.Contains(policy, "elasticloadbalancing:CreateLoadBalancer") {
+		t.Errorf("expected the controller's policy on the role, got %s", policy)
+	}
+
+	m = pulumitest.NewMocks()
+	values := map[string]string{
+		"loadBalancerController":               `{"test": true}`,
+		"loadBalancerControllerServiceAccount": `{"annotations": {"example.com/owner": "platform"}, "labels": {"team": "platform"}}`,
+		"loadBalancerControllerNodeTaint":      `{"key": "dedicated", "value": "system"}`,
+		"helmRelease":                          `{"test": true}`,
+		"chartVersions":                        `{"aws-load-balancer-controller": "1.6.2"}`,
+	}
+	err = pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = InstallLoadBalancerController(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases := m.ByType("kubernetes:helm.sh/v3:Release")
+	if len(releases) != 1 {
+		t.Fatalf("expected the controller's release, got %v", releases)
+	}
+	chartValues := releases[0].Inputs["values"].ObjectValue()
+	annotations := chartValues["serviceAccount"].ObjectValue()["annotations"].ObjectValue()
+	if annotations["example.com/owner"].StringValue() != "platform" ||
+		!strings.HasSuffix(annotations["eks.amazonaws.com/role-arn"].StringValue(), "test-load-balancer-controller-irsa") {
+		t.Errorf("expected the extra annotation next to the role ARN, got %v", annotations)
+	}
+	// The labels go on the service account alone, not on every object of the chart
+	if labels, ok := chartValues["additionalLabels"]; ok {
+		t.Errorf("expected no labels for all of the chart's objects, got %v", labels)
+	}
+	patches := m.ByType("kubernetes:core/v1:ServiceAccountPatch")
+	if len(patches) != 1 {
+		t.Fatalf("expected the service account's labels to be patched, got %v", patches)
+	}
+	patched := patches[0].Inputs["metadata"].ObjectValue()
+	if patched["name"].StringValue() != "aws-load-balancer-controller" || patched["namespace"].StringValue() != "kube-system" ||
+		patched["labels"].ObjectValue()["team"].StringValue() != "platform" || !strings.Contains(patches[0].Provider, "test-k8s-ssa-provider") {
+		t.Errorf("expected the team=platform label on the controller's service account, got %v", patches[0])
+	}
+	toleration := chartValues["tolerations"].ArrayValue()[0].ObjectValue()
+	if toleration["key"].StringValue() != "dedicated" || toleration["value"].StringValue() != "system" ||
+		toleration["effect"].StringValue() != "NoSchedule" ||
+		chartValues["nodeSelector"].ObjectValue()["dedicated"].StringValue() != "system" {
+		t.Errorf("expected the controller on the dedicated=system nodes, got %v and %v", chartValues["tolerations"], chartValues["nodeSelector"])
+	}
+
+	for value, want := range map[string]string{
+		`{"annotations": {"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/other"}}`: "cannot set the eks.amazonaws.com/role-arn annotation",
+		`{"labels": {"team": "platform team"}}`:                                                   "is not a Kubernetes label",
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"loadBalancerControllerServiceAccount": value}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", value, want, err)
+		}
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"loadBalancerControllerNodeTaint": `{"key": "dedicated", "value": "system nodes"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "loadBalancerControllerNodeTaint value must be a Kubernetes label value") {
+		t.Errorf("expected an invalid taint to be rejected, got %v", err)
+	}
+}
+
+func TestNewIrsaRole(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = NewIrsaRole(ctx, cluster, "kube-system", "external-dns",
+			"arn:aws:iam::aws:policy/AmazonRoute53FullAccess")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := m.ByType("kubernetes:core/v1:ServiceAccount")
+	if len(accounts) != 1 || accounts[0].Name != "test-external-dns-sa" {
+		t.Fatalf("expected the external-dns service account, got %v", accounts)
+	}
+	metadata := accounts[0].Inputs["metadata"].ObjectValue()
+	if metadata["namespace"].StringValue() != "kube-system" ||
+		metadata["annotations"].ObjectValue()["eks.amazonaws.com/role-arn"].StringValue() !=
+			"arn:aws:iam::123456789012:role/test-external-dns-irsa" {
+		t.Errorf("expected the service account in kube-system annotated with its role, got %v", metadata)
+	}
+	var trust string
+	for _, role := range m.ByType("aws:iam/role:Role") {
+		if role.Name == "test-external-dns-irsa" {
+			trust = role.Inputs["assumeRolePolicy"].StringValue()
+		}
+	}
+	if !strings.Contains(trust, "system:serviceaccount:kube-system:external-dns") {
+		t.Errorf("expected the role to trust only the service account, got %s", trust)
+	}
+	attachments := m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment")
+	var attached bool
+	for _, attachment := range attachments {
+		if attachment.Name == "test-external-dns-irsa-policy-AmazonRoute53FullAccess" {
+			attached = true
+		}
+	}
+	if !attached {
+		t.Errorf("expected the policy to be attached to the role, got %v", attachments)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = NewIrsaRole(ctx, cluster, "kube-system", "external-dns", "AmazonRoute53FullAccess")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "IAM policy ARNs") {
+		t.Errorf("expected a policy name instead of an ARN to be rejected, got %v", err)
+	}
+}
+
+func TestKarpenter(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"karpenter":         `{"test": true}`,
+		"karpenterCpuLimit": "40",
+		"enableNodeGroup":   "false",
+		"enableFargate":     "true",
+		"fargateSubnetIds":  `["subnet-p1","subnet-p2"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return InstallKarpenter(ctx, cfg, cluster)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	charts := m.ByType("kubernetes:helm.sh/v3:Chart")
+	if len(charts) != 1 || charts[0].Name != "test-karpenter" {
+		t.Fatalf("expected the karpenter chart, got %v", charts)
+	}
+	if queues := m.ByType("aws:sqs/queue:Queue"); len(queues) != 1 {
+		t.Errorf("expected the interruption queue, got %v", queues)
+	}
+	if rules := m.ByType("aws:cloudwatch/eventRule:EventRule"); len(rules) != 4 {
+		t.Errorf("expected the four interruption event rules, got %d", len(rules))
+	}
+	if profiles := m.ByType("aws:iam/instanceProfile:InstanceProfile"); len(profiles) != 1 {
+		t.Errorf("expected the node instance profile, got %v", profiles)
+	}
+	var policy string
+	for _, p := range m.ByType("aws:iam/rolePolicy:RolePolicy") {
+		if p.Name == "test-karpenter-irsa-policy" {
+			policy = p.Inputs["policy"].StringValue()
+		}
+	}
+	if !strings.Contains(policy, `"aws:ResourceTag/kubernetes.io/cluster/test-aws-demo": "owned"`) {
+		t.Errorf("expected termination to be limited to the cluster's instances, got %s", policy)
+	}
+	resources := map[string]resource.PropertyMap{}
+	for _, r := range m.ByType("kubernetes:karpenter.sh/v1:NodePool") {
+		resources["NodePool"] = r.Inputs
+	}
+	for _, r := range m.ByType("kubernetes:karpenter.k8s.aws/v1:EC2NodeClass") {
+		resources["EC2NodeClass"] = r.Inputs
+	}
+	if len(resources) != 2 {
+		t.Fatalf("expected a NodePool and an EC2NodeClass, got %v", resources)
+	}
+	if cpu := resources["NodePool"]["spec"].ObjectValue()["limits"].ObjectValue()["cpu"].NumberValue(); cpu != 40 {
+		t.Errorf("expected the NodePool to be limited to 40 vCPUs, got %v", cpu)
+	}
+	if subnets := resources["EC2NodeClass"]["spec"].ObjectValue()["subnetSelectorTerms"].ArrayValue(); len(subnets) != 4 {
+		t.Errorf("expected the EC2NodeClass to select the network's subnets, got %v", subnets)
+	}
+	patches := m.ByType("kubernetes:core/v1:ConfigMapPatch")
+	if len(patches) != 1 || !strings.Contains(patches[0].Inputs["data"].ObjectValue()["mapRoles"].StringValue(), "system:node:{{EC2PrivateDNSName}}") {
+		t.Errorf("expected aws-auth to map the node role for Karpenter's nodes, got %v", patches)
+	}
+
+	values["clusterAutoscaler"] = `{"test": true}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterAutoscaler") {
+		t.Errorf("expected karpenter with the cluster autoscaler to be rejected, got %v", err)
+	}
+}
+
+func TestEksAddons(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"eksAddons":        `{"prod": true}`,
+		"eksAddonVersions": `{"prod": {"vpc-cni": "v1.18.3-eksbuild.1"}}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if err := InstallEksAddons(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addons := map[string]resource.PropertyMap{}
+	for _, addon := range m.ByType("aws:eks/addon:Addon") {
+		addons[addon.Name] = addon.Inputs
+	}
+	if len(addons) != 4 {
+		t.Fatalf("expected the four add-ons in prod only, got %v", addons)
+	}
+	if version := addons["prod-addon-vpc-cni"]["addonVersion"]; !version.HasValue() || version.StringValue() != "v1.18.3-eksbuild.1" {
+		t.Errorf("expected the VPC CNI to be pinned, got %v", version)
+	}
+	if version := addons["prod-addon-coredns"]["addonVersion"]; version.HasValue() {
+		t.Errorf("expected CoreDNS to take the default version, got %v", version)
+	}
+	if arn := addons["prod-addon-aws-ebs-csi-driver"]["serviceAccountRoleArn"]; !arn.HasValue() ||
+		arn.StringValue() != "arn:aws:iam::123456789012:role/prod-ebs-csi-irsa" {
+		t.Errorf("expected the EBS CSI driver to use its IRSA role, got %v", arn)
+	}
+
+	values["eksAddonVersions"] = `{"prod": {"vpc-cni": "1.18"}}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "prod")
+		if err != nil {
+			return err
+		}
+		return InstallEksAddons(ctx, cfg, cluster)
+	})
+	if err == nil || !strings.Contains(err.Error(), "eksAddonVersions") {
+		t.Errorf("expected a malformed version to be rejected, got %v", err)
+	}
+}
+
+func TestHelmRelease(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"helmRelease":   `{"test": true}`,
+		"chartVersions": `{"argo-cd": "5.46.7", "argo-rollouts": "2.32.0"}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases := m.ByType("kubernetes:helm.sh/v3:Release")
+	if len(releases) != 2 {
+		t.Fatalf("expected the test charts to be installed as releases, got %v", releases)
+	}
+	for _, r := range releases {
+		if !strings.HasPrefix(r.Name, "test-") || r.Inputs["name"].StringValue() != "test-"+r.Name ||
+			!r.Inputs["atomic"].BoolValue() || !r.Inputs["cleanupOnFail"].BoolValue() || !r.Inputs["version"].IsString() {
+			t.Errorf("expected %s to be an atomic, pinned release named like the rendered chart's, got %v", r.Name, r.Inputs)
+		}
+	}
+	if r := releases[0]; r.Inputs["repositoryOpts"].ObjectValue()["repo"].StringValue() != argoHelmRepo {
+		t.Errorf("expected %s to be installed from the Argo repo, got %v", r.Name, r.Inputs["repositoryOpts"])
+	}
+	services := m.ByType("kubernetes:core/v1:Service")
+	if len(services) != 1 || services[0].ID != "argocd/test-test-argo-cd-server" {
+		t.Errorf("expected the release's Argo CD server Service to be read back by its name, got %v", services)
+	}
+	if charts := m.ByType("kubernetes:helm.sh/v3:Chart"); len(charts) != 2 || !strings.HasPrefix(charts[0].Name, "prod-") {
+		t.Errorf("expected prod to keep its charts, got %v", charts)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"helmRelease": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "chartVersions must pin argo-cd") {
+		t.Errorf("expected an unpinned release to be refused, got %v", err)
+	}
+}
+
+func TestArgoBootstrap(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"argoBootstrap": `{"prod": {"repoUrl": "https://github.com/example/apps", "path": "envs/prod"}}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := m.ByType("kubernetes:argoproj.io/v1alpha1:Application")
+	if len(apps) != 1 || apps[0].Name != "prod-argocd-bootstrap" {
+		t.Fatalf("expected a bootstrap Application in prod only, got %v", apps)
+	}
+	source := apps[0].Inputs["spec"].ObjectValue()["source"].ObjectValue()
+	if source["repoURL"].StringValue() != "https://github.com/example/apps" || source["path"].StringValue() != "envs/prod" ||
+		source["targetRevision"].StringValue() != "HEAD" {
+		t.Errorf("expected the Application to track envs/prod at HEAD, got %v", source)
+	}
+	if !strings.Contains(apps[0].Inputs["spec"].ObjectValue()["syncPolicy"].String(), "selfHeal") {
+		t.Errorf("expected the Application to sync automatically, got %v", apps[0].Inputs["spec"])
+	}
+
+	for value, want := range map[string]string{
+		`{"test": {"repoUrl": "github.com/example/apps", "path": "envs/test"}}`:       "must be an https://",
+		`{"test": {"repoUrl": "https://github.com/example/apps", "path": "../test"}}`: "must be a directory",
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoBootstrap": value}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", value, want, err)
+		}
+	}
+}
+
+func TestHelmInstallOptions(t *testing.T) {
+	m := pulumitest.NewMocks()
+	m.RenderCharts = true
+	values := map[string]string{
+		"helmSkipAwait":      `{"test": true}`,
+		"helmInstallOptions": `{"argo-rollouts": {"skipAwait": {"test": false}}}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := map[string]bool{}
+	for _, cm := range m.ByType("kubernetes:core/v1:ConfigMap") {
+		metadata := cm.Inputs["metadata"].ObjectValue()
+		skipped[metadata["name"].StringValue()] = metadata["annotations"].IsObject() &&
+			metadata["annotations"].ObjectValue()["pulumi.com/skipAwait"].IsString()
+	}
+	if want := map[string]bool{"test-test-argo-cd": true, "test-test-argo-rollouts": false}; fmt.Sprint(skipped) != fmt.Sprint(want) {
+		t.Errorf("expected only argo-cd's resources to skip awaiting, got %v", skipped)
+	}
+
+	m = pulumitest.NewMocks()
+	values = map[string]string{
+		"helmRelease":        `{"test": true, "prod": true}`,
+		"chartVersions":      `{"argo-cd": "5.46.7", "argo-rollouts": "2.32.0"}`,
+		"helmInstallOptions": `{"argo-cd": {"skipAwait": {"test": true}, "atomic": {"prod": false}}}`,
+	}
+	err = pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		if err := ValidateConfig(cfg, []string{"test", "prod"}); err != nil {
+			return err
+		}
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			if _, err := InstallArgo(ctx, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases := map[string]string{}
+	for _, r := range m.ByType("kubernetes:helm.sh/v3:Release") {
+		releases[r.Name] = fmt.Sprintf("skipAwait=%v atomic=%v", r.Inputs["skipAwait"].BoolValue(), r.Inputs["atomic"].BoolValue())
+	}
+	want := map[string]string{
+		"test-argo-cd":       "skipAwait=true atomic=true",
+		"test-argo-rollouts": "skipAwait=false atomic=true",
+		"prod-argo-cd":       "skipAwait=false atomic=false",
+		"prod-argo-rollouts": "skipAwait=false atomic=true",
+	}
+	if fmt.Sprint(releases) != fmt.Sprint(want) {
+		t.Errorf("expected releases %v, got %v", want, releases)
+	}
+
+	values = map[string]string{"helmInstallOptions": `{"argo-cd": {"atomic": {"prod": true}}}`}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"prod"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs helmRelease") {
+		t.Errorf("expected atomic without helmRelease to be rejected, got %v", err)
+	}
+}
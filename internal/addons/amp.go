@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/amp"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const prometheusNamespace = "prometheus"
+
+// CreateAmpWorkspace creates an Amazon Managed Service for Prometheus workspace
+// when `amp` is set for the cluster's environment, aliased `ampWorkspaceAlias`
+// or `<env>-aws-demo`, and installs Prometheus to scrape the cluster and
+// remote-write to it through an IRSA role. Prometheus keeps no local storage
+// beyond its write-ahead log, so AMP is where the metrics live. Returns the
+// workspace and its remote-write URL, or nil when not enabled.
+func CreateAmpWorkspace(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) (*amp.Workspace, pulumi.StringOutput, error) {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "amp", env, false)
+	if err != nil || !enabled {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if len(cluster.NodeGroups) == 0 {
+		return nil, pulumi.StringOutput{}, fmt.Errorf("amp is set for %s, which has no node group to run Prometheus on", env)
+	}
+	alias := cfg.Get("ampWorkspaceAlias")
+	if alias == "" {
+		alias = "aws-demo"
+	}
+	region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	workspace, err := amp.NewWorkspace(ctx, fmt.Sprintf("%s-amp-workspace", env), &amp.WorkspaceArgs{
+		Alias: pulumi.String(fmt.Sprintf("%s-%s", env, alias)),
+	}, cluster.ResourceOpts(stackconfig.DataResourceOpts(cfg)...)...)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	remoteWriteUrl := pulumi.Sprintf("%sapi/v1/remote_write", workspace.PrometheusEndpoint)
+
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-prometheus-irsa", env), oidcProvider,
+		prometheusNamespace, "prometheus-server", nil, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	_, err = iam.NewRolePolicy(ctx, fmt.Sprintf("%s-prometheus-irsa-remote-write-policy", env), &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: pulumi.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": "aps:RemoteWrite",
+		        "Resource": "%s"
+		    }]
+		}`, workspace.Arn),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	labels, err := namespaceLabels(cfg, nil)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-prometheus-ns", env), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:   pulumi.String(prometheusNamespace),
+			Labels: labels,
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	cluster.Installed = append(cluster.Installed, namespace)
+	_, err = installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "prometheus",
+		Namespace: prometheusNamespace,
+		Repo:      "https://prometheus-community.github.io/helm-charts",
+	}, pulumi.Map{
+		"serviceAccounts": pulumi.Map{
+			"server": pulumi.Map{
+				"name": pulumi.String("prometheus-server"),
+				"annotations": pulumi.Map{
+					"eks.amazonaws.com/role-arn": role.Arn,
+				},
+			},
+		},
+		"server": pulumi.Map{
+			"persistentVolume": pulumi.Map{"enabled": pulumi.Bool(false)},
+			"remoteWrite": pulumi.Array{
+				pulumi.Map{
+					"url":   remoteWriteUrl,
+					"sigv4": pulumi.Map{"region": pulumi.String(region.Name)},
+				},
+			},
+		},
+		"alertmanager":           pulumi.Map{"enabled": pulumi.Bool(false)},
+		"prometheus-pushgateway": pulumi.Map{"enabled": pulumi.Bool(false)},
+	}, pulumi.DependsOn([]pulumi.Resource{namespace}))
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	return workspace, remoteWriteUrl, nil
+}
@@ -0,0 +1,46 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The service account demo apps run as to get the IRSA role.
+const appServiceAccountName = "app"
+
+// CreateAppServiceAccount creates the `app` service account in the `<env>-app`
+// namespace when `appIrsa` is set for the cluster's environment, bound through
+// IRSA to a role with the `appIrsaPolicyArns` managed policies, so demo apps that
+// run as it can call AWS. Returns the role's ARN, or "" when not enabled.
+func CreateAppServiceAccount(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "appIrsa", env, false)
+	if err != nil || !enabled {
+		return pulumi.String("").ToStringOutput(), err
+	}
+	var policyArns []string
+	if err := cfg.GetObject("appIrsaPolicyArns", &policyArns); err != nil {
+		return pulumi.StringOutput{}, fmt.Errorf("appIrsaPolicyArns must be a list of IAM policy ARNs: %w", err)
+	}
+	if len(policyArns) == 0 {
+		return pulumi.StringOutput{}, fmt.Errorf("appIrsa is set for %s but appIrsaPolicyArns lists no policies", env)
+	}
+	for _, arn := range policyArns {
+		if !iamroles.IamPolicyArn.MatchString(arn) {
+			return pulumi.StringOutput{}, fmt.Errorf("appIrsaPolicyArns entries must be IAM policy ARNs, got %q", arn)
+		}
+	}
+
+	// The app namespace is among what has been installed so far
+	irsa, err := NewIrsaRole(ctx, cluster, fmt.Sprintf("%s-app", env), appServiceAccountName, policyArns...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return irsa.Role.Arn, nil
+}
@@ -0,0 +1,286 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/helm/v3"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const argoHelmRepo = "https://argoproj.github.io/argo-helm"
+
+// Run on destroy to strip finalizers from Argo CD Applications. Without this the
+// argocd namespace can hang in Terminating once the Argo CD controller that would
+// process those finalizers has been removed.
+const argoFinalizerCleanupScript = `set -e
+kubeconfig=$(mktemp)
+trap 'rm -f "$kubeconfig"' EXIT
+printf '%s' "$KUBECONFIG_DATA" > "$kubeconfig"
+for app in $(kubectl --kubeconfig "$kubeconfig" -n argocd get applications.argoproj.io -o name 2>/dev/null); do
+	kubectl --kubeconfig "$kubeconfig" -n argocd patch "$app" --type merge -p '{"metadata":{"finalizers":null}}'
+done
+`
+
+// InstallArgo installs Argo CD and Argo Rollouts into the cluster's argocd namespace,
+// with the `argoProjects` and the `argoBootstrap` app-of-apps Application when set.
+// The Argo CD server gets a LoadBalancer Service, or with `argoCdIngress` and the
+// AWS Load Balancer Controller an ALB Ingress on its host, with TLS from ACM.
+// Returns the URL of the Argo CD server.
+func InstallArgo(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	labels, err := namespaceLabels(cfg, nil)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// Everything Argo goes into the namespace, so waiting here for the
+	// prepulled images holds back all of it
+	namespaceDeps := cluster.ComputeResources()
+	if cluster.ImagePrepuller != nil {
+		namespaceDeps = append(namespaceDeps, cluster.ImagePrepuller)
+	}
+	argocdNamespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-argocd-ns", env), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:   pulumi.String("argocd"),
+			Labels: labels,
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(namespaceDeps))...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	argoTaint := cluster.ArgoTaint
+	ha, err := argoCdHa(ctx, cfg, env, len(cluster.NodeGroups) > 0, argoTaint)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	scheme, err := argoCdLoadBalancerScheme(cfg, env)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// Without the Load Balancer Controller the server keeps its LoadBalancer Service
+	var ingress *argoCdIngressSource
+	if cluster.LoadBalancerController != nil {
+		if ingress, err = argoCdIngressConfig(cfg, env); err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+	server := pulumi.Map{}
+	argoCdDeps := []pulumi.Resource{argocdNamespace}
+	if ingress != nil {
+		certificateArn, err := argoCdCertificate(ctx, cluster, ingress)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		server["ingress"] = argoCdIngressValues(ingress.Host, certificateArn, scheme)
+		// Its webhook has to be up to admit the Ingress
+		argoCdDeps = append(argoCdDeps, cluster.LoadBalancerController)
+	} else {
+		service := pulumi.Map{
+			"type": pulumi.String("LoadBalancer"),
+		}
+		if scheme == "internal" {
+			// The first for the in-tree service controller, the second for the AWS
+			// Load Balancer Controller
+			service["annotations"] = pulumi.StringMap{
+				"service.beta.kubernetes.io/aws-load-balancer-internal": pulumi.String("true"),
+				"service.beta.kubernetes.io/aws-load-balancer-scheme":   pulumi.String("internal"),
+			}
+		}
+		server["service"] = service
+	}
+	argoCdInline := pulumi.Map{
+		"server": server,
+	}
+	if ha {
+		// The chart's documented HA setup: two replicas of the stateless
+		// components, with redis-ha or an external Redis below
+		server["replicas"] = pulumi.Int(2)
+		argoCdInline["controller"] = pulumi.Map{"replicas": pulumi.Int(1)}
+		argoCdInline["repoServer"] = pulumi.Map{"replicas": pulumi.Int(2)}
+		argoCdInline["applicationSet"] = pulumi.Map{"replicas": pulumi.Int(2)}
+	}
+	redisMode, err := argoCdRedisMode(cfg, env, ha)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	redisValues, err := argoCdRedisValues(ctx, cfg, cluster, redisMode, argocdNamespace)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	for k, v := range redisValues {
+		argoCdInline[k] = v
+	}
+	if argoTaint != nil {
+		// global covers every argo-cd component but the redis-ha subchart
+		argoTaint.ApplyTo(argoCdInline, "global")
+		if redisMode == argoRedisHa {
+			argoTaint.ApplyTo(argoCdInline, "redis-ha")
+		}
+	}
+	notifications, err := argoNotifications(ctx, cfg, cluster, argocdNamespace)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if notifications != nil {
+		argoCdInline["notifications"] = notifications
+	}
+	configs, err := argoAdminPassword(ctx, cfg, cluster, argocdNamespace)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if configs != nil {
+		argoCdInline["configs"] = configs
+	}
+	argoCd, err := installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "argo-cd",
+		Namespace: "argocd",
+		Repo:      argoHelmRepo,
+	}, argoCdInline, pulumi.DependsOn(argoCdDeps))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if err := createArgoProjects(ctx, cfg, cluster, argoCd); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if err := createArgoBootstrap(ctx, cfg, cluster, argoCd); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if cfg.GetBool("argoCleanupFinalizers") {
+		// Depending on the chart means this is deleted first on destroy, while
+		// the Application CRD and the argocd namespace still exist.
+		_, err = local.NewCommand(ctx, fmt.Sprintf("%s-argocd-finalizer-cleanup", env), &local.CommandArgs{
+			Delete: pulumi.String(argoFinalizerCleanupScript),
+			Environment: pulumi.StringMap{
+				"KUBECONFIG_DATA": cluster.Kubeconfig,
+			},
+		}, cluster.ResourceOpts(pulumi.DependsOn([]pulumi.Resource{argoCd}))...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+
+	// The dashboard is handy while trying things out but has no authentication,
+	// so it is off in prod unless asked for
+	dashboard, err := stackconfig.GetEnvBool(cfg, "argoRolloutsDashboard", env, env != "prod")
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	rolloutsInline := pulumi.Map{
+		"dashboard": pulumi.Map{
+			"enabled": pulumi.Bool(dashboard),
+		},
+	}
+	if argoTaint != nil {
+		argoTaint.ApplyTo(rolloutsInline, "controller", "dashboard")
+	}
+	_, err = installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "argo-rollouts",
+		Namespace: "argocd",
+		Repo:      argoHelmRepo,
+	}, rolloutsInline, pulumi.DependsOn([]pulumi.Resource{argocdNamespace}))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	cluster.Installed = append(cluster.Installed, argocdNamespace)
+	var url pulumi.StringOutput
+	if ingress != nil {
+		url = pulumi.String("https://" + ingress.Host).ToStringOutput()
+		address, err := argoCdIngressAddress(ctx, cluster, argoCd)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		cluster.ArgoCdIngressAddress = &address
+	} else if url, err = argoCdServerUrl(ctx, cluster, argoCd); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	cluster.ArgoCdUrl = &url
+	return url, nil
+}
+
+// Read `argoCdLoadBalancerScheme` for env, internet-facing unless set to internal.
+func argoCdLoadBalancerScheme(cfg *config.Config, env string) (string, error) {
+	scheme, err := stackconfig.GetEnvString(cfg, "argoCdLoadBalancerScheme", env)
+	if err != nil {
+		return "", err
+	}
+	switch scheme {
+	case "", "internet-facing":
+		return "internet-facing", nil
+	case "internal":
+		return scheme, nil
+	}
+	return "", fmt.Errorf("argoCdLoadBalancerScheme for %s must be internet-facing or internal, got %q", env, scheme)
+}
+
+// Read `argoCdHa` for env, off by default. redis-ha spreads its three
+// replicas over separate nodes, so when the cluster has a node group, HA is
+// refused if it can never have three nodes, and a warning is logged if it may
+// scale below that. With dedicated Argo nodes, those are the nodes that count.
+func argoCdHa(ctx *pulumi.Context, cfg *config.Config, env string, nodeGroup bool, argoTaint *cluster.NodeTaint) (bool, error) {
+	ha, err := stackconfig.GetEnvBool(cfg, "argoCdHa", env, false)
+	if err != nil || !ha || !nodeGroup {
+		return ha, err
+	}
+	if argoTaint != nil {
+		count, err := cluster.ArgoNodeCount(cfg)
+		if err != nil {
+			return false, err
+		}
+		if count < 3 {
+			return false, fmt.Errorf("argoCdHa needs at least 3 dedicated Argo nodes in %s, but argoNodeCount is %d", env, count)
+		}
+		return true, nil
+	}
+	scaling, err := cluster.NodeScalingConfig(cfg, env)
+	if err != nil {
+		return false, err
+	}
+	if scaling.Desired < 3 {
+		return false, fmt.Errorf("argoCdHa needs at least 3 nodes in %s, but nodeDesiredSize is %d", env, scaling.Desired)
+	}
+	if scaling.Min < 3 {
+		_ = ctx.Log.Warn(fmt.Sprintf("argoCdHa is on in %s but the node group can scale down to %d nodes, leaving redis-ha replicas unschedulable",
+			env, scaling.Min), nil)
+	}
+	return true, nil
+}
+
+// The chart's release name carries the resource prefix, so find the server
+// Service by suffix rather than by its full name. A Helm release does not
+// expose its objects, so the Service is read back once it is installed.
+func argoCdServerUrl(ctx *pulumi.Context, cluster *cluster.Cluster, argoCd pulumi.Resource) (pulumi.StringOutput, error) {
+	if release, ok := argoCd.(*helm.Release); ok {
+		name := chartReleaseName(cluster.Env, "argo-cd") + "-server"
+		server, err := corev1.GetService(ctx, name, pulumi.ID("argocd/"+name), nil,
+			cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{release}))...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		// Empty until the load balancer is up, which skipAwait does not wait for
+		return server.Status.LoadBalancer().Ingress().ApplyT(func(ingress []corev1.LoadBalancerIngress) string {
+			if len(ingress) == 0 || ingress[0].Hostname == nil {
+				return ""
+			}
+			return "https://" + *ingress[0].Hostname
+		}).(pulumi.StringOutput), nil
+	}
+	return argoCd.(*helm.Chart).Resources.ApplyT(func(x interface{}) pulumi.StringOutput {
+		for key, r := range x.(map[string]pulumi.Resource) {
+			if strings.HasPrefix(key, "v1/Service::argocd/") && strings.HasSuffix(key, "-argo-cd-server") {
+				ingress := r.(*corev1.Service).Status.LoadBalancer().Ingress().Index(pulumi.Int(0))
+				return pulumi.Sprintf("https://%s", ingress.Hostname().Elem())
+			}
+		}
+		return pulumi.String("").ToStringOutput()
+	}).ApplyT(func(url interface{}) string {
+		return url.(string)
+	}).(pulumi.StringOutput), nil
+}
@@ -0,0 +1,54 @@
+package addons
+
+import (
+	"fmt"
+	"regexp"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+var bcryptHash = regexp.MustCompile(`^\$2[aby]?\$[0-9]{2}\$[./A-Za-z0-9]{53}$`)
+
+// Create the argocd-secret holding the admin password when
+// `argoAdminPasswordBcrypt` is set, and return the argo-cd chart values that stop
+// the chart creating its own. Returns nil otherwise, leaving Argo CD to generate a
+// random password into argocd-initial-admin-secret. With the password supplied
+// that secret is never created. The hash is only passed on as a Pulumi secret.
+func argoAdminPassword(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, namespace pulumi.Resource) (pulumi.Map, error) {
+	hash := cfg.Get("argoAdminPasswordBcrypt")
+	if hash == "" {
+		return nil, nil
+	}
+	if !bcryptHash.MatchString(hash) {
+		// Not quoting the value, in case a plain password was set by mistake
+		return nil, fmt.Errorf("argoAdminPasswordBcrypt must be a bcrypt hash, e.g. from `argocd account bcrypt`")
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-argocd-secret", cluster.Env), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("argocd-secret"),
+			Namespace: pulumi.String("argocd"),
+			Labels: pulumi.StringMap{
+				// Argo CD only watches secrets carrying this label
+				"app.kubernetes.io/part-of": pulumi.String("argocd"),
+			},
+		},
+		// Argo CD adds its own server.secretkey on startup
+		StringData: pulumi.ToSecret(pulumi.StringMap{
+			"admin.password": pulumi.String(hash),
+		}).(pulumi.StringMapOutput),
+	}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{namespace}))...)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Installed = append(cluster.Installed, secret)
+
+	return pulumi.Map{
+		"secret": pulumi.Map{"createSecret": pulumi.Bool(false)},
+	}, nil
+}
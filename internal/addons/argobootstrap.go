@@ -0,0 +1,96 @@
+package addons
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Git repo URLs Argo CD can clone: HTTPS, SSH or scp-like.
+var gitRepoUrl = regexp.MustCompile(`^(https://|ssh://|git@)[^\s]+$`)
+
+// An environment's entry of `argoBootstrap`: the Git repo path holding the
+// Applications that make up its workloads.
+type argoBootstrapSource struct {
+	RepoUrl        string `json:"repoUrl"`
+	Path           string `json:"path"`
+	TargetRevision string `json:"targetRevision"`
+}
+
+// Read env's entry of `argoBootstrap`, with the target revision defaulting to
+// HEAD. Returns nil when env has none.
+func argoBootstrapConfig(cfg *config.Config, env string) (*argoBootstrapSource, error) {
+	var byEnv map[string]*argoBootstrapSource
+	if err := cfg.GetObject("argoBootstrap", &byEnv); err != nil {
+		return nil, fmt.Errorf("argoBootstrap must map environment names to {repoUrl, path, targetRevision} objects: %w", err)
+	}
+	source := byEnv[env]
+	if source == nil {
+		return nil, nil
+	}
+	if !gitRepoUrl.MatchString(source.RepoUrl) {
+		return nil, fmt.Errorf("argoBootstrap repoUrl for %s must be an https://, ssh:// or git@ Git URL, got %q", env, source.RepoUrl)
+	}
+	if source.Path == "" || strings.HasPrefix(source.Path, "/") || strings.Contains(source.Path, "..") {
+		return nil, fmt.Errorf("argoBootstrap path for %s must be a directory in the repo, got %q", env, source.Path)
+	}
+	if source.TargetRevision == "" {
+		source.TargetRevision = "HEAD"
+	}
+	return source, nil
+}
+
+// Create the app-of-apps Application for the cluster's environment when it has
+// an `argoBootstrap` entry, so Argo CD syncs the Applications in that repo path
+// and the cluster bootstraps its own workloads. They are synced automatically,
+// pruned and healed. argoCd is the chart that brings the Application CRD.
+func createArgoBootstrap(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, argoCd pulumi.Resource) error {
+	source, err := argoBootstrapConfig(cfg, cluster.Env)
+	if err != nil || source == nil {
+		return err
+	}
+	// After the projects too, which the child Applications may belong to
+	dependsOn := append([]pulumi.Resource{argoCd}, cluster.Installed...)
+	app, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-argocd-bootstrap", cluster.Env), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("argoproj.io/v1alpha1"),
+		Kind:       pulumi.String("Application"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("bootstrap"),
+			Namespace: pulumi.String("argocd"),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": map[string]interface{}{
+				"project": "default",
+				"source": map[string]interface{}{
+					"repoURL":        source.RepoUrl,
+					"path":           source.Path,
+					"targetRevision": source.TargetRevision,
+				},
+				// The child Applications live in argocd, and name their own destinations
+				"destination": map[string]interface{}{
+					"server":    "https://kubernetes.default.svc",
+					"namespace": "argocd",
+				},
+				"syncPolicy": map[string]interface{}{
+					"automated": map[string]interface{}{
+						"prune":    true,
+						"selfHeal": true,
+					},
+				},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(dependsOn))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, app)
+	return nil
+}
@@ -0,0 +1,206 @@
+package addons
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/acm"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/route53"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/helm/v3"
+	networkingv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/networking/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Fully qualified DNS names, in lower case.
+var dnsName = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+
+// The first major version of the argo-cd chart that reads server.ingress.hostname.
+// Earlier ones read server.ingress.hosts, and would serve no host at all.
+const argoCdIngressChartMajor = 6
+
+// An environment's entry of `argoCdIngress`: the host the Argo CD server is
+// served on, and either the certificate for it or the Route 53 zone to request
+// one in.
+type argoCdIngressSource struct {
+	Host           string `json:"host"`
+	CertificateArn string `json:"certificateArn"`
+	HostedZone     string `json:"hostedZone"`
+}
+
+// The region of certificateArn, which argoCdIngressConfig has checked is an
+// ACM certificate ARN.
+func (s *argoCdIngressSource) certificateRegion() string {
+	return cluster.AcmCertificateArn.FindStringSubmatch(s.CertificateArn)[1]
+}
+
+// Read env's entry of `argoCdIngress`. Returns nil when env has none.
+func argoCdIngressConfig(cfg *config.Config, env string) (*argoCdIngressSource, error) {
+	var byEnv map[string]*argoCdIngressSource
+	if err := cfg.GetObject("argoCdIngress", &byEnv); err != nil {
+		return nil, fmt.Errorf("argoCdIngress must map environment names to {host, certificateArn, hostedZone} objects: %w", err)
+	}
+	source := byEnv[env]
+	if source == nil {
+		return nil, nil
+	}
+	if !dnsName.MatchString(source.Host) {
+		return nil, fmt.Errorf("argoCdIngress host for %s must be a DNS name such as argocd.example.com, got %q", env, source.Host)
+	}
+	switch {
+	case source.CertificateArn != "" && source.HostedZone != "":
+		return nil, fmt.Errorf("argoCdIngress for %s sets both certificateArn and hostedZone, only one can be used", env)
+	case source.CertificateArn != "" && !cluster.AcmCertificateArn.MatchString(source.CertificateArn):
+		return nil, fmt.Errorf("argoCdIngress certificateArn for %s is not an ACM certificate ARN: %q", env, source.CertificateArn)
+	case source.HostedZone != "":
+		zone := strings.TrimSuffix(source.HostedZone, ".")
+		if source.Host != zone && !strings.HasSuffix(source.Host, "."+zone) {
+			return nil, fmt.Errorf("argoCdIngress host %s for %s is not in hostedZone %s", source.Host, env, source.HostedZone)
+		}
+		source.HostedZone = zone
+	}
+	var versions map[string]string
+	if err := cfg.GetObject("chartVersions", &versions); err != nil {
+		return nil, fmt.Errorf("chartVersions must map chart names to versions: %w", err)
+	}
+	version := versions["argo-cd"]
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0])
+	if err == nil && major < argoCdIngressChartMajor {
+		return nil, fmt.Errorf("argoCdIngress is set for %s, which needs argo-cd chart %d.0.0 or later, but chartVersions pins %s",
+			env, argoCdIngressChartMajor, version)
+	}
+	return source, nil
+}
+
+// The ARN of the certificate of the Argo CD server's ALB for source, the
+// cluster's environment's entry of `argoCdIngress`. It is certificateArn when
+// set, which must be in the cluster's region. With hostedZone a certificate is
+// requested and validated through a DNS record in that public Route 53 zone.
+// Otherwise the most recent issued certificate for the host is looked up in ACM.
+func argoCdCertificate(ctx *pulumi.Context, cluster *cluster.Cluster, source *argoCdIngressSource) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	invokeOpts := cluster.Shared.Network.InvokeOpts()
+	if source.CertificateArn != "" {
+		region, err := aws.GetRegion(ctx, nil, invokeOpts...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		certificateRegion := source.certificateRegion()
+		if !strings.EqualFold(certificateRegion, region.Name) {
+			return pulumi.StringOutput{}, fmt.Errorf("argoCdIngress certificateArn for %s is in %s, but the ALB can only use a certificate from its own region %s",
+				env, certificateRegion, region.Name)
+		}
+		return pulumi.String(source.CertificateArn).ToStringOutput(), nil
+	}
+	if source.HostedZone == "" {
+		certificate, err := acm.LookupCertificate(ctx, &acm.LookupCertificateArgs{
+			Domain:     source.Host,
+			Statuses:   []string{"ISSUED"},
+			MostRecent: pulumi.BoolRef(true),
+		}, invokeOpts...)
+		if err != nil {
+			return pulumi.StringOutput{}, fmt.Errorf("argoCdIngress for %s has no certificateArn or hostedZone, and no issued ACM certificate for %s was found: %w",
+				env, source.Host, err)
+		}
+		return pulumi.String(certificate.Arn).ToStringOutput(), nil
+	}
+
+	zone, err := route53.LookupZone(ctx, &route53.LookupZoneArgs{
+		Name:        pulumi.StringRef(source.HostedZone),
+		PrivateZone: pulumi.BoolRef(false),
+	}, invokeOpts...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	certificate, err := acm.NewCertificate(ctx, fmt.Sprintf("%s-argocd-certificate", env), &acm.CertificateArgs{
+		DomainName:       pulumi.String(source.Host),
+		ValidationMethod: pulumi.String("DNS"),
+		Tags:             cluster.ExpiryTags,
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// A certificate for a single name has a single validation record
+	validationOption := certificate.DomainValidationOptions.Index(pulumi.Int(0))
+	record, err := route53.NewRecord(ctx, fmt.Sprintf("%s-argocd-certificate-dns", env), &route53.RecordArgs{
+		ZoneId:  pulumi.String(zone.ZoneId),
+		Name:    validationOption.ResourceRecordName().Elem(),
+		Type:    validationOption.ResourceRecordType().Elem(),
+		Records: pulumi.StringArray{validationOption.ResourceRecordValue().Elem()},
+		Ttl:     pulumi.Int(60),
+		// The record stays the same for a replacement certificate of the host
+		AllowOverwrite: pulumi.Bool(true),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	validation, err := acm.NewCertificateValidation(ctx, fmt.Sprintf("%s-argocd-certificate-validation", env), &acm.CertificateValidationArgs{
+		CertificateArn:        certificate.Arn,
+		ValidationRecordFqdns: pulumi.StringArray{record.Fqdn},
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// Only handed to the ALB once issued
+	return validation.CertificateArn, nil
+}
+
+// Build the argo-cd chart's server.ingress values for an ALB Ingress on host,
+// reconciled by the AWS Load Balancer Controller. The ALB terminates TLS with
+// certificateArn, redirects HTTP to HTTPS and goes to the server pods directly
+// over HTTPS, as the server serves TLS itself.
+func argoCdIngressValues(host string, certificateArn pulumi.StringOutput, scheme string) pulumi.Map {
+	return pulumi.Map{
+		"enabled":          pulumi.Bool(true),
+		"ingressClassName": pulumi.String("alb"),
+		"hostname":         pulumi.String(host),
+		"annotations": pulumi.StringMap{
+			"alb.ingress.kubernetes.io/scheme":           pulumi.String(scheme),
+			"alb.ingress.kubernetes.io/target-type":      pulumi.String("ip"),
+			"alb.ingress.kubernetes.io/listen-ports":     pulumi.String(`[{"HTTP": 80}, {"HTTPS": 443}]`),
+			"alb.ingress.kubernetes.io/ssl-redirect":     pulumi.String("443"),
+			"alb.ingress.kubernetes.io/certificate-arn":  certificateArn,
+			"alb.ingress.kubernetes.io/ssl-policy":       pulumi.String(cluster.AlbSslPolicy),
+			"alb.ingress.kubernetes.io/backend-protocol": pulumi.String("HTTPS"),
+			"alb.ingress.kubernetes.io/healthcheck-path": pulumi.String("/healthz"),
+		},
+	}
+}
+
+// The address of the ALB in the status of the Argo CD server's Ingress, empty
+// until the controller has provisioned it. The ingress URL is known up front,
+// so this is what tells whether the server is reachable through it yet. A Helm
+// release does not expose its objects, so its Ingress is read back.
+func argoCdIngressAddress(ctx *pulumi.Context, cluster *cluster.Cluster, argoCd pulumi.Resource) (pulumi.StringOutput, error) {
+	if release, ok := argoCd.(*helm.Release); ok {
+		name := chartReleaseName(cluster.Env, "argo-cd") + "-server"
+		ingress, err := networkingv1.GetIngress(ctx, name, pulumi.ID("argocd/"+name), nil,
+			cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{release}))...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		return ingress.Status.LoadBalancer().Ingress().ApplyT(func(ingress []corev1.LoadBalancerIngress) string {
+			if len(ingress) == 0 || ingress[0].Hostname == nil {
+				return ""
+			}
+			return *ingress[0].Hostname
+		}).(pulumi.StringOutput), nil
+	}
+	return argoCd.(*helm.Chart).Resources.ApplyT(func(x interface{}) pulumi.StringOutput {
+		for key, r := range x.(map[string]pulumi.Resource) {
+			if strings.HasPrefix(key, "networking.k8s.io/v1/Ingress::argocd/") && strings.HasSuffix(key, "-argo-cd-server") {
+				ingress := r.(*networkingv1.Ingress).Status.LoadBalancer().Ingress().Index(pulumi.Int(0))
+				return ingress.Hostname().Elem()
+			}
+		}
+		return pulumi.String("").ToStringOutput()
+	}).ApplyT(func(address interface{}) string {
+		return address.(string)
+	}).(pulumi.StringOutput), nil
+}
@@ -0,0 +1,68 @@
+package addons
+
+import (
+	"fmt"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The secret the notifications controller reads `$name` references in the
+// notifier settings from.
+const argoNotificationsSecretName = "argocd-notifications-secret"
+
+// The `argoNotificationsConfig` object, passed through to the chart's
+// notifications values.
+type argoNotificationsConfig struct {
+	Notifiers map[string]string `json:"notifiers"`
+	Templates map[string]string `json:"templates"`
+	Triggers  map[string]string `json:"triggers"`
+}
+
+// Build the argo-cd chart's notifications values when `argoNotifications` is set
+// for the cluster's environment, or return nil. The tokens the notifiers refer
+// to come from the `argoNotificationsSecret` secret config and go into a
+// Kubernetes secret created here, so they never appear in the chart values.
+func argoNotifications(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, namespace pulumi.Resource) (pulumi.Map, error) {
+	enabled, err := stackconfig.GetEnvBool(cfg, "argoNotifications", cluster.Env, false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	var notifications argoNotificationsConfig
+	if err := cfg.GetObject("argoNotificationsConfig", &notifications); err != nil {
+		return nil, fmt.Errorf("argoNotificationsConfig must be an object of notifiers, templates and triggers: %w", err)
+	}
+	if len(notifications.Notifiers) == 0 {
+		return nil, fmt.Errorf("argoNotifications is set for %s but argoNotificationsConfig defines no notifiers", cluster.Env)
+	}
+	var secrets map[string]string
+	if err := cfg.GetObject("argoNotificationsSecret", &secrets); err != nil {
+		return nil, fmt.Errorf("argoNotificationsSecret must map secret names to values: %w", err)
+	}
+
+	secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-argocd-notifications-secret", cluster.Env), &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(argoNotificationsSecretName),
+			Namespace: pulumi.String("argocd"),
+		},
+		StringData: pulumi.ToSecret(pulumi.ToStringMap(secrets)).(pulumi.StringMapOutput),
+	}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{namespace}))...)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Installed = append(cluster.Installed, secret)
+
+	return pulumi.Map{
+		"enabled": pulumi.Bool(true),
+		// The secret above replaces the chart's empty one
+		"secret":    pulumi.Map{"create": pulumi.Bool(false)},
+		"notifiers": pulumi.ToStringMap(notifications.Notifiers),
+		"templates": pulumi.ToStringMap(notifications.Templates),
+		"triggers":  pulumi.ToStringMap(notifications.Triggers),
+	}, nil
+}
@@ -0,0 +1,151 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// An entry of `argoProjectsConfig`. Apart from the name, the fields are those of
+// the AppProject spec and are passed through to it as they are.
+type argoProject struct {
+	Name                       string                `json:"name"`
+	Description                string                `json:"description,omitempty"`
+	SourceRepos                []string              `json:"sourceRepos"`
+	Destinations               []argoProjectDest     `json:"destinations"`
+	ClusterResourceWhitelist   []argoProjectResource `json:"clusterResourceWhitelist,omitempty"`
+	NamespaceResourceWhitelist []argoProjectResource `json:"namespaceResourceWhitelist,omitempty"`
+	Roles                      []argoProjectRole     `json:"roles,omitempty"`
+}
+
+type argoProjectDest struct {
+	Server    string `json:"server,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace"`
+}
+
+type argoProjectResource struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+}
+
+type argoProjectRole struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Policies    []string `json:"policies,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+}
+
+// Read and check `argoProjectsConfig`, the Argo CD projects to create where
+// `argoProjects` is set. Each needs a unique name other than the built-in
+// default, source repos and destinations; each role policy must be a
+// six-field Casbin line granting to one of the project's own roles.
+func argoProjectsConfig(cfg *config.Config) ([]argoProject, error) {
+	var projects []argoProject
+	if err := cfg.GetObject("argoProjectsConfig", &projects); err != nil {
+		return nil, fmt.Errorf("argoProjectsConfig must be a list of AppProject specs with a name: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, p := range projects {
+		if len(p.Name) > 63 || !stackconfig.DnsLabel.MatchString(p.Name) {
+			return nil, fmt.Errorf("argoProjectsConfig project name %q must be a lowercase DNS label", p.Name)
+		}
+		if p.Name == "default" {
+			return nil, fmt.Errorf("argoProjectsConfig cannot define the default project, which Argo CD creates itself")
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("argoProjectsConfig defines project %s more than once", p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.SourceRepos) == 0 {
+			return nil, fmt.Errorf("argoProjectsConfig project %s has no sourceRepos", p.Name)
+		}
+		if len(p.Destinations) == 0 {
+			return nil, fmt.Errorf("argoProjectsConfig project %s has no destinations", p.Name)
+		}
+		for _, d := range p.Destinations {
+			if d.Namespace == "" || (d.Server == "") == (d.Name == "") {
+				return nil, fmt.Errorf("argoProjectsConfig project %s destinations need a namespace and one of server or name", p.Name)
+			}
+		}
+		for _, r := range append(append([]argoProjectResource(nil), p.ClusterResourceWhitelist...), p.NamespaceResourceWhitelist...) {
+			if r.Kind == "" {
+				return nil, fmt.Errorf("argoProjectsConfig project %s has a resource whitelist entry with no kind", p.Name)
+			}
+		}
+		for _, role := range p.Roles {
+			if role.Name == "" {
+				return nil, fmt.Errorf("argoProjectsConfig project %s has a role with no name", p.Name)
+			}
+			subject := fmt.Sprintf("proj:%s:%s", p.Name, role.Name)
+			for _, policy := range role.Policies {
+				fields := strings.Split(policy, ",")
+				if len(fields) != 6 || strings.TrimSpace(fields[0]) != "p" || strings.TrimSpace(fields[1]) != subject {
+					return nil, fmt.Errorf("argoProjectsConfig project %s role %s policy %q must look like \"p, %s, applications, get, %s/*, allow\"",
+						p.Name, role.Name, policy, subject, p.Name)
+				}
+			}
+		}
+	}
+	return projects, nil
+}
+
+// Create the `argoProjectsConfig` AppProjects in the argocd namespace when
+// `argoProjects` is set for the cluster's environment, so Applications can be
+// held to the repos, destinations and kinds their team is allowed. argoCd is the
+// chart that brings the AppProject CRD.
+func createArgoProjects(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, argoCd pulumi.Resource) error {
+	enabled, err := stackconfig.GetEnvBool(cfg, "argoProjects", cluster.Env, false)
+	if err != nil || !enabled {
+		return err
+	}
+	projects, err := argoProjectsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		return fmt.Errorf("argoProjects is set for %s but argoProjectsConfig defines no projects", cluster.Env)
+	}
+	for _, p := range projects {
+		spec, err := toUntyped(p)
+		if err != nil {
+			return err
+		}
+		delete(spec, "name")
+		project, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-argocd-project-%s", cluster.Env, p.Name), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("argoproj.io/v1alpha1"),
+			Kind:       pulumi.String("AppProject"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(p.Name),
+				Namespace: pulumi.String("argocd"),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": spec,
+			},
+		}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{argoCd}))...)
+		if err != nil {
+			return err
+		}
+		cluster.Installed = append(cluster.Installed, project)
+	}
+	return nil
+}
+
+// Turn a config struct back into the plain map a custom resource takes.
+func toUntyped(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var untyped map[string]interface{}
+	return untyped, json.Unmarshal(data, &untyped)
+}
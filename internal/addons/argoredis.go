@@ -0,0 +1,117 @@
+package addons
+
+import (
+	"fmt"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	argoRedisBundled  = "bundled"
+	argoRedisHa       = "ha"
+	argoRedisExternal = "external"
+)
+
+// The secret holding the external Redis password, under the key the chart expects.
+const argoExternalRedisSecretName = "argocd-external-redis"
+
+// The `argoCdExternalRedis` object.
+type argoExternalRedis struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// Read `argoCdRedis` for env: `bundled` for the chart's single Redis, `ha` for
+// redis-ha, or `external` for the Redis `argoCdExternalRedis` points at. It
+// follows argoCdHa when unset, and redis-ha only runs alongside it.
+func argoCdRedisMode(cfg *config.Config, env string, ha bool) (string, error) {
+	mode, err := stackconfig.GetEnvString(cfg, "argoCdRedis", env)
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case "":
+		if ha {
+			return argoRedisHa, nil
+		}
+		return argoRedisBundled, nil
+	case argoRedisBundled:
+		if ha {
+			return "", fmt.Errorf("argoCdRedis for %s cannot be bundled with argoCdHa, use ha or external", env)
+		}
+		return mode, nil
+	case argoRedisHa:
+		if !ha {
+			return "", fmt.Errorf("argoCdRedis for %s can only be ha with argoCdHa", env)
+		}
+		return mode, nil
+	case argoRedisExternal:
+		return mode, nil
+	}
+	return "", fmt.Errorf("argoCdRedis for %s must be %s, %s or %s, got %q", env, argoRedisBundled, argoRedisHa, argoRedisExternal, mode)
+}
+
+// Read and check `argoCdExternalRedis`, defaulting the port to 6379.
+func argoExternalRedisConfig(cfg *config.Config) (argoExternalRedis, error) {
+	var redis argoExternalRedis
+	if err := cfg.GetObject("argoCdExternalRedis", &redis); err != nil {
+		return redis, fmt.Errorf("argoCdExternalRedis must be an object with a host and port: %w", err)
+	}
+	if redis.Host == "" {
+		return redis, fmt.Errorf("argoCdRedis is external but argoCdExternalRedis has no host")
+	}
+	if redis.Port == 0 {
+		redis.Port = 6379
+	}
+	if redis.Port < 1 || redis.Port > 65535 {
+		return redis, fmt.Errorf("argoCdExternalRedis port must be between 1 and 65535, got %d", redis.Port)
+	}
+	return redis, nil
+}
+
+// Build the argo-cd chart's Redis values for mode. For an external Redis with a
+// password in the `argoCdExternalRedisPassword` secret config, the password goes
+// into a Kubernetes secret created here rather than into the chart values.
+func argoCdRedisValues(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, mode string, namespace pulumi.Resource) (pulumi.Map, error) {
+	switch mode {
+	case argoRedisHa:
+		return pulumi.Map{"redis-ha": pulumi.Map{"enabled": pulumi.Bool(true)}}, nil
+	case argoRedisBundled:
+		return pulumi.Map{}, nil
+	}
+
+	redis, err := argoExternalRedisConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	external := pulumi.Map{
+		"host": pulumi.String(redis.Host),
+		"port": pulumi.Int(redis.Port),
+	}
+	if password := cfg.Get("argoCdExternalRedisPassword"); password != "" {
+		secret, err := corev1.NewSecret(ctx, fmt.Sprintf("%s-argocd-external-redis", cluster.Env), &corev1.SecretArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:      pulumi.String(argoExternalRedisSecretName),
+				Namespace: pulumi.String("argocd"),
+			},
+			StringData: pulumi.ToSecret(pulumi.StringMap{
+				"redis-password": pulumi.String(password),
+			}).(pulumi.StringMapOutput),
+		}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{namespace}))...)
+		if err != nil {
+			return nil, err
+		}
+		cluster.Installed = append(cluster.Installed, secret)
+		external["existingSecret"] = pulumi.String(argoExternalRedisSecretName)
+	}
+	return pulumi.Map{
+		"redis":         pulumi.Map{"enabled": pulumi.Bool(false)},
+		"externalRedis": external,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// InstallClusterAutoscaler installs the cluster autoscaler into kube-system when
+// `clusterAutoscaler` is set for the cluster's environment. It finds the node
+// groups through the tags EKS puts on their autoscaling groups, and its IRSA role
+// can only resize the groups tagged as this cluster's.
+func InstallClusterAutoscaler(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "clusterAutoscaler", env, false)
+	if err != nil || !enabled {
+		return err
+	}
+	if len(cluster.NodeGroups) == 0 {
+		return fmt.Errorf("clusterAutoscaler is set for %s, which has no node group to scale", env)
+	}
+	region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return err
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-cluster-autoscaler-irsa", env), oidcProvider,
+		"kube-system", "cluster-autoscaler", nil, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicy(ctx, fmt.Sprintf("%s-cluster-autoscaler-irsa-policy", env), &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: pulumi.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": [
+		            "autoscaling:DescribeAutoScalingGroups",
+		            "autoscaling:DescribeAutoScalingInstances",
+		            "autoscaling:DescribeLaunchConfigurations",
+		            "autoscaling:DescribeScalingActivities",
+		            "autoscaling:DescribeTags",
+		            "ec2:DescribeImages",
+		            "ec2:DescribeInstanceTypes",
+		            "ec2:DescribeLaunchTemplateVersions",
+		            "ec2:GetInstanceTypesFromInstanceRequirements",
+		            "eks:DescribeNodegroup"
+		        ],
+		        "Resource": "*"
+		    }, {
+		        "Effect": "Allow",
+		        "Action": [
+		            "autoscaling:SetDesiredCapacity",
+		            "autoscaling:TerminateInstanceInAutoScalingGroup"
+		        ],
+		        "Resource": "*",
+		        "Condition": {
+		            "StringEquals": {
+		                "aws:ResourceTag/k8s.io/cluster-autoscaler/%s": "owned"
+		            }
+		        }
+		    }]
+		}`, cluster.Cluster.Name),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+
+	_, err = installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "cluster-autoscaler",
+		Namespace: "kube-system",
+		Repo:      "https://kubernetes.github.io/autoscaler",
+	}, pulumi.Map{
+		"autoDiscovery": pulumi.Map{
+			"clusterName": cluster.Cluster.Name,
+		},
+		"awsRegion": pulumi.String(region.Name),
+		"rbac": pulumi.Map{
+			"serviceAccount": pulumi.Map{
+				"name": pulumi.String("cluster-autoscaler"),
+				"annotations": pulumi.Map{
+					"eks.amazonaws.com/role-arn": role.Arn,
+				},
+			},
+		},
+	}, pulumi.DependsOn(cluster.ComputeResources()))
+	return err
+}
@@ -0,0 +1,52 @@
+package addons
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// How long a chart repo has to answer for its index before it is reported unreachable.
+const chartRepoTimeout = 10 * time.Second
+
+var chartRepoClient = &http.Client{Timeout: chartRepoTimeout}
+
+// The chart repos found reachable so far, so each is only checked once however
+// many charts and clusters use it.
+var reachableChartRepos sync.Map
+
+// Check that the repo of the chart about to be installed serves an index.yaml,
+// so a mistyped or unreachable repo, such as a mirror that is down, fails with
+// an error naming it rather than a late one from the Helm fetch. Skipped with
+// `skipHelmRepoCheck`, for offline installs, and for OCI registries, which have
+// no index.
+func checkChartRepo(cfg *config.Config, spec chartSpec) error {
+	if cfg.GetBool("skipHelmRepoCheck") || strings.HasPrefix(spec.Repo, "oci://") {
+		return nil
+	}
+	if _, ok := reachableChartRepos.Load(spec.Repo); ok {
+		return nil
+	}
+	index := strings.TrimSuffix(spec.Repo, "/") + "/index.yaml"
+	resp, err := chartRepoClient.Head(index)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		// Not every server answers HEAD; the index is only read far enough to see the status
+		resp.Body.Close()
+		resp, err = chartRepoClient.Get(index)
+	}
+	if err != nil {
+		return fmt.Errorf("chart repo %s for %s is unreachable: %w; check the repo URL, or set skipHelmRepoCheck for offline installs",
+			spec.Repo, spec.Name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("chart repo %s for %s answered %s for its index.yaml; check the repo URL, or set skipHelmRepoCheck for offline installs",
+			spec.Repo, spec.Name, resp.Status)
+	}
+	reachableChartRepos.Store(spec.Repo, true)
+	return nil
+}
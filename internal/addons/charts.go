@@ -0,0 +1,174 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Version reported for a chart that `chartVersions` does not pin, which helm
+// resolves to the newest release in the repo at install time.
+const unpinnedChartVersion = "latest"
+
+// Charts are recorded on the cluster they are installed into, whose parameters
+// would shadow the cluster package in most of this package.
+type chartSpec = cluster.Chart
+
+// An entry of `helmInstallOptions`: per-environment install switches for one
+// chart, each an object from environment name to true or false.
+type chartInstallOptions struct {
+	SkipAwait map[string]bool `json:"skipAwait"`
+	Atomic    map[string]bool `json:"atomic"`
+}
+
+// Read `helmInstallOptions`, keyed by chart name.
+func helmInstallOptionsConfig(cfg *config.Config) (map[string]chartInstallOptions, error) {
+	var byChart map[string]chartInstallOptions
+	if err := cfg.GetObject("helmInstallOptions", &byChart); err != nil {
+		return nil, fmt.Errorf("helmInstallOptions must map chart names to {skipAwait, atomic} objects of environment names to true or false: %w", err)
+	}
+	return byChart, nil
+}
+
+// How env installs chart: whether to skip waiting for its resources, from the
+// chart's `helmInstallOptions` entry or else `helmSkipAwait`, and whether a failed
+// install or upgrade is rolled back, from its entry or else whenever it is a
+// release. Only a Helm release can be rolled back, so asking for atomic needs
+// release.
+func chartInstallConfig(cfg *config.Config, chart string, env string, release bool) (skipAwait bool, atomic bool, err error) {
+	skipAwait, err = stackconfig.GetEnvBool(cfg, "helmSkipAwait", env, false)
+	if err != nil {
+		return false, false, err
+	}
+	byChart, err := helmInstallOptionsConfig(cfg)
+	if err != nil {
+		return false, false, err
+	}
+	options := byChart[chart]
+	if v, ok := options.SkipAwait[env]; ok {
+		skipAwait = v
+	}
+	atomic = release
+	if v, ok := options.Atomic[env]; ok {
+		if v && !release {
+			return false, false, fmt.Errorf("helmInstallOptions makes %s atomic in %s, which needs helmRelease", chart, env)
+		}
+		atomic = v
+	}
+	return skipAwait, atomic, nil
+}
+
+// Install a chart into the cluster as `<env>-<chart>`, with its values built by
+// chartValues from inline and at the version `chartVersions` pins it to, if any.
+// With `helmRelease` set for the environment it is installed as a Helm release
+// rather than rendered by Pulumi, which needs the version pinned. Waiting and
+// rolling back are set by chartInstallConfig. The chart's
+// repo is checked first, and the chart is recorded on the cluster for
+// ChartInventory.
+func installChart(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster, spec chartSpec, inline pulumi.Map,
+	opts ...pulumi.ResourceOption) (pulumi.Resource, error) {
+	env := cluster.Env
+	release, err := stackconfig.GetEnvBool(cfg, "helmRelease", env, false)
+	if err != nil {
+		return nil, err
+	}
+	skipAwait, atomic, err := chartInstallConfig(cfg, spec.Name, env, release)
+	if err != nil {
+		return nil, err
+	}
+	values, err := chartValues(cfg, spec.Name, env, inline)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChartRepo(cfg, spec); err != nil {
+		return nil, err
+	}
+	var versions map[string]string
+	if err := cfg.GetObject("chartVersions", &versions); err != nil {
+		return nil, fmt.Errorf("chartVersions must map chart names to versions: %w", err)
+	}
+	spec.Version = unpinnedChartVersion
+	if version := versions[spec.Name]; version != "" {
+		spec.Version = version
+	}
+	// Helm takes an OCI chart as a full reference rather than a name in a repo
+	oci := strings.HasPrefix(spec.Repo, "oci://")
+	chartRef := spec.Name
+	if oci {
+		chartRef = strings.TrimSuffix(spec.Repo, "/") + "/" + spec.Name
+	}
+	name := fmt.Sprintf("%s-%s", env, spec.Name)
+
+	var chart pulumi.Resource
+	if release {
+		if spec.Version == unpinnedChartVersion {
+			return nil, fmt.Errorf("helmRelease is set for %s, so chartVersions must pin %s", env, spec.Name)
+		}
+		args := &helm.ReleaseArgs{
+			// The release name the chart's objects were rendered under, so they keep their names
+			Name:      pulumi.String(chartReleaseName(env, spec.Name)),
+			Chart:     pulumi.String(chartRef),
+			Version:   pulumi.String(spec.Version),
+			Namespace: pulumi.String(spec.Namespace),
+			Values:    values,
+			// Roll a failed install or upgrade back rather than leave it half applied
+			Atomic:        pulumi.Bool(atomic),
+			CleanupOnFail: pulumi.Bool(atomic),
+			SkipAwait:     pulumi.Bool(skipAwait),
+			WaitForJobs:   pulumi.Bool(!skipAwait),
+		}
+		if !oci {
+			args.RepositoryOpts = &helm.RepositoryOptsArgs{Repo: pulumi.String(spec.Repo)}
+		}
+		chart, err = helm.NewRelease(ctx, name, args, cluster.KubernetesOpts(opts...)...)
+	} else {
+		args := helm.ChartArgs{
+			Chart:          pulumi.String(chartRef),
+			Namespace:      pulumi.String(spec.Namespace),
+			ResourcePrefix: env,
+			Values:         values,
+			SkipAwait:      pulumi.Bool(skipAwait),
+		}
+		if !oci {
+			args.FetchArgs = helm.FetchArgs{Repo: pulumi.String(spec.Repo)}
+		}
+		if spec.Version != unpinnedChartVersion {
+			args.Version = pulumi.String(spec.Version)
+		}
+		chart, err = helm.NewChart(ctx, name, args, cluster.KubernetesOpts(opts...)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cluster.Installed = append(cluster.Installed, chart)
+	cluster.Charts = append(cluster.Charts, spec)
+	return chart, nil
+}
+
+// The Helm release name of chart in env's cluster. helm.Chart prefixes the
+// release name with its ResourcePrefix like every other name, so the chart's
+// objects are named after <env>-<env>-<chart>.
+func chartReleaseName(env, chart string) string {
+	return fmt.Sprintf("%s-%s-%s", env, env, chart)
+}
+
+// ChartInventory lists the Helm charts installed into each cluster, keyed by
+// environment, as JSON for the helmCharts stack output.
+func ChartInventory(clusters []*cluster.Cluster) (string, error) {
+	inventory := map[string][]chartSpec{}
+	for _, cluster := range clusters {
+		inventory[cluster.Env] = append([]chartSpec{}, cluster.Charts...)
+	}
+	data, err := json.Marshal(inventory)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,138 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const containerInsightsNamespace = "amazon-cloudwatch"
+
+const eksChartsRepo = "https://aws.github.io/eks-charts"
+
+// InstallContainerInsights installs the Container Insights stack: the CloudWatch agent for node and pod
+// metrics and Fluent Bit for container logs, each with an IRSA role that can
+// write to CloudWatch.
+func InstallContainerInsights(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	clusterName := cluster.Cluster.Name
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	retentionDays, err := stackconfig.LogRetentionDays(cfg, "containerInsightsLogRetentionDays")
+	if err != nil {
+		return err
+	}
+	enableMetrics := stackconfig.GetBoolDefault(cfg, "containerInsightsMetrics", true)
+	enableLogs := stackconfig.GetBoolDefault(cfg, "containerInsightsLogs", true)
+	region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return err
+	}
+
+	// No namespaceLabels here: the agents mount host paths, which the baseline Pod
+	// Security Standard those labels usually enforce does not allow
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-cloudwatch-ns", env), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(containerInsightsNamespace),
+		},
+	}, cluster.KubernetesOpts()...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, namespace)
+
+	if enableMetrics {
+		agentRole, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-cloudwatch-agent-irsa", env), oidcProvider,
+			containerInsightsNamespace, "cloudwatch-agent", []string{"arn:aws:iam::aws:policy/CloudWatchAgentServerPolicy"},
+			cluster.ResourceOpts()...)
+		if err != nil {
+			return err
+		}
+		// Container Insights writes performance events here; create it up front so retention applies
+		_, err = cloudwatch.NewLogGroup(ctx, fmt.Sprintf("%s-container-insights-performance", env), &cloudwatch.LogGroupArgs{
+			Name:            pulumi.Sprintf("/aws/containerinsights/%s/performance", clusterName),
+			RetentionInDays: pulumi.Int(retentionDays),
+		}, cluster.ResourceOpts(stackconfig.DataResourceOpts(cfg)...)...)
+		if err != nil {
+			return err
+		}
+		_, err = installChart(ctx, cfg, cluster, chartSpec{
+			Name:      "aws-cloudwatch-metrics",
+			Namespace: containerInsightsNamespace,
+			Repo:      eksChartsRepo,
+		}, pulumi.Map{
+			"clusterName": clusterName,
+			"serviceAccount": pulumi.Map{
+				"name": pulumi.String("cloudwatch-agent"),
+				"annotations": pulumi.Map{
+					"eks.amazonaws.com/role-arn": agentRole.Arn,
+				},
+			},
+		}, pulumi.DependsOn([]pulumi.Resource{namespace}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if enableLogs {
+		fluentBitRole, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-fluent-bit-irsa", env), oidcProvider,
+			containerInsightsNamespace, "fluent-bit", []string{"arn:aws:iam::aws:policy/CloudWatchAgentServerPolicy"},
+			cluster.ResourceOpts()...)
+		if err != nil {
+			return err
+		}
+		logGroup, err := cloudwatch.NewLogGroup(ctx, fmt.Sprintf("%s-container-insights-application", env), &cloudwatch.LogGroupArgs{
+			Name:            pulumi.Sprintf("/aws/containerinsights/%s/application", clusterName),
+			RetentionInDays: pulumi.Int(retentionDays),
+		}, cluster.ResourceOpts(stackconfig.DataResourceOpts(cfg)...)...)
+		if err != nil {
+			return err
+		}
+		_, err = installChart(ctx, cfg, cluster, chartSpec{
+			Name:      "aws-for-fluent-bit",
+			Namespace: containerInsightsNamespace,
+			Repo:      eksChartsRepo,
+		}, pulumi.Map{
+			"serviceAccount": pulumi.Map{
+				"name": pulumi.String("fluent-bit"),
+				"annotations": pulumi.Map{
+					"eks.amazonaws.com/role-arn": fluentBitRole.Arn,
+				},
+			},
+			"cloudWatch": pulumi.Map{
+				"enabled": pulumi.Bool(false),
+			},
+			"cloudWatchLogs": pulumi.Map{
+				"enabled":          pulumi.Bool(true),
+				"region":           pulumi.String(region.Name),
+				"logGroupName":     logGroup.Name,
+				"autoCreateGroup":  pulumi.Bool(false),
+				"logRetentionDays": pulumi.Int(retentionDays),
+			},
+			"firehose": pulumi.Map{
+				"enabled": pulumi.Bool(false),
+			},
+			"kinesis": pulumi.Map{
+				"enabled": pulumi.Bool(false),
+			},
+			"elasticsearch": pulumi.Map{
+				"enabled": pulumi.Bool(false),
+			},
+		}, pulumi.DependsOn([]pulumi.Resource{namespace}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
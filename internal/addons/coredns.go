@@ -0,0 +1,123 @@
+package addons
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+var dnsZone = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)*[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// The server block EKS ships in the coredns ConfigMap, which every Corefile we
+// write starts with.
+const eksDefaultCorefile = `.:53 {
+    errors
+    health {
+        lameduck 5s
+    }
+    ready
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+        pods insecure
+        fallthrough in-addr.arpa ip6.arpa
+    }
+    prometheus :9153
+    forward . /etc/resolv.conf
+    cache 30
+    loop
+    reload
+    loadbalance
+}
+`
+
+// An entry of the `corednsZones` config list: queries for Zone are forwarded to
+// Upstreams instead of the VPC resolver.
+type corednsZone struct {
+	Zone      string   `json:"zone"`
+	Upstreams []string `json:"upstreams"`
+}
+
+// Read and validate the `corednsZones` config list.
+func corednsZonesConfig(cfg *config.Config) ([]corednsZone, error) {
+	var zones []corednsZone
+	if err := cfg.GetObject("corednsZones", &zones); err != nil {
+		return nil, fmt.Errorf("corednsZones must be a list of {zone, upstreams} objects: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, z := range zones {
+		if !dnsZone.MatchString(z.Zone) {
+			return nil, fmt.Errorf("corednsZones zone %q is not a valid DNS name", z.Zone)
+		}
+		if seen[z.Zone] {
+			return nil, fmt.Errorf("corednsZones zone %q is listed more than once", z.Zone)
+		}
+		seen[z.Zone] = true
+		if len(z.Upstreams) == 0 {
+			return nil, fmt.Errorf("corednsZones zone %q needs at least one upstream", z.Zone)
+		}
+		for _, upstream := range z.Upstreams {
+			if net.ParseIP(upstream) == nil {
+				return nil, fmt.Errorf("corednsZones upstream %q of %s is not an IP address", upstream, z.Zone)
+			}
+		}
+	}
+	return zones, nil
+}
+
+// Append a forwarding server block per zone to the EKS default Corefile.
+func corefile(zones []corednsZone) string {
+	var b strings.Builder
+	b.WriteString(eksDefaultCorefile)
+	for _, z := range zones {
+		fmt.Fprintf(&b, "%s:53 {\n    errors\n    cache 30\n    forward . %s\n    reload\n}\n", z.Zone, strings.Join(z.Upstreams, " "))
+	}
+	return b.String()
+}
+
+// ConfigureCoreDns patches the cluster's coredns ConfigMap so the zones in the
+// `corednsZones` config are forwarded to their own upstreams. Does nothing when
+// the list is empty. CoreDNS reloads the Corefile by itself.
+//
+// The patch is retained when removed from the program, as releasing it would
+// strip the Corefile from the ConfigMap. Emptying the list therefore leaves the
+// last Corefile in place; eksDefaultCorefile is what to restore by hand.
+func ConfigureCoreDns(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	zones, err := corednsZonesConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+
+	ssaProvider, err := cluster.ServerSideApplyProvider(ctx)
+	if err != nil {
+		return err
+	}
+	patch, err := corev1.NewConfigMapPatch(ctx, fmt.Sprintf("%s-coredns-patch", cluster.Env), &corev1.ConfigMapPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String("coredns"),
+			Namespace: pulumi.String("kube-system"),
+			Annotations: pulumi.StringMap{
+				// The Corefile is owned by the EKS field manager
+				"pulumi.com/patchForce": pulumi.String("true"),
+			},
+		},
+		Data: pulumi.StringMap{
+			"Corefile": pulumi.String(corefile(zones)),
+		},
+		// Applied over the Corefile the managed add-on writes
+	}, cluster.ResourceOpts(pulumi.Provider(ssaProvider), pulumi.RetainOnDelete(true), pulumi.DependsOn(cluster.EksAddons))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, patch)
+	return nil
+}
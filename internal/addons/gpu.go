@@ -0,0 +1,26 @@
+package addons
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// InstallGpuDevicePlugin installs the NVIDIA device plugin onto the GPU nodes
+// when the cluster has them, so pods can request `nvidia.com/gpu`.
+func InstallGpuDevicePlugin(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	if cluster.GpuNodeGroup == nil {
+		return nil
+	}
+	values := pulumi.Map{}
+	for k, v := range cluster.GpuTaint.Scheduling() {
+		values[k] = v
+	}
+	_, err := installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "nvidia-device-plugin",
+		Namespace: "kube-system",
+		Repo:      "https://nvidia.github.io/k8s-device-plugin",
+	}, values, pulumi.DependsOn([]pulumi.Resource{cluster.GpuNodeGroup}))
+	return err
+}
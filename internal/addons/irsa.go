@@ -0,0 +1,60 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+)
+
+// IrsaRole is a Kubernetes service account bound through IRSA to an IAM role.
+type IrsaRole struct {
+	Role           *iam.Role
+	ServiceAccount *corev1.ServiceAccount
+}
+
+// NewIrsaRole creates the service account serviceAccount in namespace, annotated
+// with an IAM role that only it can assume through the cluster's OIDC provider,
+// with the managed policies attached. The role is named `<env>-<service
+// account>-irsa` and the service account `<env>-<service account>-sa`, so the
+// service account name must be unique in the cluster among these. It is created
+// once everything installed so far exists, which includes the namespace when it
+// comes from CreateNamespaces. Add-ons installed by chart instead pass the role
+// ARN to the chart's own service account, as InstallClusterAutoscaler does.
+func NewIrsaRole(ctx *pulumi.Context, cluster *cluster.Cluster, namespace string, serviceAccount string,
+	policyArns ...string) (*IrsaRole, error) {
+	env := cluster.Env
+	for _, arn := range policyArns {
+		if !iamroles.IamPolicyArn.MatchString(arn) {
+			return nil, fmt.Errorf("the policies of the %s/%s service account must be IAM policy ARNs, got %q", namespace, serviceAccount, arn)
+		}
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-%s-irsa", env, serviceAccount), oidcProvider,
+		namespace, serviceAccount, policyArns, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	account, err := corev1.NewServiceAccount(ctx, fmt.Sprintf("%s-%s-sa", env, serviceAccount), &corev1.ServiceAccountArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(serviceAccount),
+			Namespace: pulumi.String(namespace),
+			Annotations: pulumi.StringMap{
+				"eks.amazonaws.com/role-arn": role.Arn,
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.Installed))...)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Installed = append(cluster.Installed, account)
+	return &IrsaRole{Role: role, ServiceAccount: account}, nil
+}
@@ -0,0 +1,382 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	karpenterRepo = "oci://public.ecr.aws/karpenter"
+	// The service account the Karpenter controller runs as in kube-system.
+	karpenterServiceAccount = "karpenter"
+	// The name of the NodePool and EC2NodeClass created for every environment.
+	karpenterNodePool        = "default"
+	defaultKarpenterCpuLimit = 100
+)
+
+// The EC2 and AWS Health events Karpenter drains nodes ahead of, sent to its
+// interruption queue.
+var karpenterInterruptionEvents = []struct {
+	name    string
+	pattern string
+}{
+	{"spot-interruption", `{"source": ["aws.ec2"], "detail-type": ["EC2 Spot Instance Interruption Warning"]}`},
+	{"rebalance", `{"source": ["aws.ec2"], "detail-type": ["EC2 Instance Rebalance Recommendation"]}`},
+	{"instance-state-change", `{"source": ["aws.ec2"], "detail-type": ["EC2 Instance State-change Notification"]}`},
+	{"scheduled-change", `{"source": ["aws.health"], "detail-type": ["AWS Health Event"]}`},
+}
+
+// The Karpenter controller's policy, after the one its getting started guide
+// creates. It can only launch instances tagged as the cluster's and a NodePool's,
+// and only terminate those.
+const karpenterControllerPolicy = `{
+    "Version": "2012-10-17",
+    "Statement": [{
+        "Sid": "AllowScopedEC2InstanceAccessActions",
+        "Effect": "Allow",
+        "Action": ["ec2:RunInstances", "ec2:CreateFleet"],
+        "Resource": [
+            "arn:aws:ec2:%[1]s::image/*",
+            "arn:aws:ec2:%[1]s::snapshot/*",
+            "arn:aws:ec2:%[1]s:*:security-group/*",
+            "arn:aws:ec2:%[1]s:*:subnet/*",
+            "arn:aws:ec2:%[1]s:*:capacity-reservation/*"
+        ]
+    }, {
+        "Sid": "AllowScopedEC2LaunchTemplateAccessActions",
+        "Effect": "Allow",
+        "Action": ["ec2:RunInstances", "ec2:CreateFleet"],
+        "Resource": "arn:aws:ec2:%[1]s:*:launch-template/*",
+        "Condition": {
+            "StringEquals": {"aws:ResourceTag/kubernetes.io/cluster/%[2]s": "owned"},
+            "StringLike": {"aws:ResourceTag/karpenter.sh/nodepool": "*"}
+        }
+    }, {
+        "Sid": "AllowScopedEC2InstanceActionsWithTags",
+        "Effect": "Allow",
+        "Action": ["ec2:RunInstances", "ec2:CreateFleet", "ec2:CreateLaunchTemplate"],
+        "Resource": [
+            "arn:aws:ec2:%[1]s:*:fleet/*",
+            "arn:aws:ec2:%[1]s:*:instance/*",
+            "arn:aws:ec2:%[1]s:*:volume/*",
+            "arn:aws:ec2:%[1]s:*:network-interface/*",
+            "arn:aws:ec2:%[1]s:*:launch-template/*",
+            "arn:aws:ec2:%[1]s:*:spot-instances-request/*"
+        ],
+        "Condition": {
+            "StringEquals": {"aws:RequestTag/kubernetes.io/cluster/%[2]s": "owned"},
+            "StringLike": {"aws:RequestTag/karpenter.sh/nodepool": "*"}
+        }
+    }, {
+        "Sid": "AllowScopedResourceCreationTagging",
+        "Effect": "Allow",
+        "Action": "ec2:CreateTags",
+        "Resource": [
+            "arn:aws:ec2:%[1]s:*:fleet/*",
+            "arn:aws:ec2:%[1]s:*:instance/*",
+            "arn:aws:ec2:%[1]s:*:volume/*",
+            "arn:aws:ec2:%[1]s:*:network-interface/*",
+            "arn:aws:ec2:%[1]s:*:launch-template/*",
+            "arn:aws:ec2:%[1]s:*:spot-instances-request/*"
+        ],
+        "Condition": {
+            "StringEquals": {
+                "aws:RequestTag/kubernetes.io/cluster/%[2]s": "owned",
+                "ec2:CreateAction": ["RunInstances", "CreateFleet", "CreateLaunchTemplate"]
+            },
+            "StringLike": {"aws:RequestTag/karpenter.sh/nodepool": "*"}
+        }
+    }, {
+        "Sid": "AllowScopedResourceTagging",
+        "Effect": "Allow",
+        "Action": "ec2:CreateTags",
+        "Resource": "arn:aws:ec2:%[1]s:*:instance/*",
+        "Condition": {
+            "StringEquals": {"aws:ResourceTag/kubernetes.io/cluster/%[2]s": "owned"},
+            "StringLike": {"aws:ResourceTag/karpenter.sh/nodepool": "*"},
+            "ForAllValues:StringEquals": {"aws:TagKeys": ["karpenter.sh/nodeclaim", "Name"]}
+        }
+    }, {
+        "Sid": "AllowScopedDeletion",
+        "Effect": "Allow",
+        "Action": ["ec2:TerminateInstances", "ec2:DeleteLaunchTemplate"],
+        "Resource": [
+            "arn:aws:ec2:%[1]s:*:instance/*",
+            "arn:aws:ec2:%[1]s:*:launch-template/*"
+        ],
+        "Condition": {
+            "StringEquals": {"aws:ResourceTag/kubernetes.io/cluster/%[2]s": "owned"},
+            "StringLike": {"aws:ResourceTag/karpenter.sh/nodepool": "*"}
+        }
+    }, {
+        "Sid": "AllowRegionalReadActions",
+        "Effect": "Allow",
+        "Action": [
+            "ec2:DescribeAvailabilityZones",
+            "ec2:DescribeImages",
+            "ec2:DescribeInstances",
+            "ec2:DescribeInstanceTypeOfferings",
+            "ec2:DescribeInstanceTypes",
+            "ec2:DescribeLaunchTemplates",
+            "ec2:DescribeSecurityGroups",
+            "ec2:DescribeSpotPriceHistory",
+            "ec2:DescribeSubnets"
+        ],
+        "Resource": "*",
+        "Condition": {
+            "StringEquals": {"aws:RequestedRegion": "%[1]s"}
+        }
+    }, {
+        "Sid": "AllowSSMReadActions",
+        "Effect": "Allow",
+        "Action": "ssm:GetParameter",
+        "Resource": "arn:aws:ssm:%[1]s::parameter/aws/service/*"
+    }, {
+        "Sid": "AllowPricingReadActions",
+        "Effect": "Allow",
+        "Action": "pricing:GetProducts",
+        "Resource": "*"
+    }, {
+        "Sid": "AllowInterruptionQueueActions",
+        "Effect": "Allow",
+        "Action": ["sqs:DeleteMessage", "sqs:GetQueueUrl", "sqs:ReceiveMessage"],
+        "Resource": "%[3]s"
+    }, {
+        "Sid": "AllowPassingInstanceRole",
+        "Effect": "Allow",
+        "Action": "iam:PassRole",
+        "Resource": "%[4]s",
+        "Condition": {
+            "StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"}
+        }
+    }, {
+        "Sid": "AllowInstanceProfileReadActions",
+        "Effect": "Allow",
+        "Action": "iam:GetInstanceProfile",
+        "Resource": "*"
+    }, {
+        "Sid": "AllowAPIServerEndpointDiscovery",
+        "Effect": "Allow",
+        "Action": "eks:DescribeCluster",
+        "Resource": "%[5]s"
+    }]
+}`
+
+// Read `karpenterCpuLimit`, the most vCPUs the default NodePool launches.
+func karpenterCpuLimit(cfg *config.Config) (int, error) {
+	limit, set, err := stackconfig.OptionalInt(cfg, "karpenterCpuLimit")
+	if err != nil || !set {
+		return defaultKarpenterCpuLimit, err
+	}
+	if limit < 1 {
+		return 0, fmt.Errorf("karpenterCpuLimit must be at least 1, got %d", limit)
+	}
+	return limit, nil
+}
+
+// Create the SQS queue Karpenter reads interruption events from, with the
+// EventBridge rules that send them.
+func createKarpenterQueue(ctx *pulumi.Context, cluster *cluster.Cluster) (*sqs.Queue, error) {
+	env := cluster.Env
+	queue, err := sqs.NewQueue(ctx, fmt.Sprintf("%s-karpenter-interruption", env), &sqs.QueueArgs{
+		// Events older than this are stale by the time they are read
+		MessageRetentionSeconds: pulumi.Int(300),
+		SqsManagedSseEnabled:    pulumi.Bool(true),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = sqs.NewQueuePolicy(ctx, fmt.Sprintf("%s-karpenter-interruption-policy", env), &sqs.QueuePolicyArgs{
+		QueueUrl: queue.Url,
+		Policy: pulumi.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": ["events.amazonaws.com", "sqs.amazonaws.com"]
+		        },
+		        "Action": "sqs:SendMessage",
+		        "Resource": "%s"
+		    }]
+		}`, queue.Arn),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range karpenterInterruptionEvents {
+		rule, err := cloudwatch.NewEventRule(ctx, fmt.Sprintf("%s-karpenter-%s", env, event.name), &cloudwatch.EventRuleArgs{
+			EventPattern: pulumi.String(event.pattern),
+		}, cluster.ResourceOpts()...)
+		if err != nil {
+			return nil, err
+		}
+		_, err = cloudwatch.NewEventTarget(ctx, fmt.Sprintf("%s-karpenter-%s", env, event.name), &cloudwatch.EventTargetArgs{
+			Rule: rule.Name,
+			Arn:  queue.Arn,
+		}, cluster.ResourceOpts()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return queue, nil
+}
+
+// The nodes' CPU architecture as Kubernetes names it, for Karpenter's requirements.
+func nodeKubernetesArch(cfg *config.Config) (string, error) {
+	arch, err := cluster.NodeArchitecture(cfg)
+	if err != nil {
+		return "", err
+	}
+	if arch == cluster.ArchArm64 {
+		return "arm64", nil
+	}
+	return "amd64", nil
+}
+
+// InstallKarpenter installs Karpenter into kube-system when `karpenter` is set
+// for the cluster's environment, with a default NodePool and EC2NodeClass that
+// launch on-demand and Spot nodes of current c, m and r instance types into the
+// network's subnets, up to `karpenterCpuLimit` vCPUs. The nodes run the latest
+// Amazon Linux 2023 EKS AMI under the node group role, through an instance
+// profile of their own, and are consolidated when empty or underused. Karpenter
+// cordons and drains them ahead of the interruptions its queue is sent. The
+// controller itself needs a node group or Fargate profile to run on.
+func InstallKarpenter(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env, shared := cluster.Env, cluster.Shared
+	if !cluster.KarpenterNodes {
+		return nil
+	}
+	if len(cluster.ComputeResources()) == 0 {
+		return fmt.Errorf("karpenter is set for %s, which has no node group or Fargate profile to run it on", env)
+	}
+	cpuLimit, err := karpenterCpuLimit(cfg)
+	if err != nil {
+		return err
+	}
+	kubernetesArch, err := nodeKubernetesArch(cfg)
+	if err != nil {
+		return err
+	}
+	region, err := aws.GetRegion(ctx, nil, shared.Network.InvokeOpts()...)
+	if err != nil {
+		return err
+	}
+
+	queue, err := createKarpenterQueue(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	instanceProfile, err := iam.NewInstanceProfile(ctx, fmt.Sprintf("%s-karpenter-node-profile", env), &iam.InstanceProfileArgs{
+		Role: shared.NodeGroupRole.Name,
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-karpenter-irsa", env), oidcProvider,
+		"kube-system", karpenterServiceAccount, nil, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+	policy, err := iam.NewRolePolicy(ctx, fmt.Sprintf("%s-karpenter-irsa-policy", env), &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: pulumi.Sprintf(karpenterControllerPolicy, region.Name, cluster.Cluster.Name, queue.Arn,
+			shared.NodeGroupRole.Arn, cluster.Cluster.Arn),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+
+	chart, err := installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "karpenter",
+		Namespace: "kube-system",
+		Repo:      karpenterRepo,
+	}, pulumi.Map{
+		"settings": pulumi.Map{
+			"clusterName":       cluster.Cluster.Name,
+			"interruptionQueue": queue.Name,
+		},
+		"serviceAccount": pulumi.Map{
+			"name": pulumi.String(karpenterServiceAccount),
+			"annotations": pulumi.Map{
+				"eks.amazonaws.com/role-arn": role.Arn,
+			},
+		},
+	}, pulumi.DependsOn(append(cluster.ComputeResources(), policy)))
+	if err != nil {
+		return err
+	}
+
+	var subnetTerms pulumi.Array
+	for _, subnet := range shared.Network.Subnets {
+		subnetTerms = append(subnetTerms, pulumi.Map{"id": subnet.Id})
+	}
+	nodeClass, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-karpenter-node-class", env), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("karpenter.k8s.aws/v1"),
+		Kind:       pulumi.String("EC2NodeClass"),
+		Metadata:   &metav1.ObjectMetaArgs{Name: pulumi.String(karpenterNodePool)},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": pulumi.Map{
+				"instanceProfile":            instanceProfile.Name,
+				"amiSelectorTerms":           pulumi.Array{pulumi.Map{"alias": pulumi.String("al2023@latest")}},
+				"subnetSelectorTerms":        subnetTerms,
+				"securityGroupSelectorTerms": pulumi.Array{pulumi.Map{"id": cluster.NodeSecurityGroupId()}},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{chart}))...)
+	if err != nil {
+		return err
+	}
+	nodePool, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-karpenter-node-pool", env), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("karpenter.sh/v1"),
+		Kind:       pulumi.String("NodePool"),
+		Metadata:   &metav1.ObjectMetaArgs{Name: pulumi.String(karpenterNodePool)},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"nodeClassRef": map[string]interface{}{
+							"group": "karpenter.k8s.aws",
+							"kind":  "EC2NodeClass",
+							"name":  karpenterNodePool,
+						},
+						"requirements": []interface{}{
+							map[string]interface{}{"key": "kubernetes.io/arch", "operator": "In", "values": []string{kubernetesArch}},
+							map[string]interface{}{"key": "karpenter.sh/capacity-type", "operator": "In", "values": []string{"spot", "on-demand"}},
+							map[string]interface{}{"key": "karpenter.k8s.aws/instance-category", "operator": "In", "values": []string{"c", "m", "r"}},
+							map[string]interface{}{"key": "karpenter.k8s.aws/instance-generation", "operator": "Gt", "values": []string{"2"}},
+						},
+						// Replaces nodes monthly, so they pick up new AMIs
+						"expireAfter": "720h",
+					},
+				},
+				"limits": map[string]interface{}{"cpu": cpuLimit},
+				"disruption": map[string]interface{}{
+					"consolidationPolicy": "WhenEmptyOrUnderutilized",
+					"consolidateAfter":    "1m",
+				},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{nodeClass}))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, nodeClass, nodePool)
+	return nil
+}
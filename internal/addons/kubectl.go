@@ -0,0 +1,65 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Writes the kubeconfig to a private temporary file for the commands that follow.
+// It is never echoed, so it does not end up in the command's output.
+const kubectlScriptHeader = `set -e
+kubeconfig=$(mktemp)
+trap 'rm -f "$kubeconfig"' EXIT
+printf '%s' "$KUBECONFIG_DATA" > "$kubeconfig"
+`
+
+// Read `postInstallKubectl`, a list of kubectl command lines. The leading
+// "kubectl" is optional.
+func postInstallKubectlConfig(cfg *config.Config) ([]string, error) {
+	var commands []string
+	if err := cfg.GetObject("postInstallKubectl", &commands); err != nil {
+		return nil, fmt.Errorf("postInstallKubectl must be a list of kubectl commands: %w", err)
+	}
+	for i, command := range commands {
+		args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), "kubectl "))
+		if args == "" || args == "kubectl" {
+			return nil, fmt.Errorf("postInstallKubectl entry %d is empty", i)
+		}
+		commands[i] = args
+	}
+	return commands, nil
+}
+
+// Build the script running each command, in order, against the kubeconfig.
+func kubectlScript(commands []string) string {
+	var script strings.Builder
+	script.WriteString(kubectlScriptHeader)
+	for _, args := range commands {
+		fmt.Fprintf(&script, "kubectl --kubeconfig \"$kubeconfig\" %s\n", args)
+	}
+	return script.String()
+}
+
+// RunPostInstallKubectl runs the `postInstallKubectl` commands against the
+// cluster once everything else has been installed into it, as an escape hatch
+// for changes the typed resources do not cover. They run again whenever the
+// list changes. Does nothing when the list is empty.
+func RunPostInstallKubectl(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	commands, err := postInstallKubectlConfig(cfg)
+	if err != nil || len(commands) == 0 {
+		return err
+	}
+	_, err = local.NewCommand(ctx, fmt.Sprintf("%s-post-install-kubectl", cluster.Env), &local.CommandArgs{
+		Create: pulumi.String(kubectlScript(commands)),
+		Environment: pulumi.StringMap{
+			"KUBECONFIG_DATA": pulumi.ToSecret(cluster.Kubeconfig).(pulumi.StringOutput),
+		},
+	}, cluster.ResourceOpts(pulumi.DependsOn(append(cluster.ComputeResources(), cluster.Installed...)))...)
+	return err
+}
@@ -0,0 +1,90 @@
+package addons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/kustomize"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/yaml"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The names kustomize accepts for the kustomization file.
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// Kinds that are not namespaced, which keep no namespace when the overlay is
+// moved into the app namespace.
+var clusterScopedKinds = []string{
+	"Namespace",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"CustomResourceDefinition",
+	"PersistentVolume",
+	"StorageClass",
+	"PriorityClass",
+	"IngressClass",
+	"ValidatingWebhookConfiguration",
+	"MutatingWebhookConfiguration",
+}
+
+// Read `appKustomizeDir` for env and check it is a kustomize directory. Returns
+// "" when it is not set.
+func appKustomizeDir(cfg *config.Config, env string) (string, error) {
+	dir, err := stackconfig.GetEnvString(cfg, "appKustomizeDir", env)
+	if err != nil || dir == "" {
+		return "", err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("appKustomizeDir %q for %s is not a readable directory", dir, env)
+	}
+	for _, name := range kustomizationFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("appKustomizeDir %q for %s has no kustomization.yaml", dir, env)
+}
+
+// Put every namespaced object of the overlay into namespace, as kustomize's own
+// namespace field would.
+func inNamespace(namespace string) yaml.Transformation {
+	return func(state map[string]interface{}, opts ...pulumi.ResourceOption) {
+		if kind, _ := state["kind"].(string); stackconfig.ContainsString(clusterScopedKinds, kind) {
+			return
+		}
+		metadata, ok := state["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			state["metadata"] = metadata
+		}
+		metadata["namespace"] = namespace
+	}
+}
+
+// DeployAppKustomization deploys the kustomize overlay `appKustomizeDir` names
+// for the cluster's environment into the `<env>-app` namespace, once the
+// namespaces and the app service account exist. kustomize builds the overlay
+// when the program runs, so the directory is read from the machine running
+// `pulumi up`. Does nothing when it is not set.
+func DeployAppKustomization(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	dir, err := appKustomizeDir(cfg, env)
+	if err != nil || dir == "" {
+		return err
+	}
+	app, err := kustomize.NewDirectory(ctx, fmt.Sprintf("%s-app-kustomize", env), kustomize.DirectoryArgs{
+		Directory:       pulumi.String(dir),
+		Transformations: []yaml.Transformation{inNamespace(fmt.Sprintf("%s-app", env))},
+		ResourcePrefix:  env,
+	}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.Installed))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, app)
+	return nil
+}
@@ -0,0 +1,382 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The service account the AWS Load Balancer Controller runs as in kube-system.
+const loadBalancerControllerServiceAccount = "aws-load-balancer-controller"
+
+// Extra metadata for the controller's service account, from
+// `loadBalancerControllerServiceAccount`.
+type serviceAccountMetadata struct {
+	Annotations map[string]string `json:"annotations"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// Read `loadBalancerControllerServiceAccount`. The IRSA role annotation is
+// always set, so it cannot be given here.
+func loadBalancerControllerServiceAccountConfig(cfg *config.Config) (serviceAccountMetadata, error) {
+	var metadata serviceAccountMetadata
+	if err := cfg.GetObject("loadBalancerControllerServiceAccount", &metadata); err != nil {
+		return metadata, fmt.Errorf("loadBalancerControllerServiceAccount must be an object with annotations and labels maps: %w", err)
+	}
+	for key := range metadata.Annotations {
+		if key == "eks.amazonaws.com/role-arn" {
+			return metadata, fmt.Errorf("loadBalancerControllerServiceAccount cannot set the %s annotation, which is the controller's IRSA role", key)
+		}
+		if !cluster.LabelKey.MatchString(key) {
+			return metadata, fmt.Errorf("loadBalancerControllerServiceAccount annotation %q is not a Kubernetes annotation key", key)
+		}
+	}
+	for key, value := range metadata.Labels {
+		if !cluster.LabelKey.MatchString(key) || !cluster.LabelValue.MatchString(value) {
+			return metadata, fmt.Errorf("loadBalancerControllerServiceAccount label %s=%s is not a Kubernetes label", key, value)
+		}
+	}
+	return metadata, nil
+}
+
+// Read `loadBalancerControllerNodeTaint`, the taint and label of the nodes the
+// controller is scheduled onto. Returns nil when not set, leaving the
+// controller to schedule anywhere.
+func loadBalancerControllerNodes(cfg *config.Config) (*cluster.NodeTaint, error) {
+	if cfg.Get("loadBalancerControllerNodeTaint") == "" {
+		return nil, nil
+	}
+	return cluster.NodeTaintConfig(cfg, "loadBalancerControllerNodeTaint", cluster.NodeTaint{})
+}
+
+// The controller's IAM policy as published with its v2 releases. It can only
+// change the load balancers, target groups and security groups it tagged with
+// elbv2.k8s.aws/cluster when it created them.
+const loadBalancerControllerPolicy = `{
+    "Version": "2012-10-17",
+    "Statement": [{
+        "Effect": "Allow",
+        "Action": "iam:CreateServiceLinkedRole",
+        "Resource": "*",
+        "Condition": {
+            "StringEquals": {
+                "iam:AWSServiceName": "elasticloadbalancing.amazonaws.com"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "ec2:DescribeAccountAttributes",
+            "ec2:DescribeAddresses",
+            "ec2:DescribeAvailabilityZones",
+            "ec2:DescribeInternetGateways",
+            "ec2:DescribeVpcs",
+            "ec2:DescribeVpcPeeringConnections",
+            "ec2:DescribeSubnets",
+            "ec2:DescribeSecurityGroups",
+            "ec2:DescribeInstances",
+            "ec2:DescribeNetworkInterfaces",
+            "ec2:DescribeTags",
+            "ec2:GetCoipPoolUsage",
+            "ec2:DescribeCoipPools",
+            "elasticloadbalancing:DescribeLoadBalancers",
+            "elasticloadbalancing:DescribeLoadBalancerAttributes",
+            "elasticloadbalancing:DescribeListeners",
+            "elasticloadbalancing:DescribeListenerCertificates",
+            "elasticloadbalancing:DescribeSSLPolicies",
+            "elasticloadbalancing:DescribeRules",
+            "elasticloadbalancing:DescribeTargetGroups",
+            "elasticloadbalancing:DescribeTargetGroupAttributes",
+            "elasticloadbalancing:DescribeTargetHealth",
+            "elasticloadbalancing:DescribeTags"
+        ],
+        "Resource": "*"
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "cognito-idp:DescribeUserPoolClient",
+            "acm:ListCertificates",
+            "acm:DescribeCertificate",
+            "iam:ListServerCertificates",
+            "iam:GetServerCertificate",
+            "waf-regional:GetWebACL",
+            "waf-regional:GetWebACLForResource",
+            "waf-regional:AssociateWebACL",
+            "waf-regional:DisassociateWebACL",
+            "wafv2:GetWebACL",
+            "wafv2:GetWebACLForResource",
+            "wafv2:AssociateWebACL",
+            "wafv2:DisassociateWebACL",
+            "shield:GetSubscriptionState",
+            "shield:DescribeProtection",
+            "shield:CreateProtection",
+            "shield:DeleteProtection"
+        ],
+        "Resource": "*"
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "ec2:AuthorizeSecurityGroupIngress",
+            "ec2:RevokeSecurityGroupIngress"
+        ],
+        "Resource": "*"
+    }, {
+        "Effect": "Allow",
+        "Action": "ec2:CreateSecurityGroup",
+        "Resource": "*"
+    }, {
+        "Effect": "Allow",
+        "Action": "ec2:CreateTags",
+        "Resource": "arn:aws:ec2:*:*:security-group/*",
+        "Condition": {
+            "StringEquals": {
+                "ec2:CreateAction": "CreateSecurityGroup"
+            },
+            "Null": {
+                "aws:RequestTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "ec2:CreateTags",
+            "ec2:DeleteTags"
+        ],
+        "Resource": "arn:aws:ec2:*:*:security-group/*",
+        "Condition": {
+            "Null": {
+                "aws:RequestTag/elbv2.k8s.aws/cluster": "true",
+                "aws:ResourceTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "ec2:AuthorizeSecurityGroupIngress",
+            "ec2:RevokeSecurityGroupIngress",
+            "ec2:DeleteSecurityGroup"
+        ],
+        "Resource": "*",
+        "Condition": {
+            "Null": {
+                "aws:ResourceTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:CreateLoadBalancer",
+            "elasticloadbalancing:CreateTargetGroup"
+        ],
+        "Resource": "*",
+        "Condition": {
+            "Null": {
+                "aws:RequestTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:CreateListener",
+            "elasticloadbalancing:DeleteListener",
+            "elasticloadbalancing:CreateRule",
+            "elasticloadbalancing:DeleteRule"
+        ],
+        "Resource": "*"
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:AddTags",
+            "elasticloadbalancing:RemoveTags"
+        ],
+        "Resource": [
+            "arn:aws:elasticloadbalancing:*:*:targetgroup/*/*",
+            "arn:aws:elasticloadbalancing:*:*:loadbalancer/net/*/*",
+            "arn:aws:elasticloadbalancing:*:*:loadbalancer/app/*/*"
+        ],
+        "Condition": {
+            "Null": {
+                "aws:RequestTag/elbv2.k8s.aws/cluster": "true",
+                "aws:ResourceTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:AddTags",
+            "elasticloadbalancing:RemoveTags"
+        ],
+        "Resource": [
+            "arn:aws:elasticloadbalancing:*:*:listener/net/*/*/*",
+            "arn:aws:elasticloadbalancing:*:*:listener/app/*/*/*",
+            "arn:aws:elasticloadbalancing:*:*:listener-rule/net/*/*/*",
+            "arn:aws:elasticloadbalancing:*:*:listener-rule/app/*/*/*"
+        ]
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:ModifyLoadBalancerAttributes",
+            "elasticloadbalancing:SetIpAddressType",
+            "elasticloadbalancing:SetSecurityGroups",
+            "elasticloadbalancing:SetSubnets",
+            "elasticloadbalancing:DeleteLoadBalancer",
+            "elasticloadbalancing:ModifyTargetGroup",
+            "elasticloadbalancing:ModifyTargetGroupAttributes",
+            "elasticloadbalancing:DeleteTargetGroup"
+        ],
+        "Resource": "*",
+        "Condition": {
+            "Null": {
+                "aws:ResourceTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": "elasticloadbalancing:AddTags",
+        "Resource": [
+            "arn:aws:elasticloadbalancing:*:*:targetgroup/*/*",
+            "arn:aws:elasticloadbalancing:*:*:loadbalancer/net/*/*",
+            "arn:aws:elasticloadbalancing:*:*:loadbalancer/app/*/*"
+        ],
+        "Condition": {
+            "StringEquals": {
+                "elasticloadbalancing:CreateAction": [
+                    "CreateTargetGroup",
+                    "CreateLoadBalancer"
+                ]
+            },
+            "Null": {
+                "aws:RequestTag/elbv2.k8s.aws/cluster": "false"
+            }
+        }
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:RegisterTargets",
+            "elasticloadbalancing:DeregisterTargets"
+        ],
+        "Resource": "arn:aws:elasticloadbalancing:*:*:targetgroup/*/*"
+    }, {
+        "Effect": "Allow",
+        "Action": [
+            "elasticloadbalancing:SetWebAcl",
+            "elasticloadbalancing:ModifyListener",
+            "elasticloadbalancing:AddListenerCertificates",
+            "elasticloadbalancing:RemoveListenerCertificates",
+            "elasticloadbalancing:ModifyRule"
+        ],
+        "Resource": "*"
+    }]
+}`
+
+// InstallLoadBalancerController installs the AWS Load Balancer Controller into
+// kube-system when `loadBalancerController` is set for the cluster's environment,
+// so Ingresses and LoadBalancer Services get ALBs and NLBs in the network's
+// tagged subnets. Its service account is bound through IRSA to a role with the
+// controller's policy. Returns the role's ARN, or "" when not enabled.
+func InstallLoadBalancerController(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "loadBalancerController", env, false)
+	if err != nil || !enabled {
+		return pulumi.String("").ToStringOutput(), err
+	}
+	if len(cluster.ComputeResources()) == 0 {
+		return pulumi.StringOutput{}, fmt.Errorf("loadBalancerController is set for %s, which has no nodes to run it on", env)
+	}
+	metadata, err := loadBalancerControllerServiceAccountConfig(cfg)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	taint, err := loadBalancerControllerNodes(cfg)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-load-balancer-controller-irsa", env), oidcProvider,
+		"kube-system", loadBalancerControllerServiceAccount, nil, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	policy, err := iam.NewRolePolicy(ctx, fmt.Sprintf("%s-load-balancer-controller-irsa-policy", env), &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: pulumi.String(loadBalancerControllerPolicy),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	annotations := pulumi.Map{}
+	for key, value := range metadata.Annotations {
+		annotations[key] = pulumi.String(value)
+	}
+	annotations["eks.amazonaws.com/role-arn"] = role.Arn
+	values := pulumi.Map{
+		"clusterName": cluster.Cluster.Name,
+		"region":      pulumi.String(region.Name),
+		// Looked up from the node metadata otherwise, which IMDS hop limits can block
+		"vpcId": cluster.Shared.Network.VpcId,
+		"serviceAccount": pulumi.Map{
+			"name":        pulumi.String(loadBalancerControllerServiceAccount),
+			"annotations": annotations,
+		},
+	}
+	if taint != nil {
+		// The chart's tolerations and nodeSelector are top-level values
+		for key, value := range taint.Scheduling() {
+			values[key] = value
+		}
+	}
+	cluster.LoadBalancerController, err = installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "aws-load-balancer-controller",
+		Namespace: "kube-system",
+		Repo:      eksChartsRepo,
+	}, values, pulumi.DependsOn(append(cluster.ComputeResources(), policy)))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if err := labelLoadBalancerControllerServiceAccount(ctx, cluster, metadata.Labels); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return role.Arn, nil
+}
+
+// Add labels to the controller's service account once the chart has created
+// it. The chart's own labels value goes on every object it renders, so they are
+// applied to the service account alone, as a patch with its own field manager.
+func labelLoadBalancerControllerServiceAccount(ctx *pulumi.Context, cluster *cluster.Cluster, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	ssaProvider, err := cluster.ServerSideApplyProvider(ctx)
+	if err != nil {
+		return err
+	}
+	patch, err := corev1.NewServiceAccountPatch(ctx, fmt.Sprintf("%s-load-balancer-controller-sa-patch", cluster.Env), &corev1.ServiceAccountPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String(loadBalancerControllerServiceAccount),
+			Namespace: pulumi.String("kube-system"),
+			Labels:    pulumi.ToStringMap(labels),
+		},
+	}, cluster.ResourceOpts(pulumi.Provider(ssaProvider), pulumi.DependsOn([]pulumi.Resource{cluster.LoadBalancerController}))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, patch)
+	return nil
+}
@@ -0,0 +1,117 @@
+package addons
+
+import (
+	"fmt"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Namespaces that already exist or that other parts of the program create.
+var reservedNamespaces = map[string]bool{
+	"default":           true,
+	"kube-system":       true,
+	"kube-public":       true,
+	"kube-node-lease":   true,
+	"argocd":            true,
+	"amazon-cloudwatch": true,
+	"external-secrets":  true,
+	"prometheus":        true,
+}
+
+// An entry of the `namespaces` config list.
+type namespaceConfig struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Read the `namespaces` config list. The `<env>-app` namespace is always part of
+// it, so listing it only adds labels or annotations.
+func namespacesConfig(cfg *config.Config, env string) ([]namespaceConfig, error) {
+	var configured []namespaceConfig
+	if err := cfg.GetObject("namespaces", &configured); err != nil {
+		return nil, fmt.Errorf("namespaces must be a list of {name, labels, annotations} objects: %w", err)
+	}
+
+	appNamespace := fmt.Sprintf("%s-app", env)
+	namespaces := []namespaceConfig{{Name: appNamespace}}
+	seen := map[string]bool{}
+	for _, ns := range configured {
+		if len(ns.Name) > 63 || !stackconfig.DnsLabel.MatchString(ns.Name) {
+			return nil, fmt.Errorf("namespace %q is not a valid DNS label", ns.Name)
+		}
+		if reservedNamespaces[ns.Name] {
+			return nil, fmt.Errorf("namespace %q is already managed outside the namespaces list", ns.Name)
+		}
+		if seen[ns.Name] {
+			return nil, fmt.Errorf("namespace %q is listed more than once", ns.Name)
+		}
+		seen[ns.Name] = true
+		if ns.Name == appNamespace {
+			namespaces[0] = ns
+			continue
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+// Merge the `namespaceLabels` config, the labels every namespace the program
+// creates gets (e.g. to enforce a Pod Security Standard), with a namespace's own
+// labels, which win on conflicts.
+func namespaceLabels(cfg *config.Config, labels map[string]string) (pulumi.StringMapInput, error) {
+	var base map[string]string
+	if err := cfg.GetObject("namespaceLabels", &base); err != nil {
+		return nil, fmt.Errorf("namespaceLabels must be a map of label names to values: %w", err)
+	}
+	merged := pulumi.StringMap{}
+	for k, v := range base {
+		merged[k] = pulumi.String(v)
+	}
+	for k, v := range labels {
+		merged[k] = pulumi.String(v)
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// CreateNamespaces creates the `<env>-app` namespace for demo workloads and any
+// others listed in the `namespaces` config. Like the argocd namespace they wait
+// for the cluster's compute, so they are not created while the provider can
+// reach an API server without any nodes.
+func CreateNamespaces(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	namespaces, err := namespacesConfig(cfg, cluster.Env)
+	if err != nil {
+		return err
+	}
+	for i, ns := range namespaces {
+		resourceName := fmt.Sprintf("%s-ns-%s", cluster.Env, ns.Name)
+		if i == 0 {
+			resourceName = fmt.Sprintf("%s-app-ns", cluster.Env)
+		}
+		labels, err := namespaceLabels(cfg, ns.Labels)
+		if err != nil {
+			return err
+		}
+		namespace, err := corev1.NewNamespace(ctx, resourceName, &corev1.NamespaceArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name:        pulumi.String(ns.Name),
+				Labels:      labels,
+				Annotations: pulumi.ToStringMap(ns.Annotations),
+			},
+		}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+		if err != nil {
+			return err
+		}
+		cluster.Installed = append(cluster.Installed, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// InstallNodeTerminationHandler installs aws-node-termination-handler when
+// `nodeTerminationHandler` is set for the cluster's environment. It runs on every
+// node and cordons and drains it when EC2 announces a spot interruption,
+// rebalance recommendation or scheduled maintenance. Node group replacements
+// need nothing extra, as EKS drains managed nodes through its own lifecycle hook.
+func InstallNodeTerminationHandler(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "nodeTerminationHandler", env, false)
+	if err != nil || !enabled {
+		return err
+	}
+	if len(cluster.NodeGroups) == 0 {
+		return fmt.Errorf("nodeTerminationHandler is set for %s, which has no node group to run it on", env)
+	}
+	_, err = installChart(ctx, cfg, cluster, chartSpec{
+		Name:      "aws-node-termination-handler",
+		Namespace: "kube-system",
+		Repo:      eksChartsRepo,
+	}, pulumi.Map{
+		"enableSpotInterruptionDraining": pulumi.Bool(true),
+		"enableRebalanceDraining":        pulumi.Bool(true),
+		"enableScheduledEventDraining":   pulumi.Bool(true),
+	}, pulumi.DependsOn(cluster.ComputeResources()))
+	return err
+}
@@ -0,0 +1,136 @@
+package addons
+
+import (
+	"fmt"
+
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	// Statically linked, so it runs inside any image of the node's architecture.
+	prepullBusyboxImage = "public.ecr.aws/docker/library/busybox:1.36-musl"
+	prepullPauseImage   = "registry.k8s.io/pause:3.9"
+)
+
+// The charts whose images are pulled ahead with `imagePrepull`, and where their
+// values set the image of their main component.
+var prepullCharts = []struct {
+	chart string
+	path  []string
+}{
+	{"argo-cd", []string{"global", "image"}},
+	{"argo-rollouts", []string{"controller", "image"}},
+}
+
+// The images the charts' value overrides pin for env, as repository:tag. An
+// image is only known once the values file sets both: left to the chart, the
+// tag follows the chart's appVersion, which is not known before it is fetched.
+func prepullImages(cfg *config.Config, env string) ([]string, error) {
+	var images []string
+	for _, c := range prepullCharts {
+		values, err := chartValuesFile(cfg, c.chart, env)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range c.path {
+			values, _ = values[key].(map[string]interface{})
+		}
+		repository, _ := values["repository"].(string)
+		tag, _ := values["tag"].(string)
+		if repository == "" || tag == "" {
+			continue
+		}
+		images = append(images, fmt.Sprintf("%s:%s", repository, tag))
+	}
+	return images, nil
+}
+
+// InstallImagePrepuller runs a DaemonSet in kube-system that pulls the Argo images
+// onto every node, when `imagePrepull` is on for the cluster's environment (off
+// by default). InstallArgo waits for it, so the Argo pods find their images on
+// the node instead of pulling them on first schedule. The images come from the
+// repository and tag set in the Argo charts' values files.
+//
+// Every image is run as an init container executing a copy of busybox, which
+// works whether or not the image has a shell of its own.
+func InstallImagePrepuller(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "imagePrepull", env, false)
+	if err != nil || !enabled {
+		return err
+	}
+	images, err := prepullImages(cfg, env)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("imagePrepull is on for %s but no Argo image is pinned; set global.image.repository and tag in values-argo-cd-%s.yaml or controller.image in values-argo-rollouts-%s.yaml under helmValuesDir",
+			env, env, env)
+	}
+
+	mount := corev1.VolumeMountArray{
+		corev1.VolumeMountArgs{Name: pulumi.String("prepull"), MountPath: pulumi.String("/prepull")},
+	}
+	initContainers := corev1.ContainerArray{
+		corev1.ContainerArgs{
+			Name:         pulumi.String("busybox"),
+			Image:        pulumi.String(prepullBusyboxImage),
+			Command:      pulumi.ToStringArray([]string{"cp", "/bin/busybox", "/prepull/busybox"}),
+			VolumeMounts: mount,
+		},
+	}
+	for i, image := range images {
+		initContainers = append(initContainers, corev1.ContainerArgs{
+			Name:         pulumi.String(fmt.Sprintf("pull-%d", i)),
+			Image:        pulumi.String(image),
+			Command:      pulumi.ToStringArray([]string{"/prepull/busybox", "true"}),
+			VolumeMounts: mount,
+		})
+	}
+	labels := pulumi.StringMap{"app.kubernetes.io/name": pulumi.String("image-prepuller")}
+	daemonSet, err := appsv1.NewDaemonSet(ctx, fmt.Sprintf("%s-image-prepuller", env), &appsv1.DaemonSetArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("image-prepuller"),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Spec: &appsv1.DaemonSetSpecArgs{
+			Selector: &metav1.LabelSelectorArgs{MatchLabels: labels},
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{Labels: labels},
+				Spec: &corev1.PodSpecArgs{
+					InitContainers: initContainers,
+					// Keeps the pod, and so the images, on the node
+					Containers: corev1.ContainerArray{
+						corev1.ContainerArgs{
+							Name:  pulumi.String("pause"),
+							Image: pulumi.String(prepullPauseImage),
+							Resources: &corev1.ResourceRequirementsArgs{
+								Requests: pulumi.StringMap{"cpu": pulumi.String("1m"), "memory": pulumi.String("8Mi")},
+							},
+						},
+					},
+					// Including the nodes tainted for Argo or GPUs
+					Tolerations: corev1.TolerationArray{
+						corev1.TolerationArgs{Operator: pulumi.String("Exists")},
+					},
+					Volumes: corev1.VolumeArray{
+						corev1.VolumeArgs{Name: pulumi.String("prepull"), EmptyDir: &corev1.EmptyDirVolumeSourceArgs{}},
+					},
+				},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+	if err != nil {
+		return err
+	}
+	cluster.ImagePrepuller = daemonSet
+	cluster.Installed = append(cluster.Installed, daemonSet)
+	return nil
+}
@@ -0,0 +1,165 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+)
+
+const (
+	sealedSecrets   = "sealed-secrets"
+	externalSecrets = "external-secrets"
+)
+
+const externalSecretsNamespace = "external-secrets"
+
+// InstallSecretsController installs the controller `secretsController` names, if
+// any: `sealed-secrets`, which decrypts SealedSecrets committed to git, or
+// `external-secrets`, which syncs secrets from AWS. The External Secrets Operator
+// gets an IRSA role that can read the account's Secrets Manager secrets and SSM
+// parameters in the cluster's region, and a sample `aws-secrets-manager`
+// SecretStore in the `<env>-app` namespace, so it runs after CreateNamespaces.
+func InstallSecretsController(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	controller, err := secretsControllerConfig(cfg)
+	if err != nil {
+		return err
+	}
+	switch controller {
+	case sealedSecrets:
+		_, err := installChart(ctx, cfg, cluster, chartSpec{
+			Name:      sealedSecrets,
+			Namespace: "kube-system",
+			Repo:      "https://bitnami-labs.github.io/sealed-secrets",
+		}, pulumi.Map{
+			// The name the kubeseal CLI looks for by default
+			"fullnameOverride": pulumi.String("sealed-secrets-controller"),
+		}, pulumi.DependsOn(cluster.ComputeResources()))
+		return err
+	case externalSecrets:
+		return installExternalSecrets(ctx, cfg, cluster)
+	}
+	return nil
+}
+
+// Read `secretsController`, which is empty when no controller is wanted.
+func secretsControllerConfig(cfg *config.Config) (string, error) {
+	switch controller := cfg.Get("secretsController"); controller {
+	case "", sealedSecrets, externalSecrets:
+		return controller, nil
+	default:
+		return "", fmt.Errorf("secretsController must be %s or %s, got %q", sealedSecrets, externalSecrets, controller)
+	}
+}
+
+func installExternalSecrets(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	env := cluster.Env
+	region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return err
+	}
+	identity, err := aws.GetCallerIdentity(ctx, cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return err
+	}
+	oidcProvider, err := cluster.OidcProvider(ctx)
+	if err != nil {
+		return err
+	}
+	role, err := iamroles.CreateIrsaRole(ctx, fmt.Sprintf("%s-external-secrets-irsa", env), oidcProvider,
+		externalSecretsNamespace, externalSecrets, nil, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+	_, err = iam.NewRolePolicy(ctx, fmt.Sprintf("%s-external-secrets-irsa-read-policy", env), &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: pulumi.String(fmt.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": [
+		            "secretsmanager:GetSecretValue",
+		            "secretsmanager:DescribeSecret",
+		            "secretsmanager:ListSecretVersionIds"
+		        ],
+		        "Resource": "arn:aws:secretsmanager:%[1]s:%[2]s:secret:*"
+		    }, {
+		        "Effect": "Allow",
+		        "Action": [
+		            "ssm:GetParameter",
+		            "ssm:GetParameters",
+		            "ssm:GetParametersByPath"
+		        ],
+		        "Resource": "arn:aws:ssm:%[1]s:%[2]s:parameter/*"
+		    }]
+		}`, region.Name, identity.AccountId)),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+
+	labels, err := namespaceLabels(cfg, nil)
+	if err != nil {
+		return err
+	}
+	namespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-external-secrets-ns", env), &corev1.NamespaceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:   pulumi.String(externalSecretsNamespace),
+			Labels: labels,
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, namespace)
+	chart, err := installChart(ctx, cfg, cluster, chartSpec{
+		Name:      externalSecrets,
+		Namespace: externalSecretsNamespace,
+		Repo:      "https://charts.external-secrets.io",
+	}, pulumi.Map{
+		"installCRDs": pulumi.Bool(true),
+		"serviceAccount": pulumi.Map{
+			"name": pulumi.String(externalSecrets),
+			"annotations": pulumi.Map{
+				"eks.amazonaws.com/role-arn": role.Arn,
+			},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{namespace}))
+	if err != nil {
+		return err
+	}
+
+	// With no auth of its own, the store uses the operator's IRSA role
+	store, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-secret-store", env), &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("external-secrets.io/v1beta1"),
+		Kind:       pulumi.String("SecretStore"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("aws-secrets-manager"),
+			Namespace: pulumi.String(fmt.Sprintf("%s-app", env)),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": map[string]interface{}{
+				"provider": map[string]interface{}{
+					"aws": map[string]interface{}{
+						"service": "SecretsManager",
+						"region":  region.Name,
+					},
+				},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn(append([]pulumi.Resource{chart}, cluster.Installed...)))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, store)
+	return nil
+}
@@ -0,0 +1,153 @@
+package addons
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	batchv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/batch/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	smokeTestName  = "eks-smoke-test"
+	smokeTestImage = "curlimages/curl:8.4.0"
+)
+
+// Checks in-cluster DNS, that the API server lists the nodes to the job's service
+// account, and that the Argo CD server answers through its service, which is
+// named after the argo-cd chart's release.
+const smokeTestScriptTemplate = `set -e
+nslookup kubernetes.default.svc.cluster.local
+sa=/var/run/secrets/kubernetes.io/serviceaccount
+curl -sSf --cacert "$sa/ca.crt" -H "Authorization: Bearer $(cat "$sa/token")" \
+	https://kubernetes.default.svc/api/v1/nodes | grep -q '"nodeInfo"'
+curl -sSfk -o /dev/null https://%s-server.argocd.svc/healthz
+echo "smoke test passed"
+`
+
+// The smoke test script for env's cluster.
+func smokeTestScript(env string) string {
+	return fmt.Sprintf(smokeTestScriptTemplate, chartReleaseName(env, "argo-cd"))
+}
+
+// Waits for the smoke test job and prints whether it succeeded, with the job's
+// logs on stderr when it did not.
+const smokeTestWaitScript = kubectlScriptHeader + `if kubectl --kubeconfig "$kubeconfig" -n default wait --for=condition=complete --timeout=300s job/` + smokeTestName + ` >/dev/null; then
+	echo succeeded
+else
+	kubectl --kubeconfig "$kubeconfig" -n default logs job/` + smokeTestName + ` >&2 || true
+	echo failed
+fi
+`
+
+// RunSmokeTest runs a Job in the default namespace that checks the cluster end to
+// end once everything else has been installed into it, when `smokeTest` is on
+// for the cluster's environment (off by default). The job is replaced on every
+// `pulumi up`, deleting the previous run's. Returns "succeeded" or "failed" once
+// the job is done, or an empty output when the test is off. A failed test does
+// not fail the update; its logs are printed instead.
+func RunSmokeTest(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	enabled, err := stackconfig.GetEnvBool(cfg, "smokeTest", env, false)
+	if err != nil || !enabled {
+		return pulumi.String("").ToStringOutput(), err
+	}
+	deps := pulumi.DependsOn(append(cluster.ComputeResources(), cluster.Installed...))
+
+	serviceAccount, err := corev1.NewServiceAccount(ctx, fmt.Sprintf("%s-smoke-test-sa", env), &corev1.ServiceAccountArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(smokeTestName),
+			Namespace: pulumi.String("default"),
+		},
+	}, cluster.KubernetesOpts(deps)...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	role, err := rbacv1.NewClusterRole(ctx, fmt.Sprintf("%s-smoke-test-role", env), &rbacv1.ClusterRoleArgs{
+		Metadata: &metav1.ObjectMetaArgs{Name: pulumi.String(smokeTestName)},
+		Rules: rbacv1.PolicyRuleArray{
+			rbacv1.PolicyRuleArgs{
+				ApiGroups: pulumi.StringArray{pulumi.String("")},
+				Resources: pulumi.StringArray{pulumi.String("nodes")},
+				Verbs:     pulumi.StringArray{pulumi.String("list")},
+			},
+		},
+	}, cluster.KubernetesOpts(deps)...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	binding, err := rbacv1.NewClusterRoleBinding(ctx, fmt.Sprintf("%s-smoke-test-binding", env), &rbacv1.ClusterRoleBindingArgs{
+		Metadata: &metav1.ObjectMetaArgs{Name: pulumi.String(smokeTestName)},
+		RoleRef: &rbacv1.RoleRefArgs{
+			ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+			Kind:     pulumi.String("ClusterRole"),
+			Name:     role.Metadata.Name().Elem(),
+		},
+		Subjects: rbacv1.SubjectArray{
+			rbacv1.SubjectArgs{
+				Kind:      pulumi.String("ServiceAccount"),
+				Name:      serviceAccount.Metadata.Name().Elem(),
+				Namespace: pulumi.String("default"),
+			},
+		},
+	}, cluster.KubernetesOpts(deps)...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	job, err := batchv1.NewJob(ctx, fmt.Sprintf("%s-smoke-test", env), &batchv1.JobArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(smokeTestName),
+			Namespace: pulumi.String("default"),
+		},
+		Spec: &batchv1.JobSpecArgs{
+			BackoffLimit:          pulumi.Int(2),
+			ActiveDeadlineSeconds: pulumi.Int(240),
+			Template: &corev1.PodTemplateSpecArgs{
+				Metadata: &metav1.ObjectMetaArgs{
+					Annotations: pulumi.StringMap{
+						// A job's template cannot change, so a new value replaces
+						// the job, which makes every update run the test again
+						"eksdemo/run": pulumi.String(time.Now().UTC().Format(time.RFC3339)),
+					},
+				},
+				Spec: &corev1.PodSpecArgs{
+					ServiceAccountName: serviceAccount.Metadata.Name().Elem(),
+					RestartPolicy:      pulumi.String("Never"),
+					Containers: corev1.ContainerArray{
+						corev1.ContainerArgs{
+							Name:    pulumi.String("smoke-test"),
+							Image:   pulumi.String(smokeTestImage),
+							Command: pulumi.ToStringArray([]string{"/bin/sh", "-c", smokeTestScript(env)}),
+						},
+					},
+				},
+			},
+		},
+	}, cluster.KubernetesOpts(pulumi.DependsOn([]pulumi.Resource{binding}), pulumi.DeleteBeforeReplace(true))...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	wait, err := local.NewCommand(ctx, fmt.Sprintf("%s-smoke-test-wait", env), &local.CommandArgs{
+		Create: pulumi.String(smokeTestWaitScript),
+		Environment: pulumi.StringMap{
+			"KUBECONFIG_DATA": pulumi.ToSecret(cluster.Kubeconfig).(pulumi.StringOutput),
+		},
+		// Wait again for every new job
+		Triggers: pulumi.Array{job.Metadata.Uid()},
+	}, cluster.ResourceOpts(pulumi.DependsOn([]pulumi.Resource{job}))...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return wait.Stdout.ApplyT(strings.TrimSpace).(pulumi.StringOutput), nil
+}
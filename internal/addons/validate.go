@@ -0,0 +1,77 @@
+package addons
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ValidateConfig runs the checks of the add-on settings that only need the stack
+// config, for every environment, and returns all the problems found as one
+// error. Returns nil when they are valid.
+func ValidateConfig(cfg *config.Config, environments []string) error {
+	var errs stackconfig.Errors
+	_, err := stackconfig.LogRetentionDays(cfg, "containerInsightsLogRetentionDays")
+	errs.Check(err)
+	_, err = namespaceLabels(cfg, nil)
+	errs.Check(err)
+	_, err = corednsZonesConfig(cfg)
+	errs.Check(err)
+	_, err = postInstallKubectlConfig(cfg)
+	errs.Check(err)
+	_, err = secretsControllerConfig(cfg)
+	errs.Check(err)
+	_, err = argoProjectsConfig(cfg)
+	errs.Check(err)
+	_, err = karpenterCpuLimit(cfg)
+	errs.Check(err)
+
+	for _, env := range environments {
+		_, err = argoBootstrapConfig(cfg, env)
+		errs.Check(err)
+		_, err = stackconfig.GetEnvBool(cfg, "eksAddons", env, false)
+		errs.Check(err)
+		_, err = eksAddonVersionsConfig(cfg, env)
+		errs.Check(err)
+		_, err = appKustomizeDir(cfg, env)
+		errs.Check(err)
+		_, err = stackconfig.GetEnvBool(cfg, "loadBalancerController", env, false)
+		errs.Check(err)
+		_, err = loadBalancerControllerServiceAccountConfig(cfg)
+		errs.Check(err)
+		_, err = loadBalancerControllerNodes(cfg)
+		errs.Check(err)
+		release, err := stackconfig.GetEnvBool(cfg, "helmRelease", env, false)
+		errs.Check(err)
+		if byChart, err := helmInstallOptionsConfig(cfg); err != nil {
+			errs.Check(err)
+		} else {
+			for chart := range byChart {
+				_, _, err = chartInstallConfig(cfg, chart, env, release)
+				errs.Check(err)
+			}
+		}
+		_, err = namespacesConfig(cfg, env)
+		errs.Check(err)
+		_, err = argoCdLoadBalancerScheme(cfg, env)
+		errs.Check(err)
+		if ingress, err := argoCdIngressConfig(cfg, env); err != nil {
+			errs.Check(err)
+		} else if ingress != nil {
+			if enabled, _ := stackconfig.GetEnvBool(cfg, "loadBalancerController", env, false); !enabled {
+				errs.Check(fmt.Errorf("argoCdIngress is set for %s, which needs loadBalancerController to get its ALB", env))
+			}
+		}
+		ha, err := stackconfig.GetEnvBool(cfg, "argoCdHa", env, false)
+		errs.Check(err)
+		if mode, err := argoCdRedisMode(cfg, env, ha); err != nil {
+			errs.Check(err)
+		} else if mode == argoRedisExternal {
+			_, err = argoExternalRedisConfig(cfg)
+			errs.Check(err)
+		}
+	}
+	return errs.Err()
+}
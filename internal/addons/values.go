@@ -0,0 +1,105 @@
+package addons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"gopkg.in/yaml.v3"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Build the values for a chart installed into env, after checking the chart can
+// run on the node architecture. When `helmValuesDir` is set,
+// `values-<chart>-<env>.yaml` from that directory is merged over the inline
+// values, so it can change any of the values the program sets, as a later `-f`
+// does with helm.
+func chartValues(cfg *config.Config, chart string, env string, inline pulumi.Map) (pulumi.Map, error) {
+	if err := cluster.CheckChartArchitecture(cfg, chart); err != nil {
+		return nil, err
+	}
+	fileValues, err := chartValuesFile(cfg, chart, env)
+	if err != nil || fileValues == nil {
+		return inline, err
+	}
+	return mergeValues(inline, fileValues), nil
+}
+
+// Load `values-<chart>-<env>.yaml` from `helmValuesDir`. Every chart installed
+// into env needs one once the directory is set, so a misnamed file is not
+// skipped unnoticed. Returns nil when the directory is unset.
+func chartValuesFile(cfg *config.Config, chart string, env string) (map[string]interface{}, error) {
+	dir := cfg.Get("helmValuesDir")
+	if dir == "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("helmValuesDir %q is not a readable directory", dir)
+	}
+
+	name := fmt.Sprintf("values-%s-%s.yaml", chart, env)
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("helmValuesDir %q has no %s for the %s chart in %s; add it, empty to keep the program's values",
+			dir, name, chart, env)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading helm values for %s in %s: %w", chart, env, err)
+	}
+	var fileValues map[string]interface{}
+	if err := yaml.Unmarshal(data, &fileValues); err != nil {
+		return nil, fmt.Errorf("parsing helm values file %s: %w", path, err)
+	}
+	return fileValues, nil
+}
+
+// Deep merge the values loaded from a file over the inline values.
+func mergeValues(inline pulumi.Map, file map[string]interface{}) pulumi.Map {
+	merged := pulumi.Map{}
+	for key, value := range inline {
+		merged[key] = value
+	}
+	for key, value := range file {
+		if fileMap, ok := value.(map[string]interface{}); ok {
+			if inlineMap, ok := inline[key].(pulumi.Map); ok {
+				merged[key] = mergeValues(inlineMap, fileMap)
+				continue
+			}
+		}
+		merged[key] = valueInput(value)
+	}
+	return merged
+}
+
+// Convert a value parsed from YAML into the input it is set as in the chart
+// values.
+func valueInput(value interface{}) pulumi.Input {
+	switch v := value.(type) {
+	case string:
+		return pulumi.String(v)
+	case bool:
+		return pulumi.Bool(v)
+	case int:
+		return pulumi.Int(v)
+	case float64:
+		return pulumi.Float64(v)
+	case map[string]interface{}:
+		m := pulumi.Map{}
+		for key, item := range v {
+			m[key] = valueInput(item)
+		}
+		return m
+	case []interface{}:
+		a := pulumi.Array{}
+		for _, item := range v {
+			a = append(a, valueInput(item))
+		}
+		return a
+	default:
+		return pulumi.Any(v)
+	}
+}
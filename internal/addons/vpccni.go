@@ -0,0 +1,112 @@
+package addons
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Create an ENIConfig for each pod subnet, named after its zone so the VPC CNI
+// picks it by the node's topology.kubernetes.io/zone label. Pods get the cluster
+// security group, as the nodes do.
+func createEniConfigs(ctx *pulumi.Context, cluster *cluster.Cluster) ([]pulumi.Resource, error) {
+	var azs []string
+	for az := range cluster.Shared.PodSubnets {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+	var eniConfigs []pulumi.Resource
+	for _, az := range azs {
+		eniConfig, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("%s-eni-config-%s", cluster.Env, az), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("crd.k8s.amazonaws.com/v1alpha1"),
+			Kind:       pulumi.String("ENIConfig"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(az),
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": map[string]interface{}{
+					"subnet":         cluster.Shared.PodSubnets[az].ID(),
+					"securityGroups": pulumi.StringArray{cluster.SecurityGroupId},
+				},
+			},
+		}, cluster.KubernetesOpts()...)
+		if err != nil {
+			return nil, err
+		}
+		eniConfigs = append(eniConfigs, eniConfig)
+	}
+	return eniConfigs, nil
+}
+
+// ConfigureVpcCni turns on prefix delegation in the VPC CNI when
+// `vpcCniPrefixDelegation` is set, so each ENI slot holds 16 pod IPs instead of
+// one, and custom networking when `podSubnets` is set, so pods get their IPs
+// from the pod subnets rather than the node subnets. The aws-node DaemonSet is
+// patched, over the VPC CNI add-on with `eksAddons`. Nodes launched before
+// either was turned on keep their old pod networking until they are replaced.
+func ConfigureVpcCni(ctx *pulumi.Context, cfg *config.Config, cluster *cluster.Cluster) error {
+	var env corev1.EnvVarPatchArray
+	if cfg.GetBool("vpcCniPrefixDelegation") {
+		env = append(env,
+			corev1.EnvVarPatchArgs{Name: pulumi.String("ENABLE_PREFIX_DELEGATION"), Value: pulumi.String("true")},
+			corev1.EnvVarPatchArgs{Name: pulumi.String("WARM_PREFIX_TARGET"), Value: pulumi.String("1")},
+		)
+	}
+	var eniConfigs []pulumi.Resource
+	if len(cluster.Shared.PodSubnets) > 0 {
+		var err error
+		if eniConfigs, err = createEniConfigs(ctx, cluster); err != nil {
+			return err
+		}
+		env = append(env,
+			corev1.EnvVarPatchArgs{Name: pulumi.String("AWS_VPC_K8S_CNI_CUSTOM_NETWORK_CFG"), Value: pulumi.String("true")},
+			corev1.EnvVarPatchArgs{Name: pulumi.String("ENI_CONFIG_LABEL_DEF"), Value: pulumi.String("topology.kubernetes.io/zone")},
+		)
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	ssaProvider, err := cluster.ServerSideApplyProvider(ctx)
+	if err != nil {
+		return err
+	}
+	// The ENIConfigs must exist before the CNI starts looking them up, and the
+	// env is applied over the managed add-on's
+	patch, err := appsv1.NewDaemonSetPatch(ctx, fmt.Sprintf("%s-vpc-cni-patch", cluster.Env), &appsv1.DaemonSetPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String("aws-node"),
+			Namespace: pulumi.String("kube-system"),
+			Annotations: pulumi.StringMap{
+				// The container env is owned by the EKS field manager
+				"pulumi.com/patchForce": pulumi.String("true"),
+			},
+		},
+		Spec: &appsv1.DaemonSetSpecPatchArgs{
+			Template: &corev1.PodTemplateSpecPatchArgs{
+				Spec: &corev1.PodSpecPatchArgs{
+					Containers: corev1.ContainerPatchArray{
+						corev1.ContainerPatchArgs{
+							Name: pulumi.String("aws-node"),
+							Env:  env,
+						},
+					},
+				},
+			},
+		},
+	}, cluster.ResourceOpts(pulumi.Provider(ssaProvider), pulumi.DependsOn(append(eniConfigs, cluster.EksAddons...)))...)
+	if err != nil {
+		return err
+	}
+	cluster.Installed = append(cluster.Installed, eniConfigs...)
+	cluster.Installed = append(cluster.Installed, patch)
+	return nil
+}
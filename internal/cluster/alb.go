@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lb"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/network"
+)
+
+// AcmCertificateArn matches ACM certificate ARNs, whose region is checked against the ALB's.
+var AcmCertificateArn = regexp.MustCompile(`^arn:aws[a-z-]*:acm:([a-z0-9-]+):[0-9]{12}:certificate/[0-9a-f-]+$`)
+
+// AlbSslPolicy is the security policy of the HTTPS listeners of the ALBs, which
+// allows TLS 1.2 and 1.3.
+const AlbSslPolicy = "ELBSecurityPolicy-TLS13-1-2-2021-06"
+
+// Read `standaloneAlbCertificateArn`, the existing ACM certificate the standalone
+// ALB terminates TLS with, and the region it is in. Returns "" when unset, for
+// plain HTTP.
+func standaloneAlbCertificate(cfg *config.Config) (arn string, region string, err error) {
+	arn = cfg.Get("standaloneAlbCertificateArn")
+	if arn == "" {
+		return "", "", nil
+	}
+	match := AcmCertificateArn.FindStringSubmatch(arn)
+	if match == nil {
+		return "", "", fmt.Errorf("standaloneAlbCertificateArn %q is not an ACM certificate ARN", arn)
+	}
+	return arn, match[1], nil
+}
+
+// CreateStandaloneAlb creates a plain ALB that forwards `standaloneAlbListenerPort` to
+// `standaloneAlbTargetPort` on the node group instances, for demoing traffic that
+// does not go through a Kubernetes ingress. With `standaloneAlbCertificateArn` the
+// listener is HTTPS, on port 443 unless the listener port is set, and the ALB
+// terminates TLS in front of the plain HTTP node port. Returns the ALB DNS name.
+func CreateStandaloneAlb(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	if len(cluster.NodeGroups) == 0 {
+		return pulumi.StringOutput{}, fmt.Errorf("enableStandaloneAlb needs the node group, which enableNodeGroup turns off")
+	}
+	certificateArn, certificateRegion, err := standaloneAlbCertificate(cfg)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if certificateArn != "" {
+		region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		if !strings.EqualFold(certificateRegion, region.Name) {
+			return pulumi.StringOutput{}, fmt.Errorf("standaloneAlbCertificateArn is in %s, but the ALB can only use a certificate from its own region %s",
+				certificateRegion, region.Name)
+		}
+	}
+	vpcId := cluster.Shared.Network.VpcId
+	listenerPort := cfg.GetInt("standaloneAlbListenerPort")
+	if listenerPort == 0 {
+		listenerPort = 80
+		if certificateArn != "" {
+			listenerPort = 443
+		}
+	}
+	targetPort := cfg.GetInt("standaloneAlbTargetPort")
+	if targetPort == 0 {
+		targetPort = 30080
+	}
+	healthCheckPath := cfg.Get("standaloneAlbHealthCheckPath")
+	if healthCheckPath == "" {
+		healthCheckPath = "/"
+	}
+	healthCheckInterval := cfg.GetInt("standaloneAlbHealthCheckInterval")
+	if healthCheckInterval == 0 {
+		healthCheckInterval = 30
+	}
+	for key, port := range map[string]int{"standaloneAlbListenerPort": listenerPort, "standaloneAlbTargetPort": targetPort} {
+		if port < 1 || port > 65535 {
+			return pulumi.StringOutput{}, fmt.Errorf("%s must be between 1 and 65535, got %d", key, port)
+		}
+	}
+
+	albSg, err := ec2.NewSecurityGroup(ctx, fmt.Sprintf("%s-standalone-alb-sg", env), &ec2.SecurityGroupArgs{
+		VpcId: vpcId,
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(listenerPort),
+				ToPort:     pulumi.Int(listenerPort),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// Let the ALB reach the target port on the nodes' security group
+	_, err = ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-standalone-alb-to-nodes", env), &ec2.SecurityGroupRuleArgs{
+		Type:                  pulumi.String("ingress"),
+		Protocol:              pulumi.String("tcp"),
+		FromPort:              pulumi.Int(targetPort),
+		ToPort:                pulumi.Int(targetPort),
+		SecurityGroupId:       cluster.NodeSecurityGroupId(),
+		SourceSecurityGroupId: albSg.ID(),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	alb, err := lb.NewLoadBalancer(ctx, fmt.Sprintf("%s-standalone-alb", env), &lb.LoadBalancerArgs{
+		LoadBalancerType: pulumi.String("application"),
+		SecurityGroups:   pulumi.StringArray{albSg.ID().ToStringOutput()},
+		Subnets:          network.SubnetIds(cluster.Shared.Network.PublicSubnets),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	targetGroup, err := lb.NewTargetGroup(ctx, fmt.Sprintf("%s-standalone-alb-tg", env), &lb.TargetGroupArgs{
+		Port:       pulumi.Int(targetPort),
+		Protocol:   pulumi.String("HTTP"),
+		TargetType: pulumi.String("instance"),
+		VpcId:      vpcId,
+		HealthCheck: &lb.TargetGroupHealthCheckArgs{
+			Path:     pulumi.String(healthCheckPath),
+			Interval: pulumi.Int(healthCheckInterval),
+		},
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	listenerArgs := &lb.ListenerArgs{
+		LoadBalancerArn: alb.Arn,
+		Port:            pulumi.Int(listenerPort),
+		Protocol:        pulumi.String("HTTP"),
+		DefaultActions: lb.ListenerDefaultActionArray{
+			lb.ListenerDefaultActionArgs{
+				Type:           pulumi.String("forward"),
+				TargetGroupArn: targetGroup.Arn,
+			},
+		},
+	}
+	if certificateArn != "" {
+		listenerArgs.Protocol = pulumi.String("HTTPS")
+		listenerArgs.CertificateArn = pulumi.String(certificateArn)
+		listenerArgs.SslPolicy = pulumi.String(AlbSslPolicy)
+	}
+	_, err = lb.NewListener(ctx, fmt.Sprintf("%s-standalone-alb-listener", env), listenerArgs, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// Register the node group instances by attaching their autoscaling groups to the
+	// target group. The attachments are named after the node group's zone, so they
+	// stay put when the zones change; the aliases adopt ones created under the
+	// earlier `-<index>` names.
+	for i, nodeGroup := range cluster.NodeGroups {
+		base := fmt.Sprintf("%s-standalone-alb-attachment", env)
+		name, previous := base, base
+		if zone := cluster.nodeGroupZones[i]; zone != "" {
+			name = fmt.Sprintf("%s-%s", base, zone)
+		}
+		if i > 0 {
+			previous = fmt.Sprintf("%s-%d", base, i)
+		}
+		opts := []pulumi.ResourceOption{}
+		if name != previous {
+			opts = append(opts, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(previous)}}))
+		}
+		_, err = autoscaling.NewAttachment(ctx, name, &autoscaling.AttachmentArgs{
+			AutoscalingGroupName: nodeGroup.Resources.Index(pulumi.Int(0)).AutoscalingGroups().Index(pulumi.Int(0)).Name().Elem(),
+			AlbTargetGroupArn:    targetGroup.Arn,
+		}, cluster.ResourceOpts(opts...)...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+	return alb.DnsName, nil
+}
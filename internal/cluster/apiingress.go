@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// An entry of the `clusterApiIngress` config list. Port defaults to 443, the API
+// endpoint's.
+type apiIngressRule struct {
+	Cidr        string `json:"cidr"`
+	Port        int    `json:"port"`
+	Description string `json:"description"`
+}
+
+// Read the `clusterApiIngress` config list, filling in the default port.
+func clusterApiIngressConfig(cfg *config.Config) ([]apiIngressRule, error) {
+	var rules []apiIngressRule
+	if err := cfg.GetObject("clusterApiIngress", &rules); err != nil {
+		return nil, fmt.Errorf("clusterApiIngress must be a list of {cidr, port, description} objects: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		if _, _, err := net.ParseCIDR(rule.Cidr); err != nil {
+			return nil, fmt.Errorf("clusterApiIngress entry %q is not a CIDR block", rule.Cidr)
+		}
+		if rule.Port == 0 {
+			rule.Port = 443
+		}
+		if rule.Port < 1 || rule.Port > 65535 {
+			return nil, fmt.Errorf("clusterApiIngress port for %s must be between 1 and 65535, got %d", rule.Cidr, rule.Port)
+		}
+		key := fmt.Sprintf("%s:%d", rule.Cidr, rule.Port)
+		if seen[key] {
+			return nil, fmt.Errorf("clusterApiIngress lists %s port %d more than once", rule.Cidr, rule.Port)
+		}
+		seen[key] = true
+	}
+	return rules, nil
+}
+
+// Allow each `clusterApiIngress` network in to the cluster security group, which
+// the private API endpoint uses, e.g. for CI runners or a VPN. The rules are named
+// after their CIDR and port, so editing the list leaves the other rules alone.
+func allowClusterApiIngress(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) error {
+	rules, err := clusterApiIngressConfig(cfg)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		args := &ec2.SecurityGroupRuleArgs{
+			Type:            pulumi.String("ingress"),
+			Protocol:        pulumi.String("tcp"),
+			FromPort:        pulumi.Int(rule.Port),
+			ToPort:          pulumi.Int(rule.Port),
+			SecurityGroupId: cluster.SecurityGroupId,
+		}
+		if strings.Contains(rule.Cidr, ":") {
+			args.Ipv6CidrBlocks = pulumi.StringArray{pulumi.String(rule.Cidr)}
+		} else {
+			args.CidrBlocks = pulumi.StringArray{pulumi.String(rule.Cidr)}
+		}
+		if rule.Description != "" {
+			args.Description = pulumi.String(rule.Description)
+		}
+		name := strings.NewReplacer(".", "-", "/", "-", ":", "-").Replace(rule.Cidr)
+		_, err := ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-api-ingress-%s-%d", cluster.Env, name, rule.Port), args,
+			cluster.ResourceOpts()...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The node CPU architectures `nodeArchitecture` takes. ArchArm64 is the one
+// charts must support explicitly.
+const (
+	archX86_64 = "x86_64"
+	ArchArm64  = "arm64"
+)
+
+// Managed node groups launch this instance type when none is given.
+const eksDefaultInstanceType = "t3.medium"
+
+// Charts whose default images are published as multi-arch manifests that include
+// arm64. Any other chart is refused on arm64 nodes rather than left to CrashLoop.
+var arm64Charts = map[string]bool{
+	"argo-cd":                      true,
+	"argo-rollouts":                true,
+	"aws-cloudwatch-metrics":       true,
+	"aws-for-fluent-bit":           true,
+	"aws-load-balancer-controller": true,
+	"aws-node-termination-handler": true,
+	"cluster-autoscaler":           true,
+	"external-secrets":             true,
+	"karpenter":                    true,
+	"nvidia-device-plugin":         true,
+	"prometheus":                   true,
+	"sealed-secrets":               true,
+}
+
+// NodeArchitecture reads the node group CPU architecture from `nodeArchitecture`, x86_64 or arm64.
+func NodeArchitecture(cfg *config.Config) (string, error) {
+	arch := cfg.Get("nodeArchitecture")
+	switch arch {
+	case "", archX86_64:
+		return archX86_64, nil
+	case ArchArm64:
+		return ArchArm64, nil
+	}
+	return "", fmt.Errorf("nodeArchitecture must be %s or %s, got %q", archX86_64, ArchArm64, arch)
+}
+
+// The AMI type and instance types for env's node group, and the instance types the
+// nodes will run, preferred first. `nodeMixedInstances`, the instanceType of env's
+// `environments` entry or `nodeInstanceType` pick the types; otherwise x86_64
+// leaves both to the EKS defaults (AL2_x86_64 on t3.medium) and arm64 uses
+// Graviton t4g.medium.
+func nodeImage(cfg *config.Config, env string) (amiType pulumi.StringPtrInput, instanceTypes pulumi.StringArrayInput, typeNames []string, err error) {
+	arch, err := NodeArchitecture(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mixed, err := nodeMixedInstances(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	settings, err := stackconfig.EnvironmentSettings(cfg, env)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if mixed != nil && settings.InstanceType != "" {
+		return nil, nil, nil, fmt.Errorf("environments entry %s cannot set instanceType with nodeMixedInstances", env)
+	}
+	if mixed != nil {
+		typeNames = mixed.instanceTypes
+	} else if settings.InstanceType != "" {
+		typeNames = []string{settings.InstanceType}
+	} else if instanceType := cfg.Get("nodeInstanceType"); instanceType != "" {
+		typeNames = []string{instanceType}
+	}
+	if arch == ArchArm64 {
+		amiType = pulumi.String("AL2_ARM_64")
+		if typeNames == nil {
+			typeNames = []string{"t4g.medium"}
+		}
+	}
+	if typeNames == nil {
+		return amiType, nil, []string{eksDefaultInstanceType}, nil
+	}
+	return amiType, stackconfig.ToPulumiStringArray(typeNames), typeNames, nil
+}
+
+// CheckChartArchitecture checks that chart can run on the node group's architecture. On arm64 only charts
+// known to ship arm64 images are allowed.
+func CheckChartArchitecture(cfg *config.Config, chart string) error {
+	arch, err := NodeArchitecture(cfg)
+	if err != nil {
+		return err
+	}
+	if arch == ArchArm64 && !arm64Charts[chart] {
+		return fmt.Errorf("chart %s is not known to publish arm64 images, so it cannot be installed on arm64 nodes", chart)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Enough for redis-ha, which argoCdHa turns on in prod by default.
+const defaultArgoNodeCount = 3
+
+// LabelKey matches Kubernetes label keys, with an optional DNS subdomain
+// prefix, and LabelValue label values.
+var (
+	LabelKey   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	LabelValue = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+// NodeTaint is the taint on a dedicated node group, such as the Argo or GPU nodes, which is
+// also their label, so the same key and value give both the charts' tolerations
+// and their node selector.
+type NodeTaint struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Read the dedicated Argo nodes' taint when `argoDedicatedNodes` is set for env,
+// from `argoNodeTaint` or `dedicated=argo` by default. Returns nil otherwise.
+func argoNodes(cfg *config.Config, env string) (*NodeTaint, error) {
+	dedicated, err := stackconfig.GetEnvBool(cfg, "argoDedicatedNodes", env, false)
+	if err != nil || !dedicated {
+		return nil, err
+	}
+	return NodeTaintConfig(cfg, "argoNodeTaint", NodeTaint{Key: "dedicated", Value: "argo"})
+}
+
+// NodeTaintConfig reads the node taint config setting key, taint when it is not set.
+func NodeTaintConfig(cfg *config.Config, key string, taint NodeTaint) (*NodeTaint, error) {
+	if err := cfg.GetObject(key, &taint); err != nil {
+		return nil, fmt.Errorf("%s must be an object with a key and value: %w", key, err)
+	}
+	if !LabelKey.MatchString(taint.Key) {
+		return nil, fmt.Errorf("%s key must be a Kubernetes label key, got %q", key, taint.Key)
+	}
+	if !LabelValue.MatchString(taint.Value) {
+		return nil, fmt.Errorf("%s value must be a Kubernetes label value, got %q", key, taint.Value)
+	}
+	return &taint, nil
+}
+
+// ArgoNodeCount reads `argoNodeCount`, the fixed size of the dedicated Argo node group.
+func ArgoNodeCount(cfg *config.Config) (int, error) {
+	count, _, err := stackconfig.OptionalInt(cfg, "argoNodeCount")
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		count = defaultArgoNodeCount
+	}
+	if count < 1 {
+		return 0, fmt.Errorf("argoNodeCount must be at least 1, got %d", count)
+	}
+	return count, nil
+}
+
+// The node group settings that keep everything but the workloads meant for them
+// off the dedicated nodes.
+func (t *NodeTaint) nodeGroupTaints() eks.NodeGroupTaintArray {
+	return eks.NodeGroupTaintArray{
+		eks.NodeGroupTaintArgs{
+			Key:    pulumi.String(t.Key),
+			Value:  pulumi.String(t.Value),
+			Effect: pulumi.String("NO_SCHEDULE"),
+		},
+	}
+}
+
+func (t *NodeTaint) nodeGroupLabels() pulumi.StringMap {
+	return pulumi.StringMap{t.Key: pulumi.String(t.Value)}
+}
+
+// Scheduling returns the chart values that schedule a workload onto the dedicated nodes and only there.
+// Every chart component meant for them gets these, so the key always matches the
+// taint.
+func (t *NodeTaint) Scheduling() pulumi.Map {
+	return pulumi.Map{
+		"tolerations": pulumi.Array{
+			pulumi.Map{
+				"key":      pulumi.String(t.Key),
+				"operator": pulumi.String("Equal"),
+				"value":    pulumi.String(t.Value),
+				"effect":   pulumi.String("NoSchedule"),
+			},
+		},
+		"nodeSelector": pulumi.StringMap{t.Key: pulumi.String(t.Value)},
+	}
+}
+
+// ApplyTo sets the scheduling values on each of the components, merging them into any
+// values already set there.
+func (t *NodeTaint) ApplyTo(values pulumi.Map, components ...string) {
+	for _, component := range components {
+		target, ok := values[component].(pulumi.Map)
+		if !ok {
+			target = pulumi.Map{}
+			values[component] = target
+		}
+		for k, v := range t.Scheduling() {
+			target[k] = v
+		}
+	}
+}
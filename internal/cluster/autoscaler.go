@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Read `nodeScaleToZero` for env, which lets its node groups scale down to no
+// nodes at all while idle. Only the cluster autoscaler brings nodes back when
+// pods are pending, so it must be installed in env too.
+func nodeScaleToZero(cfg *config.Config, env string) (bool, error) {
+	scaleToZero, err := stackconfig.GetEnvBool(cfg, "nodeScaleToZero", env, false)
+	if err != nil || !scaleToZero {
+		return false, err
+	}
+	autoscaler, err := stackconfig.GetEnvBool(cfg, "clusterAutoscaler", env, false)
+	if err != nil {
+		return false, err
+	}
+	if !autoscaler {
+		return false, fmt.Errorf("nodeScaleToZero is set for %s, which needs clusterAutoscaler to scale back up", env)
+	}
+	return true, nil
+}
+
+// The node group properties Pulumi leaves alone after creation. With the cluster
+// autoscaler in env it owns the desired size, so changing `nodeDesiredSize` then
+// only affects new node groups.
+func nodeGroupIgnoreChanges(cfg *config.Config, env string) ([]string, error) {
+	autoscaler, err := stackconfig.GetEnvBool(cfg, "clusterAutoscaler", env, false)
+	if err != nil || !autoscaler {
+		return nil, err
+	}
+	return []string{"scalingConfig.desiredSize"}, nil
+}
+
+// Tag a node group's autoscaling group with the root volume size, which the
+// cluster autoscaler cannot find out from a group with no nodes. Without it,
+// pods requesting ephemeral storage never trigger a scale up from zero.
+func tagForScaleFromZero(ctx *pulumi.Context, cfg *config.Config, name string, nodeGroup *eks.NodeGroup,
+	opts ...pulumi.ResourceOption) error {
+	size, err := nodeVolumeSize(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = autoscaling.NewTag(ctx, fmt.Sprintf("%s-ephemeral-storage-tag", name), &autoscaling.TagArgs{
+		AutoscalingGroupName: nodeGroup.Resources.Index(pulumi.Int(0)).AutoscalingGroups().Index(pulumi.Int(0)).Name().Elem(),
+		Tag: &autoscaling.TagTagArgs{
+			Key:               pulumi.String("k8s.io/cluster-autoscaler/node-template/resources/ephemeral-storage"),
+			Value:             pulumi.String(fmt.Sprintf("%dGi", size)),
+			PropagateAtLaunch: pulumi.Bool(false),
+		},
+	}, opts...)
+	return err
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"gopkg.in/yaml.v3"
+
+	"aws-go-eks/internal/iamroles"
+)
+
+// An entry of the `awsAuthPrincipals` config list: the IAM role or user Arn is
+// let into the cluster as Username, which defaults to the principal's name, in Groups.
+type awsAuthPrincipal struct {
+	Arn      string   `json:"arn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// An entry of the aws-auth ConfigMap's mapRoles, letting RoleArn into the
+// cluster as Username in Groups.
+type awsAuthRole struct {
+	RoleArn  string   `yaml:"rolearn"`
+	Username string   `yaml:"username"`
+	Groups   []string `yaml:"groups"`
+}
+
+// An entry of the aws-auth ConfigMap's mapUsers, letting UserArn into the
+// cluster as Username in Groups.
+type awsAuthUser struct {
+	UserArn  string   `yaml:"userarn"`
+	Username string   `yaml:"username"`
+	Groups   []string `yaml:"groups"`
+}
+
+// Read and validate the `awsAuthPrincipals` config list.
+func awsAuthPrincipalsConfig(cfg *config.Config) ([]awsAuthPrincipal, error) {
+	var principals []awsAuthPrincipal
+	if err := cfg.GetObject("awsAuthPrincipals", &principals); err != nil {
+		return nil, fmt.Errorf("awsAuthPrincipals must be a list of {arn, username, groups} objects: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, p := range principals {
+		if !iamroles.IamRoleArn.MatchString(p.Arn) && !iamroles.IamUserArn.MatchString(p.Arn) {
+			return nil, fmt.Errorf("awsAuthPrincipals arn %q is not an IAM role or user ARN", p.Arn)
+		}
+		if seen[p.Arn] {
+			return nil, fmt.Errorf("awsAuthPrincipals arn %s is listed more than once", p.Arn)
+		}
+		seen[p.Arn] = true
+		if p.Username != "" && !iamroles.KubernetesName.MatchString(strings.NewReplacer("{{SessionName}}", "x", "{{AccountID}}", "x").Replace(p.Username)) {
+			return nil, fmt.Errorf("awsAuthPrincipals username %q of %s is not a valid Kubernetes user name", p.Username, p.Arn)
+		}
+		if len(p.Groups) == 0 {
+			return nil, fmt.Errorf("awsAuthPrincipals arn %s needs at least one group", p.Arn)
+		}
+		for _, group := range p.Groups {
+			if err := iamroles.CheckKubernetesGroup(group); err != nil {
+				return nil, fmt.Errorf("awsAuthPrincipals arn %s: %w", p.Arn, err)
+			}
+		}
+	}
+	return principals, nil
+}
+
+// aws-auth matches roles by ARN without their path, so a role under a path, such
+// as the IAM Identity Center ones, is only let in once the path is dropped.
+func withoutRolePath(arn string) string {
+	resource := arn[strings.Index(arn, ":role/")+len(":role/"):]
+	return arn[:strings.Index(arn, ":role/")] + ":role/" + resource[strings.LastIndex(resource, "/")+1:]
+}
+
+// The name after the last slash of an IAM ARN.
+func iamName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// A role to map, whose ARN is only known once it is created.
+type awsAuthMapping struct {
+	roleArn  pulumi.StringOutput
+	username string
+	groups   []string
+}
+
+// The roles the cluster's aws-auth ConfigMap must map: the node and Fargate roles
+// as EKS maps them itself, so patching mapRoles keeps the compute joined, the
+// node role for Karpenter's nodes even without a node group, the GitHub deploy
+// role, and the roles of `awsAuthPrincipals`.
+func awsAuthMappings(cluster *Cluster, principals []awsAuthPrincipal) []awsAuthMapping {
+	shared := cluster.Shared
+	var mappings []awsAuthMapping
+	if shared.EnableNodeGroup || cluster.KarpenterNodes {
+		mappings = append(mappings, awsAuthMapping{
+			roleArn:  shared.NodeGroupRole.Arn,
+			username: "system:node:{{EC2PrivateDNSName}}",
+			groups:   []string{"system:bootstrappers", "system:nodes"},
+		})
+	}
+	if shared.FargateRoleArn != nil {
+		mappings = append(mappings, awsAuthMapping{
+			roleArn:  shared.FargateRoleArn.ToStringOutput(),
+			username: "system:node:{{SessionName}}",
+			groups:   []string{"system:bootstrappers", "system:nodes", "system:node-proxier"},
+		})
+	}
+	if shared.GithubDeploy != nil {
+		mappings = append(mappings, awsAuthMapping{
+			roleArn:  shared.GithubDeploy.Role.Arn,
+			username: "github-deploy:{{SessionName}}",
+			groups:   shared.GithubDeploy.Groups,
+		})
+	}
+	for _, p := range principals {
+		if !iamroles.IamRoleArn.MatchString(p.Arn) {
+			continue
+		}
+		username := p.Username
+		if username == "" {
+			username = iamName(p.Arn) + ":{{SessionName}}"
+		}
+		mappings = append(mappings, awsAuthMapping{
+			roleArn:  pulumi.String(withoutRolePath(p.Arn)).ToStringOutput(),
+			username: username,
+			groups:   p.Groups,
+		})
+	}
+	return mappings
+}
+
+// The users of `awsAuthPrincipals`, as aws-auth's mapUsers.
+func awsAuthUsers(principals []awsAuthPrincipal) []awsAuthUser {
+	var users []awsAuthUser
+	for _, p := range principals {
+		if !iamroles.IamUserArn.MatchString(p.Arn) {
+			continue
+		}
+		username := p.Username
+		if username == "" {
+			username = iamName(p.Arn)
+		}
+		users = append(users, awsAuthUser{UserArn: p.Arn, Username: username, Groups: p.Groups})
+	}
+	return users
+}
+
+// Patch the cluster's aws-auth ConfigMap so the principals beyond the cluster's
+// own compute are let in: the GitHub deploy role and `awsAuthPrincipals`. Does
+// nothing when there are none, leaving aws-auth to EKS.
+//
+// The patch is retained when removed from the program, as releasing it would
+// strip mapRoles and with it the nodes' access. Dropping every principal therefore
+// leaves the last ones in aws-auth, to be removed by hand.
+func configureAwsAuth(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) error {
+	principals, err := awsAuthPrincipalsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	// EKS maps the node role itself only once a node group uses it
+	karpenterOnly := cluster.KarpenterNodes && !cluster.Shared.EnableNodeGroup
+	if cluster.Shared.GithubDeploy == nil && len(principals) == 0 && !karpenterOnly {
+		return nil
+	}
+	mappings := awsAuthMappings(cluster, principals)
+	arns := make([]interface{}, len(mappings))
+	for i, m := range mappings {
+		arns[i] = m.roleArn
+	}
+	mapRoles := pulumi.All(arns...).ApplyT(func(args []interface{}) (string, error) {
+		roles := make([]awsAuthRole, len(mappings))
+		for i, m := range mappings {
+			roles[i] = awsAuthRole{RoleArn: args[i].(string), Username: m.username, Groups: m.groups}
+		}
+		data, err := yaml.Marshal(roles)
+		return string(data), err
+	}).(pulumi.StringOutput)
+	data := pulumi.StringMap{
+		"mapRoles": mapRoles,
+	}
+	if users := awsAuthUsers(principals); len(users) > 0 {
+		mapUsers, err := yaml.Marshal(users)
+		if err != nil {
+			return err
+		}
+		data["mapUsers"] = pulumi.String(mapUsers)
+	}
+
+	ssaProvider, err := cluster.ServerSideApplyProvider(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = corev1.NewConfigMapPatch(ctx, fmt.Sprintf("%s-aws-auth-patch", cluster.Env), &corev1.ConfigMapPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String("aws-auth"),
+			Namespace: pulumi.String("kube-system"),
+			Annotations: pulumi.StringMap{
+				// mapRoles is owned by EKS, which wrote the node roles into it
+				"pulumi.com/patchForce": pulumi.String("true"),
+			},
+		},
+		Data: data,
+	}, cluster.ResourceOpts(pulumi.Provider(ssaProvider), pulumi.RetainOnDelete(true))...)
+	return err
+}
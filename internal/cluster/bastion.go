@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Public SSM parameter holding the latest Amazon Linux 2 AMI, which ships with the SSM agent.
+const amazonLinux2AmiParameter = "/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2"
+
+// CreateBastion creates a small instance in a public subnet that can reach the
+// cluster's API endpoint privately, for clusters with `clusterEndpointPublicAccess`
+// turned off. It is reached through SSM Session Manager, and over SSH from
+// `bastionSshCidrs` when that is set. Returns the instance ID.
+func CreateBastion(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (pulumi.StringOutput, error) {
+	env := cluster.Env
+	privateAccess, _, err := endpointAccess(cfg)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	if !privateAccess {
+		return pulumi.StringOutput{}, fmt.Errorf("enableBastion needs clusterEndpointPrivateAccess, or the bastion cannot reach the API endpoint")
+	}
+	instanceType := cfg.Get("bastionInstanceType")
+	if instanceType == "" {
+		instanceType = "t3.micro"
+	}
+	var sshCidrs []string
+	if err := cfg.GetObject("bastionSshCidrs", &sshCidrs); err != nil {
+		return pulumi.StringOutput{}, fmt.Errorf("bastionSshCidrs must be a list of CIDR blocks: %w", err)
+	}
+	for _, cidr := range sshCidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return pulumi.StringOutput{}, fmt.Errorf("bastionSshCidrs entry %q is not a CIDR block", cidr)
+		}
+	}
+	ami, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{Name: amazonLinux2AmiParameter},
+		cluster.Shared.Network.InvokeOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	role, err := iam.NewRole(ctx, fmt.Sprintf("%s-bastion-role", env), &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "ec2.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	err = iamroles.AttachPolicies(ctx, fmt.Sprintf("%s-bastion-role-policy", env), role,
+		[]string{"arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	profile, err := iam.NewInstanceProfile(ctx, fmt.Sprintf("%s-bastion-profile", env), &iam.InstanceProfileArgs{
+		Role: role.Name,
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	var ingress ec2.SecurityGroupIngressArray
+	if len(sshCidrs) > 0 {
+		ingress = append(ingress, ec2.SecurityGroupIngressArgs{
+			Protocol:   pulumi.String("tcp"),
+			FromPort:   pulumi.Int(22),
+			ToPort:     pulumi.Int(22),
+			CidrBlocks: stackconfig.ToPulumiStringArray(sshCidrs),
+		})
+	}
+	bastionSg, err := ec2.NewSecurityGroup(ctx, fmt.Sprintf("%s-bastion-sg", env), &ec2.SecurityGroupArgs{
+		VpcId: cluster.Shared.Network.VpcId,
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Ingress: ingress,
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	// The private API endpoint uses the cluster security group
+	_, err = ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-bastion-to-api", env), &ec2.SecurityGroupRuleArgs{
+		Type:                  pulumi.String("ingress"),
+		Protocol:              pulumi.String("tcp"),
+		FromPort:              pulumi.Int(443),
+		ToPort:                pulumi.Int(443),
+		SecurityGroupId:       cluster.SecurityGroupId,
+		SourceSecurityGroupId: bastionSg.ID(),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	instance, err := ec2.NewInstance(ctx, fmt.Sprintf("%s-bastion", env), &ec2.InstanceArgs{
+		Ami:                      pulumi.String(ami.Value),
+		InstanceType:             pulumi.String(instanceType),
+		SubnetId:                 cluster.Shared.Network.PublicSubnets[0].Id,
+		AssociatePublicIpAddress: pulumi.Bool(true),
+		IamInstanceProfile:       profile.Name,
+		VpcSecurityGroupIds:      pulumi.StringArray{bastionSg.ID().ToStringOutput()},
+		MetadataOptions: &ec2.InstanceMetadataOptionsArgs{
+			HttpTokens: pulumi.String("required"),
+		},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(fmt.Sprintf("%s-aws-demo-bastion", env)),
+		},
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return instance.ID().ToStringOutput(), nil
+}
@@ -0,0 +1,605 @@
+// Package cluster provisions an environment's EKS cluster with its node groups,
+// Fargate profiles and access config, and what is built around it in AWS.
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Chart is a Helm chart installed into a cluster, as listed in the helmCharts output.
+type Chart struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo"`
+	Version   string `json:"version"`
+}
+
+// Cluster is an environment's EKS cluster, its compute, and the Kubernetes
+// provider that targets it. NodeGroups is empty or FargateProfile nil when that
+// kind of compute is disabled.
+type Cluster struct {
+	Env            string
+	Shared         *Shared
+	Cluster        *eks.Cluster
+	NodeGroups     []*eks.NodeGroup
+	FargateProfile *eks.FargateProfile
+	Provider       *kubernetes.Provider
+	Kubeconfig     pulumi.StringOutput
+	// The security group EKS creates for the control plane and managed nodes.
+	SecurityGroupId pulumi.StringOutput
+	OidcIssuerUrl   pulumi.StringOutput
+
+	// The zone each of NodeGroups is pinned to with `nodeGroupPerAz`, or "".
+	nodeGroupZones []string
+	// The node group tainted for Argo alone, with `argoDedicatedNodes`.
+	argoNodeGroup *eks.NodeGroup
+	// The taint of argoNodeGroup, which Argo tolerates, or nil.
+	ArgoTaint *NodeTaint
+	// The node group of GPU instances, with `gpuNodes`.
+	GpuNodeGroup *eks.NodeGroup
+	// The taint of GpuNodeGroup, which the device plugin tolerates, or nil.
+	GpuTaint *NodeTaint
+	// The node group of Spot instances, with `spotNodes`.
+	spotNodeGroup *eks.NodeGroup
+	// Set when Karpenter launches nodes under the node group role, with `karpenter`.
+	KarpenterNodes bool
+	// The add-ons managed through EKS with `eksAddons`.
+	EksAddons []pulumi.Resource
+	// The AWS Load Balancer Controller with `loadBalancerController`, which
+	// Ingresses wait for.
+	LoadBalancerController pulumi.Resource
+	// The nodes' own security group with `restrictNodeEgress`, or nil.
+	nodeSecurityGroup *ec2.SecurityGroup
+	oidcProvider      *iam.OpenIdConnectProvider
+	ssaProvider       *kubernetes.Provider
+	// What has been installed into the cluster so far, for steps that must run after it.
+	Installed []pulumi.Resource
+	// The Helm charts installed into the cluster so far, for ChartInventory.
+	Charts []Chart
+	// The DaemonSet pulling the Argo images with `imagePrepull`, which Argo waits for.
+	ImagePrepuller pulumi.Resource
+	// The Argo CD server URL once InstallArgo has run, for HealthReport.
+	ArgoCdUrl *pulumi.StringOutput
+	// The address of the Argo CD server's ALB with `argoCdIngress`, for HealthReport.
+	ArgoCdIngressAddress *pulumi.StringOutput
+	// The customer managed keys with `kmsKeys`, or nil.
+	kmsKeys *clusterKeys
+	// The tags marking the cluster and its node groups ephemeral with `clusterTtl`, or nil.
+	ExpiryTags pulumi.StringMapInput
+	// The EksEnvironment the cluster's resources are parented to, or nil.
+	Component pulumi.Resource
+}
+
+// ProvisionCluster creates the EKS cluster for env with its node group and/or
+// Fargate profile and a Kubernetes provider for installing workloads into it, and
+// maps the shared GitHub deploy role and `awsAuthPrincipals` into its aws-auth.
+func ProvisionCluster(ctx *pulumi.Context, cfg *config.Config, env string, shared *Shared) (*Cluster, error) {
+	return Provision(ctx, cfg, &Cluster{Env: env, Shared: shared})
+}
+
+// Provision does what ProvisionCluster does into cluster, which has its Env and Shared set, and its
+// component when it is part of an EksEnvironment.
+func Provision(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (*Cluster, error) {
+	env, shared := cluster.Env, cluster.Shared
+	privateAccess, publicAccess, err := endpointAccess(cfg)
+	if err != nil {
+		return nil, err
+	}
+	publicCidrs, err := publicAccessCidrs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := resourceTimeouts(cfg, "clusterTimeouts", defaultClusterTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := expiryTags(cfg, env, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	keys, err := createClusterKeys(ctx, cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+	version, err := stackconfig.KubernetesVersionConfig(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	logTypes, err := clusterLogTypes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var encryption eks.ClusterEncryptionConfigPtrInput
+	if keys != nil {
+		encryption = &eks.ClusterEncryptionConfigArgs{
+			Provider:  &eks.ClusterEncryptionConfigProviderArgs{KeyArn: keys.Eks.Arn},
+			Resources: pulumi.StringArray{pulumi.String("secrets")},
+		}
+	}
+	clusterArgs := &eks.ClusterArgs{
+		RoleArn:                 shared.ClusterRoleArn,
+		Version:                 version,
+		KubernetesNetworkConfig: shared.NetworkConfig,
+		EncryptionConfig:        encryption,
+		Tags:                    tags,
+		VpcConfig: &eks.ClusterVpcConfigArgs{
+			EndpointPrivateAccess: pulumi.Bool(privateAccess),
+			EndpointPublicAccess:  pulumi.Bool(publicAccess),
+			PublicAccessCidrs:     stackconfig.ToPulumiStringArray(publicCidrs),
+			SecurityGroupIds: pulumi.StringArray{
+				shared.ClusterSecurityGroup.ID().ToStringOutput(),
+			},
+			SubnetIds: network.SubnetIds(shared.Network.Subnets),
+		},
+	}
+	clusterOpts := []pulumi.ResourceOption{pulumi.Timeouts(timeouts)}
+	if len(logTypes) > 0 {
+		name, err := loggedClusterName(ctx, cfg, env)
+		if err != nil {
+			return nil, err
+		}
+		logGroup, err := createClusterLogGroup(ctx, cfg, cluster, name, tags)
+		if err != nil {
+			return nil, err
+		}
+		clusterArgs.Name = pulumi.String(name)
+		clusterArgs.EnabledClusterLogTypes = stackconfig.ToPulumiStringArray(logTypes)
+		clusterOpts = append(clusterOpts, pulumi.DependsOn([]pulumi.Resource{logGroup}))
+	}
+	// Create EKS Cluster
+	eksCluster, err := eks.NewCluster(ctx, fmt.Sprintf("%s-aws-demo", env), clusterArgs, cluster.ResourceOpts(clusterOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster.Cluster = eksCluster
+	cluster.SecurityGroupId = eksCluster.VpcConfig.ClusterSecurityGroupId().Elem()
+	cluster.OidcIssuerUrl = eksCluster.Identities.Index(pulumi.Int(0)).Oidcs().Index(pulumi.Int(0)).Issuer().Elem()
+	cluster.kmsKeys = keys
+	cluster.ExpiryTags = tags
+	// EKS names its security group after the cluster's generated name, so give it
+	// a Name tag that is easy to find in the console
+	_, err = ec2.NewTag(ctx, fmt.Sprintf("%s-cluster-sg-name-tag", env), &ec2.TagArgs{
+		ResourceId: cluster.SecurityGroupId,
+		Key:        pulumi.String("Name"),
+		Value:      pulumi.String(fmt.Sprintf("%s-aws-demo-cluster-sg", env)),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowClusterApiIngress(ctx, cfg, cluster); err != nil {
+		return nil, err
+	}
+	argoTaint, err := argoNodes(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	if argoTaint != nil && !shared.EnableNodeGroup {
+		return nil, fmt.Errorf("argoDedicatedNodes is set for %s, which has no node group", env)
+	}
+	gpu, err := gpuNodes(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	if gpu != nil && !shared.EnableNodeGroup {
+		return nil, fmt.Errorf("gpuNodes is set for %s, which has no node group", env)
+	}
+	spot, err := spotNodes(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	if spot != nil && !shared.EnableNodeGroup {
+		return nil, fmt.Errorf("spotNodes is set for %s, which has no node group", env)
+	}
+	if cluster.KarpenterNodes, err = karpenterEnabled(cfg, env); err != nil {
+		return nil, err
+	}
+	if shared.EnableNodeGroup {
+		if err := createNodeGroups(ctx, cfg, cluster, argoTaint, gpu, spot); err != nil {
+			return nil, err
+		}
+	}
+	if shared.FargateRoleArn != nil {
+		cluster.FargateProfile, err = createFargateProfile(ctx, cfg, cluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	providerDeps := cluster.ComputeResources()
+	ready, err := createClusterReadyCheck(ctx, cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if ready != nil {
+		providerDeps = append(providerDeps, ready)
+	}
+
+	// Server-side apply keeps no last-applied annotation, whose size limit large
+	// CRDs such as Argo Rollouts' overrun with client-side apply. It also adopts
+	// objects that already exist rather than failing on them, and fields other
+	// managers own make the apply fail instead of being overwritten.
+	serverSideApply, err := stackconfig.GetEnvBool(cfg, "serverSideApply", env, false)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Kubeconfig = GenerateKubeconfig(eksCluster.Endpoint, eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name)
+	cluster.Provider, err = kubernetes.NewProvider(ctx, fmt.Sprintf("%s-k8sprovider", env), &kubernetes.ProviderArgs{
+		Kubeconfig:            cluster.Kubeconfig,
+		EnableServerSideApply: pulumi.Bool(serverSideApply),
+	}, cluster.ResourceOpts(pulumi.DependsOn(providerDeps))...)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureAwsAuth(ctx, cfg, cluster); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// Read whether the API endpoint is reachable from inside the VPC
+// (`clusterEndpointPrivateAccess`, off by default) and from the internet
+// (`clusterEndpointPublicAccess`, on by default). Nodes can only reach the API
+// server through its private endpoint once `restrictNodeEgress` cuts their
+// internet egress, so that must be turned on with it.
+func endpointAccess(cfg *config.Config) (private bool, public bool, err error) {
+	private = cfg.GetBool("clusterEndpointPrivateAccess")
+	public = stackconfig.GetBoolDefault(cfg, "clusterEndpointPublicAccess", true)
+	if !private && !public {
+		return false, false, fmt.Errorf("at least one of clusterEndpointPrivateAccess and clusterEndpointPublicAccess must be true")
+	}
+	if !private && cfg.GetBool("restrictNodeEgress") {
+		return false, false, fmt.Errorf("restrictNodeEgress needs clusterEndpointPrivateAccess, or the nodes cannot reach the API server to join the cluster")
+	}
+	return private, public, nil
+}
+
+// Read `clusterEndpointPublicAccessCidrs`, the IPv4 networks the public API
+// endpoint takes requests from, or all of them when not set. The nodes would
+// only reach a restricted public endpoint from their public or NAT addresses,
+// so they are left to the private endpoint, which must be turned on.
+func publicAccessCidrs(cfg *config.Config) ([]string, error) {
+	var cidrs []string
+	if err := cfg.GetObject("clusterEndpointPublicAccessCidrs", &cidrs); err != nil {
+		return nil, fmt.Errorf("clusterEndpointPublicAccessCidrs must be a list of CIDR blocks: %w", err)
+	}
+	if len(cidrs) == 0 {
+		return []string{"0.0.0.0/0"}, nil
+	}
+	private, public, err := endpointAccess(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !public {
+		return nil, fmt.Errorf("clusterEndpointPublicAccessCidrs is set, but clusterEndpointPublicAccess is false")
+	}
+	if !private {
+		return nil, fmt.Errorf("clusterEndpointPublicAccessCidrs needs clusterEndpointPrivateAccess, or the nodes cannot reach the API server to join the cluster")
+	}
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("clusterEndpointPublicAccessCidrs entry %q is not an IPv4 CIDR block", cidr)
+		}
+	}
+	return cidrs, nil
+}
+
+// What a node group's instances run: the AMI type, the instance types and
+// whether they are on-demand or Spot, with nil fields left to the EKS defaults.
+type nodeGroupCompute struct {
+	amiType       pulumi.StringPtrInput
+	instanceTypes pulumi.StringArrayInput
+	capacityType  pulumi.StringPtrInput
+}
+
+// Create the environment's managed node groups: one across all cluster subnets,
+// or with `nodeGroupPerAz` one per availability zone, pinned to that zone's subnets
+// and given an equal share of the nodes, so the zones stay balanced even when a
+// single autoscaling group would skew. Each group is named after its zone and the
+// zones are sorted, so the names and order do not depend on the order the subnets
+// are listed in. With argoTaint, a separate group across all subnets carries that
+// taint for Argo alone, and with gpu another runs the GPU instances, tainted for
+// the pods that ask for a GPU. With spot, a group across all subnets runs Spot
+// instances, taking the sizes of the on-demand groups beyond spot's share.
+func createNodeGroups(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster, argoTaint *NodeTaint, gpu *gpuNodeConfig,
+	spot *spotNodeConfig) error {
+	env, shared := cluster.Env, cluster.Shared
+	var securityGroupIds pulumi.StringArrayInput
+	if shared.vpcEndpoints != nil && shared.vpcEndpoints.RestrictNodes {
+		nodeSg, err := createNodeSecurityGroup(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		cluster.nodeSecurityGroup = nodeSg
+		securityGroupIds = pulumi.StringArray{nodeSg.ID()}
+	}
+	var volumeKeyArn pulumi.StringInput
+	if cluster.kmsKeys != nil {
+		volumeKeyArn = cluster.kmsKeys.Ebs.Arn
+	}
+	launchTemplate, err := createNodeLaunchTemplate(ctx, cfg, env, securityGroupIds, volumeKeyArn, cluster.ResourceOpts()...)
+	if err != nil {
+		return err
+	}
+	scaling, err := NodeScalingConfig(cfg, env)
+	if err != nil {
+		return err
+	}
+	scaleToZero, err := nodeScaleToZero(cfg, env)
+	if err != nil {
+		return err
+	}
+	if scaleToZero {
+		scaling.Min = 0
+	}
+	var spotScaling nodeScaling
+	if spot != nil {
+		if scaling, spotScaling, err = spot.split(env, scaling); err != nil {
+			return err
+		}
+	}
+	ignoreChanges, err := nodeGroupIgnoreChanges(cfg, env)
+	if err != nil {
+		return err
+	}
+	amiType, instanceTypes, _, err := nodeImage(cfg, env)
+	if err != nil {
+		return err
+	}
+	version, err := stackconfig.KubernetesVersionConfig(cfg, env)
+	if err != nil {
+		return err
+	}
+	mixed, err := nodeMixedInstances(cfg)
+	if err != nil {
+		return err
+	}
+	compute := nodeGroupCompute{amiType: amiType, instanceTypes: instanceTypes}
+	if mixed != nil && mixed.spot {
+		compute.capacityType = pulumi.String("SPOT")
+	}
+	timeouts, err := resourceTimeouts(cfg, "nodeGroupTimeouts", defaultNodeGroupTimeouts)
+	if err != nil {
+		return err
+	}
+	newNodeGroup := func(name string, subnetIds pulumi.StringArrayInput, scaling nodeScaling, taint *NodeTaint,
+		compute nodeGroupCompute) (*eks.NodeGroup, error) {
+		var taints eks.NodeGroupTaintArrayInput
+		var labels pulumi.StringMapInput
+		if taint != nil {
+			taints = taint.nodeGroupTaints()
+			labels = taint.nodeGroupLabels()
+		}
+		nodeGroup, err := eks.NewNodeGroup(ctx, name, &eks.NodeGroupArgs{
+			ClusterName:    cluster.Cluster.Name,
+			NodeGroupName:  pulumi.String(name),
+			NodeRoleArn:    pulumi.StringInput(shared.NodeGroupRole.Arn),
+			Version:        version,
+			SubnetIds:      subnetIds,
+			LaunchTemplate: launchTemplate,
+			AmiType:        compute.amiType,
+			InstanceTypes:  compute.instanceTypes,
+			CapacityType:   compute.capacityType,
+			Taints:         taints,
+			Labels:         labels,
+			Tags:           cluster.ExpiryTags,
+			ScalingConfig: &eks.NodeGroupScalingConfigArgs{
+				DesiredSize: pulumi.Int(scaling.Desired),
+				MaxSize:     pulumi.Int(scaling.Max),
+				MinSize:     pulumi.Int(scaling.Min),
+			},
+		}, cluster.ResourceOpts(pulumi.Timeouts(timeouts), pulumi.IgnoreChanges(ignoreChanges))...)
+		if err != nil {
+			return nil, err
+		}
+		if scaling.Min == 0 {
+			if err := tagForScaleFromZero(ctx, cfg, name, nodeGroup, cluster.ResourceOpts()...); err != nil {
+				return nil, err
+			}
+		}
+		return nodeGroup, nil
+	}
+
+	name := fmt.Sprintf("%s-aws-demo-node-group", env)
+	if argoTaint != nil {
+		count, err := ArgoNodeCount(cfg)
+		if err != nil {
+			return err
+		}
+		cluster.argoNodeGroup, err = newNodeGroup(fmt.Sprintf("%s-argo", name), network.SubnetIds(shared.Network.Subnets),
+			// On-demand, so Argo is not interrupted along with Spot nodes
+			nodeScaling{Desired: count, Min: count, Max: count}, argoTaint, nodeGroupCompute{amiType: amiType, instanceTypes: instanceTypes})
+		if err != nil {
+			return err
+		}
+		cluster.ArgoTaint = argoTaint
+	}
+	if gpu != nil {
+		if err := checkGpuInstanceType(ctx, env, gpu, shared.Network.InvokeOpts()...); err != nil {
+			return err
+		}
+		cluster.GpuNodeGroup, err = newNodeGroup(fmt.Sprintf("%s-gpu", name), network.SubnetIds(shared.Network.Subnets),
+			nodeScaling{Desired: gpu.Count, Min: gpu.Count, Max: gpu.Count}, &gpuNodeTaint, nodeGroupCompute{
+				amiType:       pulumi.String(gpuAmiType),
+				instanceTypes: pulumi.StringArray{pulumi.String(gpu.InstanceType)},
+			})
+		if err != nil {
+			return err
+		}
+		cluster.GpuTaint = &gpuNodeTaint
+	}
+	if spot != nil {
+		if err := checkSpotInstanceTypes(ctx, cfg, spot, shared.Network.InvokeOpts()...); err != nil {
+			return err
+		}
+		cluster.spotNodeGroup, err = newNodeGroup(fmt.Sprintf("%s-spot", name), network.SubnetIds(shared.Network.Subnets),
+			spotScaling, nil, nodeGroupCompute{
+				amiType:       amiType,
+				instanceTypes: stackconfig.ToPulumiStringArray(spot.InstanceTypes),
+				capacityType:  pulumi.String("SPOT"),
+			})
+		if err != nil {
+			return err
+		}
+	}
+	if !cfg.GetBool("nodeGroupPerAz") {
+		nodeGroup, err := newNodeGroup(name, network.SubnetIds(shared.Network.Subnets), scaling, nil, compute)
+		if err != nil {
+			return err
+		}
+		cluster.NodeGroups = []*eks.NodeGroup{nodeGroup}
+		cluster.nodeGroupZones = []string{""}
+		return nil
+	}
+	byAz, azs := network.SubnetsByAz(shared.Network.Subnets)
+	azScaling, err := scaling.perAz(len(azs))
+	if err != nil {
+		return err
+	}
+	for _, az := range azs {
+		nodeGroup, err := newNodeGroup(fmt.Sprintf("%s-%s", name, az), pulumi.StringArray(byAz[az]), azScaling, nil, compute)
+		if err != nil {
+			return err
+		}
+		cluster.NodeGroups = append(cluster.NodeGroups, nodeGroup)
+		cluster.nodeGroupZones = append(cluster.nodeGroupZones, az)
+	}
+	return nil
+}
+
+// ComputeResources returns the resources that provide somewhere to schedule pods. Kubernetes resources
+// depend on these so they are not created against a cluster with no compute.
+func (c *Cluster) ComputeResources() []pulumi.Resource {
+	var compute []pulumi.Resource
+	for _, nodeGroup := range c.NodeGroups {
+		compute = append(compute, nodeGroup)
+	}
+	if c.argoNodeGroup != nil {
+		compute = append(compute, c.argoNodeGroup)
+	}
+	if c.GpuNodeGroup != nil {
+		compute = append(compute, c.GpuNodeGroup)
+	}
+	if c.spotNodeGroup != nil {
+		compute = append(compute, c.spotNodeGroup)
+	}
+	if c.FargateProfile != nil {
+		compute = append(compute, c.FargateProfile)
+	}
+	return compute
+}
+
+// NodeSecurityGroupId is the security group the managed nodes are in: their own with
+// `restrictNodeEgress`, otherwise the cluster security group.
+func (c *Cluster) NodeSecurityGroupId() pulumi.StringOutput {
+	if c.nodeSecurityGroup != nil {
+		return c.nodeSecurityGroup.ID().ToStringOutput()
+	}
+	return c.SecurityGroupId
+}
+
+// OidcProvider returns the cluster's IAM OIDC provider for IRSA. It is only
+// needed by add-ons that use IRSA, so it is created on first use.
+func (c *Cluster) OidcProvider(ctx *pulumi.Context) (*iam.OpenIdConnectProvider, error) {
+	if c.oidcProvider != nil {
+		return c.oidcProvider, nil
+	}
+	provider, err := iamroles.CreateOidcProvider(ctx, c.Env, c.OidcIssuerUrl, c.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	c.oidcProvider = provider
+	return c.oidcProvider, nil
+}
+
+// ServerSideApplyProvider returns the cluster's Kubernetes provider with server-side apply enabled, which Patch
+// resources need. Only those use it, so it is created on first use.
+func (c *Cluster) ServerSideApplyProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
+	if c.ssaProvider != nil {
+		return c.ssaProvider, nil
+	}
+	provider, err := kubernetes.NewProvider(ctx, fmt.Sprintf("%s-k8s-ssa-provider", c.Env), &kubernetes.ProviderArgs{
+		Kubeconfig:            c.Kubeconfig,
+		EnableServerSideApply: pulumi.Bool(true),
+	}, c.ResourceOpts(pulumi.DependsOn([]pulumi.Resource{c.Provider}))...)
+	if err != nil {
+		return nil, err
+	}
+	c.ssaProvider = provider
+	return c.ssaProvider, nil
+}
+
+// ResourceOpts returns the options for the cluster's resources, placing them in its network's region. As
+// part of an EksEnvironment they are parented to it, which sits in that region
+// too, and aliased to the parent they had before the component, so adopting
+// it does not replace them.
+func (c *Cluster) ResourceOpts(opts ...pulumi.ResourceOption) []pulumi.ResourceOption {
+	if c.Component == nil {
+		return c.Shared.Network.ResourceOpts(opts...)
+	}
+	previous := pulumi.Alias{NoParent: pulumi.Bool(true)}
+	if c.Shared.Network.Parent != nil {
+		previous = pulumi.Alias{Parent: c.Shared.Network.Parent}
+	}
+	return append(opts, pulumi.Parent(c.Component), pulumi.Aliases([]pulumi.Alias{previous}))
+}
+
+// KubernetesOpts returns the options for the cluster's Kubernetes resources. Every Kubernetes resource goes
+// through this, so none can fall back to the ambient kubeconfig or pick up
+// another environment's provider. The only exceptions are the Patch resources,
+// which need the server-side apply provider.
+func (c *Cluster) KubernetesOpts(opts ...pulumi.ResourceOption) []pulumi.ResourceOption {
+	return c.ResourceOpts(append([]pulumi.ResourceOption{pulumi.Provider(c.Provider)}, opts...)...)
+}
+
+// GenerateKubeconfig creates the KubeConfig structure as per
+// https://docs.aws.amazon.com/eks/latest/userguide/create-kubeconfig.html
+func GenerateKubeconfig(clusterEndpoint pulumi.StringOutput, certData pulumi.StringOutput, clusterName pulumi.StringOutput) pulumi.StringOutput {
+	return pulumi.Sprintf(`{
+        "apiVersion": "v1",
+        "clusters": [{
+            "cluster": {
+                "server": "%s",
+                "certificate-authority-data": "%s"
+            },
+            "name": "kubernetes",
+        }],
+        "contexts": [{
+            "context": {
+                "cluster": "kubernetes",
+                "user": "aws",
+            },
+            "name": "aws",
+        }],
+        "current-context": "aws",
+        "kind": "Config",
+        "users": [{
+            "name": "aws",
+            "user": {
+                "exec": {
+                    "apiVersion": "client.authentication.k8s.io/v1alpha1",
+                    "command": "aws-iam-authenticator",
+                    "args": [
+                        "token",
+                        "-i",
+                        "%s",
+                    ],
+                },
+            },
+        }],
+    }`, clusterEndpoint, certData, clusterName)
+}
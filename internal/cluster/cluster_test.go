@@ -0,0 +1,1494 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/pulumitest"
+	"aws-go-eks/internal/stackconfig"
+)
+
+func provision(ctx *pulumi.Context, cfg *config.Config, env string) (*Cluster, error) {
+	network, err := network.LookupDefaultNetwork(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := CreateShared(ctx, cfg, network)
+	if err != nil {
+		return nil, err
+	}
+	return ProvisionCluster(ctx, cfg, env, shared)
+}
+
+func TestProvisionCluster(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusters := m.ByType("aws:eks/cluster:Cluster")
+	if len(clusters) != 1 || clusters[0].Name != "test-aws-demo" {
+		t.Fatalf("expected one test-aws-demo cluster, got %v", clusters)
+	}
+	nodeGroups := m.ByType("aws:eks/nodeGroup:NodeGroup")
+	if len(nodeGroups) != 1 {
+		t.Fatalf("expected one node group, got %d", len(nodeGroups))
+	}
+	scaling := nodeGroups[0].Inputs["scalingConfig"].ObjectValue()
+	if scaling["desiredSize"].NumberValue() != 3 || scaling["minSize"].NumberValue() != 1 || scaling["maxSize"].NumberValue() != 6 {
+		t.Errorf("unexpected scaling config %v", scaling)
+	}
+	if got := len(m.ByType("pulumi:providers:kubernetes")); got != 1 {
+		t.Errorf("expected one kubernetes provider, got %d", got)
+	}
+	if got := len(m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment")); got != 5 {
+		t.Errorf("expected 5 managed policy attachments, got %d", got)
+	}
+	templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+	if len(templates) != 1 {
+		t.Fatalf("expected a launch template enforcing IMDSv2, got %d", len(templates))
+	}
+	metadata := templates[0].Inputs["metadataOptions"].ObjectValue()
+	if metadata["httpTokens"].StringValue() != "required" || metadata["httpPutResponseHopLimit"].NumberValue() != 1 {
+		t.Errorf("unexpected metadata options %v", metadata)
+	}
+
+	m = pulumitest.NewMocks()
+	err = pulumitest.Run(t, m, map[string]string{"nodeRequireImdsv2": "false", "nodeVolumeEncryption": "false"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.ByType("aws:ec2/launchTemplate:LaunchTemplate")); got != 0 {
+		t.Errorf("expected no launch template with nothing to configure, got %d", got)
+	}
+}
+
+func TestNodeUserData(t *testing.T) {
+	shell := "#!/bin/bash\necho hello > /etc/motd"
+	cloudConfig := "#cloud-config\npackages:\n  - jq"
+	cases := []struct {
+		values      map[string]string
+		script      string
+		contentType string
+	}{
+		{map[string]string{"nodeUserData": shell}, shell, "text/x-shellscript"},
+		{map[string]string{"nodeUserDataBase64": base64.StdEncoding.EncodeToString([]byte(cloudConfig))}, cloudConfig, "text/cloud-config"},
+	}
+	for _, c := range cases {
+		var userData string
+		err := pulumitest.Run(t, pulumitest.NewMocks(), c.values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			var err error
+			userData, err = nodeUserData(cfg)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(userData)
+		if err != nil {
+			t.Fatalf("expected base64 user data, got %v", err)
+		}
+		// Parsed as EKS does when it merges its bootstrap part in
+		message, err := mail.ReadMessage(bytes.NewReader(decoded))
+		if err != nil {
+			t.Fatalf("expected a MIME document, got %v:\n%s", err, decoded)
+		}
+		if version := message.Header.Get("MIME-Version"); version != "1.0" {
+			t.Errorf("expected MIME-Version 1.0, got %q", version)
+		}
+		mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/mixed" || params["boundary"] == "" {
+			t.Fatalf("expected a multipart/mixed document with a boundary, got %q, %v", mediaType, err)
+		}
+		parts := multipart.NewReader(message.Body, params["boundary"])
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("expected the script's part, got %v", err)
+		}
+		if contentType := part.Header.Get("Content-Type"); contentType != c.contentType+`; charset="us-ascii"` {
+			t.Errorf("expected a %s part, got %q", c.contentType, contentType)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(body)) != c.script {
+			t.Errorf("expected the part to hold the script, got %q", body)
+		}
+		// The closing boundary ends the document, rather than it being cut short
+		if _, err := parts.NextPart(); err != io.EOF {
+			t.Errorf("expected the document to end after one part, got %v", err)
+		}
+	}
+
+	err := pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		userData, err := nodeUserData(cfg)
+		if err == nil && userData != "" {
+			t.Errorf("expected no user data without a script, got %q", userData)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeUserData": shell, "nodeUserDataBase64": "ZWNobw=="}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := nodeUserData(cfg)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("expected both kinds of user data to be rejected, got %v", err)
+	}
+}
+
+func TestNodeScalingConfig(t *testing.T) {
+	cases := []struct {
+		values  map[string]string
+		want    nodeScaling
+		wantErr bool
+	}{
+		{values: nil, want: nodeScaling{Desired: 3, Min: 1, Max: 6}},
+		{values: map[string]string{"nodeDesiredSize": "2"}, want: nodeScaling{Desired: 2, Min: 1, Max: 4}},
+		{values: map[string]string{"nodeDesiredSize": "5", "nodeMinSize": "0", "nodeMaxSize": "8", "clusterAutoscaler": `{"test": true}`},
+			want: nodeScaling{Desired: 5, Min: 0, Max: 8}},
+		{values: map[string]string{"nodeDesiredSize": "5", "nodeMinSize": "0", "karpenter": `{"test": true}`}, want: nodeScaling{Desired: 5, Min: 0, Max: 10}},
+		// Nothing would bring the nodes back
+		{values: map[string]string{"nodeDesiredSize": "5", "nodeMinSize": "0", "nodeMaxSize": "8"}, wantErr: true},
+		{values: map[string]string{"environments": `[{"name": "test", "minSize": 0}]`}, wantErr: true},
+		{values: map[string]string{"nodeDesiredSize": "1"}, want: nodeScaling{Desired: 1, Min: 1, Max: 2}},
+		{values: map[string]string{"nodeDesiredSize": "4", "nodeMaxSize": "3"}, wantErr: true},
+		{values: map[string]string{"nodeDesiredSize": "three"}, wantErr: true},
+	}
+	for _, c := range cases {
+		var got nodeScaling
+		err := pulumitest.Run(t, pulumitest.NewMocks(), c.values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			var err error
+			got, err = NodeScalingConfig(cfg, "test")
+			return err
+		})
+		if (err != nil) != c.wantErr {
+			t.Errorf("%v: unexpected error %v", c.values, err)
+			continue
+		}
+		if !c.wantErr && got != c.want {
+			t.Errorf("%v: expected %+v, got %+v", c.values, c.want, got)
+		}
+	}
+}
+
+func TestNodeScalingPerAz(t *testing.T) {
+	cases := []struct {
+		scaling nodeScaling
+		zones   int
+		want    nodeScaling
+	}{
+		{nodeScaling{Desired: 4, Min: 1, Max: 9}, 2, nodeScaling{Desired: 2, Min: 1, Max: 5}},
+		{nodeScaling{Desired: 6, Min: 2, Max: 6}, 3, nodeScaling{Desired: 2, Min: 1, Max: 2}},
+		{nodeScaling{Desired: 3, Min: 1, Max: 3}, 3, nodeScaling{Desired: 1, Min: 1, Max: 1}},
+		{nodeScaling{Desired: 4, Min: 3, Max: 8}, 2, nodeScaling{Desired: 2, Min: 2, Max: 4}},
+		{nodeScaling{Desired: 4, Min: 0, Max: 8}, 2, nodeScaling{Desired: 2, Min: 0, Max: 4}},
+	}
+	for _, c := range cases {
+		got, err := c.scaling.perAz(c.zones)
+		if err != nil {
+			t.Errorf("%+v over %d zones: unexpected error %v", c.scaling, c.zones, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%+v over %d zones: expected %+v, got %+v", c.scaling, c.zones, c.want, got)
+		}
+	}
+	if _, err := (nodeScaling{Desired: 3, Min: 1, Max: 6}).perAz(2); err == nil {
+		t.Error("expected a desired size that does not divide over the zones to be rejected")
+	}
+}
+
+func TestClusterSecurityGroup(t *testing.T) {
+	m := pulumitest.NewMocks()
+	var mu sync.Mutex
+	var securityGroupId string
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		cluster.SecurityGroupId.ApplyT(func(id string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			securityGroupId = id
+			return id
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if securityGroupId != "sg-test-aws-demo" {
+		t.Errorf("expected the EKS-created security group of the cluster, got %q", securityGroupId)
+	}
+	tags := m.ByType("aws:ec2/tag:Tag")
+	if len(tags) != 1 || tags[0].Inputs["resourceId"].StringValue() != "sg-test-aws-demo" ||
+		tags[0].Inputs["key"].StringValue() != "Name" || tags[0].Inputs["value"].StringValue() != "test-aws-demo-cluster-sg" {
+		t.Errorf("expected a Name tag on the cluster security group, got %v", tags)
+	}
+	for _, sg := range m.ByType("aws:ec2/securityGroup:SecurityGroup") {
+		if sg.Name == "test-cluster-sg" && sg.Inputs["tags"].ObjectValue()["Name"].StringValue() != "aws-demo-shared-cluster-sg" {
+			t.Errorf("expected the shared security group to be named, got %v", sg.Inputs["tags"])
+		}
+	}
+}
+
+func TestClusterReadyCheck(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"waitForClusterReady": "true", "clusterReadyTimeoutSeconds": "120"}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := m.ByType("command:local:Command")
+	if len(commands) != 1 || commands[0].Name != "test-cluster-ready" {
+		t.Fatalf("expected a readiness check for test, got %v", commands)
+	}
+	inputs := commands[0].Inputs
+	environment := inputs["environment"].ObjectValue()
+	if !strings.Contains(inputs["create"].StringValue(), `"$CLUSTER_ENDPOINT/readyz"`) ||
+		environment["CLUSTER_STATUS"].StringValue() != "ACTIVE" || environment["TIMEOUT_SECONDS"].StringValue() != "120" {
+		t.Errorf("expected the check to poll the ACTIVE cluster's readyz for up to 120s, got %v", inputs)
+	}
+
+	m = pulumitest.NewMocks()
+	err = pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands := m.ByType("command:local:Command"); len(commands) != 0 {
+		t.Errorf("expected no readiness check unless waitForClusterReady is set, got %v", commands)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"waitForClusterReady": "true", "clusterReadyTimeoutSeconds": "-1"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterReadyTimeoutSeconds must be positive") {
+		t.Errorf("expected a negative timeout to be rejected, got %v", err)
+	}
+}
+
+func TestNodeCapacityReservation(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"nodeCapacityReservation": `{"prod": "cr-0123456789abcdef0"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "prod")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+	if len(templates) != 1 {
+		t.Fatalf("expected one launch template, got %d", len(templates))
+	}
+	target := templates[0].Inputs["capacityReservationSpecification"].ObjectValue()["capacityReservationTarget"].ObjectValue()
+	if target["capacityReservationId"].StringValue() != "cr-0123456789abcdef0" {
+		t.Errorf("expected the reservation to be targeted, got %v", target)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeCapacityReservation": `{"prod": "reservation-1"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "prod")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "nodeCapacityReservation") {
+		t.Errorf("expected an invalid reservation ID to be rejected, got %v", err)
+	}
+}
+
+func TestNodeVolumeEncryption(t *testing.T) {
+	keyArn := "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"nodeVolumeKmsKeyArn": keyArn, "nodeVolumeSize": "50"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "prod")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+	if len(templates) != 1 {
+		t.Fatalf("expected one launch template, got %d", len(templates))
+	}
+	mappings := templates[0].Inputs["blockDeviceMappings"].ArrayValue()
+	if len(mappings) != 1 || mappings[0].ObjectValue()["deviceName"].StringValue() != "/dev/xvda" {
+		t.Fatalf("expected the root volume to be mapped, got %v", mappings)
+	}
+	ebs := mappings[0].ObjectValue()["ebs"].ObjectValue()
+	if ebs["encrypted"].StringValue() != "true" || ebs["kmsKeyId"].StringValue() != keyArn || ebs["volumeSize"].NumberValue() != 50 {
+		t.Errorf("unexpected root volume %v", ebs)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeVolumeKmsKeyArn": "alias/nodes"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "prod")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "nodeVolumeKmsKeyArn") {
+		t.Errorf("expected a key that is not an ARN to be rejected, got %v", err)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	var summary string
+	values := map[string]string{"enableContainerInsights": "true", "containerInsightsLogs": "false"}
+	err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		summary, err = inventory(cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3 t3.medium nodes (min 1, max 6); public API endpoint; charts argo-cd, argo-rollouts, aws-cloudwatch-metrics"
+	if summary != want {
+		t.Errorf("expected %q, got %q", want, summary)
+	}
+}
+
+func TestWriteSsmParameters(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"ssmParameterPrefix": "/eksdemo"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		return WriteSsmParameters(ctx, cfg, cluster, pulumi.String("").ToStringOutput())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, p := range m.ByType("aws:ssm/parameter:Parameter") {
+		types[p.Inputs["name"].StringValue()] = p.Inputs["type"].StringValue()
+	}
+	if len(types) != 5 || types["/eksdemo/test/kubeconfig"] != "SecureString" || types["/eksdemo/test/cluster-name"] != "String" {
+		t.Errorf("unexpected parameters %v", types)
+	}
+}
+
+func TestAttachPolicyNames(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		names = append(names, a.Name)
+	}
+	sort.Strings(names)
+	want := "ngpa-AmazonEC2ContainerRegistryReadOnly,ngpa-AmazonEKSWorkerNodePolicy,ngpa-AmazonEKS_CNI_Policy,rpa-AmazonEKSClusterPolicy,rpa-AmazonEKSServicePolicy"
+	if strings.Join(names, ",") != want {
+		t.Errorf("expected attachments named after their policies, got %v", names)
+	}
+}
+
+func TestCreateBastion(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"clusterEndpointPrivateAccess": "true", "bastionSshCidrs": `["203.0.113.0/24"]`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = CreateBastion(ctx, cfg, cluster)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances := m.ByType("aws:ec2/instance:Instance")
+	if len(instances) != 1 || instances[0].Inputs["ami"].StringValue() != "ami-123" {
+		t.Errorf("expected one bastion on the latest Amazon Linux 2 AMI, got %v", instances)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = CreateBastion(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterEndpointPrivateAccess") {
+		t.Errorf("expected a bastion without private endpoint access to be rejected, got %v", err)
+	}
+}
+
+func TestInstanceTypeOffered(t *testing.T) {
+	m := pulumitest.NewMocks()
+	m.MissingOfferingAz = "eu-west-1b"
+	err := pulumitest.Run(t, m, map[string]string{"nodeInstanceType": "t2.small"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "t2.small is not offered in eu-west-1b") {
+		t.Errorf("expected the missing zone to be reported, got %v", err)
+	}
+
+	m = pulumitest.NewMocks()
+	m.MissingOfferingAz = "eu-west-1b"
+	err = pulumitest.Run(t, m, map[string]string{"nodeInstanceType": "t2.small", "skipInstanceTypeCheck": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Errorf("expected skipInstanceTypeCheck to skip the check, got %v", err)
+	}
+}
+
+func TestClusterRoleArn(t *testing.T) {
+	m := pulumitest.NewMocks()
+	arn := "arn:aws:iam::123456789012:role/platform/eks-cluster"
+	err := pulumitest.Run(t, m, map[string]string{"clusterRoleArn": arn}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range m.ByType("aws:iam/role:Role") {
+		if role.Name == "eks-iam-eksRole" {
+			t.Errorf("expected no cluster role to be created when clusterRoleArn is set")
+		}
+	}
+	clusters := m.ByType("aws:eks/cluster:Cluster")
+	if len(clusters) != 1 || clusters[0].Inputs["roleArn"].StringValue() != arn {
+		t.Errorf("expected the cluster to use %s, got %v", arn, clusters)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterRoleArn": "eks-cluster"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "not an IAM role ARN") {
+		t.Errorf("expected a bare role name to be rejected, got %v", err)
+	}
+}
+
+func TestNodeGroupPerAz(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"nodeGroupPerAz": "true", "nodeDesiredSize": "4", "nodeMinSize": "1", "nodeMaxSize": "9"}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeGroups := m.ByType("aws:eks/nodeGroup:NodeGroup")
+	if len(nodeGroups) != 2 {
+		t.Fatalf("expected a node group per zone, got %d", len(nodeGroups))
+	}
+	for _, ng := range nodeGroups {
+		az := strings.TrimPrefix(ng.Name, "test-aws-demo-node-group-")
+		for _, subnet := range ng.Inputs["subnetIds"].ArrayValue() {
+			if m.SubnetAzs[subnet.StringValue()] != az {
+				t.Errorf("expected %s to only use subnets in %s, got %s", ng.Name, az, subnet.StringValue())
+			}
+		}
+		scaling := ng.Inputs["scalingConfig"].ObjectValue()
+		if scaling["desiredSize"].NumberValue() != 2 || scaling["minSize"].NumberValue() != 1 || scaling["maxSize"].NumberValue() != 5 {
+			t.Errorf("unexpected scaling config %v for %s", scaling, ng.Name)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeGroupPerAz": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "multiple of the 2 availability zones") {
+		t.Errorf("expected 3 nodes over 2 zones to be rejected, got %v", err)
+	}
+}
+
+func TestAllowCrossClusterTraffic(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		network, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		var clusters []*Cluster
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := ProvisionCluster(ctx, cfg, env, shared)
+			if err != nil {
+				return err
+			}
+			clusters = append(clusters, cluster)
+		}
+		return AllowCrossClusterTraffic(ctx, clusters)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rule := range m.ByType("aws:ec2/securityGroupRule:SecurityGroupRule") {
+		names = append(names, rule.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "prod-from-test-cluster-traffic,test-from-prod-cluster-traffic" {
+		t.Errorf("expected reciprocal rules between test and prod, got %v", names)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		test, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		otherNetwork := *test.Shared.Network
+		otherNetwork.VpcId = pulumi.String("vpc-456")
+		otherShared := *test.Shared
+		otherShared.Network = &otherNetwork
+		prod := &Cluster{Env: "prod", Shared: &otherShared}
+		return AllowCrossClusterTraffic(ctx, []*Cluster{test, prod})
+	})
+	if err == nil || !strings.Contains(err.Error(), "one VPC") {
+		t.Errorf("expected clusters in different VPCs to be rejected, got %v", err)
+	}
+}
+
+func TestResourceTimeouts(t *testing.T) {
+	var timeouts *pulumi.CustomTimeouts
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterTimeouts": `{"create": "1h"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		timeouts, err = resourceTimeouts(cfg, "clusterTimeouts", defaultClusterTimeouts)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts.Create != "1h" || timeouts.Update != defaultClusterTimeouts.Update || timeouts.Delete != defaultClusterTimeouts.Delete {
+		t.Errorf("expected create to be overridden and the rest defaulted, got %+v", timeouts)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeGroupTimeouts": `{"create": "forever"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "nodeGroupTimeouts.create") {
+		t.Errorf("expected an invalid duration to be rejected, got %v", err)
+	}
+}
+
+func TestWriteKubectlContextScript(t *testing.T) {
+	t.Setenv("CI", "")
+	path := filepath.Join(t.TempDir(), "use-clusters.sh")
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"kubectlContextScript": path}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = WriteKubectlContextScript(ctx, cfg, []*Cluster{cluster}, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "aws eks update-kubeconfig --region ") || !strings.Contains(string(script), "--name test-aws-demo --alias test") {
+		t.Errorf("expected an update-kubeconfig line for the test cluster, got:\n%s", script)
+	}
+
+	t.Setenv("CI", "true")
+	ciPath := filepath.Join(t.TempDir(), "use-clusters.sh")
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"kubectlContextScript": ciPath}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		_, err = WriteKubectlContextScript(ctx, cfg, []*Cluster{cluster}, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ciPath); !os.IsNotExist(err) {
+		t.Errorf("expected no script in CI, got %v", err)
+	}
+}
+
+func TestNodeGroupsIgnoreSubnetOrder(t *testing.T) {
+	// The node groups and what hangs off them. Registration order is up to the
+	// engine, so only the names and inputs are compared.
+	deploy := func(order []string) []string {
+		m := pulumitest.NewMocks()
+		m.SubnetOrder = order
+		err := pulumitest.Run(t, m, map[string]string{"nodeGroupPerAz": "true", "nodeDesiredSize": "4"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			cluster, err := provision(ctx, cfg, "test")
+			if err != nil {
+				return err
+			}
+			_, err = CreateStandaloneAlb(ctx, cfg, cluster)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, nodeGroup := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+			var subnets []string
+			for _, id := range nodeGroup.Inputs["subnetIds"].ArrayValue() {
+				subnets = append(subnets, id.StringValue())
+			}
+			got = append(got, nodeGroup.Name+"="+strings.Join(subnets, ","))
+		}
+		for _, attachment := range m.ByType("aws:autoscaling/attachment:Attachment") {
+			got = append(got, attachment.Name)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	first := deploy([]string{"subnet-a1", "subnet-a2", "subnet-b1", "subnet-b2"})
+	second := deploy([]string{"subnet-b2", "subnet-a2", "subnet-b1", "subnet-a1"})
+	if strings.Join(first, " ") != strings.Join(second, " ") {
+		t.Errorf("expected the same node groups whatever the subnet order, got\n%v\n%v", first, second)
+	}
+	want := []string{
+		"test-aws-demo-node-group-eu-west-1a=subnet-a1,subnet-a2",
+		"test-aws-demo-node-group-eu-west-1b=subnet-b1,subnet-b2",
+		"test-standalone-alb-attachment-eu-west-1a",
+		"test-standalone-alb-attachment-eu-west-1b",
+	}
+	if strings.Join(first, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, first)
+	}
+}
+
+func TestClusterApiIngress(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"clusterApiIngress": `[{"cidr": "10.8.0.0/16", "description": "VPN"}, {"cidr": "2001:db8::/32", "port": 8443}]`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := map[string]pulumi.MockResourceArgs{}
+	for _, rule := range m.ByType("aws:ec2/securityGroupRule:SecurityGroupRule") {
+		rules[rule.Name] = rule
+	}
+	vpn, ok := rules["test-api-ingress-10-8-0-0-16-443"]
+	if !ok {
+		t.Fatalf("expected a rule for the VPN CIDR on 443, got %v", rules)
+	}
+	if vpn.Inputs["cidrBlocks"].ArrayValue()[0].StringValue() != "10.8.0.0/16" || vpn.Inputs["description"].StringValue() != "VPN" {
+		t.Errorf("unexpected VPN rule %v", vpn.Inputs)
+	}
+	v6, ok := rules["test-api-ingress-2001-db8---32-8443"]
+	if !ok || v6.Inputs["ipv6CidrBlocks"].ArrayValue()[0].StringValue() != "2001:db8::/32" {
+		t.Errorf("expected an IPv6 rule on 8443, got %v", rules)
+	}
+
+	values["clusterApiIngress"] = `[{"cidr": "10.8.0.0/16", "port": 70000}]`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "between 1 and 65535") {
+		t.Errorf("expected an invalid port to be rejected, got %v", err)
+	}
+}
+
+func TestNodeGroupIgnoreChanges(t *testing.T) {
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterAutoscaler": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for env, want := range map[string]string{"test": "scalingConfig.desiredSize", "prod": ""} {
+			ignored, err := nodeGroupIgnoreChanges(cfg, env)
+			if err != nil {
+				return err
+			}
+			if got := strings.Join(ignored, ","); got != want {
+				t.Errorf("expected %s node groups to ignore %q, got %q", env, want, got)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestrictNodeEgress(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"restrictNodeEgress":           "true",
+		"clusterEndpointPrivateAccess": "true",
+		"vpcEndpointServices":          `["logs", "sts"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var endpoints []string
+	for _, e := range m.ByType("aws:ec2/vpcEndpoint:VpcEndpoint") {
+		endpoints = append(endpoints, e.Inputs["serviceName"].StringValue())
+		if e.Inputs["vpcEndpointType"].StringValue() == "Interface" && len(e.Inputs["subnetIds"].ArrayValue()) != 2 {
+			t.Errorf("expected %s in one subnet per zone, got %v", e.Name, e.Inputs["subnetIds"])
+		}
+	}
+	sort.Strings(endpoints)
+	expected := "com.amazonaws.eu-west-1.ec2 com.amazonaws.eu-west-1.ecr.api com.amazonaws.eu-west-1.ecr.dkr " +
+		"com.amazonaws.eu-west-1.logs com.amazonaws.eu-west-1.s3 com.amazonaws.eu-west-1.sts"
+	if strings.Join(endpoints, " ") != expected {
+		t.Errorf("expected endpoints %s, got %v", expected, endpoints)
+	}
+	templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+	if len(templates) != 1 || templates[0].Inputs["vpcSecurityGroupIds"].ArrayValue()[0].StringValue() != "test-node-sg_id" {
+		t.Errorf("expected the nodes to be launched in the test-node-sg security group, got %v", templates)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"restrictNodeEgress": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs clusterEndpointPrivateAccess") {
+		t.Errorf("expected restricted egress without the private endpoint to be rejected, got %v", err)
+	}
+	m = pulumitest.NewMocks()
+	m.NoVpcDnsHostnames = true
+	err = pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "DNS hostnames") {
+		t.Errorf("expected a VPC without DNS hostnames to be rejected, got %v", err)
+	}
+}
+
+func TestStandaloneAlbCertificate(t *testing.T) {
+	certificateArn := "arn:aws:acm:eu-west-1:123456789012:certificate/0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0"
+	for _, tc := range []struct {
+		values   map[string]string
+		protocol string
+		port     float64
+	}{
+		{map[string]string{}, "HTTP", 80},
+		{map[string]string{"standaloneAlbCertificateArn": certificateArn}, "HTTPS", 443},
+		{map[string]string{"standaloneAlbCertificateArn": certificateArn, "standaloneAlbListenerPort": "8443"}, "HTTPS", 8443},
+	} {
+		m := pulumitest.NewMocks()
+		err := pulumitest.Run(t, m, tc.values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			cluster, err := provision(ctx, cfg, "test")
+			if err != nil {
+				return err
+			}
+			_, err = CreateStandaloneAlb(ctx, cfg, cluster)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners := m.ByType("aws:lb/listener:Listener")
+		if len(listeners) != 1 || listeners[0].Inputs["protocol"].StringValue() != tc.protocol ||
+			listeners[0].Inputs["port"].NumberValue() != tc.port {
+			t.Errorf("expected an %s listener on %v with %v, got %v", tc.protocol, tc.port, tc.values, listeners)
+		}
+		if tc.protocol == "HTTPS" && listeners[0].Inputs["certificateArn"].StringValue() != certificateArn {
+			t.Errorf("expected the listener to use the certificate, got %v", listeners[0].Inputs)
+		}
+	}
+
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"standaloneAlbCertificateArn": "arn:aws:acm:us-east-1:123456789012:certificate/abc-123"},
+		func(ctx *pulumi.Context, cfg *config.Config) error {
+			cluster, err := provision(ctx, cfg, "test")
+			if err != nil {
+				return err
+			}
+			_, err = CreateStandaloneAlb(ctx, cfg, cluster)
+			return err
+		})
+	if err == nil || !strings.Contains(err.Error(), "its own region") {
+		t.Errorf("expected a certificate from another region to be rejected, got %v", err)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"standaloneAlbCertificateArn": "arn:aws:iam::123456789012:server-certificate/demo"},
+		func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+	if err == nil || !strings.Contains(err.Error(), "not an ACM certificate ARN") {
+		t.Errorf("expected a non-ACM ARN to be rejected, got %v", err)
+	}
+}
+
+func TestNodeMixedInstances(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"nodeMixedInstances": `{"instanceTypes": [{"type": "m5.large", "weight": 1}, {"type": "m5a.large", "weight": 3}, {"type": "m6i.large", "weight": 2}],
+			"spotAllocationStrategy": "capacity-optimized"}`,
+		"argoDedicatedNodes": `{"test": true}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ng := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		var types []string
+		for _, v := range ng.Inputs["instanceTypes"].ArrayValue() {
+			types = append(types, v.StringValue())
+		}
+		if strings.Join(types, " ") != "m5a.large m6i.large m5.large" {
+			t.Errorf("expected %s to prefer the heaviest instance types, got %v", ng.Name, types)
+		}
+		spot := ng.Inputs["capacityType"].HasValue() && ng.Inputs["capacityType"].StringValue() == "SPOT"
+		if spot != (ng.Name == "test-aws-demo-node-group") {
+			t.Errorf("expected only the main node group on Spot, got %v for %s", ng.Inputs["capacityType"], ng.Name)
+		}
+	}
+
+	for mixed, want := range map[string]string{
+		`{"instanceTypes": [{"type": "m5.large", "weight": 0}]}`:                                           "from 1 to 999",
+		`{"instanceTypes": [{"type": "m5.large", "weight": 1}], "spotAllocationStrategy": "lowest-price"}`: "not available on managed node groups",
+		`{"instanceTypes": [{"type": "m5.large", "weight": 1}], "spotAllocationStrategy": "cheapest"}`:     "must be capacity-optimized or lowest-price",
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"nodeMixedInstances": mixed}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected nodeMixedInstances %s to be rejected with %q, got %v", mixed, want, err)
+		}
+	}
+}
+
+func TestGithubDeploy(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"githubDeploy": `{"repo": "my-org/my-app", "refs": ["refs/heads/main", "refs/tags/v*"]}`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		network, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		shared.GithubDeploy, err = iamroles.CreateGithubDeployRole(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		_, err = ProvisionCluster(ctx, cfg, "test", shared)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches := m.ByType("kubernetes:core/v1:ConfigMapPatch")
+	if len(patches) != 1 {
+		t.Fatalf("expected one aws-auth patch, got %v", patches)
+	}
+	mapRoles := patches[0].Inputs["data"].ObjectValue()["mapRoles"].StringValue()
+	for _, want := range []string{"role/nodegroup-iam-role", "system:node:{{EC2PrivateDNSName}}", "role/github-deploy-role", "system:masters"} {
+		if !strings.Contains(mapRoles, want) {
+			t.Errorf("expected mapRoles to contain %s, got %s", want, mapRoles)
+		}
+	}
+}
+
+func TestAwsAuthPrincipals(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"awsAuthPrincipals": `[
+		{"arn": "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_Admin_1234", "groups": ["system:masters"]},
+		{"arn": "arn:aws:iam::123456789012:user/alice", "groups": ["viewers"]}
+	]`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patches := m.ByType("kubernetes:core/v1:ConfigMapPatch")
+	if len(patches) != 1 {
+		t.Fatalf("expected one aws-auth patch, got %v", patches)
+	}
+	data := patches[0].Inputs["data"].ObjectValue()
+	mapRoles := data["mapRoles"].StringValue()
+	for _, want := range []string{"role/nodegroup-iam-role", "rolearn: arn:aws:iam::123456789012:role/AWSReservedSSO_Admin_1234", "AWSReservedSSO_Admin_1234:{{SessionName}}"} {
+		if !strings.Contains(mapRoles, want) {
+			t.Errorf("expected mapRoles to contain %s, got %s", want, mapRoles)
+		}
+	}
+	if mapUsers := data["mapUsers"].StringValue(); !strings.Contains(mapUsers, "userarn: arn:aws:iam::123456789012:user/alice") ||
+		!strings.Contains(mapUsers, "username: alice") {
+		t.Errorf("expected alice in mapUsers, got %s", mapUsers)
+	}
+
+	for _, principals := range []string{
+		`[{"arn": "arn:aws:iam::123456789012:group/admins", "groups": ["system:masters"]}]`,
+		`[{"arn": "arn:aws:iam::123456789012:user/bob", "groups": ["system:nodes"]}]`,
+		`[{"arn": "arn:aws:iam::123456789012:user/bob", "groups": ["has space"]}]`,
+		`[{"arn": "arn:aws:iam::123456789012:user/bob"}]`,
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"awsAuthPrincipals": principals}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil {
+			t.Errorf("expected %s to be refused", principals)
+		}
+	}
+}
+
+func TestKmsKeys(t *testing.T) {
+	for mode, want := range map[string]string{
+		"shared":      "test-kms-key",
+		"per-service": "test-kms-key-ebs test-kms-key-eks",
+	} {
+		m := pulumitest.NewMocks()
+		var arns map[string]pulumi.StringOutput
+		err := pulumitest.Run(t, m, map[string]string{"kmsKeys": mode}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			cluster, err := provision(ctx, cfg, "test")
+			arns = KmsKeyArns(cluster)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, k := range m.ByType("aws:kms/key:Key") {
+			keys = append(keys, k.Name)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, " ") != want {
+			t.Errorf("expected %s keys %s, got %v", mode, want, keys)
+		}
+		if len(arns) != len(keys) {
+			t.Errorf("expected an ARN to export per %s key, got %v", mode, arns)
+		}
+		clusters := m.ByType("aws:eks/cluster:Cluster")
+		if len(clusters) != 1 || !strings.Contains(clusters[0].Inputs["encryptionConfig"].String(), "secrets") {
+			t.Errorf("expected the %s cluster to encrypt secrets, got %v", mode, clusters)
+		}
+		templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+		if len(templates) != 1 || !strings.Contains(templates[0].Inputs["blockDeviceMappings"].String(), "kmsKeyId") {
+			t.Errorf("expected the %s node volumes to use the key, got %v", mode, templates)
+		}
+	}
+
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"kmsKeys": "shared", "nodeVolumeKmsKeyArn": "arn:aws:kms:eu-west-1:123456789012:key/abc"},
+		func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+	if err == nil || !strings.Contains(err.Error(), "nodeVolumeKmsKeyArn") {
+		t.Errorf("expected nodeVolumeKmsKeyArn to conflict with kmsKeys, got %v", err)
+	}
+}
+
+func TestClusterTtl(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"clusterTtl": `{"test": "72h"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"aws:eks/cluster:Cluster", "aws:eks/nodeGroup:NodeGroup"} {
+		for _, r := range m.ByType(typ) {
+			tags := r.Inputs["tags"]
+			ephemeral := strings.HasPrefix(r.Name, "test-")
+			if !ephemeral {
+				if tags.HasValue() {
+					t.Errorf("expected %s to have no expiry tags, got %v", r.Name, tags)
+				}
+				continue
+			}
+			expiresAt, err := time.Parse(time.RFC3339, tags.ObjectValue()["ExpiresAt"].StringValue())
+			if err != nil || tags.ObjectValue()["Ephemeral"].StringValue() != "true" {
+				t.Errorf("expected %s to be tagged ephemeral with an expiry, got %v", r.Name, tags)
+			}
+			if until := time.Until(expiresAt); until < 71*time.Hour || until > 72*time.Hour {
+				t.Errorf("expected %s to expire in 72h, got %s", r.Name, expiresAt)
+			}
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterTtl": `{"test": "3d"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterTtl") {
+		t.Errorf("expected 3d to be refused, got %v", err)
+	}
+}
+
+func TestServerSideApply(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"serverSideApply": `{"prod": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range m.ByType("pulumi:providers:kubernetes") {
+		ssa := p.Inputs["enableServerSideApply"]
+		enabled := ssa.IsBool() && ssa.BoolValue()
+		switch p.Name {
+		case "prod-k8sprovider":
+			if !enabled {
+				t.Errorf("expected prod's provider to use server-side apply, got %v", ssa)
+			}
+		case "test-k8sprovider":
+			if enabled {
+				t.Errorf("expected test's provider to keep client-side apply, got %v", ssa)
+			}
+		}
+	}
+}
+
+func TestEnvironments(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"environments": `[{"name": "staging", "instanceType": "t3.large", "desiredSize": 2, "kubernetesVersion": "1.24"},
+			{"name": "prod", "desiredSize": 4}]`,
+		"nodeMaxSize": "6",
+	}
+	var environments []string
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		if environments, err = stackconfig.Environments(cfg); err != nil {
+			return err
+		}
+		for _, env := range environments {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(environments, ",") != "staging,prod" {
+		t.Errorf("expected the configured environments, got %v", environments)
+	}
+	for _, ng := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		scaling := ng.Inputs["scalingConfig"].ObjectValue()
+		desired, max := scaling["desiredSize"].NumberValue(), scaling["maxSize"].NumberValue()
+		switch ng.Name {
+		case "staging-aws-demo-node-group":
+			if desired != 2 || max != 6 {
+				t.Errorf("expected staging to run 2 of at most 6 nodes, got %v of %v", desired, max)
+			}
+			if types := ng.Inputs["instanceTypes"]; !types.IsArray() || types.ArrayValue()[0].StringValue() != "t3.large" {
+				t.Errorf("expected staging to run t3.large, got %v", types)
+			}
+			if v := ng.Inputs["version"]; !v.IsString() || v.StringValue() != "1.24" {
+				t.Errorf("expected staging's nodes on 1.24, got %v", v)
+			}
+		case "prod-aws-demo-node-group":
+			if desired != 4 {
+				t.Errorf("expected prod to run 4 nodes, got %v", desired)
+			}
+			if v := ng.Inputs["version"]; v.IsString() {
+				t.Errorf("expected prod's nodes to keep the cluster's version, got %v", v)
+			}
+		default:
+			t.Errorf("unexpected node group %s", ng.Name)
+		}
+	}
+	for _, c := range m.ByType("aws:eks/cluster:Cluster") {
+		if v := c.Inputs["version"]; c.Name == "staging-aws-demo" && (!v.IsString() || v.StringValue() != "1.24") {
+			t.Errorf("expected staging's cluster on 1.24, got %v", v)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		environments, err = stackconfig.Environments(cfg)
+		return err
+	})
+	if err != nil || strings.Join(environments, ",") != "test,prod" {
+		t.Errorf("expected test and prod by default, got %v, %v", environments, err)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"environments": `[{"name": "test", "kubernetesVersion": "latest"}]`},
+		func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+	if err == nil || !strings.Contains(err.Error(), "kubernetesVersion") {
+		t.Errorf("expected an invalid version to be rejected, got %v", err)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{
+		"environments":       `[{"name": "test", "instanceType": "m5.large"}]`,
+		"nodeMixedInstances": `{"instanceTypes": [{"type": "m5.large", "weight": 1}, {"type": "m5a.large", "weight": 1}]}`,
+	}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "cannot set instanceType") {
+		t.Errorf("expected instanceType with nodeMixedInstances to be rejected, got %v", err)
+	}
+}
+
+func TestDedicatedVpc(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"useDefaultVpc": "false",
+		// The mocked offerings only cover the default VPC's zones
+		"skipInstanceTypeCheck": "true",
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cidrs := map[string]string{}
+	for _, subnet := range m.ByType("aws:ec2/subnet:Subnet") {
+		cidrs[subnet.Name] = subnet.Inputs["cidrBlock"].StringValue()
+	}
+	want := map[string]string{
+		"aws-demo-vpc-private-eu-west-1a": "10.0.0.0/19",
+		"aws-demo-vpc-private-eu-west-1b": "10.0.32.0/19",
+		"aws-demo-vpc-private-eu-west-1c": "10.0.64.0/19",
+		"aws-demo-vpc-public-eu-west-1a":  "10.0.128.0/19",
+		"aws-demo-vpc-public-eu-west-1b":  "10.0.160.0/19",
+		"aws-demo-vpc-public-eu-west-1c":  "10.0.192.0/19",
+	}
+	if fmt.Sprint(cidrs) != fmt.Sprint(want) {
+		t.Errorf("expected subnets %v, got %v", want, cidrs)
+	}
+	if nats := m.ByType("aws:ec2/natGateway:NatGateway"); len(nats) != 3 {
+		t.Errorf("expected a NAT gateway per zone, got %d", len(nats))
+	}
+	for _, cluster := range m.ByType("aws:eks/cluster:Cluster") {
+		var ids []string
+		for _, id := range cluster.Inputs["vpcConfig"].ObjectValue()["subnetIds"].ArrayValue() {
+			ids = append(ids, id.StringValue())
+		}
+		if !strings.HasPrefix(strings.Join(ids, ","), "aws-demo-vpc-private-") || len(ids) != 3 {
+			t.Errorf("expected the cluster in the private subnets, got %v", ids)
+		}
+	}
+
+	if vpcs := m.ByType("aws:ec2/vpc:Vpc"); len(vpcs) != 1 || vpcs[0].Inputs["assignGeneratedIpv6CidrBlock"].BoolValue() {
+		t.Errorf("expected an IPv4 only VPC by default, got %v", vpcs)
+	}
+
+	m = pulumitest.NewMocks()
+	values["ipFamily"] = "ipv6"
+	err = pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vpcs := m.ByType("aws:ec2/vpc:Vpc"); len(vpcs) != 1 || !vpcs[0].Inputs["assignGeneratedIpv6CidrBlock"].BoolValue() {
+		t.Errorf("expected the VPC to get an IPv6 block, got %v", vpcs)
+	}
+	ipv6Cidrs := map[string]string{}
+	for _, subnet := range m.ByType("aws:ec2/subnet:Subnet") {
+		if !subnet.Inputs["assignIpv6AddressOnCreation"].BoolValue() {
+			t.Errorf("expected %s to assign IPv6 addresses on launch", subnet.Name)
+		}
+		ipv6Cidrs[subnet.Name] = subnet.Inputs["ipv6CidrBlock"].StringValue()
+	}
+	if ipv6Cidrs["aws-demo-vpc-private-eu-west-1b"] != "2001:db8:1200:1::/64" || ipv6Cidrs["aws-demo-vpc-public-eu-west-1a"] != "2001:db8:1200:4::/64" {
+		t.Errorf("expected each subnet to get its /64 of the VPC's block, got %v", ipv6Cidrs)
+	}
+	if gateways := m.ByType("aws:ec2/egressOnlyInternetGateway:EgressOnlyInternetGateway"); len(gateways) != 1 {
+		t.Errorf("expected an egress-only internet gateway, got %v", gateways)
+	}
+	for _, table := range m.ByType("aws:ec2/routeTable:RouteTable") {
+		if !strings.Contains(table.Inputs["routes"].String(), "::/0") {
+			t.Errorf("expected %s to route IPv6 out, got %v", table.Name, table.Inputs["routes"])
+		}
+	}
+	if clusters := m.ByType("aws:eks/cluster:Cluster"); len(clusters) != 1 ||
+		clusters[0].Inputs["kubernetesNetworkConfig"].ObjectValue()["ipFamily"].StringValue() != "ipv6" {
+		t.Errorf("expected an IPv6 cluster, got %v", clusters)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"useDefaultVpc": "false", "vpcCidr": "10.0.0.0/24"},
+		func(ctx *pulumi.Context, cfg *config.Config) error {
+			return network.ValidateConfig(cfg, []string{"test"})
+		})
+	if err == nil || !strings.Contains(err.Error(), "vpcCidr") {
+		t.Errorf("expected a VPC too small to split to be rejected, got %v", err)
+	}
+}
+
+func TestSpotNodes(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"spotNodes":             `{"test": true}`,
+		"spotNodeInstanceTypes": `["m5.large", "m5a.large"]`,
+	}
+	var summary string
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		if summary, err = inventory(cfg, "test"); err != nil {
+			return err
+		}
+		for _, env := range []string{"test", "prod"} {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]string{}
+	for _, ng := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		scaling := ng.Inputs["scalingConfig"].ObjectValue()
+		size := fmt.Sprintf("%v/%v/%v", scaling["minSize"].NumberValue(), scaling["desiredSize"].NumberValue(), scaling["maxSize"].NumberValue())
+		if ng.Inputs["capacityType"].HasValue() {
+			size += " " + ng.Inputs["capacityType"].StringValue()
+		}
+		sizes[ng.Name] = size
+	}
+	want := map[string]string{
+		// 20% of min 1, desired 3 and max 6 rounded up stays on-demand
+		"test-aws-demo-node-group":      "1/1/2",
+		"test-aws-demo-node-group-spot": "0/2/4 SPOT",
+		"prod-aws-demo-node-group":      "1/3/6",
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("expected node groups %v, got %v", want, sizes)
+	}
+	if !strings.Contains(summary, "2 m5.large/m5a.large spot nodes (min 0, max 4)") {
+		t.Errorf("expected the spot nodes in the inventory, got %q", summary)
+	}
+
+	for key, value := range map[string]string{
+		"spotOnDemandBasePercentage": "100",
+		"nodeMixedInstances":         `{"instanceTypes": [{"type": "m5.large", "weight": 1}], "spotAllocationStrategy": "capacity-optimized"}`,
+	} {
+		bad := map[string]string{key: value}
+		for k, v := range values {
+			bad[k] = v
+		}
+		err := pulumitest.Run(t, pulumitest.NewMocks(), bad, func(ctx *pulumi.Context, cfg *config.Config) error {
+			_, err := provision(ctx, cfg, "test")
+			return err
+		})
+		if err == nil || !strings.Contains(err.Error(), "spot") && !strings.Contains(err.Error(), "Spot") {
+			t.Errorf("expected %s %s to be rejected, got %v", key, value, err)
+		}
+	}
+}
+
+func TestFargateSelectors(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"enableFargate":              "true",
+		"fargateSubnetIds":           `["subnet-p1","subnet-p2"]`,
+		"fargateSelectors":           `{"test": [{"namespace": "argocd", "labels": {"app.kubernetes.io/name": "argocd-repo-server"}}]}`,
+		"fargatePodExecutionRoleArn": "arn:aws:iam::123456789012:role/fargate-pods",
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		for _, env := range []string{"test", "prod"} {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range m.ByType("aws:iam/role:Role") {
+		if role.Name == "fargate-pod-execution-role" {
+			t.Error("expected the configured pod execution role to be used instead of creating one")
+		}
+	}
+	selectors := map[string][]resource.PropertyValue{}
+	for _, profile := range m.ByType("aws:eks/fargateProfile:FargateProfile") {
+		if profile.Inputs["podExecutionRoleArn"].StringValue() != "arn:aws:iam::123456789012:role/fargate-pods" {
+			t.Errorf("expected %s to use the configured role, got %v", profile.Name, profile.Inputs["podExecutionRoleArn"])
+		}
+		selectors[profile.Name] = profile.Inputs["selectors"].ArrayValue()
+	}
+	test := selectors["test-fargate-profile"]
+	if len(test) != 1 || test[0].ObjectValue()["namespace"].StringValue() != "argocd" ||
+		test[0].ObjectValue()["labels"].ObjectValue()["app.kubernetes.io/name"].StringValue() != "argocd-repo-server" {
+		t.Errorf("expected test to run only the Argo CD repo server on Fargate, got %v", test)
+	}
+	if len(selectors["prod-fargate-profile"]) != 4 {
+		t.Errorf("expected prod to keep the default namespace selectors, got %v", selectors["prod-fargate-profile"])
+	}
+
+	values["fargateSelectors"] = `{"test": [{"namespace": "Argo CD"}]}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "fargateSelectors") {
+		t.Errorf("expected an invalid namespace to be rejected, got %v", err)
+	}
+}
+
+func TestClusterLogs(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, map[string]string{"clusterLogTypes": `["api", "audit"]`, "logRetentionDays": "14"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The log group is named after the cluster before it exists
+	clusters := m.ByType("aws:eks/cluster:Cluster")
+	if len(clusters) != 1 || clusters[0].Inputs["name"].StringValue() != "test-aws-demo-test" {
+		t.Fatalf("expected the cluster to be named after its environment and stack, got %v", clusters)
+	}
+	if logTypes := clusters[0].Inputs["enabledClusterLogTypes"].ArrayValue(); len(logTypes) != 2 ||
+		logTypes[0].StringValue() != "api" || logTypes[1].StringValue() != "audit" {
+		t.Errorf("expected the cluster to send the api and audit logs, got %v", logTypes)
+	}
+	logGroups := m.ByType("aws:cloudwatch/logGroup:LogGroup")
+	if len(logGroups) != 1 || logGroups[0].Inputs["name"].StringValue() != "/aws/eks/test-aws-demo-test/cluster" ||
+		logGroups[0].Inputs["retentionInDays"].NumberValue() != 14 {
+		t.Errorf("expected the control plane log group of the cluster's name to keep events for 14 days, got %v", logGroups)
+	}
+	for _, c := range m.ByType("command:local:Command") {
+		if c.Name == "test-cluster-logging" {
+			t.Errorf("expected logging to be set on the cluster, not by a command, got %v", c)
+		}
+	}
+
+	m = pulumitest.NewMocks()
+	err = pulumitest.Run(t, m, map[string]string{"clusterLogTypes": `["api"]`, "clusterNames": `{"test": "test-aws-demo-4f1c2a9"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusters := m.ByType("aws:eks/cluster:Cluster"); clusters[0].Inputs["name"].StringValue() != "test-aws-demo-4f1c2a9" {
+		t.Errorf("expected clusterNames to keep the cluster's name, got %v", clusters[0].Inputs["name"])
+	}
+	if logGroups := m.ByType("aws:cloudwatch/logGroup:LogGroup"); logGroups[0].Inputs["name"].StringValue() != "/aws/eks/test-aws-demo-4f1c2a9/cluster" {
+		t.Errorf("expected the log group of the pinned name, got %v", logGroups[0].Inputs["name"])
+	}
+
+	m = pulumitest.NewMocks()
+	err = pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logGroups := m.ByType("aws:cloudwatch/logGroup:LogGroup"); len(logGroups) != 0 {
+		t.Errorf("expected no control plane log group without clusterLogTypes, got %v", logGroups)
+	}
+	if clusters := m.ByType("aws:eks/cluster:Cluster"); clusters[0].Inputs.HasValue("name") {
+		t.Errorf("expected the cluster's name to stay generated without clusterLogTypes, got %v", clusters[0].Inputs["name"])
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterLogTypes": `["api", "kubelet"]`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "kubelet") {
+		t.Errorf("expected an unknown log type to be rejected, got %v", err)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"clusterNames": `{"test": "-test"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "clusterNames") {
+		t.Errorf("expected an invalid cluster name to be rejected, got %v", err)
+	}
+}
+
+func TestPrivateEndpoint(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"clusterEndpointPrivateAccess":     "true",
+		"clusterEndpointPublicAccessCidrs": `["203.0.113.0/24"]`,
+		"vpcEndpoints":                     "true",
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := provision(ctx, cfg, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := m.ByType("aws:eks/cluster:Cluster")
+	if len(clusters) != 1 || !strings.Contains(clusters[0].Inputs["vpcConfig"].String(), "203.0.113.0/24") ||
+		strings.Contains(clusters[0].Inputs["vpcConfig"].String(), "0.0.0.0/0") {
+		t.Errorf("expected the public endpoint to only take requests from 203.0.113.0/24, got %v", clusters)
+	}
+	if endpoints := m.ByType("aws:ec2/vpcEndpoint:VpcEndpoint"); len(endpoints) != 5 {
+		t.Errorf("expected the EC2, ECR API, ECR DKR, STS and S3 endpoints, got %v", endpoints)
+	}
+	templates := m.ByType("aws:ec2/launchTemplate:LaunchTemplate")
+	if len(templates) != 1 || templates[0].Inputs.HasValue("vpcSecurityGroupIds") {
+		t.Errorf("expected the nodes to keep the cluster security group without restrictNodeEgress, got %v", templates)
+	}
+
+	for _, values := range []map[string]string{
+		{"clusterEndpointPublicAccessCidrs": `["203.0.113.0/24"]`},
+		{"clusterEndpointPrivateAccess": "true", "clusterEndpointPublicAccessCidrs": `["2001:db8::/32"]`},
+		{"clusterEndpointPrivateAccess": "true", "clusterEndpointPublicAccess": "false", "clusterEndpointPublicAccessCidrs": `["203.0.113.0/24"]`},
+	} {
+		err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), "clusterEndpointPublicAccessCidrs") {
+			t.Errorf("expected %v to be rejected, got %v", values, err)
+		}
+	}
+}
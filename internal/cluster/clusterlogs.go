@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The control plane logs EKS can send to CloudWatch.
+var clusterLogTypeNames = []string{"api", "audit", "authenticator", "controllerManager", "scheduler"}
+
+// Read `clusterLogTypes`, the control plane logs EKS sends to CloudWatch. Empty
+// by default, which leaves control plane logging off.
+func clusterLogTypes(cfg *config.Config) ([]string, error) {
+	var types []string
+	if err := cfg.GetObject("clusterLogTypes", &types); err != nil {
+		return nil, fmt.Errorf("clusterLogTypes must be a list of log types: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, logType := range types {
+		if !stackconfig.ContainsString(clusterLogTypeNames, logType) {
+			return nil, fmt.Errorf("clusterLogTypes must only list %v, got %q", clusterLogTypeNames, logType)
+		}
+		if seen[logType] {
+			return nil, fmt.Errorf("clusterLogTypes lists %q twice", logType)
+		}
+		seen[logType] = true
+	}
+	return types, nil
+}
+
+// The names EKS accepts for a cluster.
+var eksClusterName = regexp.MustCompile(`^[0-9A-Za-z][A-Za-z0-9_-]{0,99}$`)
+
+// Read `clusterNames`, the names of the clusters with control plane logs by
+// environment.
+func clusterNamesConfig(cfg *config.Config) (map[string]string, error) {
+	var names map[string]string
+	if err := cfg.GetObject("clusterNames", &names); err != nil {
+		return nil, fmt.Errorf("clusterNames must map environment names to cluster names: %w", err)
+	}
+	for env, name := range names {
+		if !eksClusterName.MatchString(name) {
+			return nil, fmt.Errorf("clusterNames for %s must be an EKS cluster name of up to 100 letters, digits, - and _, got %q", env, name)
+		}
+	}
+	return names, nil
+}
+
+// The name of env's cluster when it sends control plane logs. Its log group is
+// named after it and has to exist first, so the name cannot be generated along
+// with the cluster: it is the one in `clusterNames`, or else
+// `<env>-aws-demo-<stack>`.
+func loggedClusterName(ctx *pulumi.Context, cfg *config.Config, env string) (string, error) {
+	names, err := clusterNamesConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	if name, ok := names[env]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("%s-aws-demo-%s", env, ctx.Stack()), nil
+}
+
+// Create the log group EKS sends the control plane logs of the cluster named
+// name to, keeping events for `logRetentionDays`. EKS creates one that never
+// expires when it is missing, so the cluster has to depend on it. The log
+// group is retained with `retainDataOnDelete`.
+func createClusterLogGroup(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster, name string, tags pulumi.StringMapInput) (*cloudwatch.LogGroup, error) {
+	retentionDays, err := stackconfig.LogRetentionDays(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	return cloudwatch.NewLogGroup(ctx, fmt.Sprintf("%s-cluster-logs", cluster.Env), &cloudwatch.LogGroupArgs{
+		Name:            pulumi.Sprintf("/aws/eks/%s/cluster", name),
+		RetentionInDays: pulumi.Int(retentionDays),
+		Tags:            tags,
+	}, cluster.ResourceOpts(stackconfig.DataResourceOpts(cfg)...)...)
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// AllowCrossClusterTraffic lets every cluster's nodes and pods reach every other
+// cluster's, by allowing all traffic into each cluster security group from the
+// others'. Security groups can only reference each other within a VPC, so the
+// clusters must share one.
+func AllowCrossClusterTraffic(ctx *pulumi.Context, clusters []*Cluster) error {
+	if len(clusters) < 2 {
+		_ = ctx.Log.Warn("allowCrossClusterTraffic is on but only one environment is deployed, so there is nothing to connect", nil)
+		return nil
+	}
+	for _, cluster := range clusters[1:] {
+		if cluster.Shared.Network != clusters[0].Shared.Network {
+			return fmt.Errorf("allowCrossClusterTraffic needs the clusters in one VPC, but %s and %s are in different ones",
+				clusters[0].Env, cluster.Env)
+		}
+	}
+
+	for _, to := range clusters {
+		for _, from := range clusters {
+			if to == from {
+				continue
+			}
+			_, err := ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-from-%s-cluster-traffic", to.Env, from.Env), &ec2.SecurityGroupRuleArgs{
+				Type:                  pulumi.String("ingress"),
+				Protocol:              pulumi.String("-1"),
+				FromPort:              pulumi.Int(0),
+				ToPort:                pulumi.Int(0),
+				SecurityGroupId:       to.SecurityGroupId,
+				SourceSecurityGroupId: from.SecurityGroupId,
+			}, to.ResourceOpts()...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Create the security group the environment's nodes get in place of the cluster
+// security group with `restrictNodeEgress`. It lets in what the cluster security
+// group would, from the control plane and other nodes, but only lets out traffic
+// to those, the VPC endpoints and the VPC's DNS resolver. The cluster security
+// group is opened to it in turn.
+func createNodeSecurityGroup(ctx *pulumi.Context, cluster *Cluster) (*ec2.SecurityGroup, error) {
+	env, shared := cluster.Env, cluster.Shared
+	endpoints := shared.vpcEndpoints
+	// All traffic, as EKS allows within the cluster security group
+	protocol, from, to := pulumi.String("-1"), pulumi.Int(0), pulumi.Int(0)
+	nodeSg, err := ec2.NewSecurityGroup(ctx, fmt.Sprintf("%s-node-sg", env), &ec2.SecurityGroupArgs{
+		VpcId: shared.Network.VpcId,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(fmt.Sprintf("%s-aws-demo-node-sg", env)),
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{Protocol: protocol, FromPort: from, ToPort: to, Self: pulumi.Bool(true)},
+			ec2.SecurityGroupIngressArgs{Protocol: protocol, FromPort: from, ToPort: to,
+				SecurityGroups: pulumi.StringArray{cluster.SecurityGroupId}},
+		},
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{Protocol: protocol, FromPort: from, ToPort: to, Self: pulumi.Bool(true)},
+			ec2.SecurityGroupEgressArgs{Protocol: protocol, FromPort: from, ToPort: to,
+				SecurityGroups: pulumi.StringArray{cluster.SecurityGroupId}},
+			ec2.SecurityGroupEgressArgs{Protocol: pulumi.String("tcp"), FromPort: pulumi.Int(443), ToPort: pulumi.Int(443),
+				SecurityGroups: pulumi.StringArray{endpoints.EndpointSecurityGroup.ID()}},
+			ec2.SecurityGroupEgressArgs{Protocol: pulumi.String("tcp"), FromPort: pulumi.Int(443), ToPort: pulumi.Int(443),
+				PrefixListIds: pulumi.StringArray{endpoints.S3PrefixListId}},
+			ec2.SecurityGroupEgressArgs{Protocol: pulumi.String("udp"), FromPort: pulumi.Int(53), ToPort: pulumi.Int(53),
+				CidrBlocks: stackconfig.ToPulumiStringArray(shared.Network.VpcCidrs)},
+			ec2.SecurityGroupEgressArgs{Protocol: pulumi.String("tcp"), FromPort: pulumi.Int(53), ToPort: pulumi.Int(53),
+				CidrBlocks: stackconfig.ToPulumiStringArray(shared.Network.VpcCidrs)},
+		},
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ec2.NewSecurityGroupRule(ctx, fmt.Sprintf("%s-cluster-sg-from-nodes", env), &ec2.SecurityGroupRuleArgs{
+		Type:                  pulumi.String("ingress"),
+		Protocol:              protocol,
+		FromPort:              from,
+		ToPort:                to,
+		SecurityGroupId:       cluster.SecurityGroupId,
+		SourceSecurityGroupId: nodeSg.ID(),
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	return nodeSg, nil
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Read which compute the clusters get: the managed node group (`enableNodeGroup`,
+// on by default) and/or a Fargate profile (`enableFargate`). At least one is needed
+// for anything to be scheduled.
+func computeOptions(cfg *config.Config) (nodeGroup bool, fargate bool, err error) {
+	nodeGroup = stackconfig.GetBoolDefault(cfg, "enableNodeGroup", true)
+	fargate = cfg.GetBool("enableFargate")
+	if !nodeGroup && !fargate {
+		return false, false, fmt.Errorf("at least one of enableNodeGroup and enableFargate must be true")
+	}
+	return nodeGroup, fargate, nil
+}
+
+// EKS limits on a Fargate profile's selectors.
+const (
+	maxFargateSelectors      = 5
+	maxFargateSelectorLabels = 5
+)
+
+// A Fargate profile selector: the pods of Namespace that carry all of Labels.
+type fargateSelector struct {
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// Read env's `fargateSelectors`, the pods its Fargate profile runs. Defaults to
+// every pod of the namespaces this program installs into.
+func fargateSelectorsConfig(cfg *config.Config, env string) ([]fargateSelector, error) {
+	var byEnv map[string][]fargateSelector
+	if err := cfg.GetObject("fargateSelectors", &byEnv); err != nil {
+		return nil, fmt.Errorf("fargateSelectors must map environment names to lists of {namespace, labels} objects: %w", err)
+	}
+	selectors, ok := byEnv[env]
+	if !ok {
+		namespaces := []string{"kube-system", "default", "argocd", fmt.Sprintf("%s-app", env)}
+		// The namespace InstallSecretsController runs the External Secrets Operator in
+		if cfg.Get("secretsController") == "external-secrets" {
+			namespaces = append(namespaces, "external-secrets")
+		}
+		for _, ns := range namespaces {
+			selectors = append(selectors, fargateSelector{Namespace: ns})
+		}
+		return selectors, nil
+	}
+	if len(selectors) == 0 || len(selectors) > maxFargateSelectors {
+		return nil, fmt.Errorf("fargateSelectors for %s must list 1 to %d selectors, got %d", env, maxFargateSelectors, len(selectors))
+	}
+	for _, selector := range selectors {
+		if len(selector.Namespace) > 63 || !stackconfig.DnsLabel.MatchString(selector.Namespace) {
+			return nil, fmt.Errorf("fargateSelectors namespace %q for %s is not a valid DNS label", selector.Namespace, env)
+		}
+		if len(selector.Labels) > maxFargateSelectorLabels {
+			return nil, fmt.Errorf("fargateSelectors for %s can match at most %d labels in %s, got %d",
+				env, maxFargateSelectorLabels, selector.Namespace, len(selector.Labels))
+		}
+	}
+	return selectors, nil
+}
+
+// Create a Fargate profile for the pods `fargateSelectors` picks, by default
+// those of the namespaces this program installs into, so the cluster's system
+// pods and add-ons can run without any nodes.
+func createFargateProfile(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (*eks.FargateProfile, error) {
+	env, shared := cluster.Env, cluster.Shared
+	configured, err := fargateSelectorsConfig(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	var selectors eks.FargateProfileSelectorArray
+	for _, selector := range configured {
+		args := eks.FargateProfileSelectorArgs{Namespace: pulumi.String(selector.Namespace)}
+		if len(selector.Labels) > 0 {
+			args.Labels = pulumi.ToStringMap(selector.Labels)
+		}
+		selectors = append(selectors, args)
+	}
+	return eks.NewFargateProfile(ctx, fmt.Sprintf("%s-fargate-profile", env), &eks.FargateProfileArgs{
+		ClusterName:         cluster.Cluster.Name,
+		FargateProfileName:  pulumi.String(fmt.Sprintf("%s-fargate-profile", env)),
+		PodExecutionRoleArn: shared.FargateRoleArn,
+		SubnetIds:           shared.FargateSubnetIds,
+		Selectors:           selectors,
+	}, cluster.ResourceOpts()...)
+}
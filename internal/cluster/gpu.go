@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	defaultGpuInstanceType = "g4dn.xlarge"
+	defaultGpuNodeCount    = 1
+	// The EKS optimized AMI with the NVIDIA driver and container runtime.
+	gpuAmiType = "AL2_x86_64_GPU"
+)
+
+// The taint and label of the GPU nodes, named after the resource the device
+// plugin advertises. The device plugin chart tolerates it by default.
+var gpuNodeTaint = NodeTaint{Key: "nvidia.com/gpu", Value: "true"}
+
+// The `gpuNodes` settings for an environment.
+type gpuNodeConfig struct {
+	InstanceType string
+	Count        int
+}
+
+// Read the GPU node group settings when `gpuNodes` is set for env: a fixed
+// `gpuNodeCount` nodes of `gpuNodeInstanceType`. Returns nil otherwise.
+func gpuNodes(cfg *config.Config, env string) (*gpuNodeConfig, error) {
+	enabled, err := stackconfig.GetEnvBool(cfg, "gpuNodes", env, false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	gpu := &gpuNodeConfig{InstanceType: cfg.Get("gpuNodeInstanceType")}
+	if gpu.InstanceType == "" {
+		gpu.InstanceType = defaultGpuInstanceType
+	}
+	count, set, err := stackconfig.OptionalInt(cfg, "gpuNodeCount")
+	if err != nil {
+		return nil, err
+	}
+	gpu.Count = defaultGpuNodeCount
+	if set {
+		gpu.Count = count
+	}
+	if gpu.Count < 1 {
+		return nil, fmt.Errorf("gpuNodeCount must be at least 1, got %d", gpu.Count)
+	}
+	return gpu, nil
+}
+
+// Check the GPU instance type has NVIDIA GPUs and runs the x86_64 GPU AMI, and
+// log the capacity the node group adds.
+func checkGpuInstanceType(ctx *pulumi.Context, env string, gpu *gpuNodeConfig, opts ...pulumi.InvokeOption) error {
+	info, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{InstanceType: gpu.InstanceType}, opts...)
+	if err != nil {
+		return err
+	}
+	gpus, model := 0, ""
+	for _, g := range info.Gpuses {
+		if g.Manufacturer == "NVIDIA" {
+			gpus += g.Count
+			model = g.Name
+		}
+	}
+	if gpus == 0 {
+		return fmt.Errorf("gpuNodeInstanceType %s has no NVIDIA GPUs", gpu.InstanceType)
+	}
+	if !stackconfig.ContainsString(info.SupportedArchitectures, archX86_64) {
+		return fmt.Errorf("gpuNodeInstanceType %s is not x86_64, which the EKS GPU AMI needs", gpu.InstanceType)
+	}
+	_ = ctx.Log.Info(fmt.Sprintf("%s GPU capacity: %d %s node(s) with %d NVIDIA %s GPU(s) each, %d in total",
+		env, gpu.Count, gpu.InstanceType, gpus, model, gpu.Count*gpus), nil)
+	return nil
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Check that the node instance type is offered in every availability zone of the
+// cluster subnets, subnetAzs, so nodes do not fail to launch in some of them.
+func checkInstanceTypeOffered(ctx *pulumi.Context, instanceType string, subnetAzs []string, opts ...pulumi.InvokeOption) error {
+	azs := map[string]bool{}
+	for _, az := range subnetAzs {
+		azs[az] = true
+	}
+	locationType := "availability-zone"
+	offerings, err := ec2.GetInstanceTypeOfferings(ctx, &ec2.GetInstanceTypeOfferingsArgs{
+		LocationType: &locationType,
+		Filters: []ec2.GetInstanceTypeOfferingsFilter{
+			{Name: "instance-type", Values: []string{instanceType}},
+		},
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	for _, az := range offerings.Locations {
+		delete(azs, az)
+	}
+	if len(azs) > 0 {
+		var missing []string
+		for az := range azs {
+			missing = append(missing, az)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("node instance type %s is not offered in %s; pick another nodeInstanceType or limit the subnets with maxSubnets",
+			instanceType, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Prefix delegation assigns /28 prefixes to ENIs, which only Nitro instances support.
+func checkPrefixDelegationSupported(ctx *pulumi.Context, instanceType string, opts ...pulumi.InvokeOption) error {
+	info, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{InstanceType: instanceType}, opts...)
+	if err != nil {
+		return err
+	}
+	if info.Hypervisor != "nitro" {
+		return fmt.Errorf("vpcCniPrefixDelegation needs a Nitro instance type, but %s runs on %q", instanceType, info.Hypervisor)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// LogInventory logs a one-line summary of what the config asks for in env: its
+// compute, API endpoint access and charts. It is only logged during previews, as
+// a quick read for reviewers next to Pulumi's resource diff.
+func LogInventory(ctx *pulumi.Context, cfg *config.Config, env string) error {
+	if !ctx.DryRun() {
+		return nil
+	}
+	summary, err := inventory(cfg, env)
+	if err != nil {
+		return err
+	}
+	return ctx.Log.Info(fmt.Sprintf("%s: %s", env, summary), nil)
+}
+
+func inventory(cfg *config.Config, env string) (string, error) {
+	nodeGroup, fargate, err := computeOptions(cfg)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	if nodeGroup {
+		scaling, err := NodeScalingConfig(cfg, env)
+		if err != nil {
+			return "", err
+		}
+		_, _, instanceTypes, err := nodeImage(cfg, env)
+		if err != nil {
+			return "", err
+		}
+		mixed, err := nodeMixedInstances(cfg)
+		if err != nil {
+			return "", err
+		}
+		scaleToZero, err := nodeScaleToZero(cfg, env)
+		if err != nil {
+			return "", err
+		}
+		if scaleToZero {
+			scaling.Min = 0
+		}
+		spot, err := spotNodes(cfg, env)
+		if err != nil {
+			return "", err
+		}
+		var spotScaling nodeScaling
+		if spot != nil {
+			if scaling, spotScaling, err = spot.split(env, scaling); err != nil {
+				return "", err
+			}
+		}
+		capacity := ""
+		if mixed != nil && mixed.spot {
+			capacity = " spot"
+		}
+		nodes := fmt.Sprintf("%d %s%s nodes (min %d, max %d)",
+			scaling.Desired, strings.Join(instanceTypes, "/"), capacity, scaling.Min, scaling.Max)
+		reservation, err := stackconfig.GetEnvString(cfg, "nodeCapacityReservation", env)
+		if err != nil {
+			return "", err
+		}
+		if reservation != "" {
+			nodes += ", capacity reservation " + reservation
+		}
+		if cfg.GetBool("nodeGroupPerAz") {
+			nodes += ", one node group per availability zone"
+		}
+		parts = append(parts, nodes)
+		if spot != nil {
+			parts = append(parts, fmt.Sprintf("%d %s spot nodes (min %d, max %d)",
+				spotScaling.Desired, strings.Join(spot.InstanceTypes, "/"), spotScaling.Min, spotScaling.Max))
+		}
+
+		argoTaint, err := argoNodes(cfg, env)
+		if err != nil {
+			return "", err
+		}
+		if argoTaint != nil {
+			count, err := ArgoNodeCount(cfg)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%d %s nodes dedicated to Argo (taint %s=%s)",
+				count, strings.Join(instanceTypes, "/"), argoTaint.Key, argoTaint.Value))
+		}
+	}
+	if fargate {
+		parts = append(parts, "Fargate profile")
+	}
+	privateAccess, publicAccess, err := endpointAccess(cfg)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case privateAccess && publicAccess:
+		parts = append(parts, "public and private API endpoint")
+	case privateAccess:
+		parts = append(parts, "private API endpoint")
+	default:
+		parts = append(parts, "public API endpoint")
+	}
+	if cfg.GetBool("enableBastion") {
+		parts = append(parts, "bastion")
+	}
+
+	charts := []string{"argo-cd", "argo-rollouts"}
+	if cfg.GetBool("enableContainerInsights") {
+		if stackconfig.GetBoolDefault(cfg, "containerInsightsMetrics", true) {
+			charts = append(charts, "aws-cloudwatch-metrics")
+		}
+		if stackconfig.GetBoolDefault(cfg, "containerInsightsLogs", true) {
+			charts = append(charts, "aws-for-fluent-bit")
+		}
+	}
+	parts = append(parts, "charts "+strings.Join(charts, ", "))
+	if cfg.GetBool("enableStandaloneAlb") {
+		parts = append(parts, "standalone ALB")
+	}
+	return strings.Join(parts, "; "), nil
+}
@@ -0,0 +1,25 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Read `karpenter` for env, which replaces the cluster autoscaler there.
+func karpenterEnabled(cfg *config.Config, env string) (bool, error) {
+	enabled, err := stackconfig.GetEnvBool(cfg, "karpenter", env, false)
+	if err != nil || !enabled {
+		return false, err
+	}
+	autoscaler, err := stackconfig.GetEnvBool(cfg, "clusterAutoscaler", env, false)
+	if err != nil {
+		return false, err
+	}
+	if autoscaler {
+		return false, fmt.Errorf("karpenter and clusterAutoscaler are both set for %s, which would fight over the nodes", env)
+	}
+	return true, nil
+}
@@ -0,0 +1,193 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/kms"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	kmsKeysAwsManaged = "aws-managed"
+	kmsKeysShared     = "shared"
+	kmsKeysPerService = "per-service"
+)
+
+// The customer managed keys of an environment with `kmsKeys`. Eks and Ebs are
+// the same key when shared, and Ebs is nil without a node group.
+type clusterKeys struct {
+	Eks    *kms.Key
+	Ebs    *kms.Key
+	shared bool
+}
+
+// Read `kmsKeys`: aws-managed (the default) leaves encryption to the AWS managed
+// keys, shared creates one customer managed key per environment for every
+// service, and per-service one per service.
+func kmsKeysMode(cfg *config.Config) (string, error) {
+	mode := cfg.Get("kmsKeys")
+	switch mode {
+	case "":
+		return kmsKeysAwsManaged, nil
+	case kmsKeysAwsManaged:
+		return mode, nil
+	case kmsKeysShared, kmsKeysPerService:
+		if cfg.Get("nodeVolumeKmsKeyArn") != "" {
+			return "", fmt.Errorf("nodeVolumeKmsKeyArn cannot be set with kmsKeys %s, which creates the node volume key", mode)
+		}
+		if !stackconfig.GetBoolDefault(cfg, "nodeVolumeEncryption", true) {
+			return "", fmt.Errorf("kmsKeys %s encrypts the node volumes, but nodeVolumeEncryption is false", mode)
+		}
+		return mode, nil
+	}
+	return "", fmt.Errorf("kmsKeys must be %s, %s or %s, got %q", kmsKeysAwsManaged, kmsKeysShared, kmsKeysPerService, mode)
+}
+
+// Key policy statements letting a service use the key: EKS through the cluster
+// role, to envelope encrypt Kubernetes secrets, and EBS through the EC2 Auto
+// Scaling service linked role, which launches the nodes with encrypted volumes.
+const (
+	eksKeyStatement = `{
+		        "Sid": "EksSecrets",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "AWS": "%[1]s"
+		        },
+		        "Action": ["kms:Encrypt", "kms:Decrypt", "kms:DescribeKey", "kms:CreateGrant"],
+		        "Resource": "*"
+		    }`
+	ebsKeyStatement = `{
+		        "Sid": "EbsNodeVolumes",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "AWS": "arn:aws:iam::%[2]s:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+		        },
+		        "Action": ["kms:Encrypt", "kms:Decrypt", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:DescribeKey"],
+		        "Resource": "*"
+		    }, {
+		        "Sid": "EbsNodeVolumeGrants",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "AWS": "arn:aws:iam::%[2]s:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+		        },
+		        "Action": "kms:CreateGrant",
+		        "Resource": "*",
+		        "Condition": {
+		            "Bool": {
+		                "kms:GrantIsForAWSResource": "true"
+		            }
+		        }
+		    }`
+)
+
+// Create the key of service, or the shared key when it is "", with a policy made
+// of the account's own access, which lets IAM policies grant the key as usual,
+// and the given service statements.
+func createKmsKey(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster, service string, description string,
+	accountId string, statements []string) (*kms.Key, error) {
+	env := cluster.Env
+	name, alias := "kms-key", fmt.Sprintf("alias/%s-aws-demo", env)
+	if service != "" {
+		name += "-" + service
+		alias += "-" + service
+	}
+	policy := cluster.Shared.ClusterRoleArn.ToStringOutput().ApplyT(func(clusterRoleArn string) string {
+		return fmt.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Sid": "AccountAccess",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "AWS": "arn:aws:iam::%[2]s:root"
+		        },
+		        "Action": "kms:*",
+		        "Resource": "*"
+		    }, `+strings.Join(statements, ", ")+`]
+		}`, clusterRoleArn, accountId)
+	}).(pulumi.StringOutput)
+	key, err := kms.NewKey(ctx, fmt.Sprintf("%s-%s", env, name), &kms.KeyArgs{
+		Description:          pulumi.String(description),
+		EnableKeyRotation:    pulumi.Bool(true),
+		DeletionWindowInDays: pulumi.Int(7),
+		Policy:               policy,
+	}, stackconfig.DataResourceOpts(cfg, cluster.ResourceOpts()...)...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = kms.NewAlias(ctx, fmt.Sprintf("%s-%s-alias", env, name), &kms.AliasArgs{
+		Name:        pulumi.String(alias),
+		TargetKeyId: key.KeyId,
+	}, cluster.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Create the customer managed keys of the cluster's environment for `kmsKeys`,
+// before the EKS cluster that uses them. Returns nil with the AWS managed keys.
+func createClusterKeys(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (*clusterKeys, error) {
+	env, shared := cluster.Env, cluster.Shared
+	mode, err := kmsKeysMode(cfg)
+	if err != nil || mode == kmsKeysAwsManaged {
+		return nil, err
+	}
+	identity, err := aws.GetCallerIdentity(ctx, shared.Network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	ebs := shared.EnableNodeGroup
+
+	keys := &clusterKeys{shared: mode == kmsKeysShared}
+	if keys.shared {
+		statements := []string{eksKeyStatement}
+		if ebs {
+			statements = append(statements, ebsKeyStatement)
+		}
+		key, err := createKmsKey(ctx, cfg, cluster, "", fmt.Sprintf("%s EKS secrets and node volumes", env),
+			identity.AccountId, statements)
+		if err != nil {
+			return nil, err
+		}
+		keys.Eks = key
+		if ebs {
+			keys.Ebs = key
+		}
+		return keys, nil
+	}
+
+	if keys.Eks, err = createKmsKey(ctx, cfg, cluster, "eks", fmt.Sprintf("%s EKS secrets", env),
+		identity.AccountId, []string{eksKeyStatement}); err != nil {
+		return nil, err
+	}
+	if ebs {
+		if keys.Ebs, err = createKmsKey(ctx, cfg, cluster, "ebs", fmt.Sprintf("%s node volumes", env),
+			identity.AccountId, []string{ebsKeyStatement}); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// KmsKeyArns returns the ARNs of the cluster's customer managed keys to export,
+// keyed by service (Eks, Ebs), or by "" for a shared key. Empty with the AWS
+// managed keys.
+func KmsKeyArns(cluster *Cluster) map[string]pulumi.StringOutput {
+	keys := cluster.kmsKeys
+	if keys == nil {
+		return nil
+	}
+	if keys.shared {
+		return map[string]pulumi.StringOutput{"": keys.Eks.Arn}
+	}
+	arns := map[string]pulumi.StringOutput{"Eks": keys.Eks.Arn}
+	if keys.Ebs != nil {
+		arns["Ebs"] = keys.Ebs.Arn
+	}
+	return arns
+}
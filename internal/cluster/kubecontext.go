@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// A cluster to add to the script, with the kubectl context name to give it.
+type kubeContext struct {
+	alias   string
+	region  string
+	cluster *Cluster
+}
+
+// WriteKubectlContextScript writes a shell script to the `kubectlContextScript`
+// path that adds a kubectl context for every cluster, named after its
+// environment (`<env>-replica` for replicas), with `aws eks update-kubeconfig`.
+// The script is only written on update, and never when the CI environment
+// variable is set, as a build agent has no use for it. Returns the path written,
+// or "" when there is nothing to write.
+func WriteKubectlContextScript(ctx *pulumi.Context, cfg *config.Config, clusters []*Cluster, replicas []*Cluster) (pulumi.StringOutput, error) {
+	path := cfg.Get("kubectlContextScript")
+	if path == "" || ctx.DryRun() {
+		return pulumi.String("").ToStringOutput(), nil
+	}
+	if os.Getenv("CI") != "" {
+		_ = ctx.Log.Info("Not writing kubectlContextScript in CI", nil)
+		return pulumi.String("").ToStringOutput(), nil
+	}
+
+	var contexts []kubeContext
+	add := func(cluster *Cluster, alias string) error {
+		region, err := aws.GetRegion(ctx, nil, cluster.Shared.Network.InvokeOpts()...)
+		if err != nil {
+			return err
+		}
+		contexts = append(contexts, kubeContext{alias: alias, region: region.Name, cluster: cluster})
+		return nil
+	}
+	for _, cluster := range clusters {
+		if err := add(cluster, cluster.Env); err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+	for _, cluster := range replicas {
+		if err := add(cluster, fmt.Sprintf("%s-replica", cluster.Env)); err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+
+	var names []interface{}
+	for _, c := range contexts {
+		names = append(names, c.cluster.Cluster.Name)
+	}
+	return pulumi.All(names...).ApplyT(func(resolved []interface{}) (string, error) {
+		script := "#!/bin/sh\n# Adds a kubectl context for each of the stack's EKS clusters.\nset -e\n"
+		var aliases []string
+		for i, c := range contexts {
+			script += fmt.Sprintf("aws eks update-kubeconfig --region %s --name %s --alias %s\n",
+				c.region, resolved[i].(string), c.alias)
+			aliases = append(aliases, c.alias)
+		}
+		script += fmt.Sprintf("echo 'Added contexts: %s'\n", strings.Join(aliases, " "))
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			return "", fmt.Errorf("writing kubectlContextScript: %w", err)
+		}
+		return path, nil
+	}).(pulumi.StringOutput), nil
+}
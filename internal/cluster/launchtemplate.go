@@ -0,0 +1,217 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// EC2 rejects user data larger than 16 KB before base64 encoding.
+const maxUserDataBytes = 16 * 1024
+
+const userDataBoundary = "==EKSDEMOBOUNDARY=="
+
+var capacityReservationId = regexp.MustCompile(`^cr-([0-9a-f]{8}|[0-9a-f]{17})$`)
+
+var kmsKeyArn = regexp.MustCompile(`^arn:aws[a-z-]*:kms:[a-z0-9-]+:[0-9]{12}:(key|alias)/.+$`)
+
+// The EKS optimized Amazon Linux AMIs boot from this device.
+const nodeRootDevice = "/dev/xvda"
+
+// Size of the managed node group's default root volume, kept for the one the
+// launch template replaces it with.
+const defaultNodeVolumeSize = 20
+
+// Create a launch template for an environment's node group when any launch template
+// setting is configured or securityGroupIds replaces the cluster security group.
+// Returns nil otherwise, so the node group keeps the EKS managed default. The
+// root volume is encrypted with volumeKeyArn when it is set.
+func createNodeLaunchTemplate(ctx *pulumi.Context, cfg *config.Config, env string,
+	securityGroupIds pulumi.StringArrayInput, volumeKeyArn pulumi.StringInput, opts ...pulumi.ResourceOption) (eks.NodeGroupLaunchTemplatePtrInput, error) {
+	userData, err := nodeUserData(cfg)
+	if err != nil {
+		return nil, err
+	}
+	capacityReservation, err := nodeCapacityReservation(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+	metadataOptions, err := nodeMetadataOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	blockDevices, err := nodeBlockDeviceMappings(cfg, volumeKeyArn)
+	if err != nil {
+		return nil, err
+	}
+	if userData == "" && capacityReservation == nil && metadataOptions == nil && blockDevices == nil && securityGroupIds == nil {
+		return nil, nil
+	}
+
+	args := &ec2.LaunchTemplateArgs{
+		BlockDeviceMappings:              blockDevices,
+		CapacityReservationSpecification: capacityReservation,
+		MetadataOptions:                  metadataOptions,
+		VpcSecurityGroupIds:              securityGroupIds,
+	}
+	if userData != "" {
+		args.UserData = pulumi.String(userData)
+	}
+	launchTemplate, err := ec2.NewLaunchTemplate(ctx, fmt.Sprintf("%s-node-launch-template", env), args, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &eks.NodeGroupLaunchTemplateArgs{
+		Id:      launchTemplate.ID(),
+		Version: launchTemplate.LatestVersion.ApplyT(func(v int) string { return fmt.Sprint(v) }).(pulumi.StringOutput),
+	}, nil
+}
+
+// Require IMDSv2 session tokens on the nodes unless `nodeRequireImdsv2` is false.
+// The hop limit (`nodeMetadataHopLimit`) defaults to 1 so that pods, which are one
+// network hop further away than the node, cannot fetch the node role's credentials.
+// The VPC CNI and other host network pods still reach the metadata service; pods
+// that need it without host networking (rather than using IRSA) need a limit of 2.
+func nodeMetadataOptions(cfg *config.Config) (ec2.LaunchTemplateMetadataOptionsPtrInput, error) {
+	if !stackconfig.GetBoolDefault(cfg, "nodeRequireImdsv2", true) {
+		return nil, nil
+	}
+	hopLimit := cfg.GetInt("nodeMetadataHopLimit")
+	if hopLimit == 0 {
+		hopLimit = 1
+	}
+	if hopLimit < 1 || hopLimit > 64 {
+		return nil, fmt.Errorf("nodeMetadataHopLimit must be between 1 and 64, got %d", hopLimit)
+	}
+	return &ec2.LaunchTemplateMetadataOptionsArgs{
+		HttpEndpoint:            pulumi.String("enabled"),
+		HttpTokens:              pulumi.String("required"),
+		HttpPutResponseHopLimit: pulumi.Int(hopLimit),
+	}, nil
+}
+
+// Encrypt the nodes' root volume unless `nodeVolumeEncryption` is false, with
+// keyArn, the KMS key `nodeVolumeKmsKeyArn` names or else the account's AWS
+// managed EBS key.
+// The volume is `nodeVolumeSize` GiB of gp3, 20 unless set. A key other than the
+// AWS managed one must let the EC2 Auto Scaling service linked role use it, or
+// the nodes fail to launch.
+func nodeBlockDeviceMappings(cfg *config.Config, keyArn pulumi.StringInput) (ec2.LaunchTemplateBlockDeviceMappingArrayInput, error) {
+	configuredKeyArn := cfg.Get("nodeVolumeKmsKeyArn")
+	if !stackconfig.GetBoolDefault(cfg, "nodeVolumeEncryption", true) {
+		if configuredKeyArn != "" {
+			return nil, fmt.Errorf("nodeVolumeKmsKeyArn is set but nodeVolumeEncryption is false")
+		}
+		return nil, nil
+	}
+	size, err := nodeVolumeSize(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ebs := &ec2.LaunchTemplateBlockDeviceMappingEbsArgs{
+		Encrypted:           pulumi.String("true"),
+		VolumeSize:          pulumi.Int(size),
+		VolumeType:          pulumi.String("gp3"),
+		DeleteOnTermination: pulumi.String("true"),
+	}
+	if configuredKeyArn != "" {
+		if !kmsKeyArn.MatchString(configuredKeyArn) {
+			return nil, fmt.Errorf("nodeVolumeKmsKeyArn must be a KMS key or alias ARN, got %q", configuredKeyArn)
+		}
+		ebs.KmsKeyId = pulumi.String(configuredKeyArn)
+	}
+	if keyArn != nil {
+		ebs.KmsKeyId = keyArn
+	}
+	return ec2.LaunchTemplateBlockDeviceMappingArray{
+		ec2.LaunchTemplateBlockDeviceMappingArgs{
+			DeviceName: pulumi.String(nodeRootDevice),
+			Ebs:        ebs,
+		},
+	}, nil
+}
+
+// Read the size in GiB of the nodes' root volume from `nodeVolumeSize`.
+func nodeVolumeSize(cfg *config.Config) (int, error) {
+	size := cfg.GetInt("nodeVolumeSize")
+	if size == 0 {
+		size = defaultNodeVolumeSize
+	}
+	if size < 1 || size > 16384 {
+		return 0, fmt.Errorf("nodeVolumeSize must be between 1 and 16384 GiB, got %d", size)
+	}
+	return size, nil
+}
+
+// Read env's entry of `nodeCapacityReservation`: `open` to use any matching open
+// capacity reservation, `none` to avoid them, or a reservation ID to target. Returns
+// nil when the environment has no entry.
+func nodeCapacityReservation(cfg *config.Config, env string) (ec2.LaunchTemplateCapacityReservationSpecificationPtrInput, error) {
+	reservation, err := stackconfig.GetEnvString(cfg, "nodeCapacityReservation", env)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case reservation == "":
+		return nil, nil
+	case reservation == "open" || reservation == "none":
+		return &ec2.LaunchTemplateCapacityReservationSpecificationArgs{
+			CapacityReservationPreference: pulumi.String(reservation),
+		}, nil
+	case capacityReservationId.MatchString(reservation):
+		return &ec2.LaunchTemplateCapacityReservationSpecificationArgs{
+			CapacityReservationTarget: &ec2.LaunchTemplateCapacityReservationSpecificationCapacityReservationTargetArgs{
+				CapacityReservationId: pulumi.String(reservation),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("nodeCapacityReservation for %s must be open, none or a cr-xxxxxxxxxxxxxxxxx reservation ID, got %q", env, reservation)
+}
+
+// Read the extra node bootstrap script from `nodeUserData` (plain) or
+// `nodeUserDataBase64`, and wrap it as a MIME multi-part document. EKS merges
+// that with its own part which runs the bootstrap script, so nodes still join
+// the cluster. Returns the base64 encoded user data, or "" when none is set.
+func nodeUserData(cfg *config.Config) (string, error) {
+	script := cfg.Get("nodeUserData")
+	if encoded := cfg.Get("nodeUserDataBase64"); encoded != "" {
+		if script != "" {
+			return "", fmt.Errorf("only one of nodeUserData and nodeUserDataBase64 can be set")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", fmt.Errorf("nodeUserDataBase64 is not valid base64: %w", err)
+		}
+		script = string(decoded)
+	}
+	if script == "" {
+		return "", nil
+	}
+
+	contentType := "text/x-shellscript"
+	if strings.HasPrefix(script, "#cloud-config") {
+		contentType = "text/cloud-config"
+	}
+	userData := fmt.Sprintf(`MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="%s"
+
+--%s
+Content-Type: %s; charset="us-ascii"
+
+%s
+
+--%s--
+`, userDataBoundary, userDataBoundary, contentType, script, userDataBoundary)
+	if len(userData) > maxUserDataBytes {
+		return "", fmt.Errorf("node user data is %d bytes, EC2 allows at most %d", len(userData), maxUserDataBytes)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(userData)), nil
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// The largest weight an autoscaling group accepts for an instance type.
+const maxInstanceWeight = 999
+
+// Spot allocation strategies, of which managed node groups run capacity-optimized
+// (EKS picks the Spot pools with the most spare capacity) and cannot be switched
+// to lowest-price.
+const (
+	spotCapacityOptimized = "capacity-optimized"
+	spotLowestPrice       = "lowest-price"
+)
+
+// An entry of the `nodeMixedInstances` instance types.
+type weightedInstanceType struct {
+	Type   string `json:"type"`
+	Weight int    `json:"weight"`
+}
+
+// The `nodeMixedInstances` object.
+type mixedInstancesConfig struct {
+	InstanceTypes          []weightedInstanceType `json:"instanceTypes"`
+	SpotAllocationStrategy string                 `json:"spotAllocationStrategy"`
+}
+
+// The node group's instance types, highest weight first, and whether it runs on Spot.
+type mixedInstances struct {
+	instanceTypes []string
+	spot          bool
+}
+
+// Read `nodeMixedInstances`, the instance types the node group may launch with
+// their weights and, for Spot, the allocation strategy. Returns nil when unset,
+// leaving a single on-demand `nodeInstanceType`. Managed node groups take an
+// ordered list of instance types rather than weights, so the weights rank the
+// types: on-demand capacity is launched from the first type that has it, and
+// Spot from whichever pools the strategy picks among them.
+func nodeMixedInstances(cfg *config.Config) (*mixedInstances, error) {
+	var mixed mixedInstancesConfig
+	if err := cfg.GetObject("nodeMixedInstances", &mixed); err != nil {
+		return nil, fmt.Errorf("nodeMixedInstances must be an object of instanceTypes and spotAllocationStrategy: %w", err)
+	}
+	if len(mixed.InstanceTypes) == 0 {
+		if mixed.SpotAllocationStrategy != "" {
+			return nil, fmt.Errorf("nodeMixedInstances needs instanceTypes")
+		}
+		return nil, nil
+	}
+	if cfg.Get("nodeInstanceType") != "" {
+		return nil, fmt.Errorf("nodeInstanceType cannot be set with nodeMixedInstances, which lists the instance types")
+	}
+	seen := map[string]bool{}
+	for _, t := range mixed.InstanceTypes {
+		if t.Type == "" {
+			return nil, fmt.Errorf("nodeMixedInstances has an instance type with no type")
+		}
+		if seen[t.Type] {
+			return nil, fmt.Errorf("nodeMixedInstances lists %s more than once", t.Type)
+		}
+		seen[t.Type] = true
+		if t.Weight < 1 || t.Weight > maxInstanceWeight {
+			return nil, fmt.Errorf("nodeMixedInstances weight of %s must be an integer from 1 to %d, got %d", t.Type, maxInstanceWeight, t.Weight)
+		}
+	}
+	result := &mixedInstances{}
+	switch mixed.SpotAllocationStrategy {
+	case "":
+	case spotCapacityOptimized:
+		result.spot = true
+	case spotLowestPrice:
+		return nil, fmt.Errorf("nodeMixedInstances spotAllocationStrategy %s is not available on managed node groups, which always use %s",
+			spotLowestPrice, spotCapacityOptimized)
+	default:
+		return nil, fmt.Errorf("nodeMixedInstances spotAllocationStrategy must be %s or %s, got %q",
+			spotCapacityOptimized, spotLowestPrice, mixed.SpotAllocationStrategy)
+	}
+	types := append([]weightedInstanceType(nil), mixed.InstanceTypes...)
+	sort.SliceStable(types, func(i, j int) bool { return types[i].Weight > types[j].Weight })
+	for _, t := range types {
+		result.instanceTypes = append(result.instanceTypes, t.Type)
+	}
+	return result, nil
+}
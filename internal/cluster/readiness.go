@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pulumi/pulumi-command/sdk/go/command/local"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const defaultClusterReadyTimeout = 300
+
+// Polls the API server's unauthenticated readiness endpoint until it answers "ok".
+const clusterReadyScript = `if [ "$CLUSTER_STATUS" != "ACTIVE" ]; then
+	echo "cluster is $CLUSTER_STATUS, expected ACTIVE" >&2
+	exit 1
+fi
+waited=0
+until [ "$(curl -sk --max-time 5 "$CLUSTER_ENDPOINT/readyz")" = "ok" ]; do
+	if [ "$waited" -ge "$TIMEOUT_SECONDS" ]; then
+		echo "cluster API at $CLUSTER_ENDPOINT was not ready after ${TIMEOUT_SECONDS}s" >&2
+		exit 1
+	fi
+	sleep 5
+	waited=$((waited + 5))
+done
+`
+
+// Create a check that the cluster is ACTIVE and its API server is answering, when
+// `waitForClusterReady` is set. The Kubernetes provider depends on it so no chart
+// is applied before the endpoint serves. Returns nil when the check is disabled.
+func createClusterReadyCheck(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster) (pulumi.Resource, error) {
+	if !cfg.GetBool("waitForClusterReady") {
+		return nil, nil
+	}
+	timeout := cfg.GetInt("clusterReadyTimeoutSeconds")
+	if timeout == 0 {
+		timeout = defaultClusterReadyTimeout
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("clusterReadyTimeoutSeconds must be positive, got %d", timeout)
+	}
+	return local.NewCommand(ctx, fmt.Sprintf("%s-cluster-ready", cluster.Env), &local.CommandArgs{
+		Create: pulumi.String(clusterReadyScript),
+		Environment: pulumi.StringMap{
+			"CLUSTER_ENDPOINT": cluster.Cluster.Endpoint,
+			"CLUSTER_STATUS":   cluster.Cluster.Status,
+			"TIMEOUT_SECONDS":  pulumi.String(strconv.Itoa(timeout)),
+		},
+	}, cluster.ResourceOpts(pulumi.DependsOn(cluster.ComputeResources()))...)
+}
@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+const defaultNodeDesiredSize = 3
+
+// Node group sizes. Only the desired size needs to be set: the minimum defaults
+// to two nodes below it (but at least one) and the maximum to twice it, so a
+// single value gives room to demo scaling in both directions.
+type nodeScaling struct {
+	Desired int
+	Min     int
+	Max     int
+}
+
+// NodeScalingConfig reads env's node group sizes from its `environments` entry, falling back to
+// `nodeDesiredSize`, `nodeMinSize` and `nodeMaxSize`.
+func NodeScalingConfig(cfg *config.Config, env string) (nodeScaling, error) {
+	settings, err := stackconfig.EnvironmentSettings(cfg, env)
+	if err != nil {
+		return nodeScaling{}, err
+	}
+	desired, _, err := stackconfig.OptionalInt(cfg, "nodeDesiredSize")
+	if err != nil {
+		return nodeScaling{}, err
+	}
+	if settings.DesiredSize != nil {
+		desired = *settings.DesiredSize
+	} else if desired == 0 {
+		desired = defaultNodeDesiredSize
+	}
+	scaling := nodeScaling{Desired: desired, Min: desired - 2, Max: desired * 2}
+	if scaling.Min < 1 {
+		scaling.Min = 1
+	}
+
+	if min, ok, err := stackconfig.OptionalInt(cfg, "nodeMinSize"); err != nil {
+		return nodeScaling{}, err
+	} else if settings.MinSize != nil {
+		scaling.Min = *settings.MinSize
+	} else if ok {
+		scaling.Min = min
+	}
+	if max, ok, err := stackconfig.OptionalInt(cfg, "nodeMaxSize"); err != nil {
+		return nodeScaling{}, err
+	} else if settings.MaxSize != nil {
+		scaling.Max = *settings.MaxSize
+	} else if ok {
+		scaling.Max = max
+	}
+	if err := scaling.validate(); err != nil {
+		if settings.DesiredSize != nil || settings.MinSize != nil || settings.MaxSize != nil {
+			return nodeScaling{}, fmt.Errorf("environments entry %s: %w", env, err)
+		}
+		return nodeScaling{}, err
+	}
+	if scaling.Min == 0 {
+		autoscaled, err := nodesAutoscaled(cfg, env)
+		if err != nil {
+			return nodeScaling{}, err
+		}
+		if !autoscaled {
+			return nodeScaling{}, fmt.Errorf("nodeMinSize is 0 for %s, which lets its node group scale to no nodes with nothing to bring them back; set it to at least 1, or set clusterAutoscaler or karpenter",
+				env)
+		}
+	}
+	return scaling, nil
+}
+
+// Whether nodes are added back to env's cluster when pods are pending, by the
+// cluster autoscaler scaling its node groups or by Karpenter launching its own.
+func nodesAutoscaled(cfg *config.Config, env string) (bool, error) {
+	autoscaler, err := stackconfig.GetEnvBool(cfg, "clusterAutoscaler", env, false)
+	if err != nil || autoscaler {
+		return autoscaler, err
+	}
+	return stackconfig.GetEnvBool(cfg, "karpenter", env, false)
+}
+
+func (s nodeScaling) validate() error {
+	if s.Desired < 1 {
+		return fmt.Errorf("nodeDesiredSize must be at least 1, got %d", s.Desired)
+	}
+	if s.Min < 0 {
+		return fmt.Errorf("nodeMinSize must not be negative, got %d", s.Min)
+	}
+	if s.Min > s.Desired || s.Desired > s.Max {
+		return fmt.Errorf("node group sizes must satisfy min <= desired <= max, got min %d, desired %d, max %d", s.Min, s.Desired, s.Max)
+	}
+	return nil
+}
+
+// Split the sizes over n per-AZ node groups. The desired size must divide evenly
+// so that every zone runs the same number of nodes. The minimum and maximum are
+// rounded up, so together the groups still cover the configured range and none
+// can scale to no nodes unless the minimum is 0.
+func (s nodeScaling) perAz(n int) (nodeScaling, error) {
+	if s.Desired%n != 0 {
+		return nodeScaling{}, fmt.Errorf("nodeGroupPerAz needs nodeDesiredSize to be a multiple of the %d availability zones, got %d",
+			n, s.Desired)
+	}
+	return nodeScaling{Desired: s.Desired / n, Min: (s.Min + n - 1) / n, Max: (s.Max + n - 1) / n}, nil
+}
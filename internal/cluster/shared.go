@@ -0,0 +1,155 @@
+package cluster
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Shared holds the resources that every environment's cluster is built on.
+type Shared struct {
+	Network *network.Network
+	// The cluster's service role, or nil when `clusterRoleArn` supplies one.
+	ClusterRole          *iam.Role
+	ClusterRoleArn       pulumi.StringInput
+	NodeGroupRole        *iam.Role
+	ClusterSecurityGroup *ec2.SecurityGroup
+	NetworkConfig        eks.ClusterKubernetesNetworkConfigPtrInput
+
+	// Set when the clusters get a managed node group.
+	EnableNodeGroup bool
+	// Set when the clusters get a Fargate profile. FargateRole is nil when
+	// `fargatePodExecutionRoleArn` supplies the role.
+	FargateRole      *iam.Role
+	FargateRoleArn   pulumi.StringInput
+	FargateSubnetIds pulumi.StringArrayInput
+	// The pod subnet of each availability zone with `podSubnets`, for VPC CNI
+	// custom networking, or nil.
+	PodSubnets map[string]*ec2.Subnet
+	// The role GitHub Actions deploys with, mapped into every cluster's aws-auth
+	// when set. CreateGithubDeployRole makes it.
+	GithubDeploy *iamroles.GithubDeploy
+
+	// The VPC endpoints with `vpcEndpoints` or `restrictNodeEgress`, or nil.
+	vpcEndpoints *network.VpcEndpoints
+}
+
+// CreateShared creates the cluster (unless `clusterRoleArn` is set), node group
+// and Fargate (unless `fargatePodExecutionRoleArn` is set) IAM roles, the cluster
+// security group, the pod subnets and the VPC endpoints for the nodes, and
+// validates the Kubernetes network config.
+func CreateShared(ctx *pulumi.Context, cfg *config.Config, network *network.Network) (*Shared, error) {
+	networkConfig, err := network.ClusterNetworkConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	enableNodeGroup, enableFargate, err := computeOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if enableNodeGroup {
+		environments, err := stackconfig.Environments(cfg)
+		if err != nil {
+			return nil, err
+		}
+		var instanceTypes []string
+		for _, env := range environments {
+			_, _, envInstanceTypes, err := nodeImage(cfg, env)
+			if err != nil {
+				return nil, err
+			}
+			for _, instanceType := range envInstanceTypes {
+				if !stackconfig.ContainsString(instanceTypes, instanceType) {
+					instanceTypes = append(instanceTypes, instanceType)
+				}
+			}
+		}
+		azs := network.AvailabilityZones()
+		for _, instanceType := range instanceTypes {
+			if !cfg.GetBool("skipInstanceTypeCheck") {
+				if err := checkInstanceTypeOffered(ctx, instanceType, azs, network.InvokeOpts()...); err != nil {
+					return nil, err
+				}
+			}
+			if cfg.GetBool("vpcCniPrefixDelegation") {
+				if err := checkPrefixDelegationSupported(ctx, instanceType, network.InvokeOpts()...); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	podSubnets, err := network.CreatePodSubnets(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := network.CreateVpcEndpoints(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fargateRoleArn pulumi.StringInput
+	var fargateIamRole *iam.Role
+	var fargateSubnets pulumi.StringArrayInput
+	if enableFargate {
+		if fargateSubnets, err = network.FargateSubnetIds(cfg); err != nil {
+			return nil, err
+		}
+		if fargateRoleArn, fargateIamRole, err = iamroles.FargateRole(ctx, cfg, network); err != nil {
+			return nil, err
+		}
+	}
+	clusterRoleArn, clusterRole, err := iamroles.ClusterRole(ctx, cfg, network)
+	if err != nil {
+		return nil, err
+	}
+	nodeGroupRole, err := iamroles.CreateNodeGroupRole(ctx, cfg, network.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	// Create a Security Group that we can use to actually connect to our cluster
+	clusterSg, err := ec2.NewSecurityGroup(ctx, "test-cluster-sg", &ec2.SecurityGroupArgs{
+		VpcId: network.VpcId,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("aws-demo-shared-cluster-sg"),
+		},
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(80),
+				ToPort:     pulumi.Int(80),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+	}, network.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Shared{
+		Network:              network,
+		ClusterRole:          clusterRole,
+		ClusterRoleArn:       clusterRoleArn,
+		NodeGroupRole:        nodeGroupRole,
+		ClusterSecurityGroup: clusterSg,
+		NetworkConfig:        networkConfig,
+		EnableNodeGroup:      enableNodeGroup,
+		FargateRole:          fargateIamRole,
+		FargateRoleArn:       fargateRoleArn,
+		FargateSubnetIds:     fargateSubnets,
+		PodSubnets:           podSubnets,
+		vpcEndpoints:         endpoints,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+const defaultSpotOnDemandBasePercentage = 20
+
+// The `spotNodes` settings for an environment.
+type spotNodeConfig struct {
+	InstanceTypes []string
+	// The share of the environment's node group sizes kept on-demand.
+	OnDemandBasePercentage int
+}
+
+// Read the Spot node group settings when `spotNodes` is set for env: the
+// `spotNodeInstanceTypes` it may launch and the `spotOnDemandBasePercentage` of
+// the nodes that stay on-demand. Returns nil otherwise.
+func spotNodes(cfg *config.Config, env string) (*spotNodeConfig, error) {
+	enabled, err := stackconfig.GetEnvBool(cfg, "spotNodes", env, false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	spot := &spotNodeConfig{}
+	if err := cfg.GetObject("spotNodeInstanceTypes", &spot.InstanceTypes); err != nil {
+		return nil, fmt.Errorf("spotNodeInstanceTypes must be a list of instance types: %w", err)
+	}
+	if len(spot.InstanceTypes) == 0 {
+		return nil, fmt.Errorf("spotNodes is set for %s but spotNodeInstanceTypes lists no instance types", env)
+	}
+	seen := map[string]bool{}
+	for _, instanceType := range spot.InstanceTypes {
+		if instanceType == "" || seen[instanceType] {
+			return nil, fmt.Errorf("spotNodeInstanceTypes must list distinct instance types, got %q", spot.InstanceTypes)
+		}
+		seen[instanceType] = true
+	}
+	mixed, err := nodeMixedInstances(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if mixed != nil && mixed.spot {
+		return nil, fmt.Errorf("spotNodes is set for %s, whose node group already runs on Spot with nodeMixedInstances", env)
+	}
+	percentage, set, err := stackconfig.OptionalInt(cfg, "spotOnDemandBasePercentage")
+	if err != nil {
+		return nil, err
+	}
+	spot.OnDemandBasePercentage = defaultSpotOnDemandBasePercentage
+	if set {
+		spot.OnDemandBasePercentage = percentage
+	}
+	if spot.OnDemandBasePercentage < 1 || spot.OnDemandBasePercentage > 99 {
+		return nil, fmt.Errorf("spotOnDemandBasePercentage must be from 1 to 99, got %d", spot.OnDemandBasePercentage)
+	}
+	return spot, nil
+}
+
+// Split env's node group sizes between the on-demand node groups and the Spot
+// one. The on-demand share of each size is rounded up, so there is always an
+// on-demand node to fall back on, and the Spot group takes the rest.
+func (s *spotNodeConfig) split(env string, scaling nodeScaling) (onDemand nodeScaling, spot nodeScaling, err error) {
+	share := func(size int) int {
+		return (size*s.OnDemandBasePercentage + 99) / 100
+	}
+	onDemand = nodeScaling{Desired: share(scaling.Desired), Min: share(scaling.Min), Max: share(scaling.Max)}
+	spot = nodeScaling{
+		Desired: scaling.Desired - onDemand.Desired,
+		Min:     scaling.Min - onDemand.Min,
+		Max:     scaling.Max - onDemand.Max,
+	}
+	if spot.Max < 1 {
+		return nodeScaling{}, nodeScaling{}, fmt.Errorf("spotNodes is set for %s, but a maximum of %d node(s) leaves none for Spot at %d%% on-demand",
+			env, scaling.Max, s.OnDemandBasePercentage)
+	}
+	return onDemand, spot, nil
+}
+
+// Check every Spot instance type runs the node group's architecture, as the Spot
+// group shares the AMI type of the on-demand ones. Unlike the on-demand types,
+// they need not be offered in every zone: Spot launches wherever one of them is.
+func checkSpotInstanceTypes(ctx *pulumi.Context, cfg *config.Config, spot *spotNodeConfig, opts ...pulumi.InvokeOption) error {
+	arch, err := NodeArchitecture(cfg)
+	if err != nil {
+		return err
+	}
+	for _, instanceType := range spot.InstanceTypes {
+		info, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{InstanceType: instanceType}, opts...)
+		if err != nil {
+			return err
+		}
+		if !stackconfig.ContainsString(info.SupportedArchitectures, arch) {
+			return fmt.Errorf("spotNodeInstanceTypes %s is not %s, the nodeArchitecture", instanceType, arch)
+		}
+	}
+	return nil
+}
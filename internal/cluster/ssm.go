@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+type ssmParameter struct {
+	name   string
+	value  pulumi.StringInput
+	secure bool
+}
+
+// WriteSsmParameters publishes the cluster's details to SSM Parameter Store under
+// `<ssmParameterPrefix>/<env>/`, for tooling that does not read Pulumi outputs.
+// Does nothing when `ssmParameterPrefix` is unset. The kubeconfig is stored as a
+// SecureString.
+func WriteSsmParameters(ctx *pulumi.Context, cfg *config.Config, cluster *Cluster, argoCdUrl pulumi.StringOutput) error {
+	prefix := cfg.Get("ssmParameterPrefix")
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("ssmParameterPrefix must start with / and not end with one, got %q", prefix)
+	}
+
+	// SSM rejects empty values, and the URL is only known once the load balancer
+	// exists, which skipped awaits do not wait for
+	argoCdUrlValue := argoCdUrl.ApplyT(func(url string) string {
+		if url == "" {
+			return "unavailable"
+		}
+		return url
+	}).(pulumi.StringOutput)
+
+	parameters := []ssmParameter{
+		{name: "cluster-name", value: cluster.Cluster.Name},
+		{name: "endpoint", value: cluster.Cluster.Endpoint},
+		{name: "oidc-issuer-url", value: cluster.OidcIssuerUrl},
+		{name: "argocd-url", value: argoCdUrlValue},
+		{name: "kubeconfig", value: cluster.Kubeconfig, secure: true},
+	}
+	for _, p := range parameters {
+		paramType := ssm.ParameterTypeString
+		if p.secure {
+			paramType = ssm.ParameterTypeSecureString
+		}
+		_, err := ssm.NewParameter(ctx, fmt.Sprintf("%s-ssm-%s", cluster.Env, p.name), &ssm.ParameterArgs{
+			Name:  pulumi.String(fmt.Sprintf("%s/%s/%s", prefix, cluster.Env, p.name)),
+			Type:  paramType,
+			Value: p.value,
+		}, cluster.ResourceOpts()...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Longer than the provider's 30m/60m/15m, which a cluster in a busy account can
+// overrun while still coming up fine.
+var defaultClusterTimeouts = pulumi.CustomTimeouts{Create: "45m", Update: "90m", Delete: "30m"}
+
+// Longer than the provider's 60m, to leave room for draining large node groups.
+var defaultNodeGroupTimeouts = pulumi.CustomTimeouts{Create: "90m", Update: "90m", Delete: "90m"}
+
+// Read create, update and delete timeouts from the key's config object, e.g.
+// `{"create": "1h"}`, in Go duration syntax. Unset ones keep the defaults.
+func resourceTimeouts(cfg *config.Config, key string, defaults pulumi.CustomTimeouts) (*pulumi.CustomTimeouts, error) {
+	var configured map[string]string
+	if err := cfg.GetObject(key, &configured); err != nil {
+		return nil, fmt.Errorf("%s must map create, update and/or delete to durations: %w", key, err)
+	}
+	timeouts := defaults
+	for op, value := range configured {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s.%s must be a positive duration such as 45m, got %q", key, op, value)
+		}
+		switch op {
+		case "create":
+			timeouts.Create = value
+		case "update":
+			timeouts.Update = value
+		case "delete":
+			timeouts.Delete = value
+		default:
+			return nil, fmt.Errorf("%s can only set create, update and delete, got %q", key, op)
+		}
+	}
+	return &timeouts, nil
+}
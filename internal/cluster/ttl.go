@@ -0,0 +1,31 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Build the tags marking env's cluster as ephemeral when `clusterTtl` is set for
+// it, e.g. `{"test": "72h"}` in Go duration syntax: `Ephemeral=true` and
+// `ExpiresAt`, the time the TTL runs out counted from now, in RFC 3339 UTC, for
+// a reaper to find and delete stale clusters by. As it is counted from each
+// deploy, every `pulumi up` moves the expiry forward. Returns nil without a TTL.
+func expiryTags(cfg *config.Config, env string, now time.Time) (pulumi.StringMapInput, error) {
+	value, err := stackconfig.GetEnvString(cfg, "clusterTtl", env)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("clusterTtl for %s must be a positive duration such as 72h, got %q", env, value)
+	}
+	return pulumi.StringMap{
+		"Ephemeral": pulumi.String("true"),
+		"ExpiresAt": pulumi.String(now.Add(ttl).UTC().Format(time.RFC3339)),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ValidateConfig runs the checks of the cluster and compute settings that only
+// need the stack config, for every environment, and returns all the problems
+// found as one error. Returns nil when they are valid.
+func ValidateConfig(cfg *config.Config, environments []string) error {
+	var errs stackconfig.Errors
+	_, _, err := endpointAccess(cfg)
+	errs.Check(err)
+	_, err = publicAccessCidrs(cfg)
+	errs.Check(err)
+	_, _, err = computeOptions(cfg)
+	errs.Check(err)
+	_, err = nodeMetadataOptions(cfg)
+	errs.Check(err)
+	_, err = nodeBlockDeviceMappings(cfg, nil)
+	errs.Check(err)
+	_, err = nodeUserData(cfg)
+	errs.Check(err)
+	_, err = resourceTimeouts(cfg, "clusterTimeouts", defaultClusterTimeouts)
+	errs.Check(err)
+	_, err = resourceTimeouts(cfg, "nodeGroupTimeouts", defaultNodeGroupTimeouts)
+	errs.Check(err)
+	_, err = stackconfig.LogRetentionDays(cfg, "")
+	errs.Check(err)
+	_, err = clusterLogTypes(cfg)
+	errs.Check(err)
+	_, err = clusterNamesConfig(cfg)
+	errs.Check(err)
+	_, err = ArgoNodeCount(cfg)
+	errs.Check(err)
+	_, err = clusterApiIngressConfig(cfg)
+	errs.Check(err)
+	_, _, err = standaloneAlbCertificate(cfg)
+	errs.Check(err)
+	_, err = awsAuthPrincipalsConfig(cfg)
+	errs.Check(err)
+	_, err = kmsKeysMode(cfg)
+	errs.Check(err)
+
+	for _, env := range environments {
+		_, err := NodeScalingConfig(cfg, env)
+		errs.Check(err)
+		_, _, _, err = nodeImage(cfg, env)
+		errs.Check(err)
+		_, err = nodeCapacityReservation(cfg, env)
+		errs.Check(err)
+		_, err = argoNodes(cfg, env)
+		errs.Check(err)
+		_, err = gpuNodes(cfg, env)
+		errs.Check(err)
+		_, err = spotNodes(cfg, env)
+		errs.Check(err)
+		_, err = fargateSelectorsConfig(cfg, env)
+		errs.Check(err)
+		_, err = karpenterEnabled(cfg, env)
+		errs.Check(err)
+		_, err = expiryTags(cfg, env, time.Now())
+		errs.Check(err)
+		_, err = stackconfig.GetEnvBool(cfg, "serverSideApply", env, false)
+		errs.Check(err)
+		_, err = nodeScaleToZero(cfg, env)
+		errs.Check(err)
+	}
+	return errs.Err()
+}
@@ -0,0 +1,160 @@
+package iamroles
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The issuer of the tokens GitHub Actions hands to workflows, without its scheme.
+const githubOidcIssuer = "token.actions.githubusercontent.com"
+
+// Thumbprints of the CAs behind the GitHub Actions issuer. IAM no longer checks
+// them for this issuer, but still requires one.
+var githubOidcThumbprints = []string{
+	"6938fd4d98bab03faadb97b34396831e3780aea1",
+	"1c58a3a8518e8759bf075b76b750d4f2df264fcd",
+}
+
+var (
+	githubRepo            = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+	githubOidcProviderArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:oidc-provider/` + regexp.QuoteMeta(githubOidcIssuer) + `$`)
+)
+
+// The `githubDeploy` object: workflows of Repo running on one of Refs may assume
+// the deploy role, which every cluster maps into Groups. OidcProviderArn names
+// the account's GitHub provider when it already has one, as IAM allows only one
+// per issuer.
+type githubDeployAccess struct {
+	Repo            string   `json:"repo"`
+	Refs            []string `json:"refs"`
+	Groups          []string `json:"groups"`
+	OidcProviderArn string   `json:"oidcProviderArn"`
+}
+
+// GithubDeploy is the role GitHub Actions workflows assume to deploy to the
+// clusters, and the Kubernetes groups it is mapped into.
+type GithubDeploy struct {
+	Role   *iam.Role
+	Groups []string
+}
+
+// Read and check `githubDeploy`, defaulting the refs to the main branch and the
+// groups to system:masters. Returns nil when it is unset.
+func githubDeployConfig(cfg *config.Config) (*githubDeployAccess, error) {
+	var deploy *githubDeployAccess
+	if err := cfg.GetObject("githubDeploy", &deploy); err != nil {
+		return nil, fmt.Errorf("githubDeploy must be an object with a repo, refs and groups: %w", err)
+	}
+	if deploy == nil {
+		return nil, nil
+	}
+	if !githubRepo.MatchString(deploy.Repo) {
+		return nil, fmt.Errorf("githubDeploy repo must be an owner/name GitHub repository, got %q", deploy.Repo)
+	}
+	if len(deploy.Refs) == 0 {
+		deploy.Refs = []string{"refs/heads/main"}
+	}
+	for _, ref := range deploy.Refs {
+		if !strings.HasPrefix(ref, "refs/") {
+			return nil, fmt.Errorf("githubDeploy ref %q must be a full ref such as refs/heads/main or refs/tags/*", ref)
+		}
+	}
+	if len(deploy.Groups) == 0 {
+		deploy.Groups = []string{"system:masters"}
+	}
+	for _, group := range deploy.Groups {
+		if err := CheckKubernetesGroup(group); err != nil {
+			return nil, fmt.Errorf("githubDeploy: %w", err)
+		}
+	}
+	if deploy.OidcProviderArn != "" && !githubOidcProviderArn.MatchString(deploy.OidcProviderArn) {
+		return nil, fmt.Errorf("githubDeploy oidcProviderArn must be the ARN of the %s provider, got %q", githubOidcIssuer, deploy.OidcProviderArn)
+	}
+	return deploy, nil
+}
+
+// CreateGithubDeployRole creates, with `githubDeploy`, an IAM role that GitHub
+// Actions workflows of the configured repo and refs can assume without stored
+// credentials, along with the account's GitHub OIDC provider unless an existing
+// one is given. Set it on the Shared resources before provisioning the clusters
+// to map it into their aws-auth ConfigMap. Returns nil when `githubDeploy` is unset.
+func CreateGithubDeployRole(ctx *pulumi.Context, cfg *config.Config) (*GithubDeploy, error) {
+	deploy, err := githubDeployConfig(cfg)
+	if err != nil || deploy == nil {
+		return nil, err
+	}
+
+	providerArn := pulumi.String(deploy.OidcProviderArn).ToStringOutput()
+	if deploy.OidcProviderArn == "" {
+		provider, err := iam.NewOpenIdConnectProvider(ctx, "github-oidc-provider", &iam.OpenIdConnectProviderArgs{
+			Url:             pulumi.String("https://" + githubOidcIssuer),
+			ClientIdLists:   pulumi.StringArray{pulumi.String("sts.amazonaws.com")},
+			ThumbprintLists: stackconfig.ToPulumiStringArray(githubOidcThumbprints),
+		})
+		if err != nil {
+			return nil, err
+		}
+		providerArn = provider.Arn
+	}
+
+	subjects := make([]string, len(deploy.Refs))
+	for i, ref := range deploy.Refs {
+		subjects[i] = fmt.Sprintf("repo:%s:ref:%s", deploy.Repo, ref)
+	}
+	subjectsJson, err := json.Marshal(subjects)
+	if err != nil {
+		return nil, err
+	}
+	assumeRolePolicy := providerArn.ApplyT(func(arn string) string {
+		return fmt.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Federated": "%s"
+		        },
+		        "Action": "sts:AssumeRoleWithWebIdentity",
+		        "Condition": {
+		            "StringEquals": {
+		                "%s:aud": "sts.amazonaws.com"
+		            },
+		            "StringLike": {
+		                "%s:sub": %s
+		            }
+		        }
+		    }]
+		}`, arn, githubOidcIssuer, githubOidcIssuer, subjectsJson)
+	}).(pulumi.StringOutput)
+
+	role, err := iam.NewRole(ctx, "github-deploy-role", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Enough for `aws eks update-kubeconfig`; what the role may do in the
+	// clusters is decided by the groups aws-auth maps it into
+	_, err = iam.NewRolePolicy(ctx, "github-deploy-role-policy", &iam.RolePolicyArgs{
+		Role: role.Name,
+		Policy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": ["eks:DescribeCluster", "eks:ListClusters"],
+		        "Resource": "*"
+		    }]
+		}`),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GithubDeploy{Role: role, Groups: deploy.Groups}, nil
+}
@@ -0,0 +1,119 @@
+package iamroles
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/pulumitest"
+)
+
+func TestGithubDeploy(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{"githubDeploy": `{"repo": "my-org/my-app", "refs": ["refs/heads/main", "refs/tags/v*"]}`}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		deploy, err := CreateGithubDeployRole(ctx, cfg)
+		if err == nil && deploy == nil {
+			t.Error("expected a deploy role with githubDeploy set")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providers := m.ByType("aws:iam/openIdConnectProvider:OpenIdConnectProvider"); len(providers) != 1 ||
+		providers[0].Inputs["url"].StringValue() != "https://token.actions.githubusercontent.com" {
+		t.Errorf("expected the GitHub OIDC provider, got %v", providers)
+	}
+	var trust string
+	for _, r := range m.ByType("aws:iam/role:Role") {
+		if r.Name == "github-deploy-role" {
+			trust = r.Inputs["assumeRolePolicy"].StringValue()
+		}
+	}
+	for _, sub := range []string{"repo:my-org/my-app:ref:refs/heads/main", "repo:my-org/my-app:ref:refs/tags/v*"} {
+		if !strings.Contains(trust, sub) {
+			t.Errorf("expected the deploy role to trust %s, got %s", sub, trust)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		deploy, err := CreateGithubDeployRole(ctx, cfg)
+		if deploy != nil {
+			t.Errorf("expected no deploy role without githubDeploy, got %v", deploy)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"githubDeploy": `{"repo": "my-app"}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "githubDeploy repo") {
+		t.Error("expected a repo without its owner to be refused")
+	}
+}
+
+func TestClusterRole(t *testing.T) {
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		_, role, err := ClusterRole(ctx, cfg, defaultNetwork)
+		if err == nil && role == nil {
+			t.Error("expected a cluster role to be created without clusterRoleArn")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		names = append(names, a.Name)
+	}
+	if strings.Join(names, ",") != "rpa-AmazonEKSServicePolicy,rpa-AmazonEKSClusterPolicy" {
+		t.Errorf("expected the EKS cluster policies attached, got %v", names)
+	}
+
+	m = pulumitest.NewMocks()
+	arn := "arn:aws:iam::123456789012:role/platform/eks-cluster"
+	err = pulumitest.Run(t, m, map[string]string{"clusterRoleArn": arn}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		_, role, err := ClusterRole(ctx, cfg, defaultNetwork)
+		if role != nil {
+			t.Error("expected no cluster role to be created when clusterRoleArn is set")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := m.ByType("aws:iam/role:Role"); len(roles) != 0 {
+		t.Errorf("expected no roles, got %v", roles)
+	}
+}
+
+func TestCheckKubernetesGroup(t *testing.T) {
+	for group, ok := range map[string]bool{
+		"system:masters": true,
+		"viewers":        true,
+		"system:nodes":   false,
+		"has space":      false,
+		"":               false,
+	} {
+		if err := CheckKubernetesGroup(group); (err == nil) != ok {
+			t.Errorf("expected group %q allowed to be %v, got %v", group, ok, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package iamroles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Thumbprint of the root CA that signs the EKS OIDC issuer endpoints.
+const eksOidcThumbprint = "9e99a48a9960b14926bb7f3b02e22da2b0ab7280"
+
+// CreateOidcProvider registers the cluster's OIDC issuer with IAM so Kubernetes service accounts can
+// assume IAM roles (IRSA).
+func CreateOidcProvider(ctx *pulumi.Context, env string, issuerUrl pulumi.StringOutput,
+	opts ...pulumi.ResourceOption) (*iam.OpenIdConnectProvider, error) {
+	return iam.NewOpenIdConnectProvider(ctx, fmt.Sprintf("%s-oidc-provider", env), &iam.OpenIdConnectProviderArgs{
+		Url:             issuerUrl,
+		ClientIdLists:   pulumi.StringArray{pulumi.String("sts.amazonaws.com")},
+		ThumbprintLists: pulumi.StringArray{pulumi.String(eksOidcThumbprint)},
+	}, opts...)
+}
+
+// CreateIrsaRole creates an IAM role that only the given service account can assume through the
+// cluster's OIDC provider, with the managed policies attached.
+func CreateIrsaRole(ctx *pulumi.Context, name string, oidcProvider *iam.OpenIdConnectProvider,
+	namespace string, serviceAccount string, policyArns []string, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	assumeRolePolicy := pulumi.All(oidcProvider.Arn, oidcProvider.Url).ApplyT(func(args []interface{}) string {
+		issuer := strings.TrimPrefix(args[1].(string), "https://")
+		return fmt.Sprintf(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Federated": "%s"
+		        },
+		        "Action": "sts:AssumeRoleWithWebIdentity",
+		        "Condition": {
+		            "StringEquals": {
+		                "%s:sub": "system:serviceaccount:%s:%s",
+		                "%s:aud": "sts.amazonaws.com"
+		            }
+		        }
+		    }]
+		}`, args[0], issuer, namespace, serviceAccount, issuer)
+	}).(pulumi.StringOutput)
+
+	role, err := iam.NewRole(ctx, name, &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicy,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := AttachPolicies(ctx, fmt.Sprintf("%s-policy", name), role, policyArns, opts...); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// AttachPolicies attaches managed policies to role as `<prefix>-<policy name>`, so that adding or
+// removing a policy leaves the other attachments alone. The aliases adopt
+// attachments created under the earlier `<prefix>-<index>` names.
+func AttachPolicies(ctx *pulumi.Context, prefix string, role *iam.Role, policyArns []string, opts ...pulumi.ResourceOption) error {
+	for i, policyArn := range policyArns {
+		policyName := policyArn[strings.LastIndex(policyArn, "/")+1:]
+		_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("%s-%s", prefix, policyName), &iam.RolePolicyAttachmentArgs{
+			PolicyArn: pulumi.String(policyArn),
+			Role:      role.Name,
+		}, append([]pulumi.ResourceOption{
+			pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(fmt.Sprintf("%s-%d", prefix, i))}}),
+		}, opts...)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
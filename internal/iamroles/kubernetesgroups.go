@@ -0,0 +1,30 @@
+package iamroles
+
+import (
+	"fmt"
+	"regexp"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// IamUserArn matches IAM user ARNs, and KubernetesName the names of Kubernetes
+// users and groups.
+var (
+	IamUserArn     = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:user/.+$`)
+	KubernetesName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9:._@-]*$`)
+)
+
+// The groups EKS puts the cluster's own compute in, which no one else should join.
+var awsAuthComputeGroups = []string{"system:bootstrappers", "system:nodes", "system:node-proxier"}
+
+// CheckKubernetesGroup checks that group may be given in aws-auth: a valid name, and not one of the
+// groups reserved for the cluster's compute.
+func CheckKubernetesGroup(group string) error {
+	if !KubernetesName.MatchString(group) {
+		return fmt.Errorf("%q is not a valid Kubernetes group name", group)
+	}
+	if stackconfig.ContainsString(awsAuthComputeGroups, group) {
+		return fmt.Errorf("group %s is reserved for the cluster's nodes", group)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package iamroles creates the IAM roles of the clusters, their node groups and
+// Fargate profiles, the IRSA roles of service accounts and the GitHub deploy role.
+package iamroles
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/network"
+)
+
+// IamPolicyArn matches the ARNs of customer and AWS managed policies.
+var IamPolicyArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::([0-9]{12}|aws):policy/.+$`)
+
+// FargateRole uses the pod execution role given by `fargatePodExecutionRoleArn`, as
+// `clusterRoleArn` does for the cluster role, or creates the one shared by every
+// environment's Fargate profile.
+func FargateRole(ctx *pulumi.Context, cfg *config.Config, network *network.Network) (pulumi.StringInput, *iam.Role, error) {
+	if arn := cfg.Get("fargatePodExecutionRoleArn"); arn != "" {
+		if !IamRoleArn.MatchString(arn) {
+			return nil, nil, fmt.Errorf("fargatePodExecutionRoleArn %q is not an IAM role ARN", arn)
+		}
+		return pulumi.String(arn), nil, nil
+	}
+	role, err := createFargateRole(ctx, network.ResourceOpts()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return role.Arn, role, nil
+}
+
+// Create the pod execution role shared by every environment's Fargate profile.
+func createFargateRole(ctx *pulumi.Context, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	role, err := iam.NewRole(ctx, "fargate-pod-execution-role", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "eks-fargate-pods.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = iam.NewRolePolicyAttachment(ctx, "fargate-pod-execution-rpa", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonEKSFargatePodExecutionRolePolicy"),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// IamRoleArn matches role ARNs as IAM prints them, including roles under a path.
+var IamRoleArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$`)
+
+// ClusterRole uses the cluster service role given by `clusterRoleArn`, for accounts where
+// roles are created outside of this program, or creates one with the EKS cluster
+// policies attached.
+func ClusterRole(ctx *pulumi.Context, cfg *config.Config, network *network.Network) (pulumi.StringInput, *iam.Role, error) {
+	if arn := cfg.Get("clusterRoleArn"); arn != "" {
+		if !IamRoleArn.MatchString(arn) {
+			return nil, nil, fmt.Errorf("clusterRoleArn %q is not an IAM role ARN", arn)
+		}
+		return pulumi.String(arn), nil, nil
+	}
+	role, err := iam.NewRole(ctx, "eks-iam-eksRole", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2008-10-17",
+		    "Statement": [{
+		        "Sid": "",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "eks.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+	}, network.ResourceOpts()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	eksPolicies := []string{
+		"arn:aws:iam::aws:policy/AmazonEKSServicePolicy",
+		"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
+	}
+	if err := AttachPolicies(ctx, "rpa", role, eksPolicies, network.ResourceOpts()...); err != nil {
+		return nil, nil, err
+	}
+	return role.Arn, role, nil
+}
+
+// CreateNodeGroupRole creates the role shared by every environment's node groups, with the EKS worker
+// node, VPC CNI and ECR read-only policies. IPv6 clusters also need the VPC CNI
+// to manage IPv6 addresses, which AmazonEKS_CNI_Policy does not cover.
+func CreateNodeGroupRole(ctx *pulumi.Context, cfg *config.Config, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	nodeGroupRole, err := iam.NewRole(ctx, "nodegroup-iam-role", &iam.RoleArgs{
+		AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Sid": "",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "ec2.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	nodeGroupPolicies := []string{
+		"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
+		"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
+		"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
+	}
+	if err := AttachPolicies(ctx, "ngpa", nodeGroupRole, nodeGroupPolicies, opts...); err != nil {
+		return nil, err
+	}
+	if network.ClusterIpFamily(cfg) == network.IpFamilyIpv6 {
+		_, err := iam.NewRolePolicy(ctx, "nodegroup-cni-ipv6-policy", &iam.RolePolicyArgs{
+			Role: nodeGroupRole.Name,
+			Policy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": [
+		            "ec2:AssignIpv6Addresses",
+		            "ec2:DescribeInstances",
+		            "ec2:DescribeTags",
+		            "ec2:DescribeNetworkInterfaces",
+		            "ec2:DescribeInstanceTypes"
+		        ],
+		        "Resource": "*"
+		    }, {
+		        "Effect": "Allow",
+		        "Action": ["ec2:CreateTags"],
+		        "Resource": ["arn:aws:ec2:*:*:network-interface/*"]
+		    }]
+		}`),
+		}, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodeGroupRole, nil
+}
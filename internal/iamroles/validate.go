@@ -0,0 +1,17 @@
+package iamroles
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ValidateConfig runs the checks of the IAM role settings that only need the
+// stack config, and returns all the problems found as one error. Returns nil
+// when they are valid.
+func ValidateConfig(cfg *config.Config, environments []string) error {
+	var errs stackconfig.Errors
+	_, err := githubDeployConfig(cfg)
+	errs.Check(err)
+	return errs.Err()
+}
@@ -0,0 +1,138 @@
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Private ranges EKS accepts for the service CIDR.
+var serviceCidrRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// The IP families `ipFamily` takes.
+const (
+	ipFamilyIpv4 = "ipv4"
+	IpFamilyIpv6 = "ipv6"
+)
+
+// ClusterNetworkConfig builds the cluster's Kubernetes network config from `serviceIpv4Cidr` and
+// `ipFamily`. Returns nil when neither is set, so EKS keeps its defaults.
+func (n *Network) ClusterNetworkConfig(ctx *pulumi.Context, cfg *config.Config) (eks.ClusterKubernetesNetworkConfigPtrInput, error) {
+	serviceCidr := cfg.Get("serviceIpv4Cidr")
+	ipFamily := ClusterIpFamily(cfg)
+	if serviceCidr == "" && ipFamily == ipFamilyIpv4 {
+		return nil, nil
+	}
+
+	args := &eks.ClusterKubernetesNetworkConfigArgs{}
+	switch ipFamily {
+	case ipFamilyIpv4:
+	case IpFamilyIpv6:
+		if serviceCidr != "" {
+			return nil, fmt.Errorf("serviceIpv4Cidr cannot be set when ipFamily is %q, EKS assigns the IPv6 service range", IpFamilyIpv6)
+		}
+		// The VPC created with useDefaultVpc false gets its IPv6 blocks with the subnets
+		if n.defaultVpc != nil {
+			if err := validateIpv6Prerequisites(ctx, n.defaultVpc, n.defaultSubnetIds, n.InvokeOpts()...); err != nil {
+				return nil, err
+			}
+		}
+		args.IpFamily = pulumi.String(IpFamilyIpv6)
+	default:
+		return nil, fmt.Errorf("unsupported ipFamily %q, expected %q or %q", ipFamily, ipFamilyIpv4, IpFamilyIpv6)
+	}
+	if serviceCidr != "" {
+		if err := validateServiceCidr(serviceCidr, n.VpcCidrs); err != nil {
+			return nil, err
+		}
+		args.ServiceIpv4Cidr = pulumi.String(serviceCidr)
+	}
+	return args, nil
+}
+
+// ClusterIpFamily reads `ipFamily`, ipv4 when it is unset.
+func ClusterIpFamily(cfg *config.Config) string {
+	if ipFamily := cfg.Get("ipFamily"); ipFamily != "" {
+		return ipFamily
+	}
+	return ipFamilyIpv4
+}
+
+// IPv6 pod networking needs an IPv6 CIDR on the VPC and on every cluster subnet.
+func validateIpv6Prerequisites(ctx *pulumi.Context, vpc *ec2.LookupVpcResult, subnetIds []string, opts ...pulumi.InvokeOption) error {
+	if vpc.Ipv6CidrBlock == "" {
+		return fmt.Errorf("ipFamily %q requires an IPv6 CIDR block on VPC %s", IpFamilyIpv6, vpc.Id)
+	}
+	var missing []string
+	for _, id := range subnetIds {
+		subnetId := id
+		subnet, err := ec2.LookupSubnet(ctx, &ec2.LookupSubnetArgs{Id: &subnetId}, opts...)
+		if err != nil {
+			return err
+		}
+		if subnet.Ipv6CidrBlock == "" {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ipFamily %q requires an IPv6 CIDR block on every cluster subnet, missing on %v", IpFamilyIpv6, missing)
+	}
+	return nil
+}
+
+func vpcCidrs(vpc *ec2.LookupVpcResult) []string {
+	cidrs := []string{vpc.CidrBlock}
+	for _, association := range vpc.CidrBlockAssociations {
+		if association.CidrBlock != vpc.CidrBlock {
+			cidrs = append(cidrs, association.CidrBlock)
+		}
+	}
+	return cidrs
+}
+
+// Check the service CIDR is a /12 to /24 block inside a private range that does
+// not overlap any of the VPC CIDRs.
+func validateServiceCidr(serviceCidr string, vpcCidrs []string) error {
+	_, serviceNet, err := net.ParseCIDR(serviceCidr)
+	if err != nil || serviceNet.IP.To4() == nil {
+		return fmt.Errorf("serviceIpv4Cidr %q is not a valid IPv4 CIDR", serviceCidr)
+	}
+	if ones, _ := serviceNet.Mask.Size(); ones < 12 || ones > 24 {
+		return fmt.Errorf("serviceIpv4Cidr %q must have a prefix length between /12 and /24", serviceCidr)
+	}
+	private := false
+	for _, r := range serviceCidrRanges {
+		_, rangeNet, _ := net.ParseCIDR(r)
+		if cidrContains(rangeNet, serviceNet) {
+			private = true
+			break
+		}
+	}
+	if !private {
+		return fmt.Errorf("serviceIpv4Cidr %q must be within one of %v", serviceCidr, serviceCidrRanges)
+	}
+	for _, vpcCidr := range vpcCidrs {
+		_, vpcNet, err := net.ParseCIDR(vpcCidr)
+		if err != nil {
+			continue
+		}
+		if cidrsOverlap(serviceNet, vpcNet) {
+			return fmt.Errorf("serviceIpv4Cidr %q overlaps the VPC CIDR %s", serviceCidr, vpcCidr)
+		}
+	}
+	return nil
+}
+
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
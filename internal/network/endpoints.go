@@ -0,0 +1,138 @@
+package network
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// The services a node needs to join the cluster and run its pods with
+// `restrictNodeEgress`: the EC2 API for the VPC CNI, ECR for images and STS for
+// IRSA. ECR's image layers come from S3, which gets a gateway endpoint instead.
+var nodeEgressServices = []string{"ec2", "ecr.api", "ecr.dkr", "sts"}
+
+var vpcEndpointService = regexp.MustCompile(`^[a-z0-9]+([.-][a-z0-9]+)*$`)
+
+// VpcEndpoints are the VPC endpoints created with `vpcEndpoints` or `restrictNodeEgress`.
+type VpcEndpoints struct {
+	// Set with `restrictNodeEgress`, which limits the nodes to the endpoints.
+	RestrictNodes bool
+	// Guards the interface endpoints, which take HTTPS from the VPC.
+	EndpointSecurityGroup *ec2.SecurityGroup
+	// The S3 gateway endpoint's prefix list, for egress rules.
+	S3PrefixListId pulumi.StringOutput
+}
+
+// Read whether to create VPC endpoints, which `vpcEndpoints` does for nodes in
+// subnets without a route to the internet and `restrictNodeEgress` for nodes
+// limited to them, and the services to add them for: those in nodeEgressServices
+// plus any in `vpcEndpointServices`, e.g. ["logs"] for Container Insights.
+// Returns nil services when neither is set.
+func vpcEndpointsConfig(cfg *config.Config) (restrictNodes bool, services []string, err error) {
+	restrictNodes = cfg.GetBool("restrictNodeEgress")
+	if !restrictNodes && !cfg.GetBool("vpcEndpoints") {
+		return false, nil, nil
+	}
+	var extra []string
+	if err := cfg.GetObject("vpcEndpointServices", &extra); err != nil {
+		return false, nil, fmt.Errorf("vpcEndpointServices must be a list of service names: %w", err)
+	}
+	services = append([]string(nil), nodeEgressServices...)
+	for _, service := range extra {
+		if !vpcEndpointService.MatchString(service) || service == "s3" {
+			return false, nil, fmt.Errorf("vpcEndpointServices entry %q must be an interface endpoint service name such as logs or ecr.api", service)
+		}
+		if !stackconfig.ContainsString(services, service) {
+			services = append(services, service)
+		}
+	}
+	return restrictNodes, services, nil
+}
+
+// CreateVpcEndpoints creates the VPC endpoints the nodes use with `vpcEndpoints` or
+// `restrictNodeEgress`: an interface endpoint with private DNS for each service,
+// in one subnet of each of the cluster's availability zones, and an S3 gateway
+// endpoint on the VPC's route tables. Returns nil when neither is set.
+func (n *Network) CreateVpcEndpoints(ctx *pulumi.Context, cfg *config.Config) (*VpcEndpoints, error) {
+	restrictNodes, services, err := vpcEndpointsConfig(cfg)
+	if err != nil || services == nil {
+		return nil, err
+	}
+	// Private DNS is what points the services' usual hostnames at the endpoints.
+	// A created VPC has both turned on
+	if vpc := n.defaultVpc; vpc != nil && (!vpc.EnableDnsSupport || !vpc.EnableDnsHostnames) {
+		return nil, fmt.Errorf("VPC endpoints need DNS support and DNS hostnames turned on in VPC %s for their private DNS", vpc.Id)
+	}
+	region, err := aws.GetRegion(ctx, nil, n.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	byAz, azs := SubnetsByAz(n.Subnets)
+	// An interface endpoint takes at most one subnet per zone
+	var endpointSubnets pulumi.StringArray
+	for _, az := range azs {
+		endpointSubnets = append(endpointSubnets, byAz[az][0])
+	}
+	if restrictNodes {
+		_ = ctx.Log.Warn("restrictNodeEgress is on: nodes can only pull images from ECR in "+region.Name+
+			", so charts with images from other registries need them mirrored to ECR or an ECR pull through cache", nil)
+	}
+
+	endpointSg, err := ec2.NewSecurityGroup(ctx, "vpc-endpoints-sg", &ec2.SecurityGroupArgs{
+		VpcId: n.VpcId,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("aws-demo-vpc-endpoints-sg"),
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(443),
+				ToPort:     pulumi.Int(443),
+				CidrBlocks: stackconfig.ToPulumiStringArray(n.VpcCidrs),
+			},
+		},
+	}, n.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		_, err := ec2.NewVpcEndpoint(ctx, fmt.Sprintf("vpc-endpoint-%s", strings.ReplaceAll(service, ".", "-")), &ec2.VpcEndpointArgs{
+			VpcId:             n.VpcId,
+			ServiceName:       pulumi.String(fmt.Sprintf("com.amazonaws.%s.%s", region.Name, service)),
+			VpcEndpointType:   pulumi.String("Interface"),
+			PrivateDnsEnabled: pulumi.Bool(true),
+			SubnetIds:         endpointSubnets,
+			SecurityGroupIds:  pulumi.StringArray{endpointSg.ID()},
+		}, n.ResourceOpts()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	routeTableIds := n.routeTableIds
+	if n.defaultVpc != nil {
+		vpcId := n.defaultVpc.Id
+		routeTables, err := ec2.GetRouteTables(ctx, &ec2.GetRouteTablesArgs{VpcId: &vpcId}, n.InvokeOpts()...)
+		if err != nil {
+			return nil, err
+		}
+		routeTableIds = stackconfig.ToPulumiStringArray(routeTables.Ids)
+	}
+	s3, err := ec2.NewVpcEndpoint(ctx, "vpc-endpoint-s3", &ec2.VpcEndpointArgs{
+		VpcId:           n.VpcId,
+		ServiceName:     pulumi.String(fmt.Sprintf("com.amazonaws.%s.s3", region.Name)),
+		VpcEndpointType: pulumi.String("Gateway"),
+		RouteTableIds:   routeTableIds,
+	}, n.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	return &VpcEndpoints{RestrictNodes: restrictNodes, EndpointSecurityGroup: endpointSg, S3PrefixListId: s3.PrefixListId}, nil
+}
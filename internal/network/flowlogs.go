@@ -0,0 +1,139 @@
+package network
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+const (
+	flowLogDestinationCloudWatch = "cloud-watch-logs"
+	flowLogDestinationS3         = "s3"
+)
+
+// S3 bucket ARNs, optionally with a folder to write the flow logs under.
+var s3BucketArn = regexp.MustCompile(`^arn:aws[a-z-]*:s3:::[a-z0-9][a-z0-9.-]{1,61}[a-z0-9](/.*)?$`)
+
+// The flow log settings: where the logs go, and which traffic they record.
+type flowLogSettings struct {
+	DestinationType string
+	BucketArn       string
+	TrafficType     string
+}
+
+// Read `flowLogDestinationType`, `flowLogBucketArn` and `flowLogTrafficType`.
+func flowLogConfig(cfg *config.Config) (flowLogSettings, error) {
+	settings := flowLogSettings{
+		DestinationType: cfg.Get("flowLogDestinationType"),
+		BucketArn:       cfg.Get("flowLogBucketArn"),
+		TrafficType:     cfg.Get("flowLogTrafficType"),
+	}
+	if settings.DestinationType == "" {
+		settings.DestinationType = flowLogDestinationCloudWatch
+	}
+	if settings.TrafficType == "" {
+		settings.TrafficType = "ALL"
+	}
+	switch settings.DestinationType {
+	case flowLogDestinationCloudWatch:
+	case flowLogDestinationS3:
+		if settings.BucketArn == "" {
+			return settings, fmt.Errorf("flowLogBucketArn must be set when flowLogDestinationType is %q", flowLogDestinationS3)
+		}
+		if !s3BucketArn.MatchString(settings.BucketArn) {
+			return settings, fmt.Errorf("flowLogBucketArn must be an S3 bucket ARN such as arn:aws:s3:::my-bucket, got %q", settings.BucketArn)
+		}
+	default:
+		return settings, fmt.Errorf("unsupported flowLogDestinationType %q, expected %q or %q",
+			settings.DestinationType, flowLogDestinationCloudWatch, flowLogDestinationS3)
+	}
+	switch settings.TrafficType {
+	case "ACCEPT", "REJECT", "ALL":
+	default:
+		return settings, fmt.Errorf("flowLogTrafficType must be ACCEPT, REJECT or ALL, got %q", settings.TrafficType)
+	}
+	return settings, nil
+}
+
+// CreateFlowLogs creates VPC flow logs for the cluster VPC, recording the
+// `flowLogTrafficType` traffic. Logs go to a new CloudWatch log group
+// by default, or to the S3 bucket given by `flowLogBucketArn` when
+// `flowLogDestinationType` is "s3". Returns the log destination ARN.
+func CreateFlowLogs(ctx *pulumi.Context, cfg *config.Config, vpcId pulumi.StringInput) (pulumi.StringOutput, error) {
+	settings, err := flowLogConfig(cfg)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	args := &ec2.FlowLogArgs{
+		VpcId:              vpcId,
+		TrafficType:        pulumi.String(settings.TrafficType),
+		LogDestinationType: pulumi.String(settings.DestinationType),
+	}
+	var destination pulumi.StringOutput
+	switch settings.DestinationType {
+	case flowLogDestinationCloudWatch:
+		retentionDays, err := stackconfig.LogRetentionDays(cfg, "")
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		logGroup, err := cloudwatch.NewLogGroup(ctx, "vpc-flow-logs", &cloudwatch.LogGroupArgs{
+			RetentionInDays: pulumi.Int(retentionDays),
+		}, stackconfig.DataResourceOpts(cfg)...)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		flowLogRole, err := iam.NewRole(ctx, "vpc-flow-logs-role", &iam.RoleArgs{
+			AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Sid": "",
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "vpc-flow-logs.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		_, err = iam.NewRolePolicy(ctx, "vpc-flow-logs-policy", &iam.RolePolicyArgs{
+			Role: flowLogRole.Name,
+			Policy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Action": [
+		            "logs:CreateLogStream",
+		            "logs:PutLogEvents",
+		            "logs:DescribeLogGroups",
+		            "logs:DescribeLogStreams"
+		        ],
+		        "Resource": "*"
+		    }]
+		}`),
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		args.IamRoleArn = flowLogRole.Arn
+		destination = logGroup.Arn
+	case flowLogDestinationS3:
+		destination = pulumi.String(settings.BucketArn).ToStringOutput()
+	}
+	args.LogDestination = destination
+
+	if _, err := ec2.NewFlowLog(ctx, "vpc-flow-log", args); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	return destination, nil
+}
@@ -0,0 +1,142 @@
+// Package network looks up or creates the VPC and subnets the clusters run in,
+// with the pod subnets, VPC endpoints and flow logs built on them.
+package network
+
+import (
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Network is the VPC the clusters are placed in and the subnets they use: the
+// default VPC and its public subnets, or the VPC created with `useDefaultVpc`
+// false and its private subnets.
+type Network struct {
+	VpcId pulumi.StringInput
+	// The VPC's IPv4 CIDR blocks, the primary one first.
+	VpcCidrs []string
+	// The subnets of the clusters and their nodes.
+	Subnets []Subnet
+	// The subnets internet-facing load balancers and the bastion go in. The same
+	// as Subnets in the default VPC, whose subnets are all public.
+	PublicSubnets []Subnet
+
+	// The default VPC as looked up and the IDs of Subnets in it, for the checks
+	// that look them up, or nil for a created VPC.
+	defaultVpc       *ec2.LookupVpcResult
+	defaultSubnetIds []string
+	// The route tables of a created VPC. The default VPC's are looked up instead.
+	routeTableIds pulumi.StringArrayInput
+	// Set for the replica region, see LookupReplicaNetwork.
+	Parent pulumi.Resource
+}
+
+// Subnet is a subnet of the Network and the availability zone it is in, which is
+// known before the subnet is created.
+type Subnet struct {
+	Id               pulumi.StringInput
+	AvailabilityZone string
+}
+
+// LookupDefaultNetwork reads back the default VPC and its public subnets,
+// limited to `maxSubnets` when set, or creates a dedicated VPC when
+// `useDefaultVpc` is false.
+func LookupDefaultNetwork(ctx *pulumi.Context, cfg *config.Config) (*Network, error) {
+	return lookupNetwork(ctx, cfg, nil)
+}
+
+// Look up the default network, or create the dedicated one, in the region of
+// parent's AWS provider, or of the stack's default provider when parent is nil.
+func lookupNetwork(ctx *pulumi.Context, cfg *config.Config, parent pulumi.Resource) (*Network, error) {
+	network := &Network{Parent: parent}
+	if !stackconfig.GetBoolDefault(cfg, "useDefaultVpc", true) {
+		return createDedicatedNetwork(ctx, cfg, network)
+	}
+	t := true
+	vpc, err := ec2.LookupVpc(ctx, &ec2.LookupVpcArgs{Default: &t}, network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	subnet, err := ec2.GetSubnetIds(ctx, &ec2.GetSubnetIdsArgs{VpcId: vpc.Id}, network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	subnetIds, err := selectSubnets(ctx, cfg, subnet.Ids, network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	// The API returns the subnets in no particular order; sort them so the
+	// resources built on them see the same list on every run
+	sort.Strings(subnetIds)
+	subnetsByAz, _, err := groupSubnetsByAz(ctx, subnetIds, network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	subnetAzs := map[string]string{}
+	for az, ids := range subnetsByAz {
+		for _, id := range ids {
+			subnetAzs[id] = az
+		}
+	}
+	for _, id := range subnetIds {
+		network.Subnets = append(network.Subnets, Subnet{Id: pulumi.String(id), AvailabilityZone: subnetAzs[id]})
+	}
+	network.PublicSubnets = network.Subnets
+	network.VpcId = pulumi.String(vpc.Id)
+	network.VpcCidrs = vpcCidrs(vpc)
+	network.defaultVpc = vpc
+	network.defaultSubnetIds = subnetIds
+	return network, nil
+}
+
+// SubnetIds returns the IDs of subnets.
+func SubnetIds(subnets []Subnet) pulumi.StringArray {
+	ids := make(pulumi.StringArray, len(subnets))
+	for i, subnet := range subnets {
+		ids[i] = subnet.Id
+	}
+	return ids
+}
+
+// SubnetsByAz groups subnets by availability zone, keeping their order. Returns the zones in
+// sorted order and the subnets of each.
+func SubnetsByAz(subnets []Subnet) (map[string][]pulumi.StringInput, []string) {
+	byAz := map[string][]pulumi.StringInput{}
+	var azs []string
+	for _, subnet := range subnets {
+		if _, ok := byAz[subnet.AvailabilityZone]; !ok {
+			azs = append(azs, subnet.AvailabilityZone)
+		}
+		byAz[subnet.AvailabilityZone] = append(byAz[subnet.AvailabilityZone], subnet.Id)
+	}
+	sort.Strings(azs)
+	return byAz, azs
+}
+
+// AvailabilityZones returns the availability zones of the network's subnets, in sorted order.
+func (n *Network) AvailabilityZones() []string {
+	_, azs := SubnetsByAz(n.Subnets)
+	return azs
+}
+
+// ResourceOpts returns the options for the resources built on the network. In the replica region they are
+// parented to the replica component, which gives them its AWS provider and keeps
+// their URNs apart from the primary region's resources of the same name.
+func (n *Network) ResourceOpts(opts ...pulumi.ResourceOption) []pulumi.ResourceOption {
+	if n.Parent == nil {
+		return opts
+	}
+	return append(opts, pulumi.Parent(n.Parent))
+}
+
+// InvokeOpts returns the options for the invokes made about the network, so they query its region.
+func (n *Network) InvokeOpts() []pulumi.InvokeOption {
+	if n.Parent == nil {
+		return nil
+	}
+	return []pulumi.InvokeOption{pulumi.Parent(n.Parent)}
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/pulumitest"
+)
+
+func TestSelectSubnets(t *testing.T) {
+	m := pulumitest.NewMocks()
+	var network *Network
+	err := pulumitest.Run(t, m, map[string]string{"maxSubnets": "2"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		network, err = LookupDefaultNetwork(ctx, cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(network.defaultSubnetIds, ",") != "subnet-a1,subnet-b1" {
+		t.Errorf("expected one subnet per zone, got %v", network.defaultSubnetIds)
+	}
+
+	m = pulumitest.NewMocks()
+	m.SubnetAzs["subnet-b1"], m.SubnetAzs["subnet-b2"] = "eu-west-1a", "eu-west-1a"
+	err = pulumitest.Run(t, m, map[string]string{"maxSubnets": "2"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := LookupDefaultNetwork(ctx, cfg)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "availability zone") {
+		t.Errorf("expected a single zone to be rejected, got %v", err)
+	}
+}
+
+func TestValidateServiceCidr(t *testing.T) {
+	vpc := []string{"172.31.0.0/16"}
+	cases := map[string]bool{
+		"10.100.0.0/16":   true,
+		"172.31.128.0/20": false,
+		"8.8.0.0/16":      false,
+		"10.0.0.0/8":      false,
+		"not-a-cidr":      false,
+	}
+	for cidr, valid := range cases {
+		if err := validateServiceCidr(cidr, vpc); (err == nil) != valid {
+			t.Errorf("validateServiceCidr(%q) = %v, expected valid=%v", cidr, err, valid)
+		}
+	}
+}
+
+func TestCreateFlowLogs(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"flowLogDestinationType": "s3",
+		"flowLogBucketArn":       "arn:aws:s3:::audit-logs/vpc",
+		"flowLogTrafficType":     "REJECT",
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := CreateFlowLogs(ctx, cfg, pulumi.String("vpc-123"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flowLogs := m.ByType("aws:ec2/flowLog:FlowLog")
+	if len(flowLogs) != 1 || flowLogs[0].Inputs["logDestination"].StringValue() != "arn:aws:s3:::audit-logs/vpc" ||
+		flowLogs[0].Inputs["trafficType"].StringValue() != "REJECT" {
+		t.Fatalf("expected a flow log of rejected traffic to the bucket, got %v", flowLogs)
+	}
+	if groups := m.ByType("aws:cloudwatch/logGroup:LogGroup"); len(groups) != 0 {
+		t.Errorf("expected no log group for an S3 destination, got %v", groups)
+	}
+
+	for settings, want := range map[string]string{
+		`{"flowLogDestinationType": "kinesis"}`:                                           "unsupported flowLogDestinationType",
+		`{"flowLogDestinationType": "s3"}`:                                                "flowLogBucketArn must be set",
+		`{"flowLogDestinationType": "s3", "flowLogBucketArn": "audit-logs"}`:              "must be an S3 bucket ARN",
+		`{"flowLogDestinationType": "s3", "flowLogBucketArn": "arn:aws:s3:::Audit_Logs"}`: "must be an S3 bucket ARN",
+		`{"flowLogTrafficType": "all"}`:                                                   "must be ACCEPT, REJECT or ALL",
+	} {
+		var values map[string]string
+		if err := json.Unmarshal([]byte(settings), &values); err != nil {
+			t.Fatal(err)
+		}
+		values["enableFlowLogs"] = "true"
+		err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", settings, want, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// Read `podSubnets`, the CIDR of the pod subnet to create in each availability
+// zone for VPC CNI custom networking, e.g. {"eu-west-1a": "100.64.0.0/19"}.
+// Returns nil when unset, leaving pods in the node subnets.
+func podSubnetsConfig(cfg *config.Config) (map[string]string, error) {
+	var podSubnets map[string]string
+	if err := cfg.GetObject("podSubnets", &podSubnets); err != nil {
+		return nil, fmt.Errorf("podSubnets must map availability zones to CIDRs: %w", err)
+	}
+	if len(podSubnets) == 0 {
+		return nil, nil
+	}
+	if ClusterIpFamily(cfg) == IpFamilyIpv6 {
+		return nil, fmt.Errorf("podSubnets cannot be set when ipFamily is %q, custom networking is IPv4 only", IpFamilyIpv6)
+	}
+	for az, cidr := range podSubnets {
+		_, podNet, err := net.ParseCIDR(cidr)
+		if err != nil || podNet.IP.To4() == nil {
+			return nil, fmt.Errorf("podSubnets CIDR %q for %s is not a valid IPv4 CIDR", cidr, az)
+		}
+		if ones, _ := podNet.Mask.Size(); ones < 16 || ones > 28 {
+			return nil, fmt.Errorf("podSubnets CIDR %q for %s must have a prefix length between /16 and /28", cidr, az)
+		}
+	}
+	return podSubnets, nil
+}
+
+// CreatePodSubnets creates the pod subnets from `podSubnets`, one in every availability zone of the
+// network's region the node subnets are in and no others, as a node whose zone
+// has no pod subnet cannot start pods. The CIDRs must be inside the VPC, usually in a secondary
+// CIDR block such as 100.64.0.0/16 associated with it beforehand. Returns the
+// subnets by zone, or nil when unset.
+func (n *Network) CreatePodSubnets(ctx *pulumi.Context, cfg *config.Config) (map[string]*ec2.Subnet, error) {
+	podSubnets, err := podSubnetsConfig(cfg)
+	if err != nil || podSubnets == nil {
+		return nil, err
+	}
+	nodeAzs := n.AvailabilityZones()
+	var missing []string
+	for _, az := range nodeAzs {
+		if _, ok := podSubnets[az]; !ok {
+			missing = append(missing, az)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("podSubnets has no CIDR for %s, where the cluster has node subnets", strings.Join(missing, ", "))
+	}
+	region, err := aws.GetRegion(ctx, nil, n.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	// Zones of other regions are left to the replica region's network
+	var extra []string
+	for az := range podSubnets {
+		if strings.HasPrefix(az, region.Name) && !stackconfig.ContainsString(nodeAzs, az) {
+			extra = append(extra, az)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return nil, fmt.Errorf("podSubnets has CIDRs for %s, where the cluster has no node subnets (node subnets are in %s)",
+			strings.Join(extra, ", "), strings.Join(nodeAzs, ", "))
+	}
+
+	subnets := map[string]*ec2.Subnet{}
+	for _, az := range nodeAzs {
+		cidr := podSubnets[az]
+		if err := checkCidrInVpc(cidr, n.VpcCidrs); err != nil {
+			return nil, fmt.Errorf("podSubnets CIDR %q for %s: %w", cidr, az, err)
+		}
+		subnet, err := ec2.NewSubnet(ctx, fmt.Sprintf("pod-subnet-%s", az), &ec2.SubnetArgs{
+			VpcId:            n.VpcId,
+			AvailabilityZone: pulumi.String(az),
+			CidrBlock:        pulumi.String(cidr),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(fmt.Sprintf("aws-demo-pods-%s", az)),
+			},
+		}, n.ResourceOpts()...)
+		if err != nil {
+			return nil, err
+		}
+		subnets[az] = subnet
+	}
+	return subnets, nil
+}
+
+func checkCidrInVpc(cidr string, vpcCidrs []string) error {
+	_, inner, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	for _, vpcCidr := range vpcCidrs {
+		_, vpcNet, err := net.ParseCIDR(vpcCidr)
+		if err == nil && cidrContains(vpcNet, inner) {
+			return nil
+		}
+	}
+	return fmt.Errorf("not inside any of the VPC CIDRs %v; associate a secondary CIDR block with the VPC first", vpcCidrs)
+}
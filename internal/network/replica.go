@@ -0,0 +1,50 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Groups the replica region's resources. Its AWS provider is inherited by every
+// child, and the children's URNs carry its type, so they can reuse the primary
+// region's resource names.
+type replicaRegion struct {
+	pulumi.ResourceState
+}
+
+// LookupReplicaNetwork reads back the default VPC and public subnets of
+// `replicaRegion`, for building disaster-recovery replicas of the clusters there.
+// Everything built on the returned network is created in that region; opts are
+// added to the component the region's resources are parented to.
+func LookupReplicaNetwork(ctx *pulumi.Context, cfg *config.Config, opts ...pulumi.ResourceOption) (*Network, error) {
+	region := cfg.Get("replicaRegion")
+	if region == "" {
+		return nil, fmt.Errorf("replicaRegion must be set when enableReplicaRegion is true")
+	}
+	primary, err := aws.GetRegion(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if region == primary.Name {
+		return nil, fmt.Errorf("replicaRegion must differ from the stack's region %s", primary.Name)
+	}
+
+	provider, err := aws.NewProvider(ctx, "replica-aws", &aws.ProviderArgs{
+		Region: pulumi.String(region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	component := &replicaRegion{}
+	err = ctx.RegisterComponentResource("eksdemo:index:ReplicaRegion", "replica", component, append(opts, pulumi.Providers(provider))...)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.RegisterResourceOutputs(component, pulumi.Map{}); err != nil {
+		return nil, err
+	}
+	return lookupNetwork(ctx, cfg, component)
+}
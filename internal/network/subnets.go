@@ -0,0 +1,93 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// FargateSubnetIds reads the subnets Fargate pods run in. Fargate only supports private subnets,
+// which the default VPC does not have, so they must be given explicitly there.
+// A created VPC's private subnets are used when `fargateSubnetIds` is unset.
+func (n *Network) FargateSubnetIds(cfg *config.Config) (pulumi.StringArrayInput, error) {
+	var ids []string
+	if err := cfg.GetObject("fargateSubnetIds", &ids); err != nil {
+		return nil, fmt.Errorf("fargateSubnetIds must be a list of subnet IDs: %w", err)
+	}
+	if len(ids) > 0 {
+		return stackconfig.ToPulumiStringArray(ids), nil
+	}
+	if n.defaultVpc == nil {
+		return SubnetIds(n.Subnets), nil
+	}
+	return nil, fmt.Errorf("fargateSubnetIds must list the private subnets to run Fargate pods in when enableFargate is true")
+}
+
+// EKS requires the cluster subnets to span at least two availability zones.
+const minClusterAzs = 2
+
+// Limit the subnets handed to the cluster to `maxSubnets`, picking them round-robin
+// across availability zones so the selection is spread as widely as possible.
+// All subnets are returned when `maxSubnets` is unset.
+func selectSubnets(ctx *pulumi.Context, cfg *config.Config, subnetIds []string, opts ...pulumi.InvokeOption) ([]string, error) {
+	maxSubnets := cfg.GetInt("maxSubnets")
+	if maxSubnets == 0 {
+		return subnetIds, nil
+	}
+	if maxSubnets < minClusterAzs {
+		return nil, fmt.Errorf("maxSubnets must be at least %d, got %d", minClusterAzs, maxSubnets)
+	}
+
+	subnetsByAz, azs, err := groupSubnetsByAz(ctx, subnetIds, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []string
+	usedAzs := map[string]bool{}
+	for round := 0; len(selected) < maxSubnets; round++ {
+		picked := false
+		for _, az := range azs {
+			if round < len(subnetsByAz[az]) && len(selected) < maxSubnets {
+				selected = append(selected, subnetsByAz[az][round])
+				usedAzs[az] = true
+				picked = true
+			}
+		}
+		if !picked {
+			break
+		}
+	}
+	if len(usedAzs) < minClusterAzs {
+		return nil, fmt.Errorf("selected subnets %v span %d availability zone(s), EKS requires at least %d",
+			selected, len(usedAzs), minClusterAzs)
+	}
+	return selected, nil
+}
+
+// Group subnets by availability zone. Returns the zones in sorted order and the
+// subnets of each, also sorted.
+func groupSubnetsByAz(ctx *pulumi.Context, subnetIds []string, opts ...pulumi.InvokeOption) (map[string][]string, []string, error) {
+	ids := append([]string(nil), subnetIds...)
+	sort.Strings(ids)
+	subnetsByAz := map[string][]string{}
+	var azs []string
+	for _, id := range ids {
+		subnetId := id
+		subnet, err := ec2.LookupSubnet(ctx, &ec2.LookupSubnetArgs{Id: &subnetId}, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := subnetsByAz[subnet.AvailabilityZone]; !ok {
+			azs = append(azs, subnet.AvailabilityZone)
+		}
+		subnetsByAz[subnet.AvailabilityZone] = append(subnetsByAz[subnet.AvailabilityZone], id)
+	}
+	sort.Strings(azs)
+	return subnetsByAz, azs, nil
+}
@@ -0,0 +1,27 @@
+package network
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ValidateConfig runs the checks of the network settings that only need the
+// stack config, and returns all the problems found as one error. Returns nil
+// when they are valid.
+func ValidateConfig(cfg *config.Config, environments []string) error {
+	var errs stackconfig.Errors
+	_, err := podSubnetsConfig(cfg)
+	errs.Check(err)
+	_, _, err = vpcEndpointsConfig(cfg)
+	errs.Check(err)
+	if cfg.GetBool("enableFlowLogs") {
+		_, err = flowLogConfig(cfg)
+		errs.Check(err)
+	}
+	if !stackconfig.GetBoolDefault(cfg, "useDefaultVpc", true) {
+		_, err = dedicatedVpcConfig(cfg)
+		errs.Check(err)
+	}
+	return errs.Err()
+}
@@ -0,0 +1,272 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const (
+	defaultVpcCidr = "10.0.0.0/16"
+	// The zones the dedicated VPC spans, when the region has as many.
+	dedicatedVpcAzs = 3
+)
+
+// Groups the dedicated VPC's resources. Its children's URNs carry its type, so
+// the replica region's VPC can reuse the same names.
+type dedicatedVpc struct {
+	pulumi.ResourceState
+}
+
+// Read `vpcCidr`, the IPv4 range of the VPC created with `useDefaultVpc` false.
+// It is split into eight equal blocks: the private subnets take the first three
+// and the public ones the fifth to seventh, so it must leave room for subnets of
+// at least a /24.
+func dedicatedVpcConfig(cfg *config.Config) (*net.IPNet, error) {
+	if cfg.Get("maxSubnets") != "" {
+		return nil, fmt.Errorf("maxSubnets only limits the default VPC's subnets and cannot be set with useDefaultVpc false")
+	}
+	cidr := cfg.Get("vpcCidr")
+	if cidr == "" {
+		cidr = defaultVpcCidr
+	}
+	ip, vpcNet, err := net.ParseCIDR(cidr)
+	if err != nil || vpcNet.IP.To4() == nil || !ip.Equal(vpcNet.IP) {
+		return nil, fmt.Errorf("vpcCidr %q is not a valid IPv4 network CIDR", cidr)
+	}
+	if ones, _ := vpcNet.Mask.Size(); ones < 16 || ones > 21 {
+		return nil, fmt.Errorf("vpcCidr %q must have a prefix length between /16 and /21", cidr)
+	}
+	return vpcNet, nil
+}
+
+// The index-th of the blocks newBits longer than the prefix of network.
+func cidrSubnet(network *net.IPNet, newBits int, index int) string {
+	ones, _ := network.Mask.Size()
+	start := binary.BigEndian.Uint32(network.IP.To4()) | uint32(index)<<(32-ones-newBits)
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, start)
+	return fmt.Sprintf("%s/%d", ip, ones+newBits)
+}
+
+// The index-th /64 of vpcCidr, the /56 IPv6 block AWS assigns a VPC.
+func ipv6CidrSubnet(vpcCidr string, index int) (string, error) {
+	_, network, err := net.ParseCIDR(vpcCidr)
+	if err != nil || network.IP.To4() != nil {
+		return "", fmt.Errorf("the VPC's IPv6 CIDR block %q is not an IPv6 network", vpcCidr)
+	}
+	if ones, _ := network.Mask.Size(); ones != 56 {
+		return "", fmt.Errorf("the VPC's IPv6 CIDR block %q is not a /56", vpcCidr)
+	}
+	ip := append(net.IP(nil), network.IP...)
+	ip[7] = byte(index)
+	return fmt.Sprintf("%s/64", ip), nil
+}
+
+// Create the VPC used with `useDefaultVpc` false into network: a public and a
+// private subnet in each of the first three zones of the region, each private
+// subnet reaching the internet through a NAT gateway in its zone's public subnet.
+// The subnets carry the tags the AWS Load Balancer Controller discovers them by,
+// public ones for internet-facing load balancers and private ones for internal
+// ones. The clusters and their nodes go in the private subnets. With an
+// `ipFamily` of ipv6 the VPC also gets an Amazon-provided IPv6 block, each subnet
+// a /64 of it with addresses assigned on launch, and the private subnets IPv6
+// egress through an egress-only internet gateway.
+func createDedicatedNetwork(ctx *pulumi.Context, cfg *config.Config, network *Network) (*Network, error) {
+	vpcNet, err := dedicatedVpcConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ipv6 := ClusterIpFamily(cfg) == IpFamilyIpv6
+	state := "available"
+	zones, err := aws.GetAvailabilityZones(ctx, &aws.GetAvailabilityZonesArgs{
+		State: &state,
+		// Leaves out the Local and Wavelength Zones
+		Filters: []aws.GetAvailabilityZonesFilter{
+			{Name: "opt-in-status", Values: []string{"opt-in-not-required"}},
+		},
+	}, network.InvokeOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	azs := append([]string(nil), zones.Names...)
+	sort.Strings(azs)
+	if len(azs) < minClusterAzs {
+		return nil, fmt.Errorf("the region has %d availability zone(s), EKS requires at least %d", len(azs), minClusterAzs)
+	}
+	if len(azs) > dedicatedVpcAzs {
+		azs = azs[:dedicatedVpcAzs]
+	}
+
+	component := &dedicatedVpc{}
+	err = ctx.RegisterComponentResource("eksdemo:index:Vpc", "aws-demo-vpc", component, network.ResourceOpts()...)
+	if err != nil {
+		return nil, err
+	}
+	parent := pulumi.Parent(component)
+	vpc, err := ec2.NewVpc(ctx, "aws-demo-vpc", &ec2.VpcArgs{
+		CidrBlock: pulumi.String(vpcNet.String()),
+		// For the private DNS of VPC endpoints, such as those of restrictNodeEgress
+		EnableDnsSupport:             pulumi.Bool(true),
+		EnableDnsHostnames:           pulumi.Bool(true),
+		AssignGeneratedIpv6CidrBlock: pulumi.Bool(ipv6),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("aws-demo"),
+		},
+	}, parent)
+	if err != nil {
+		return nil, err
+	}
+	// The /64 of the VPC's IPv6 block at index, or nil without IPv6
+	subnetIpv6Cidr := func(index int) pulumi.StringPtrInput {
+		if !ipv6 {
+			return nil
+		}
+		return vpc.Ipv6CidrBlock.ApplyT(func(cidr string) (string, error) {
+			return ipv6CidrSubnet(cidr, index)
+		}).(pulumi.StringOutput)
+	}
+	igw, err := ec2.NewInternetGateway(ctx, "aws-demo-vpc-igw", &ec2.InternetGatewayArgs{
+		VpcId: vpc.ID(),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("aws-demo"),
+		},
+	}, parent)
+	if err != nil {
+		return nil, err
+	}
+	publicRoutes := ec2.RouteTableRouteArray{
+		ec2.RouteTableRouteArgs{CidrBlock: pulumi.String("0.0.0.0/0"), GatewayId: igw.ID()},
+	}
+	var egressOnlyGateway *ec2.EgressOnlyInternetGateway
+	if ipv6 {
+		publicRoutes = append(publicRoutes, ec2.RouteTableRouteArgs{Ipv6CidrBlock: pulumi.String("::/0"), GatewayId: igw.ID()})
+		egressOnlyGateway, err = ec2.NewEgressOnlyInternetGateway(ctx, "aws-demo-vpc-eigw", &ec2.EgressOnlyInternetGatewayArgs{
+			VpcId: vpc.ID(),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String("aws-demo"),
+			},
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	publicRouteTable, err := ec2.NewRouteTable(ctx, "aws-demo-vpc-public-rt", &ec2.RouteTableArgs{
+		VpcId:  vpc.ID(),
+		Routes: publicRoutes,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String("aws-demo-public"),
+		},
+	}, parent)
+	if err != nil {
+		return nil, err
+	}
+	routeTableIds := pulumi.StringArray{publicRouteTable.ID()}
+
+	// No kubernetes.io/cluster/<name> tags: the cluster names are generated, and
+	// the load balancer controller has not needed them since 2.1.1
+	var privateSubnets, publicSubnets []Subnet
+	for i, az := range azs {
+		public, err := ec2.NewSubnet(ctx, fmt.Sprintf("aws-demo-vpc-public-%s", az), &ec2.SubnetArgs{
+			VpcId:                       vpc.ID(),
+			AvailabilityZone:            pulumi.String(az),
+			CidrBlock:                   pulumi.String(cidrSubnet(vpcNet, 3, 4+i)),
+			Ipv6CidrBlock:               subnetIpv6Cidr(4 + i),
+			AssignIpv6AddressOnCreation: pulumi.Bool(ipv6),
+			MapPublicIpOnLaunch:         pulumi.Bool(true),
+			Tags: pulumi.StringMap{
+				"Name":                   pulumi.String(fmt.Sprintf("aws-demo-public-%s", az)),
+				"kubernetes.io/role/elb": pulumi.String("1"),
+			},
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("aws-demo-vpc-public-%s", az), &ec2.RouteTableAssociationArgs{
+			SubnetId:     public.ID(),
+			RouteTableId: publicRouteTable.ID(),
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		eip, err := ec2.NewEip(ctx, fmt.Sprintf("aws-demo-vpc-nat-%s", az), &ec2.EipArgs{
+			Vpc: pulumi.Bool(true),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(fmt.Sprintf("aws-demo-nat-%s", az)),
+			},
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		nat, err := ec2.NewNatGateway(ctx, fmt.Sprintf("aws-demo-vpc-nat-%s", az), &ec2.NatGatewayArgs{
+			AllocationId: eip.ID(),
+			SubnetId:     public.ID(),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(fmt.Sprintf("aws-demo-%s", az)),
+			},
+		}, parent, pulumi.DependsOn([]pulumi.Resource{igw}))
+		if err != nil {
+			return nil, err
+		}
+
+		private, err := ec2.NewSubnet(ctx, fmt.Sprintf("aws-demo-vpc-private-%s", az), &ec2.SubnetArgs{
+			VpcId:                       vpc.ID(),
+			AvailabilityZone:            pulumi.String(az),
+			CidrBlock:                   pulumi.String(cidrSubnet(vpcNet, 3, i)),
+			Ipv6CidrBlock:               subnetIpv6Cidr(i),
+			AssignIpv6AddressOnCreation: pulumi.Bool(ipv6),
+			Tags: pulumi.StringMap{
+				"Name":                            pulumi.String(fmt.Sprintf("aws-demo-private-%s", az)),
+				"kubernetes.io/role/internal-elb": pulumi.String("1"),
+			},
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		privateRoutes := ec2.RouteTableRouteArray{
+			ec2.RouteTableRouteArgs{CidrBlock: pulumi.String("0.0.0.0/0"), NatGatewayId: nat.ID()},
+		}
+		if egressOnlyGateway != nil {
+			privateRoutes = append(privateRoutes, ec2.RouteTableRouteArgs{
+				Ipv6CidrBlock:       pulumi.String("::/0"),
+				EgressOnlyGatewayId: egressOnlyGateway.ID(),
+			})
+		}
+		privateRouteTable, err := ec2.NewRouteTable(ctx, fmt.Sprintf("aws-demo-vpc-private-%s", az), &ec2.RouteTableArgs{
+			VpcId:  vpc.ID(),
+			Routes: privateRoutes,
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(fmt.Sprintf("aws-demo-private-%s", az)),
+			},
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("aws-demo-vpc-private-%s", az), &ec2.RouteTableAssociationArgs{
+			SubnetId:     private.ID(),
+			RouteTableId: privateRouteTable.ID(),
+		}, parent)
+		if err != nil {
+			return nil, err
+		}
+		routeTableIds = append(routeTableIds, privateRouteTable.ID())
+		publicSubnets = append(publicSubnets, Subnet{Id: public.ID(), AvailabilityZone: az})
+		privateSubnets = append(privateSubnets, Subnet{Id: private.ID(), AvailabilityZone: az})
+	}
+	if err := ctx.RegisterResourceOutputs(component, pulumi.Map{"vpcId": vpc.ID()}); err != nil {
+		return nil, err
+	}
+
+	network.VpcId = vpc.ID().ToStringOutput()
+	network.VpcCidrs = []string{vpcNet.String()}
+	network.Subnets = privateSubnets
+	network.PublicSubnets = publicSubnets
+	network.routeTableIds = routeTableIds
+	return network, nil
+}
@@ -0,0 +1,233 @@
+// Package pulumitest runs the program's functions against mocks of AWS and
+// Kubernetes, for the tests of every package.
+package pulumitest
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// Project is the name of the Pulumi project the tests run as, which prefixes
+// their config keys.
+const Project = "eksdemo"
+
+// Mocks records every resource the program registers and answers the AWS
+// invokes it makes with a default VPC whose subnets are spread over two zones.
+type Mocks struct {
+	mu        sync.Mutex
+	resources []pulumi.MockResourceArgs
+	SubnetAzs map[string]string
+	// A zone left out of the instance type offerings
+	MissingOfferingAz string
+	// The order getSubnetIds lists the subnets in, when set
+	SubnetOrder []string
+	// Turns off DNS hostnames in the default VPC
+	NoVpcDnsHostnames bool
+	// Renders every chart as a ConfigMap named after its release
+	RenderCharts bool
+	// Holds back resources of this type, so only what depends on them waits
+	SlowType string
+	// The outputs of the last update of the stack, which stack references read
+	StackOutputs map[string]interface{}
+}
+
+// NewMocks returns Mocks with two subnets in each of eu-west-1a and eu-west-1b.
+func NewMocks() *Mocks {
+	return &Mocks{SubnetAzs: map[string]string{
+		"subnet-a1": "eu-west-1a",
+		"subnet-a2": "eu-west-1a",
+		"subnet-b1": "eu-west-1b",
+		"subnet-b2": "eu-west-1b",
+	}}
+}
+
+// NewResource records the resource and fills in the outputs AWS would.
+func (m *Mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	if args.TypeToken == m.SlowType {
+		time.Sleep(200 * time.Millisecond)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resources = append(m.resources, args)
+	outputs := args.Inputs.Copy()
+	if args.TypeToken == "aws:eks/cluster:Cluster" {
+		// Stands in for the auto-generated name
+		outputs["name"] = resource.NewStringProperty(args.Name)
+		outputs["status"] = resource.NewStringProperty("ACTIVE")
+		vpcConfig := args.Inputs["vpcConfig"].ObjectValue().Copy()
+		vpcConfig["clusterSecurityGroupId"] = resource.NewStringProperty("sg-" + args.Name)
+		outputs["vpcConfig"] = resource.NewObjectProperty(vpcConfig)
+		outputs["identities"] = resource.NewPropertyValue([]interface{}{
+			map[string]interface{}{"oidcs": []interface{}{
+				map[string]interface{}{"issuer": "https://oidc.eks.eu-west-1.amazonaws.com/id/" + args.Name},
+			}},
+		})
+	}
+	if args.TypeToken == "aws:eks/nodeGroup:NodeGroup" {
+		outputs["nodeGroupName"] = resource.NewStringProperty(args.Name)
+		outputs["status"] = resource.NewStringProperty("ACTIVE")
+		outputs["resources"] = resource.NewPropertyValue([]interface{}{
+			map[string]interface{}{"autoscalingGroups": []interface{}{
+				map[string]interface{}{"name": args.Name + "-asg"},
+			}},
+		})
+	}
+	if args.TypeToken == "pulumi:pulumi:StackReference" {
+		stackOutputs := m.StackOutputs
+		if stackOutputs == nil {
+			stackOutputs = map[string]interface{}{}
+		}
+		outputs["outputs"] = resource.NewPropertyValue(stackOutputs)
+	}
+	if args.TypeToken == "aws:iam/role:Role" {
+		outputs["arn"] = resource.NewStringProperty("arn:aws:iam::123456789012:role/" + args.Name)
+	}
+	if args.TypeToken == "aws:ec2/vpc:Vpc" && args.Inputs["assignGeneratedIpv6CidrBlock"].IsBool() &&
+		args.Inputs["assignGeneratedIpv6CidrBlock"].BoolValue() {
+		outputs["ipv6CidrBlock"] = resource.NewStringProperty("2001:db8:1200::/56")
+	}
+	if args.TypeToken == "aws:acm/certificate:Certificate" {
+		outputs["arn"] = resource.NewStringProperty("arn:aws:acm:eu-west-1:123456789012:certificate/" + args.Name)
+		outputs["domainValidationOptions"] = resource.NewPropertyValue([]interface{}{map[string]interface{}{
+			"resourceRecordName":  "_a1." + args.Inputs["domainName"].StringValue() + ".",
+			"resourceRecordType":  "CNAME",
+			"resourceRecordValue": "_b2.acm-validations.aws.",
+		}})
+	}
+	return args.Name + "_id", outputs, nil
+}
+
+// Call answers the invokes the program makes.
+func (m *Mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	switch args.Token {
+	case "aws:ec2/getVpc:getVpc":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"id":                 "vpc-123",
+			"cidrBlock":          "172.31.0.0/16",
+			"enableDnsSupport":   true,
+			"enableDnsHostnames": !m.NoVpcDnsHostnames,
+		}), nil
+	case "aws:ec2/getRouteTables:getRouteTables":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"ids": []interface{}{"rtb-123"}}), nil
+	case "aws:ec2/getSubnetIds:getSubnetIds":
+		var ids []interface{}
+		for id := range m.SubnetAzs {
+			ids = append(ids, id)
+		}
+		if m.SubnetOrder != nil {
+			ids = nil
+			for _, id := range m.SubnetOrder {
+				ids = append(ids, id)
+			}
+		}
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"ids": ids}), nil
+	case "aws:ec2/getSubnet:getSubnet":
+		id := args.Args["id"].StringValue()
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"id":               id,
+			"availabilityZone": m.SubnetAzs[id],
+		}), nil
+	case "aws:ssm/getParameter:getParameter":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"value": "ami-123"}), nil
+	case "aws:ec2/getInstanceTypeOfferings:getInstanceTypeOfferings":
+		var locations []interface{}
+		for _, az := range m.SubnetAzs {
+			if az != m.MissingOfferingAz {
+				locations = append(locations, az)
+			}
+		}
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"locations": locations}), nil
+	case "aws:ec2/getInstanceType:getInstanceType":
+		hypervisor := "nitro"
+		if strings.HasPrefix(args.Args["instanceType"].StringValue(), "t2.") {
+			hypervisor = "xen"
+		}
+		result := map[string]interface{}{"hypervisor": hypervisor, "supportedArchitectures": []interface{}{"x86_64"}}
+		if strings.HasPrefix(args.Args["instanceType"].StringValue(), "g4dn.") {
+			result["gpuses"] = []interface{}{map[string]interface{}{"count": 1, "manufacturer": "NVIDIA", "name": "T4"}}
+		}
+		return resource.NewPropertyMapFromMap(result), nil
+	case "aws:index/getAvailabilityZones:getAvailabilityZones":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"names": []interface{}{"eu-west-1c", "eu-west-1a", "eu-west-1b"},
+		}), nil
+	case "aws:index/getRegion:getRegion":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"name": "eu-west-1"}), nil
+	case "kubernetes:helm:template":
+		if !m.RenderCharts {
+			break
+		}
+		var opts struct {
+			ReleaseName string `json:"release_name"`
+			Namespace   string `json:"namespace"`
+		}
+		if err := json.Unmarshal([]byte(args.Args["jsonOpts"].StringValue()), &opts); err != nil {
+			return nil, err
+		}
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"result": []interface{}{
+			map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{
+				"name": opts.ReleaseName, "namespace": opts.Namespace,
+			}},
+		}}), nil
+	case "kubernetes:kustomize:directory":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"result": []interface{}{
+			map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "web"}},
+			map[string]interface{}{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRole", "metadata": map[string]interface{}{"name": "web"}},
+		}}), nil
+	case "aws:acm/getCertificate:getCertificate":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"arn": "arn:aws:acm:eu-west-1:123456789012:certificate/" + args.Args["domain"].StringValue(),
+		}), nil
+	case "aws:route53/getZone:getZone":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"zoneId": "Z123", "name": args.Args["name"].StringValue()}), nil
+	case "aws:index/getCallerIdentity:getCallerIdentity":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{"accountId": "123456789012"}), nil
+	}
+	return resource.PropertyMap{}, nil
+}
+
+// ByType returns the resources of type typ registered so far.
+func (m *Mocks) ByType(typ string) []pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found []pulumi.MockResourceArgs
+	for _, r := range m.resources {
+		if r.TypeToken == typ {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Registered returns every resource registered so far, in the order it was.
+func (m *Mocks) Registered() []pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]pulumi.MockResourceArgs{}, m.resources...)
+}
+
+// WithConfig sets the project's config keys to values.
+func WithConfig(values map[string]string) pulumi.RunOption {
+	return func(info *pulumi.RunInfo) {
+		// The tests install charts without reaching their repos, unless they say otherwise
+		info.Config = map[string]string{Project + ":skipHelmRepoCheck": "true"}
+		for k, v := range values {
+			info.Config[Project+":"+k] = v
+		}
+	}
+}
+
+// Run runs body as a Pulumi program against m, with the config values.
+func Run(t *testing.T, m *Mocks, values map[string]string, body func(ctx *pulumi.Context, cfg *config.Config) error) error {
+	t.Helper()
+	return pulumi.RunErr(func(ctx *pulumi.Context) error {
+		return body(ctx, config.New(ctx, ""))
+	}, pulumi.WithMocks(Project, "test", m), WithConfig(values))
+}
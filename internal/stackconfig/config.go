@@ -0,0 +1,82 @@
+// Package stackconfig reads the stack config settings every area of the program
+// shares: the environments, the per-environment values and log retention.
+package stackconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// ToPulumiStringArray converts a to a Pulumi string array.
+func ToPulumiStringArray(a []string) pulumi.StringArrayInput {
+	var res []pulumi.StringInput
+	for _, s := range a {
+		res = append(res, pulumi.String(s))
+	}
+	return pulumi.StringArray(res)
+}
+
+// GetBoolDefault reads an optional bool config value, falling back to def when it is unset.
+func GetBoolDefault(cfg *config.Config, key string, def bool) bool {
+	v, err := cfg.TryBool(key)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// GetEnvBool reads a per-environment bool, given as an object from environment name to value
+// (e.g. `{"test": true, "prod": false}`), falling back to def for environments
+// that are not listed.
+func GetEnvBool(cfg *config.Config, key string, env string, def bool) (bool, error) {
+	var byEnv map[string]bool
+	if err := cfg.GetObject(key, &byEnv); err != nil {
+		return false, fmt.Errorf("%s must map environment names to true or false: %w", key, err)
+	}
+	if v, ok := byEnv[env]; ok {
+		return v, nil
+	}
+	return def, nil
+}
+
+// GetEnvString reads a per-environment string, given as an object from environment name to
+// value. Returns "" for environments that are not listed.
+func GetEnvString(cfg *config.Config, key string, env string) (string, error) {
+	var byEnv map[string]string
+	if err := cfg.GetObject(key, &byEnv); err != nil {
+		return "", fmt.Errorf("%s must map environment names to strings: %w", key, err)
+	}
+	return byEnv[env], nil
+}
+
+// ContainsString reports whether values contains value.
+func ContainsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DnsLabel matches DNS labels. Environment names end up in Kubernetes namespace
+// names, so they must be DNS labels.
+var DnsLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// OptionalInt reads an int config value, reporting whether it was set at all so that an
+// explicit 0 can be told apart from a missing key.
+func OptionalInt(cfg *config.Config, key string) (int, bool, error) {
+	raw := cfg.Get(key)
+	if raw == "" {
+		return 0, false, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s must be an integer, got %q", key, raw)
+	}
+	return v, true, nil
+}
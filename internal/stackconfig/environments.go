@@ -0,0 +1,137 @@
+package stackconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// The environments provisioned when the `environments` config list is unset.
+var defaultEnvironments = []string{"test", "prod"}
+
+var kubernetesVersion = regexp.MustCompile(`^1\.[0-9]+$`)
+
+// An entry of the `environments` config list. Set fields override the stack-wide
+// node group and cluster settings for that environment alone.
+type environmentConfig struct {
+	Name              string `json:"name"`
+	InstanceType      string `json:"instanceType"`
+	DesiredSize       *int   `json:"desiredSize"`
+	MinSize           *int   `json:"minSize"`
+	MaxSize           *int   `json:"maxSize"`
+	KubernetesVersion string `json:"kubernetesVersion"`
+}
+
+// Read and check the `environments` config list.
+func environmentsConfig(cfg *config.Config) ([]environmentConfig, error) {
+	var environments []environmentConfig
+	if err := cfg.GetObject("environments", &environments); err != nil {
+		return nil, fmt.Errorf("environments must be a list of {name, instanceType, desiredSize, minSize, maxSize, kubernetesVersion} objects: %w", err)
+	}
+	for i, env := range environments {
+		if env.Name == "" {
+			return nil, fmt.Errorf("environments entry %d has no name", i)
+		}
+		if env.KubernetesVersion != "" && !kubernetesVersion.MatchString(env.KubernetesVersion) {
+			return nil, fmt.Errorf("environments entry %s kubernetesVersion must be a minor version such as 1.23, got %q",
+				env.Name, env.KubernetesVersion)
+		}
+	}
+	return environments, nil
+}
+
+// Environments returns the names of the environments the `environments` config
+// list defines, in order, or test and prod when it is unset.
+func Environments(cfg *config.Config) ([]string, error) {
+	environments, err := environmentsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(environments) == 0 {
+		return defaultEnvironments, nil
+	}
+	names := make([]string, len(environments))
+	for i, env := range environments {
+		names[i] = env.Name
+	}
+	return names, nil
+}
+
+// EnvironmentSettings returns the `environments` entry of env, or the zero value when it has none, which
+// keeps the stack-wide settings.
+func EnvironmentSettings(cfg *config.Config, env string) (environmentConfig, error) {
+	environments, err := environmentsConfig(cfg)
+	if err != nil {
+		return environmentConfig{}, err
+	}
+	for _, e := range environments {
+		if e.Name == env {
+			return e, nil
+		}
+	}
+	return environmentConfig{}, nil
+}
+
+// KubernetesVersionConfig returns the Kubernetes version env's `environments` entry pins its cluster and node
+// groups to, or nil to leave new clusters on the EKS default and existing ones
+// where they are.
+func KubernetesVersionConfig(cfg *config.Config, env string) (pulumi.StringPtrInput, error) {
+	settings, err := EnvironmentSettings(cfg, env)
+	if err != nil || settings.KubernetesVersion == "" {
+		return nil, err
+	}
+	return pulumi.String(settings.KubernetesVersion), nil
+}
+
+// FilterEnvironments narrows the environments to provision down to the `onlyEnvironments` config list.
+// Every entry must name a known environment. All environments are returned when
+// the list is unset. The environments left out are no longer declared, so the
+// caller must make sure none of them has resources in the stack yet.
+//
+// With `environmentPerStack` set, only the environment named like the stack is
+// provisioned, so each environment can be deployed and destroyed on its own.
+func FilterEnvironments(ctx *pulumi.Context, cfg *config.Config, environments []string) ([]string, error) {
+	var only []string
+	if err := cfg.GetObject("onlyEnvironments", &only); err != nil {
+		return nil, fmt.Errorf("reading onlyEnvironments: %w", err)
+	}
+	if cfg.GetBool("environmentPerStack") {
+		if len(only) > 0 {
+			return nil, fmt.Errorf("onlyEnvironments cannot be combined with environmentPerStack")
+		}
+		for _, env := range environments {
+			if env == ctx.Stack() {
+				return []string{env}, nil
+			}
+		}
+		return nil, fmt.Errorf("environmentPerStack is set but stack %q is not a known environment, expected one of %s",
+			ctx.Stack(), strings.Join(environments, ", "))
+	}
+	if len(only) == 0 {
+		return environments, nil
+	}
+
+	known := map[string]bool{}
+	for _, env := range environments {
+		known[env] = true
+	}
+	wanted := map[string]bool{}
+	for _, env := range only {
+		if !known[env] {
+			return nil, fmt.Errorf("onlyEnvironments entry %q is not a known environment, expected one of %s",
+				env, strings.Join(environments, ", "))
+		}
+		wanted[env] = true
+	}
+
+	var filtered []string
+	for _, env := range environments {
+		if wanted[env] {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered, nil
+}
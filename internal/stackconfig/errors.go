@@ -0,0 +1,50 @@
+package stackconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors are every problem the ValidateConfig checks found, reported together.
+type Errors []error
+
+// Error lists the problems, one per line when there are several.
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  - " + err.Error()
+	}
+	return fmt.Sprintf("%d config problems:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+// Check adds err to the problems found, or each of them when it is Errors. A
+// problem with a stack-wide setting is found again for every environment, so
+// one already reported is skipped.
+func (e *Errors) Check(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(Errors); ok {
+		for _, err := range errs {
+			e.Check(err)
+		}
+		return
+	}
+	for _, reported := range *e {
+		if reported.Error() == err.Error() {
+			return
+		}
+	}
+	*e = append(*e, err)
+}
+
+// Err returns the problems found as one error, or nil when there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
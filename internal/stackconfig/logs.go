@@ -0,0 +1,36 @@
+package stackconfig
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const defaultLogRetentionDays = 30
+
+// The retention periods CloudWatch Logs accepts.
+var validLogRetentionDays = map[int]bool{
+	1: true, 3: true, 5: true, 7: true, 14: true, 30: true, 60: true, 90: true, 120: true, 150: true,
+	180: true, 365: true, 400: true, 545: true, 731: true, 1827: true, 3653: true,
+}
+
+// LogRetentionDays reads how many days the CloudWatch log groups the program creates keep their
+// events, from `logRetentionDays`. A log group with a setting of its own from
+// before that one, like `containerInsightsLogRetentionDays`, passes it as
+// overrideKey, which wins when set.
+func LogRetentionDays(cfg *config.Config, overrideKey string) (int, error) {
+	var days int
+	if overrideKey != "" {
+		days = cfg.GetInt(overrideKey)
+	}
+	if days == 0 {
+		days = cfg.GetInt("logRetentionDays")
+	}
+	if days == 0 {
+		days = defaultLogRetentionDays
+	}
+	if !validLogRetentionDays[days] {
+		return 0, fmt.Errorf("log retention must be one of CloudWatch's periods such as 7, 30 or 365 days, got %d", days)
+	}
+	return days, nil
+}
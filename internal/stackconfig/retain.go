@@ -0,0 +1,18 @@
+package stackconfig
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// DataResourceOpts returns the options for the resources that hold data worth keeping after the stack is
+// gone: the VPC flow log group, the control plane and Container Insights log
+// groups, the Prometheus workspaces and the KMS keys. With `retainDataOnDelete`
+// set, `pulumi destroy` and replacements drop them from the stack but leave them
+// in the account, to be cleaned up by hand. Compute and networking are always deleted.
+func DataResourceOpts(cfg *config.Config, opts ...pulumi.ResourceOption) []pulumi.ResourceOption {
+	if cfg.GetBool("retainDataOnDelete") {
+		opts = append(opts, pulumi.RetainOnDelete(true))
+	}
+	return opts
+}
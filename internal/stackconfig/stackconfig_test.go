@@ -0,0 +1,80 @@
+package stackconfig
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/pulumitest"
+)
+
+func TestFilterEnvironments(t *testing.T) {
+	all := []string{"test", "prod"}
+	var filtered []string
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"onlyEnvironments": `["test"]`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		filtered, err = FilterEnvironments(ctx, cfg, all)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(filtered, ",") != "test" {
+		t.Errorf("expected only test, got %v", filtered)
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"onlyEnvironments": `["staging"]`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := FilterEnvironments(ctx, cfg, all)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("expected unknown environment to be rejected, got %v", err)
+	}
+
+	// The mocked stack is called "test"
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"environmentPerStack": "true"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		filtered, err = FilterEnvironments(ctx, cfg, all)
+		return err
+	})
+	if err != nil || strings.Join(filtered, ",") != "test" {
+		t.Errorf("expected only the stack's environment, got %v, %v", filtered, err)
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	var test, prod, staging bool
+	err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoRolloutsDashboard": `{"prod": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var err error
+		if test, err = GetEnvBool(cfg, "argoRolloutsDashboard", "test", true); err != nil {
+			return err
+		}
+		if prod, err = GetEnvBool(cfg, "argoRolloutsDashboard", "prod", false); err != nil {
+			return err
+		}
+		staging, err = GetEnvBool(cfg, "argoRolloutsDashboard", "staging", false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !test || !prod || staging {
+		t.Errorf("expected listed environments to override the default, got test=%v prod=%v staging=%v", test, prod, staging)
+	}
+}
+
+func TestRetainDataOnDelete(t *testing.T) {
+	for value, want := range map[string]int{"": 0, "false": 0, "true": 1} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"retainDataOnDelete": value}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			if got := len(DataResourceOpts(cfg)); got != want {
+				t.Errorf("expected %d option(s) with retainDataOnDelete %q, got %d", want, value, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
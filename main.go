@@ -1,332 +1,136 @@
 package main
 
 import (
-	"fmt"
-
-	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ec2"
-	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
-	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
-	// appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
-	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
-	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
-	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/providers"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/addons"
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/stackconfig"
+	"aws-go-eks/pkg/eksdemo"
 )
 
 func main() {
 	pulumi.Run(func(ctx *pulumi.Context) error {
-		// Read back the default VPC and public subnets, which we will use.
-		t := true
-		vpc, err := ec2.LookupVpc(ctx, &ec2.LookupVpcArgs{Default: &t})
+		cfg := config.New(ctx, "")
+		// Record every resource name as it is declared, to catch collisions
+		names, err := eksdemo.TrackResourceNames(ctx)
 		if err != nil {
 			return err
 		}
-		subnet, err := ec2.GetSubnetIds(ctx, &ec2.GetSubnetIdsArgs{VpcId: vpc.Id})
+
+		eksClusters, err := stackconfig.Environments(cfg)
 		if err != nil {
 			return err
 		}
-		eksRole, err := iam.NewRole(ctx, "eks-iam-eksRole", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(`{
-		    "Version": "2008-10-17",
-		    "Statement": [{
-		        "Sid": "",
-		        "Effect": "Allow",
-		        "Principal": {
-		            "Service": "eks.amazonaws.com"
-		        },
-		        "Action": "sts:AssumeRole"
-		    }]
-		}`),
-		})
+		environments := eksClusters
+		eksClusters, err = stackconfig.FilterEnvironments(ctx, cfg, environments)
 		if err != nil {
 			return err
 		}
-		eksPolicies := []string{
-			"arn:aws:iam::aws:policy/AmazonEKSServicePolicy",
-			"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
+		// Refuse to drop environments that are already in the stack
+		if err := eksdemo.CheckExcludedEnvironments(ctx, environments, eksClusters); err != nil {
+			return err
 		}
-		for i, eksPolicy := range eksPolicies {
-			_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("rpa-%d", i), &iam.RolePolicyAttachmentArgs{
-				PolicyArn: pulumi.String(eksPolicy),
-				Role:      eksRole.Name,
-			})
-			if err != nil {
-				return err
-			}
+		if err := eksdemo.CheckResourceNames(eksClusters); err != nil {
+			return err
 		}
-		// Create the EC2 NodeGroup Role
-		nodeGroupRole, err := iam.NewRole(ctx, "nodegroup-iam-role", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(`{
-		    "Version": "2012-10-17",
-		    "Statement": [{
-		        "Sid": "",
-		        "Effect": "Allow",
-		        "Principal": {
-		            "Service": "ec2.amazonaws.com"
-		        },
-		        "Action": "sts:AssumeRole"
-		    }]
-		}`),
-		})
-		if err != nil {
+		// Report every config problem at once, before creating anything
+		if err := eksdemo.ValidateConfig(cfg, eksClusters); err != nil {
 			return err
 		}
-		nodeGroupPolicies := []string{
-			"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
-			"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
-			"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
+
+		// Read back the default VPC and public subnets, which we will use.
+		defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
 		}
-		for i, nodeGroupPolicy := range nodeGroupPolicies {
-			_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("ngpa-%d", i), &iam.RolePolicyAttachmentArgs{
-				Role:      nodeGroupRole.Name,
-				PolicyArn: pulumi.String(nodeGroupPolicy),
-			})
+		// Optionally enable VPC flow logs for network auditing
+		if cfg.GetBool("enableFlowLogs") {
+			flowLogDestination, err := network.CreateFlowLogs(ctx, cfg, defaultNetwork.VpcId)
 			if err != nil {
 				return err
 			}
+			ctx.Export("flowLogDestination", flowLogDestination)
 		}
-		// Create a Security Group that we can use to actually connect to our cluster
-		clusterSg, err := ec2.NewSecurityGroup(ctx, "test-cluster-sg", &ec2.SecurityGroupArgs{
-			VpcId: pulumi.String(vpc.Id),
-			Egress: ec2.SecurityGroupEgressArray{
-				ec2.SecurityGroupEgressArgs{
-					Protocol:   pulumi.String("-1"),
-					FromPort:   pulumi.Int(0),
-					ToPort:     pulumi.Int(0),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
-			},
-			Ingress: ec2.SecurityGroupIngressArray{
-				ec2.SecurityGroupIngressArgs{
-					Protocol:   pulumi.String("tcp"),
-					FromPort:   pulumi.Int(80),
-					ToPort:     pulumi.Int(80),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
-			},
-		})
+		shared, err := cluster.CreateShared(ctx, cfg, defaultNetwork)
 		if err != nil {
 			return err
 		}
-
-		eksClusters := []string{
-			"test",
-			"prod",
+		// Optionally let GitHub Actions deploy to the clusters without stored credentials
+		githubDeploy, err := iamroles.CreateGithubDeployRole(ctx, cfg)
+		if err != nil {
+			return err
 		}
-
-		for _, env := range eksClusters {
-		// Create EKS Cluster
-			eksCluster, err := eks.NewCluster(ctx, fmt.Sprintf("%s-aws-demo", env), &eks.ClusterArgs{
-				RoleArn: pulumi.StringInput(eksRole.Arn),
-				VpcConfig: &eks.ClusterVpcConfigArgs{
-					PublicAccessCidrs: pulumi.StringArray{
-						pulumi.String("0.0.0.0/0"),
-					},
-					SecurityGroupIds: pulumi.StringArray{
-						clusterSg.ID().ToStringOutput(),
-					},
-					SubnetIds: toPulumiStringArray(subnet.Ids),
-				},
-			})
+		if githubDeploy != nil {
+			ctx.Export("githubDeployRoleArn", githubDeploy.Role.Arn)
+		}
+		shared.GithubDeploy = githubDeploy
+		// Optionally replicate every environment's cluster into a second region for DR demos
+		var replicaShared *cluster.Shared
+		if cfg.GetBool("enableReplicaRegion") {
+			replicaNetwork, err := network.LookupReplicaNetwork(ctx, cfg, names.ReplicaRegion()...)
 			if err != nil {
 				return err
 			}
-
-			// iamOidcProvider, err := eks.NewIdentityProviderConfig(ctx, "iam-oidc-provicer", &eks.IdentityProviderConfigArgs{
-			// 	ClusterName: eksCluster.Name,	
-			// })
-			// if err != nil {
-			// 	return err
-			// }
-			// if iamOidcProvider == nil {
-			// 	return err
-			// }
-
-			nodeGroup, err := eks.NewNodeGroup(ctx, fmt.Sprintf("%s-aws-demo-node-group", env), &eks.NodeGroupArgs{
-				ClusterName:   eksCluster.Name,
-				NodeGroupName: pulumi.String(fmt.Sprintf("%s-aws-demo-node-group", env)),
-				NodeRoleArn:   pulumi.StringInput(nodeGroupRole.Arn),
-				SubnetIds:     toPulumiStringArray(subnet.Ids),
-				ScalingConfig: &eks.NodeGroupScalingConfigArgs{
-					DesiredSize: pulumi.Int(3),
-					MaxSize:     pulumi.Int(6),
-					MinSize:     pulumi.Int(1),
-				},
-			})
+			replicaShared, err = cluster.CreateShared(ctx, cfg, replicaNetwork)
 			if err != nil {
 				return err
 			}
+			replicaShared.GithubDeploy = githubDeploy
+		}
 
-			ctx.Export(fmt.Sprintf("%sKubeconfig", env), generateKubeconfig(eksCluster.Endpoint,
-				eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name))
-
-			k8sProvider, err := providers.NewProvider(ctx, fmt.Sprintf("%s-k8sprovider", env), &providers.ProviderArgs{
-				Kubeconfig: generateKubeconfig(eksCluster.Endpoint,
-					eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name),
-			}, pulumi.DependsOn([]pulumi.Resource{nodeGroup}))
+		var clusters, replicas []*cluster.Cluster
+		for _, env := range eksClusters {
+			environment, err := eksdemo.NewEksEnvironment(ctx, env, &eksdemo.EksEnvironmentArgs{
+				Config:        cfg,
+				Shared:        shared,
+				ReplicaShared: replicaShared,
+				Names:         names,
+			})
 			if err != nil {
 				return err
 			}
+			eksdemo.ExportEnvironment(ctx, environment)
+			clusters = append(clusters, environment.Cluster)
+			if environment.Replica != nil {
+				replicas = append(replicas, environment.Replica.Cluster)
+			}
+		}
 
-			argocdNamespace, err := corev1.NewNamespace(ctx, fmt.Sprintf("%s-argocd-ns", env), &corev1.NamespaceArgs{
-				Metadata: &metav1.ObjectMetaArgs{
-					Name: pulumi.String("argocd"),
-				},
-			}, pulumi.Provider(k8sProvider))
+		// List the Helm charts installed into each cluster, for audits
+		helmCharts, err := addons.ChartInventory(clusters)
+		if err != nil {
+			return err
+		}
+		ctx.Export("helmCharts", pulumi.String(helmCharts))
+		if len(replicas) > 0 {
+			replicaHelmCharts, err := addons.ChartInventory(replicas)
 			if err != nil {
 				return err
 			}
+			ctx.Export("replicaHelmCharts", pulumi.String(replicaHelmCharts))
+		}
 
-			_, err1 := helm.NewChart(ctx, fmt.Sprintf("%s-argo-cd", env), helm.ChartArgs{
-				Chart:     pulumi.String("argo-cd"),
-				Namespace: pulumi.String("argocd"),
-				ResourcePrefix: env,
-				FetchArgs: helm.FetchArgs{
-					Repo: pulumi.String("https://argoproj.github.io/argo-helm"),
-				},
-				Values: pulumi.Map{
-					"server": pulumi.Map{
-						"service": pulumi.Map{
-							"type": pulumi.String("LoadBalancer"),
-						},
-					},
-				},
-			}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{argocdNamespace}))
-			if err1 != nil {
-				return err
-			}
+		// Summarise whether each cluster came up, for a dashboard
+		ctx.Export("clusterHealth", eksdemo.HealthReport(clusters, replicas))
 
-			_, err3 := helm.NewChart(ctx, fmt.Sprintf("%s-argo-rollouts", env), helm.ChartArgs{
-				Chart:     pulumi.String("argo-rollouts"),
-				Namespace: pulumi.String("argocd"),
-				ResourcePrefix: env,
-				FetchArgs: helm.FetchArgs{
-					Repo: pulumi.String("https://argoproj.github.io/argo-helm"),
-				},
-				Values: pulumi.Map{
-					"dashboard": pulumi.Map{
-						"enabled": pulumi.String("true"),
-						},
-				},
-			}, pulumi.Provider(k8sProvider), pulumi.DependsOn([]pulumi.Resource{argocdNamespace}))
-			if err3 != nil {
-				return err
-			}
+		// Optionally write a script that adds a kubectl context for each cluster
+		kubectlContextScript, err := cluster.WriteKubectlContextScript(ctx, cfg, clusters, replicas)
+		if err != nil {
+			return err
+		}
+		ctx.Export("kubectlContextScript", kubectlContextScript)
 
-			_, err2 := corev1.NewNamespace(ctx, fmt.Sprintf("%s-app-ns", env), &corev1.NamespaceArgs{
-				Metadata: &metav1.ObjectMetaArgs{
-					Name: pulumi.String(fmt.Sprintf("%s-app", env)),
-				},
-			}, pulumi.Provider(k8sProvider))
-			if err2 != nil {
+		// Optionally let the clusters reach each other, e.g. for a multi-cluster service mesh
+		if cfg.GetBool("allowCrossClusterTraffic") {
+			if err := cluster.AllowCrossClusterTraffic(ctx, clusters); err != nil {
 				return err
 			}
 		}
 
-		// appLabels := pulumi.StringMap{
-		// 	"app": pulumi.String("iac-workshop"),
-		// }
-		// _, err = appsv1.NewDeployment(ctx, "app-dep", &appsv1.DeploymentArgs{
-		// 	Metadata: &metav1.ObjectMetaArgs{
-		// 		Namespace: namespace.Metadata.Elem().Name(),
-		// 	},
-		// 	Spec: appsv1.DeploymentSpecArgs{
-		// 		Selector: &metav1.LabelSelectorArgs{
-		// 			MatchLabels: appLabels,
-		// 		},
-		// 		Replicas: pulumi.Int(3),
-		// 		Template: &corev1.PodTemplateSpecArgs{
-		// 			Metadata: &metav1.ObjectMetaArgs{
-		// 				Labels: appLabels,
-		// 			},
-		// 			Spec: &corev1.PodSpecArgs{
-		// 				Containers: corev1.ContainerArray{
-		// 					corev1.ContainerArgs{
-		// 						Name:  pulumi.String("iac-workshop"),
-		// 						Image: pulumi.String("jocatalin/kubernetes-bootcamp:v2"),
-		// 					}},
-		// 			},
-		// 		},
-		// 	},
-		// }, pulumi.Provider(k8sProvider))
-		// if err != nil {
-		// 	return err
-		// }
-
-		// service, err := corev1.NewService(ctx, "app-service", &corev1.ServiceArgs{
-		// 	Metadata: &metav1.ObjectMetaArgs{
-		// 		Namespace: namespace.Metadata.Elem().Name(),
-		// 		Labels:    appLabels,
-		// 	},
-		// 	Spec: &corev1.ServiceSpecArgs{
-		// 		Ports: corev1.ServicePortArray{
-		// 			corev1.ServicePortArgs{
-		// 				Port:       pulumi.Int(80),
-		// 				TargetPort: pulumi.Int(8080),
-		// 			},
-		// 		},
-		// 		Selector: appLabels,
-		// 		Type:     pulumi.String("LoadBalancer"),
-		// 	},
-		// }, pulumi.Provider(k8sProvider))
-		// if err != nil {
-		// 	return err
-		// }
-
-		// ctx.Export("url", service.Status.ApplyT(func(status *corev1.ServiceStatus) *string {
-		// 	ingress := status.LoadBalancer.Ingress[0]
-		// 	if ingress.Hostname != nil {
-		// 		return ingress.Hostname
-		// 	}
-		// 	return ingress.Ip
-		// }))
-
-		return nil
+		return names.Err()
 	})
 }
-
-//Create the KubeConfig Structure as per https://docs.aws.amazon.com/eks/latest/userguide/create-kubeconfig.html
-func generateKubeconfig(clusterEndpoint pulumi.StringOutput, certData pulumi.StringOutput, clusterName pulumi.StringOutput) pulumi.StringOutput {
-	return pulumi.Sprintf(`{
-        "apiVersion": "v1",
-        "clusters": [{
-            "cluster": {
-                "server": "%s",
-                "certificate-authority-data": "%s"
-            },
-            "name": "kubernetes",
-        }],
-        "contexts": [{
-            "context": {
-                "cluster": "kubernetes",
-                "user": "aws",
-            },
-            "name": "aws",
-        }],
-        "current-context": "aws",
-        "kind": "Config",
-        "users": [{
-            "name": "aws",
-            "user": {
-                "exec": {
-                    "apiVersion": "client.authentication.k8s.io/v1alpha1",
-                    "command": "aws-iam-authenticator",
-                    "args": [
-                        "token",
-                        "-i",
-                        "%s",
-                    ],
-                },
-            },
-        }],
-    }`, clusterEndpoint, certData, clusterName)
-}
-
-func toPulumiStringArray(a []string) pulumi.StringArrayInput {
-	var res []pulumi.StringInput
-	for _, s := range a {
-		res = append(res, pulumi.String(s))
-	}
-	return pulumi.StringArray(res)
-}
@@ -0,0 +1,594 @@
+package eksdemo
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/addons"
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/pulumitest"
+	"aws-go-eks/internal/stackconfig"
+)
+
+func provision(ctx *pulumi.Context, cfg *config.Config, env string) (*cluster.Cluster, error) {
+	defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := cluster.CreateShared(ctx, cfg, defaultNetwork)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.ProvisionCluster(ctx, cfg, env, shared)
+}
+
+func TestCheckResourceNames(t *testing.T) {
+	if err := CheckResourceNames([]string{"test", "prod"}); err != nil {
+		t.Errorf("expected test and prod to be accepted, got %v", err)
+	}
+	if err := CheckResourceNames([]string{"test", "test"}); err == nil {
+		t.Error("expected duplicate environments to be rejected")
+	}
+	if err := CheckResourceNames([]string{"Test_1"}); err == nil {
+		t.Error("expected an invalid DNS label to be rejected")
+	}
+}
+
+func TestResourceNames(t *testing.T) {
+	// Provisions what main does for environments, recording their names
+	provisionTracked := func(m *pulumitest.Mocks, values map[string]string, environments ...string) (*ResourceNames, error) {
+		var names *ResourceNames
+		err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+			var err error
+			if names, err = TrackResourceNames(ctx); err != nil {
+				return err
+			}
+			defaultNetwork, err := network.LookupDefaultNetwork(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			shared, err := cluster.CreateShared(ctx, cfg, defaultNetwork)
+			if err != nil {
+				return err
+			}
+			replicaNetwork, err := network.LookupReplicaNetwork(ctx, cfg, names.ReplicaRegion()...)
+			if err != nil {
+				return err
+			}
+			replicaShared, err := cluster.CreateShared(ctx, cfg, replicaNetwork)
+			if err != nil {
+				return err
+			}
+			for _, env := range environments {
+				_, err := NewEksEnvironment(ctx, env, &EksEnvironmentArgs{
+					Config: cfg, Shared: shared, ReplicaShared: replicaShared, Names: names,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return names.Err()
+		})
+		return names, err
+	}
+
+	m := pulumitest.NewMocks()
+	m.RenderCharts = true
+	values := map[string]string{"replicaRegion": "eu-central-1", "nodeGroupPerAz": "true", "nodeDesiredSize": "2"}
+	names, err := provisionTracked(m, values, "test", "prod")
+	if err != nil {
+		t.Fatalf("expected the replicas and shared resources of both regions not to collide, got %v", err)
+	}
+	for _, key := range []string{
+		" aws:eks/nodeGroup:NodeGroup test-aws-demo-node-group-eu-west-1a",
+		" kubernetes:core/v1:ConfigMap prod-argocd/prod-prod-argo-cd",
+		"replica region aws:eks/cluster:Cluster prod-aws-demo",
+		"replica region aws:iam/role:Role eks-iam-eksRole",
+	} {
+		if _, ok := names.owners[key]; !ok {
+			t.Errorf("expected %q to be recorded", key)
+		}
+	}
+	if owner := names.owners[" aws:eks/nodeGroup:NodeGroup test-aws-demo-node-group-eu-west-1a"]; owner != `environment "test"` {
+		t.Errorf("expected the per-AZ node group to be owned by test, got %q", owner)
+	}
+
+	// test's extra app-ns namespace is named like test-ns's app namespace
+	values = map[string]string{"replicaRegion": "eu-central-1", "namespaces": `[{"name": "app-ns"}]`}
+	_, err = provisionTracked(pulumitest.NewMocks(), values, "test", "test-ns")
+	if err == nil || !strings.Contains(err.Error(), `"test-ns-app-ns" of environment "test-ns" collides with a name used by environment "test"`) {
+		t.Errorf("expected the namespaces of test and test-ns to collide, got %v", err)
+	}
+}
+
+func TestCheckExcludedEnvironments(t *testing.T) {
+	// Provisions what main does for the environments onlyEnvironments keeps
+	provisionFiltered := func(ctx *pulumi.Context, cfg *config.Config) error {
+		environments := []string{"test", "prod"}
+		filtered, err := stackconfig.FilterEnvironments(ctx, cfg, environments)
+		if err != nil {
+			return err
+		}
+		if err := CheckExcludedEnvironments(ctx, environments, filtered); err != nil {
+			return err
+		}
+		for _, env := range filtered {
+			if _, err := provision(ctx, cfg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	values := map[string]string{"onlyEnvironments": `["test"]`}
+
+	m := pulumitest.NewMocks()
+	err := pulumitest.Run(t, m, values, provisionFiltered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusters := m.ByType("aws:eks/cluster:Cluster"); len(clusters) != 1 {
+		t.Errorf("expected only the test cluster on a stack without prod, got %v", clusters)
+	}
+
+	m = pulumitest.NewMocks()
+	m.StackOutputs = map[string]interface{}{"testEndpoint": "https://test", "prodEndpoint": "https://prod"}
+	err = pulumitest.Run(t, m, values, provisionFiltered)
+	if err == nil || !strings.Contains(err.Error(), "prod already provisioned") {
+		t.Errorf("expected leaving out the provisioned prod to be refused, got %v", err)
+	}
+	if clusters := m.ByType("aws:eks/cluster:Cluster"); len(clusters) != 0 {
+		t.Errorf("expected the update to stop before declaring any cluster, got %v", clusters)
+	}
+
+	m = pulumitest.NewMocks()
+	m.StackOutputs = map[string]interface{}{"testEndpoint": "https://test", "prodEndpoint": "https://prod"}
+	err = pulumitest.Run(t, m, nil, provisionFiltered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := m.ByType("pulumi:pulumi:StackReference"); len(refs) != 0 {
+		t.Errorf("expected no stack reference without onlyEnvironments, got %v", refs)
+	}
+}
+
+func TestProvisionReplica(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"enableReplicaRegion":    "true",
+		"replicaRegion":          "eu-central-1",
+		"eksAddons":              `{"test": true}`,
+		"loadBalancerController": `{"test": true}`,
+		"karpenter":              `{"test": true}`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		if _, err := provision(ctx, cfg, "test"); err != nil {
+			return err
+		}
+		network, err := network.LookupReplicaNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := cluster.CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		_, _, err = ProvisionReplica(ctx, cfg, "test", shared)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := m.ByType("pulumi:providers:aws")
+	if len(providers) != 1 || providers[0].Inputs["region"].StringValue() != "eu-central-1" {
+		t.Fatalf("expected one replica AWS provider for eu-central-1, got %v", providers)
+	}
+	clusters := m.ByType("aws:eks/cluster:Cluster")
+	if len(clusters) != 2 {
+		t.Fatalf("expected a primary and a replica cluster, got %d", len(clusters))
+	}
+	var replicas int
+	for _, c := range clusters {
+		if strings.Contains(c.Provider, "replica-aws") {
+			replicas++
+		}
+	}
+	if replicas != 1 {
+		t.Errorf("expected one cluster created with the replica provider, got %d", replicas)
+	}
+	if got := len(m.ByType("aws:eks/nodeGroup:NodeGroup")); got != 2 {
+		t.Errorf("expected the replica to mirror the node group, got %d node groups", got)
+	}
+	// The replica gets the add-ons the primary would, from its own region's providers
+	for _, check := range []struct{ typ, name string }{
+		{"aws:eks/addon:Addon", "test-addon-vpc-cni"},
+		{"aws:eks/addon:Addon", "test-addon-coredns"},
+		{"kubernetes:helm.sh/v3:Chart", "test-aws-load-balancer-controller"},
+		{"kubernetes:helm.sh/v3:Chart", "test-karpenter"},
+		{"aws:sqs/queue:Queue", "test-karpenter-interruption"},
+	} {
+		var found []string
+		for _, r := range m.ByType(check.typ) {
+			if r.Name == check.name {
+				found = append(found, r.Provider)
+			}
+		}
+		if len(found) != 1 || !strings.Contains(found[0], "replica-aws") && !strings.Contains(found[0], "eksdemo:index:ReplicaRegion$") {
+			t.Errorf("expected the replica's %s %s, from the replica region's provider, got %v", check.typ, check.name, found)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"replicaRegion": "eu-west-1"}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		_, err := network.LookupReplicaNetwork(ctx, cfg)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "must differ") {
+		t.Errorf("expected a replica in the primary region to be rejected, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	values := map[string]string{
+		"nodeDesiredSize":   "0",
+		"nodeMinSize":       "5",
+		"logRetentionDays":  "10",
+		"secretsController": "vault",
+		"nodeScaleToZero":   `{"prod": true}`,
+	}
+	err := pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test", "prod"})
+	})
+	if err == nil {
+		t.Fatal("expected the config to be rejected")
+	}
+	for _, key := range []string{"node group sizes", "retention", "secretsController", "nodeScaleToZero"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected the %s problem to be reported, got %v", key, err)
+		}
+	}
+
+	err = pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test", "prod"})
+	})
+	if err != nil {
+		t.Errorf("expected the default config to be valid, got %v", err)
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+	err := pulumitest.Run(t, pulumitest.NewMocks(), nil, func(ctx *pulumi.Context, cfg *config.Config) error {
+		var clusters []*cluster.Cluster
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			clusters = append(clusters, cluster)
+		}
+		// The mocked chart renders no server Service, so prod's Argo CD stays pending
+		if _, err := addons.InstallArgo(ctx, cfg, clusters[1]); err != nil {
+			return err
+		}
+		HealthReport(clusters, nil).ApplyT(func(report string) string {
+			expected := `{"prod":{"ready":false,"cluster":"ACTIVE","nodeGroups":{"prod-aws-demo-node-group":"ACTIVE"},"argoCd":"pending"},` +
+				`"test":{"ready":true,"cluster":"ACTIVE","nodeGroups":{"test-aws-demo-node-group":"ACTIVE"}}}`
+			if report != expected {
+				t.Errorf("expected health report\n%s\ngot\n%s", expected, report)
+			}
+			return report
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{"spotNodes": `{"test": true}`, "spotNodeInstanceTypes": `["m5.large", "m5a.large"]`}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		c, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		HealthReport([]*cluster.Cluster{c}, nil).ApplyT(func(report string) string {
+			expected := `{"test":{"ready":true,"cluster":"ACTIVE",` +
+				`"nodeGroups":{"test-aws-demo-node-group":"ACTIVE","test-aws-demo-node-group-spot":"ACTIVE"}}}`
+			if report != expected {
+				t.Errorf("expected health report\n%s\ngot\n%s", expected, report)
+			}
+			return report
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEksEnvironment(t *testing.T) {
+	m := pulumitest.NewMocks()
+	var mu sync.Mutex
+	var urns []string
+	err := pulumitest.Run(t, m, map[string]string{"nodeTerminationHandler": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		network, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := cluster.CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		environment, err := NewEksEnvironment(ctx, "test", &EksEnvironmentArgs{Config: cfg, Shared: shared})
+		if err != nil {
+			return err
+		}
+		if environment.Cluster == nil || environment.Replica != nil || environment.BastionInstanceId != nil {
+			return fmt.Errorf("expected a cluster and no replica or bastion, got %+v", environment)
+		}
+		for _, r := range []pulumi.Resource{environment.Cluster.Cluster, environment.Cluster.NodeGroups[0], environment.Cluster.Provider} {
+			r.URN().ApplyT(func(urn pulumi.URN) error {
+				mu.Lock()
+				defer mu.Unlock()
+				urns = append(urns, string(urn))
+				return nil
+			})
+		}
+		ExportEnvironment(ctx, environment)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if components := m.ByType("eksdemo:index:EksEnvironment"); len(components) != 1 || components[0].Name != "test" {
+		t.Errorf("expected one EksEnvironment named test, got %v", components)
+	}
+	if len(urns) != 3 {
+		t.Fatalf("expected the URNs of the cluster, node group and provider, got %v", urns)
+	}
+	for _, urn := range urns {
+		if !strings.Contains(urn, "::eksdemo:index:EksEnvironment$") {
+			t.Errorf("expected %s to be parented to the environment", urn)
+		}
+	}
+	if charts := m.ByType("kubernetes:helm.sh/v3:Chart"); len(charts) == 0 {
+		t.Error("expected the environment's charts to be installed")
+	}
+}
+
+// Checks invariants of the whole topology across environments, so a refactor
+// that moves resources between environments or drops one shows up here.
+func TestTopology(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"environments":      `[{"name": "test", "instanceType": "t2.small"}, {"name": "prod"}]`,
+		"appIrsa":           `{"test": true, "prod": true}`,
+		"appIrsaPolicyArns": `["arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"]`,
+	}
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		environments, err := stackconfig.Environments(cfg)
+		if err != nil {
+			return err
+		}
+		network, err := network.LookupDefaultNetwork(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		shared, err := cluster.CreateShared(ctx, cfg, network)
+		if err != nil {
+			return err
+		}
+		for _, env := range environments {
+			if _, err := NewEksEnvironment(ctx, env, &EksEnvironmentArgs{Config: cfg, Shared: shared}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The node group and cluster roles are shared, so the environments only add
+	// the managed policy attachments of their IRSA roles
+	attachments := map[string]int{}
+	for _, a := range m.ByType("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		switch {
+		case strings.HasPrefix(a.Name, "ngpa-"):
+			attachments["node group"]++
+		case strings.HasPrefix(a.Name, "rpa-"):
+			attachments["cluster"]++
+		case strings.HasSuffix(a.Name, "-app-irsa-policy-AmazonS3ReadOnlyAccess"):
+			attachments[strings.TrimSuffix(a.Name, "-app-irsa-policy-AmazonS3ReadOnlyAccess")]++
+		default:
+			t.Errorf("unexpected managed policy attachment %s", a.Name)
+		}
+	}
+	want := map[string]int{"node group": 3, "cluster": 2, "test": 1, "prod": 1}
+	if fmt.Sprint(attachments) != fmt.Sprint(want) {
+		t.Errorf("expected managed policy attachments %v, got %v", want, attachments)
+	}
+
+	roles := map[string]bool{}
+	for _, r := range m.ByType("aws:iam/role:Role") {
+		roles[r.Name] = true
+	}
+	accounts := map[string]bool{}
+	for _, sa := range m.ByType("kubernetes:core/v1:ServiceAccount") {
+		annotations := sa.Inputs["metadata"].ObjectValue()["annotations"]
+		if !annotations.IsObject() {
+			continue
+		}
+		arn, ok := annotations.ObjectValue()["eks.amazonaws.com/role-arn"]
+		if !ok {
+			continue
+		}
+		accounts[sa.Name] = true
+		if !arn.IsString() || !iamroles.IamRoleArn.MatchString(arn.StringValue()) ||
+			!roles[strings.TrimPrefix(arn.StringValue(), "arn:aws:iam::123456789012:role/")] {
+			t.Errorf("expected %s to be annotated with the ARN of a role of the stack, got %v", sa.Name, arn)
+		}
+	}
+	if !accounts["test-app-sa"] || !accounts["prod-app-sa"] {
+		t.Errorf("expected an annotated app service account per environment, got %v", accounts)
+	}
+
+	var prodGroups int
+	for _, ng := range m.ByType("aws:eks/nodeGroup:NodeGroup") {
+		if !strings.HasPrefix(ng.Name, "prod-") {
+			continue
+		}
+		prodGroups++
+		if strings.Contains(ng.Inputs["instanceTypes"].String(), "t2.small") {
+			t.Errorf("expected the prod node groups to keep the stack-wide instance type, got %s on %s", ng.Inputs["instanceTypes"], ng.Name)
+		}
+	}
+	if prodGroups == 0 {
+		t.Error("expected prod to have a node group")
+	}
+}
+
+func TestArgoCdIngress(t *testing.T) {
+	m := pulumitest.NewMocks()
+	values := map[string]string{
+		"loadBalancerController": `{"test": true, "prod": true}`,
+		"argoCdIngress":          `{"test": {"host": "argocd.test.example.com", "hostedZone": "example.com"}, "prod": {"host": "argocd.example.com"}}`,
+		"helmRelease":            `{"test": true, "prod": true}`,
+		"chartVersions":          `{"argo-cd": "6.7.3", "argo-rollouts": "2.32.0", "aws-load-balancer-controller": "1.6.2"}`,
+	}
+	var mu sync.Mutex
+	urls := map[string]string{}
+	var report string
+	err := pulumitest.Run(t, m, values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		if err := ValidateConfig(cfg, []string{"test", "prod"}); err != nil {
+			return err
+		}
+		var clusters []*cluster.Cluster
+		for _, env := range []string{"test", "prod"} {
+			cluster, err := provision(ctx, cfg, env)
+			if err != nil {
+				return err
+			}
+			clusters = append(clusters, cluster)
+			if _, err := addons.InstallLoadBalancerController(ctx, cfg, cluster); err != nil {
+				return err
+			}
+			url, err := addons.InstallArgo(ctx, cfg, cluster)
+			if err != nil {
+				return err
+			}
+			env := env
+			url.ApplyT(func(u string) string {
+				mu.Lock()
+				defer mu.Unlock()
+				urls[env] = u
+				return u
+			})
+		}
+		HealthReport(clusters, nil).ApplyT(func(r string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			report = r
+			return r
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls["test"] != "https://argocd.test.example.com" || urls["prod"] != "https://argocd.example.com" {
+		t.Errorf("expected the HTTPS URLs of the hosts, got %v", urls)
+	}
+	// The Ingresses read back have no ALB yet
+	ingresses := m.ByType("kubernetes:networking.k8s.io/v1:Ingress")
+	ids := map[string]bool{}
+	for _, ingress := range ingresses {
+		ids[string(ingress.ID)] = true
+	}
+	if len(ingresses) != 2 || !ids["argocd/test-test-argo-cd-server"] || !ids["argocd/prod-prod-argo-cd-server"] {
+		t.Errorf("expected each release's Argo CD server Ingress to be read back, got %v", ingresses)
+	}
+	if strings.Count(report, `"argoCd":"pending"`) != 2 {
+		t.Errorf("expected Argo CD to be pending until its ALB is up, got %s", report)
+	}
+	certificates := m.ByType("aws:acm/certificate:Certificate")
+	if len(certificates) != 1 || certificates[0].Name != "test-argocd-certificate" ||
+		certificates[0].Inputs["validationMethod"].StringValue() != "DNS" {
+		t.Fatalf("expected a DNS validated certificate requested in test only, got %v", certificates)
+	}
+	records := m.ByType("aws:route53/record:Record")
+	if len(records) != 1 || records[0].Inputs["zoneId"].StringValue() != "Z123" ||
+		records[0].Inputs["name"].StringValue() != "_a1.argocd.test.example.com." {
+		t.Errorf("expected the validation record in the hosted zone, got %v", records)
+	}
+	certificateArns := map[string]string{
+		"test": "arn:aws:acm:eu-west-1:123456789012:certificate/test-argocd-certificate",
+		"prod": "arn:aws:acm:eu-west-1:123456789012:certificate/argocd.example.com",
+	}
+	for _, release := range m.ByType("kubernetes:helm.sh/v3:Release") {
+		env := strings.TrimSuffix(release.Name, "-argo-cd")
+		if env == release.Name {
+			continue
+		}
+		server := release.Inputs["values"].ObjectValue()["server"].ObjectValue()
+		if server.HasValue("service") {
+			t.Errorf("expected %s to keep the chart's ClusterIP Service, got %v", env, server["service"])
+		}
+		ingress := server["ingress"].ObjectValue()
+		annotations := ingress["annotations"].ObjectValue()
+		if ingress["ingressClassName"].StringValue() != "alb" || ingress["hostname"].StringValue() != strings.TrimPrefix(urls[env], "https://") ||
+			annotations["alb.ingress.kubernetes.io/certificate-arn"].StringValue() != certificateArns[env] ||
+			annotations["alb.ingress.kubernetes.io/scheme"].StringValue() != "internet-facing" {
+			t.Errorf("expected an internet-facing ALB Ingress with %s's certificate, got %v", env, ingress)
+		}
+	}
+
+	for value, want := range map[string]string{
+		`{"test": {"host": "argocd.example.org", "hostedZone": "example.com"}}`:                                                                          "not in hostedZone",
+		`{"test": {"host": "argocd.example.com", "certificateArn": "arn:aws:acm:us-east-1:123456789012:certificate/0a1b", "hostedZone": "example.com"}}`: "only one can be used",
+		`{"test": {"host": "https://argocd.example.com"}}`:                                                                                               "must be a DNS name",
+	} {
+		err := pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoCdIngress": value, "loadBalancerController": `{"test": true}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+			return ValidateConfig(cfg, []string{"test"})
+		})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to be rejected with %q, got %v", value, want, err)
+		}
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{
+		"argoCdIngress":          `{"test": {"host": "argocd.example.com"}}`,
+		"loadBalancerController": `{"test": true}`,
+		"chartVersions":          `{"argo-cd": "5.46.7"}`,
+	}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs argo-cd chart 6.0.0 or later") {
+		t.Errorf("expected an argo-cd chart without server.ingress.hostname to be rejected, got %v", err)
+	}
+	err = pulumitest.Run(t, pulumitest.NewMocks(), map[string]string{"argoCdIngress": `{"test": {"host": "argocd.example.com"}}`}, func(ctx *pulumi.Context, cfg *config.Config) error {
+		return ValidateConfig(cfg, []string{"test"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "needs loadBalancerController") {
+		t.Errorf("expected argoCdIngress without the controller to be rejected, got %v", err)
+	}
+	values["argoCdIngress"] = `{"test": {"host": "argocd.example.com", "certificateArn": "arn:aws:acm:us-east-1:123456789012:certificate/0a1b"}}`
+	err = pulumitest.Run(t, pulumitest.NewMocks(), values, func(ctx *pulumi.Context, cfg *config.Config) error {
+		cluster, err := provision(ctx, cfg, "test")
+		if err != nil {
+			return err
+		}
+		if _, err := addons.InstallLoadBalancerController(ctx, cfg, cluster); err != nil {
+			return err
+		}
+		_, err = addons.InstallArgo(ctx, cfg, cluster)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "its own region") {
+		t.Errorf("expected a certificate from another region to be rejected, got %v", err)
+	}
+}
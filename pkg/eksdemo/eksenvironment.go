@@ -0,0 +1,211 @@
+package eksdemo
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/amp"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/addons"
+	"aws-go-eks/internal/cluster"
+)
+
+// EksEnvironmentArgs are the inputs of an EksEnvironment.
+type EksEnvironmentArgs struct {
+	// The stack config the environment's settings are read from.
+	Config *config.Config
+	// The resources every environment's cluster is built on, from CreateShared.
+	Shared *cluster.Shared
+	// The replica region's shared resources to replicate the cluster into, from
+	// CreateShared on LookupReplicaNetwork, or nil for no replica.
+	ReplicaShared *cluster.Shared
+	// Records the names of the environment's resources to find collisions with
+	// other environments, or nil not to.
+	Names *ResourceNames
+}
+
+// EksEnvironment is an environment's cluster with everything this program
+// installs into it and builds around it, as one component in the resource tree.
+// Optional outputs are nil when their feature is off.
+type EksEnvironment struct {
+	pulumi.ResourceState
+
+	Cluster         *cluster.Cluster
+	ArgoCdUrl       pulumi.StringOutput
+	AppRoleArn      pulumi.StringOutput
+	SmokeTestStatus pulumi.StringOutput
+	// The IRSA role of the AWS Load Balancer Controller, "" without
+	// `loadBalancerController`.
+	LoadBalancerControllerRoleArn pulumi.StringOutput
+	// The DNS name of the ALB with `enableStandaloneAlb`.
+	StandaloneAlbDnsName *pulumi.StringOutput
+	// The bastion instance with `enableBastion`.
+	BastionInstanceId *pulumi.StringOutput
+	// The Amazon Managed Service for Prometheus workspace with `amp`.
+	AmpWorkspace      *amp.Workspace
+	AmpRemoteWriteUrl pulumi.StringOutput
+	// The environment's replica in the replica region, itself an EksEnvironment
+	// parented to that region, with only Cluster and ArgoCdUrl set.
+	Replica *EksEnvironment
+}
+
+// Register env's component in the region of shared's network.
+func registerEksEnvironment(ctx *pulumi.Context, env string, shared *cluster.Shared, opts ...pulumi.ResourceOption) (*EksEnvironment, error) {
+	environment := &EksEnvironment{}
+	err := ctx.RegisterComponentResource("eksdemo:index:EksEnvironment", env, environment, shared.Network.ResourceOpts(opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return environment, nil
+}
+
+// NewEksEnvironment provisions env's cluster and installs into it in order:
+// the standalone ALB and bastion when enabled, the EKS add-ons, the CoreDNS and
+// VPC CNI config, the AWS Load Balancer Controller, Container Insights, the node
+// termination handler, the cluster autoscaler or Karpenter, the GPU device
+// plugin, the Prometheus workspace, the image prepuller, Argo and its SSM
+// parameters, the namespaces with the app service account and kustomize overlay,
+// the secrets controller, the post-install kubectl commands and the smoke test.
+// With args.ReplicaShared it then replicates the cluster, as ProvisionReplica
+// does. Every resource is parented to the component; those created before it
+// existed are aliased, so adopting it does not replace them. With args.Names it
+// fails when a name collides with one declared before.
+func NewEksEnvironment(ctx *pulumi.Context, env string, args *EksEnvironmentArgs, opts ...pulumi.ResourceOption) (*EksEnvironment, error) {
+	cfg := args.Config
+	if err := cluster.LogInventory(ctx, cfg, env); err != nil {
+		return nil, err
+	}
+	opts = append(args.Names.ownedBy("", fmt.Sprintf("environment %q", env)), opts...)
+	environment, err := registerEksEnvironment(ctx, env, args.Shared, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cluster.Provision(ctx, cfg, &cluster.Cluster{Env: env, Shared: args.Shared, Component: environment})
+	if err != nil {
+		return nil, err
+	}
+	environment.Cluster = c
+
+	if cfg.GetBool("enableStandaloneAlb") {
+		albDnsName, err := cluster.CreateStandaloneAlb(ctx, cfg, c)
+		if err != nil {
+			return nil, err
+		}
+		environment.StandaloneAlbDnsName = &albDnsName
+	}
+	if cfg.GetBool("enableBastion") {
+		bastionId, err := cluster.CreateBastion(ctx, cfg, c)
+		if err != nil {
+			return nil, err
+		}
+		environment.BastionInstanceId = &bastionId
+	}
+
+	if err := addons.InstallEksAddons(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.ConfigureCoreDns(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.ConfigureVpcCni(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	environment.LoadBalancerControllerRoleArn, err = addons.InstallLoadBalancerController(ctx, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GetBool("enableContainerInsights") {
+		if err := addons.InstallContainerInsights(ctx, cfg, c); err != nil {
+			return nil, err
+		}
+	}
+	if err := addons.InstallNodeTerminationHandler(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.InstallClusterAutoscaler(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.InstallKarpenter(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.InstallGpuDevicePlugin(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	environment.AmpWorkspace, environment.AmpRemoteWriteUrl, err = addons.CreateAmpWorkspace(ctx, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := addons.InstallImagePrepuller(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	environment.ArgoCdUrl, err = addons.InstallArgo(ctx, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := cluster.WriteSsmParameters(ctx, cfg, c, environment.ArgoCdUrl); err != nil {
+		return nil, err
+	}
+	if err := addons.CreateNamespaces(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	environment.AppRoleArn, err = addons.CreateAppServiceAccount(ctx, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := addons.DeployAppKustomization(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.InstallSecretsController(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	if err := addons.RunPostInstallKubectl(ctx, cfg, c); err != nil {
+		return nil, err
+	}
+	environment.SmokeTestStatus, err = addons.RunSmokeTest(ctx, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.ReplicaShared != nil {
+		replicaOpts := args.Names.ownedBy(replicaRegionNames, fmt.Sprintf("the replica of environment %q", env))
+		if environment.Replica, err = newReplicaEnvironment(ctx, cfg, env, args.ReplicaShared, replicaOpts...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := args.Names.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(environment, pulumi.Map{
+		"endpoint":  c.Cluster.Endpoint,
+		"argoCdUrl": environment.ArgoCdUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return environment, nil
+}
+
+// Replicate env's cluster into the region of shared's network, as its own
+// EksEnvironment under the replica region's component.
+func newReplicaEnvironment(ctx *pulumi.Context, cfg *config.Config, env string, shared *cluster.Shared, opts ...pulumi.ResourceOption) (*EksEnvironment, error) {
+	replica, err := registerEksEnvironment(ctx, env, shared, opts...)
+	if err != nil {
+		return nil, err
+	}
+	replica.Cluster, replica.ArgoCdUrl, err = provisionReplica(ctx, cfg, &cluster.Cluster{Env: env, Shared: shared, Component: replica})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.RegisterResourceOutputs(replica, pulumi.Map{
+		"endpoint":  replica.Cluster.Cluster.Endpoint,
+		"argoCdUrl": replica.ArgoCdUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replica, nil
+}
@@ -0,0 +1,105 @@
+package eksdemo
+
+import (
+	"encoding/json"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// EKS's status for a cluster, node group or Fargate profile that is up.
+const eksStatusActive = "ACTIVE"
+
+// An environment's entry in the health report. Each part holds the status EKS
+// reports for it; Argo CD is "ready" once its server has a load balancer, or
+// with `argoCdIngress` once the Ingress has an ALB.
+type clusterHealth struct {
+	Ready          bool              `json:"ready"`
+	Cluster        string            `json:"cluster"`
+	NodeGroups     map[string]string `json:"nodeGroups,omitempty"`
+	FargateProfile string            `json:"fargateProfile,omitempty"`
+	ArgoCd         string            `json:"argoCd,omitempty"`
+}
+
+// HealthReport builds a JSON map of each cluster's readiness, keyed by
+// environment and by `<env>-replica` for replicas: the status of the cluster,
+// its node groups and Fargate profile, and whether the Argo CD server is
+// reachable. An environment that is only partly up is reported as not ready
+// with the parts that are lagging, rather than failing the output.
+func HealthReport(clusters, replicas []*cluster.Cluster) pulumi.StringOutput {
+	type entry struct {
+		key     string
+		cluster *cluster.Cluster
+	}
+	var entries []entry
+	for _, c := range clusters {
+		entries = append(entries, entry{c.Env, c})
+	}
+	for _, c := range replicas {
+		entries = append(entries, entry{c.Env + "-replica", c})
+	}
+
+	// Flatten every cluster's signals into one list for pulumi.All, in the
+	// order they are read back below
+	var signals []interface{}
+	for _, e := range entries {
+		c := e.cluster
+		signals = append(signals, c.Cluster.Status)
+		// The same node groups and Fargate profile everything installed waits for
+		for _, compute := range c.ComputeResources() {
+			switch r := compute.(type) {
+			case *eks.NodeGroup:
+				signals = append(signals, r.NodeGroupName, r.Status)
+			case *eks.FargateProfile:
+				signals = append(signals, r.Status)
+			}
+		}
+		if c.ArgoCdUrl != nil {
+			// The ingress URL is set from the start, so wait for its ALB instead
+			argoCd := *c.ArgoCdUrl
+			if c.ArgoCdIngressAddress != nil {
+				argoCd = *c.ArgoCdIngressAddress
+			}
+			signals = append(signals, argoCd)
+		}
+	}
+	return pulumi.All(signals...).ApplyT(func(values []interface{}) (string, error) {
+		next := func() string {
+			s, _ := values[0].(string)
+			values = values[1:]
+			return s
+		}
+		report := map[string]clusterHealth{}
+		for _, e := range entries {
+			c := e.cluster
+			health := clusterHealth{Cluster: next()}
+			health.Ready = health.Cluster == eksStatusActive
+			for _, compute := range c.ComputeResources() {
+				switch compute.(type) {
+				case *eks.NodeGroup:
+					name, status := next(), next()
+					if health.NodeGroups == nil {
+						health.NodeGroups = map[string]string{}
+					}
+					health.NodeGroups[name] = status
+					health.Ready = health.Ready && status == eksStatusActive
+				case *eks.FargateProfile:
+					health.FargateProfile = next()
+					health.Ready = health.Ready && health.FargateProfile == eksStatusActive
+				}
+			}
+			if c.ArgoCdUrl != nil {
+				health.ArgoCd = "pending"
+				if address := next(); address != "" && address != "https://" {
+					health.ArgoCd = "ready"
+				}
+				health.Ready = health.Ready && health.ArgoCd == "ready"
+			}
+			report[e.key] = health
+		}
+		data, err := json.Marshal(report)
+		return string(data), err
+	}).(pulumi.StringOutput)
+}
@@ -0,0 +1,105 @@
+package eksdemo
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ResourceNames records the name of every resource as it is declared, so that
+// two environments can never produce the same name, which would otherwise only
+// surface as a confusing mid-deploy failure. Names are recorded per type and
+// region, as Pulumi tells resources of another type or parent apart; that covers
+// the names built at run time too, such as the per-AZ node groups and the
+// children of Helm charts.
+type ResourceNames struct {
+	mu       sync.Mutex
+	owners   map[string]string
+	recorded map[pulumi.Resource]bool
+	err      error
+}
+
+// The region of the replicas' names, apart from the stack's region.
+const replicaRegionNames = "replica region"
+
+// TrackResourceNames starts recording the names of the resources declared in
+// ctx, owned by "global resources" unless declared under a component given the
+// options of the returned ResourceNames. Call it before declaring anything.
+func TrackResourceNames(ctx *pulumi.Context) (*ResourceNames, error) {
+	names := &ResourceNames{owners: map[string]string{}, recorded: map[pulumi.Resource]bool{}}
+	if err := ctx.RegisterStackTransformation(names.recorder("", "global resources")); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ReplicaRegion returns the options of LookupReplicaNetwork's component, so the
+// names of the replica region's resources are recorded apart from the same
+// names in the stack's region.
+func (n *ResourceNames) ReplicaRegion() []pulumi.ResourceOption {
+	return n.ownedBy(replicaRegionNames, "the replica region's resources")
+}
+
+// A transformation recording the name of every resource it sees in region for
+// owner. A resource passes through the recorders of its parents too, its own
+// first, so a resource seen again keeps its first owner.
+func (n *ResourceNames) recorder(region, owner string) pulumi.ResourceTransformation {
+	return func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.recorded[args.Resource] {
+			return nil
+		}
+		n.recorded[args.Resource] = true
+		key := fmt.Sprintf("%s %s %s", region, args.Type, args.Name)
+		if existing, ok := n.owners[key]; ok {
+			if n.err == nil {
+				n.err = fmt.Errorf("resource name %q of %s collides with a name used by %s", args.Name, owner, existing)
+			}
+			return nil
+		}
+		n.owners[key] = owner
+		return nil
+	}
+}
+
+// Options recording the names of a component's resources in region for owner,
+// or none when names are not tracked.
+func (n *ResourceNames) ownedBy(region, owner string) []pulumi.ResourceOption {
+	if n == nil {
+		return nil
+	}
+	return []pulumi.ResourceOption{pulumi.Transformations([]pulumi.ResourceTransformation{n.recorder(region, owner)})}
+}
+
+// Err returns the first collision between the names declared so far. A
+// transformation cannot fail the declaration it sees, so callers check it
+// after each environment and once everything is declared.
+func (n *ResourceNames) Err() error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// CheckResourceNames checks up front that the environment names are unique
+// DNS labels, which every generated name starts with. Collisions between the
+// generated names themselves are found by ResourceNames as they are declared.
+func CheckResourceNames(environments []string) error {
+	seen := map[string]bool{}
+	for _, env := range environments {
+		if !stackconfig.DnsLabel.MatchString(env) {
+			return fmt.Errorf("environment name %q must be a lowercase DNS label", env)
+		}
+		if seen[env] {
+			return fmt.Errorf("environment %q is listed twice", env)
+		}
+		seen[env] = true
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package eksdemo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"aws-go-eks/internal/cluster"
+)
+
+// Formats of the stack output names ExportCluster sets for an environment.
+const (
+	endpointOutput             = "%sEndpoint"
+	kubeconfigOutput           = "%sKubeconfig"
+	clusterSecurityGroupOutput = "%sClusterSecurityGroupId"
+	oidcIssuerUrlOutput        = "%sOidcIssuerUrl"
+)
+
+// ExportCluster exports the outputs another stack needs to work with the
+// cluster, which ReferenceCluster reads back.
+func ExportCluster(ctx *pulumi.Context, cluster *cluster.Cluster) {
+	ctx.Export(fmt.Sprintf(endpointOutput, cluster.Env), cluster.Cluster.Endpoint)
+	ctx.Export(fmt.Sprintf(kubeconfigOutput, cluster.Env), cluster.Kubeconfig)
+	ctx.Export(fmt.Sprintf(clusterSecurityGroupOutput, cluster.Env), cluster.SecurityGroupId)
+	ctx.Export(fmt.Sprintf(oidcIssuerUrlOutput, cluster.Env), cluster.OidcIssuerUrl)
+}
+
+// ExportEnvironment exports what the environment provides: the cluster as
+// ExportCluster does, the ARNs of its customer managed keys, its add-ons'
+// endpoints and roles, and its replica's endpoint, kubeconfig and Argo CD URL.
+func ExportEnvironment(ctx *pulumi.Context, environment *EksEnvironment) {
+	env := environment.Cluster.Env
+	ExportCluster(ctx, environment.Cluster)
+	for service, arn := range cluster.KmsKeyArns(environment.Cluster) {
+		ctx.Export(fmt.Sprintf("%s%sKmsKeyArn", env, service), arn)
+	}
+	if environment.StandaloneAlbDnsName != nil {
+		ctx.Export(fmt.Sprintf("%sStandaloneAlbDnsName", env), *environment.StandaloneAlbDnsName)
+	}
+	if environment.BastionInstanceId != nil {
+		ctx.Export(fmt.Sprintf("%sBastionInstanceId", env), *environment.BastionInstanceId)
+	}
+	if environment.AmpWorkspace != nil {
+		ctx.Export(fmt.Sprintf("%sAmpWorkspaceId", env), environment.AmpWorkspace.ID())
+		ctx.Export(fmt.Sprintf("%sAmpRemoteWriteUrl", env), environment.AmpRemoteWriteUrl)
+	}
+	ctx.Export(fmt.Sprintf("%sArgoCdUrl", env), environment.ArgoCdUrl)
+	ctx.Export(fmt.Sprintf("%sAppRoleArn", env), environment.AppRoleArn)
+	ctx.Export(fmt.Sprintf("%sLoadBalancerControllerRoleArn", env), environment.LoadBalancerControllerRoleArn)
+	ctx.Export(fmt.Sprintf("%sSmokeTestStatus", env), environment.SmokeTestStatus)
+	if replica := environment.Replica; replica != nil {
+		ctx.Export(fmt.Sprintf("%sReplicaEndpoint", env), replica.Cluster.Cluster.Endpoint)
+		ctx.Export(fmt.Sprintf("%sReplicaKubeconfig", env), replica.Cluster.Kubeconfig)
+		ctx.Export(fmt.Sprintf("%sReplicaArgoCdUrl", env), replica.ArgoCdUrl)
+	}
+}
+
+// ClusterReference is an environment's cluster as exported by another stack.
+type ClusterReference struct {
+	Endpoint        pulumi.StringOutput
+	Kubeconfig      pulumi.StringOutput
+	SecurityGroupId pulumi.StringOutput
+	OidcIssuerUrl   pulumi.StringOutput
+}
+
+// ReferenceCluster reads env's cluster outputs from stack, given as
+// `<org>/<project>/<stack>`, so a downstream stack can deploy into a cluster that
+// is managed in its own stack.
+func ReferenceCluster(ctx *pulumi.Context, stack string, env string) (*ClusterReference, error) {
+	ref, err := pulumi.NewStackReference(ctx, stack, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterReference{
+		Endpoint:        ref.GetStringOutput(pulumi.Sprintf(endpointOutput, env)),
+		Kubeconfig:      ref.GetStringOutput(pulumi.Sprintf(kubeconfigOutput, env)),
+		SecurityGroupId: ref.GetStringOutput(pulumi.Sprintf(clusterSecurityGroupOutput, env)),
+		OidcIssuerUrl:   ref.GetStringOutput(pulumi.Sprintf(oidcIssuerUrlOutput, env)),
+	}, nil
+}
+
+// CheckExcludedEnvironments returns an error when any of environments that is
+// not in provisioned, such as those `onlyEnvironments` leaves out, already has a
+// cluster in this stack. Its resources would no longer be declared, so the
+// update would delete them rather than leave them as they are. Whether it has
+// one is read from the stack's own outputs of its last update.
+func CheckExcludedEnvironments(ctx *pulumi.Context, environments, provisioned []string) error {
+	if len(provisioned) == len(environments) {
+		return nil
+	}
+	self, err := pulumi.NewStackReference(ctx, ctx.Stack(), nil)
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, env := range provisioned {
+		wanted[env] = true
+	}
+	var excluded []string
+	var endpoints []interface{}
+	for _, env := range environments {
+		if !wanted[env] {
+			excluded = append(excluded, env)
+			endpoints = append(endpoints, self.GetOutput(pulumi.Sprintf(endpointOutput, env)))
+		}
+	}
+	// Nothing may be declared before the check, so its outputs are waited for
+	existing := make(chan []string, 1)
+	pulumi.All(endpoints...).ApplyT(func(values []interface{}) error {
+		var found []string
+		for i, value := range values {
+			if value != nil {
+				found = append(found, excluded[i])
+			}
+		}
+		existing <- found
+		return nil
+	})
+	if found := <-existing; len(found) > 0 {
+		return fmt.Errorf("%s already provisioned in stack %s, and leaving them out would delete their resources; "+
+			"provision them too, or use pulumi up --target to update the others alone", strings.Join(found, ", "), ctx.Stack())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package eksdemo
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/addons"
+	"aws-go-eks/internal/cluster"
+)
+
+// ProvisionReplica creates env's replica cluster from the replica region's shared
+// resources and installs what the primary cluster gets: the EKS add-ons, the
+// CoreDNS and VPC CNI config, the AWS Load Balancer Controller, Container
+// Insights, the node termination handler, the cluster autoscaler or Karpenter,
+// the GPU device plugin and the image prepuller when enabled, Argo, the
+// namespaces with the app service account and kustomize overlay, the secrets
+// controller and the post-install kubectl commands. Returns the cluster and the
+// URL of its Argo CD server.
+func ProvisionReplica(ctx *pulumi.Context, cfg *config.Config, env string, shared *cluster.Shared) (*cluster.Cluster, pulumi.StringOutput, error) {
+	return provisionReplica(ctx, cfg, &cluster.Cluster{Env: env, Shared: shared})
+}
+
+// ProvisionReplica into c, which has its Env and Shared set, and its
+// component when it is part of an EksEnvironment.
+func provisionReplica(ctx *pulumi.Context, cfg *config.Config, c *cluster.Cluster) (*cluster.Cluster, pulumi.StringOutput, error) {
+	c, err := cluster.Provision(ctx, cfg, c)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallEksAddons(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.ConfigureCoreDns(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.ConfigureVpcCni(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if _, err := addons.InstallLoadBalancerController(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if cfg.GetBool("enableContainerInsights") {
+		if err := addons.InstallContainerInsights(ctx, cfg, c); err != nil {
+			return nil, pulumi.StringOutput{}, err
+		}
+	}
+	if err := addons.InstallNodeTerminationHandler(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallClusterAutoscaler(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallKarpenter(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallGpuDevicePlugin(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallImagePrepuller(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	argoCdUrl, err := addons.InstallArgo(ctx, cfg, c)
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.CreateNamespaces(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if _, err := addons.CreateAppServiceAccount(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.DeployAppKustomization(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.InstallSecretsController(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	if err := addons.RunPostInstallKubectl(ctx, cfg, c); err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+	return c, argoCdUrl, nil
+}
@@ -0,0 +1,24 @@
+package eksdemo
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+
+	"aws-go-eks/internal/addons"
+	"aws-go-eks/internal/cluster"
+	"aws-go-eks/internal/iamroles"
+	"aws-go-eks/internal/network"
+	"aws-go-eks/internal/stackconfig"
+)
+
+// ValidateConfig runs the checks that only need the stack config, for every
+// environment, and returns all the problems found as one error rather than
+// stopping at the first. Checks that need AWS lookups still run later, as the
+// resources are built. Returns nil when the config is valid.
+func ValidateConfig(cfg *config.Config, environments []string) error {
+	var errs stackconfig.Errors
+	errs.Check(network.ValidateConfig(cfg, environments))
+	errs.Check(iamroles.ValidateConfig(cfg, environments))
+	errs.Check(cluster.ValidateConfig(cfg, environments))
+	errs.Check(addons.ValidateConfig(cfg, environments))
+	return errs.Err()
+}